@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
@@ -8,9 +9,11 @@ import (
 	"k2ray/internal/api/middleware"
 	"k2ray/internal/config"
 	"k2ray/internal/db"
+	"k2ray/internal/failover"
 	"k2ray/internal/logger"
 	"k2ray/internal/metrics"
 	"k2ray/internal/redis"
+	"k2ray/internal/subscription"
 	"runtime"
 )
 
@@ -58,6 +61,12 @@ func main() {
 	// Initialize Redis connection
 	redis.InitRedis()
 
+	// Keep subscription feeds in sync in the background
+	subscription.StartScheduler(context.Background())
+
+	// Health-check the active configuration and fail over when it goes down
+	failover.StartScheduler(context.Background())
+
 	// Create a new Gin router without the default middleware
 	router := gin.New()
 
@@ -0,0 +1,427 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"k2ray/internal/archive"
+	"k2ray/internal/db"
+	"k2ray/internal/security"
+	"k2ray/internal/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// maxArchiveConfigs caps the number of configurations in an imported archive.
+const maxArchiveConfigs = 1000
+
+// Archive import conflict strategies, applied when an archived configuration
+// has the same name as an existing one.
+const (
+	conflictSkip      = "skip"      // keep the existing configuration
+	conflictOverwrite = "overwrite" // replace the existing configuration's data, recorded as a revision
+	conflictRename    = "rename"    // import under a new name such as "Name (2)"
+)
+
+// Archive import actions.
+const (
+	actionCreate    = "create"
+	actionOverwrite = "overwrite"
+	actionSkip      = "skip"
+	actionUnchanged = "unchanged"
+)
+
+// ArchiveImportResult reports what importing one archived configuration did,
+// or would do in a dry run.
+type ArchiveImportResult struct {
+	Index    int                `json:"index"`
+	Name     string             `json:"name"`
+	Protocol string             `json:"protocol"`
+	Success  bool               `json:"success"`
+	Action   string             `json:"action,omitempty"`    // create, overwrite, skip or unchanged
+	NewName  string             `json:"new_name,omitempty"`  // Set when the configuration was renamed
+	ConfigID int64              `json:"config_id,omitempty"` // The created or overwritten configuration
+	Changes  []utils.JSONChange `json:"changes,omitempty"`   // What an overwrite changes
+	Error    string             `json:"error,omitempty"`
+	Details  map[string]string  `json:"details,omitempty"`
+}
+
+// ArchiveImportResponse is the response for an archive import.
+type ArchiveImportResponse struct {
+	DryRun      bool                  `json:"dry_run"`
+	Strategy    string                `json:"strategy"`
+	Created     int                   `json:"created"`
+	Overwritten int                   `json:"overwritten"`
+	Skipped     int                   `json:"skipped"`
+	Unchanged   int                   `json:"unchanged"`
+	Failed      int                   `json:"failed"`
+	Results     []ArchiveImportResult `json:"results"`
+}
+
+// exportArchive writes configs, all owned by userID, as a k2ray archive.
+func exportArchive(c *gin.Context, userID int64, configs []db.Configuration) {
+	includeTags, err := strconv.ParseBool(c.DefaultQuery("include_tags", "true"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "include_tags must be a boolean"})
+		return
+	}
+	includeRevisions, err := strconv.ParseBool(c.DefaultQuery("include_revisions", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "include_revisions must be a boolean"})
+		return
+	}
+
+	a, err := buildArchive(userID, configs, includeTags, includeRevisions)
+	if err != nil {
+		log.Error().Err(err).Msg("Error building configuration archive")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export configurations"})
+		return
+	}
+	body, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		log.Error().Err(err).Msg("Error encoding configuration archive")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export configurations"})
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="k2ray-archive.json"`)
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+func buildArchive(userID int64, configs []db.Configuration, includeTags, includeRevisions bool) (*archive.Archive, error) {
+	groups, err := groupNames(userID)
+	if err != nil {
+		return nil, err
+	}
+	var tags map[int64][]string
+	if includeTags {
+		ids := make([]int64, len(configs))
+		for i, config := range configs {
+			ids[i] = config.ID
+		}
+		if tags, err = db.ConfigTags(ids); err != nil {
+			return nil, err
+		}
+	}
+
+	a := archive.New()
+	for _, config := range configs {
+		item := archive.Config{
+			Name:       config.Name,
+			Protocol:   config.Protocol,
+			ConfigData: json.RawMessage(config.ConfigData),
+			Remarks:    config.Remarks,
+			Tags:       tags[config.ID],
+			CreatedAt:  config.CreatedAt,
+			UpdatedAt:  config.UpdatedAt,
+		}
+		if config.GroupID != nil {
+			item.Group = groups[*config.GroupID]
+		}
+		if includeRevisions {
+			revisions, err := db.ListConfigRevisions(config.ID)
+			if err != nil {
+				return nil, err
+			}
+			for i := len(revisions) - 1; i >= 0; i-- {
+				r := revisions[i]
+				rev := archive.Revision{Revision: r.Revision, Name: r.Name, ConfigData: json.RawMessage(r.ConfigData), Comment: r.Comment, CreatedAt: r.CreatedAt}
+				if r.Author != nil {
+					rev.Author = *r.Author
+				}
+				item.Revisions = append(item.Revisions, rev)
+			}
+		}
+		a.Configs = append(a.Configs, item)
+	}
+	return a, nil
+}
+
+// groupNames maps the IDs of the user's groups to their names.
+func groupNames(userID int64) (map[int64]string, error) {
+	rows, err := db.DB.Query("SELECT id, name FROM config_groups WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
+
+// archiveImport holds the state of one archive import.
+type archiveImport struct {
+	c        *gin.Context
+	userID   int64
+	strategy string
+	dryRun   bool
+	byName   map[string]*db.Configuration // The user's configurations, and the ones imported so far
+	groups   map[int64]string
+	authors  map[string]*int64
+}
+
+// importArchive imports an archive posted to ImportConfigs.
+func importArchive(c *gin.Context, userID int64, body []byte) {
+	strategy := c.DefaultQuery("strategy", conflictSkip)
+	if strategy != conflictSkip && strategy != conflictOverwrite && strategy != conflictRename {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "strategy must be 'skip', 'overwrite' or 'rename'"})
+		return
+	}
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dry_run must be a boolean"})
+		return
+	}
+	a, err := archive.Parse(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(a.Configs) > maxArchiveConfigs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("An archive can hold at most %d configurations", maxArchiveConfigs)})
+		return
+	}
+
+	imp := &archiveImport{c: c, userID: userID, strategy: strategy, dryRun: dryRun, byName: make(map[string]*db.Configuration), authors: make(map[string]*int64)}
+	configs, err := userConfigs(userID)
+	if err == nil {
+		pointers := make([]*db.Configuration, len(configs))
+		for i := range configs {
+			pointers[i] = &configs[i]
+		}
+		err = attachTags(pointers)
+		// When names repeat, the oldest configuration is the one matched.
+		for i := len(configs) - 1; i >= 0; i-- {
+			imp.byName[configs[i].Name] = &configs[i]
+		}
+	}
+	if err == nil {
+		imp.groups, err = groupNames(userID)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error loading configurations for archive import")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import archive"})
+		return
+	}
+
+	resp := ArchiveImportResponse{DryRun: dryRun, Strategy: strategy, Results: make([]ArchiveImportResult, 0, len(a.Configs))}
+	for i, item := range a.Configs {
+		result := imp.importConfig(item)
+		result.Index = i
+		switch {
+		case !result.Success:
+			resp.Failed++
+		case result.Action == actionCreate:
+			resp.Created++
+		case result.Action == actionOverwrite:
+			resp.Overwritten++
+		case result.Action == actionSkip:
+			resp.Skipped++
+		case result.Action == actionUnchanged:
+			resp.Unchanged++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (imp *archiveImport) importConfig(item archive.Config) ArchiveImportResult {
+	result := ArchiveImportResult{Name: item.Name, Protocol: item.Protocol}
+	if n := utf8.RuneCountInString(item.Name); n < 3 || n > 50 {
+		result.Error = "Configuration name must be between 3 and 50 characters"
+		return result
+	}
+	if _, err := validateAndDecode(item.Protocol, item.ConfigData); err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			result.Error, result.Details = verr.Msg, verr.Fields
+		} else {
+			result.Error = "An unexpected error occurred during validation"
+		}
+		return result
+	}
+	tags := item.Tags
+	if tags != nil {
+		tags = normalizeTags(tags)
+	}
+
+	existing := imp.byName[item.Name]
+	switch {
+	case existing == nil:
+		result.Action = actionCreate
+	case imp.strategy == conflictSkip:
+		result.Success, result.Action, result.ConfigID = true, actionSkip, existing.ID
+		return result
+	case imp.strategy == conflictRename:
+		result.Action = actionCreate
+		result.NewName = archive.RenameCopy(item.Name, 50, func(name string) bool { return imp.byName[name] != nil })
+	case existing.Protocol != item.Protocol:
+		result.Error = fmt.Sprintf("Cannot overwrite configuration %d, which uses protocol '%s'", existing.ID, existing.Protocol)
+		return result
+	default:
+		return imp.overwrite(existing, item, tags, result)
+	}
+
+	config := db.Configuration{
+		UserID:     imp.userID,
+		Name:       item.Name,
+		Protocol:   item.Protocol,
+		ConfigData: string(item.ConfigData),
+		Remarks:    item.Remarks,
+		Tags:       tags,
+	}
+	if result.NewName != "" {
+		config.Name = result.NewName
+	}
+	if config.Tags == nil {
+		config.Tags = []string{}
+	}
+	imp.byName[config.Name] = &config
+	if imp.dryRun {
+		result.Success = true
+		return result
+	}
+
+	groupID, err := imp.groupID(item.Group)
+	if err != nil {
+		log.Error().Err(err).Str("group", item.Group).Msg("Error creating group for archive import")
+		result.Error = "Failed to create group"
+		return result
+	}
+	config.GroupID = groupID
+	history, err := imp.history(item.Revisions)
+	if err != nil {
+		log.Error().Err(err).Msg("Error resolving revision authors for archive import")
+		result.Error = "Failed to create configuration"
+		return result
+	}
+	created, err := insertConfigWithHistory(imp.c, config, history, "Imported from archive")
+	if err != nil {
+		log.Error().Err(err).Msg("Error executing SQL for archive import")
+		result.Error = "Failed to create configuration"
+		return result
+	}
+	*imp.byName[config.Name] = created
+	result.Success, result.ConfigID = true, created.ID
+	return result
+}
+
+// overwrite replaces an existing configuration's data, remarks and group, and
+// its tags if the archive carries them.
+func (imp *archiveImport) overwrite(existing *db.Configuration, item archive.Config, tags []string, result ArchiveImportResult) ArchiveImportResult {
+	result.ConfigID = existing.ID
+	if tags == nil {
+		tags = existing.Tags
+	}
+	current := existing.ConfigData
+	var group string
+	if existing.GroupID != nil {
+		group = imp.groups[*existing.GroupID]
+	}
+
+	changes, err := utils.DiffJSON(
+		archiveDocument(json.RawMessage(current), existing.Remarks, group, existing.Tags),
+		archiveDocument(item.ConfigData, item.Remarks, item.Group, tags))
+	if err != nil {
+		result.Error = "Failed to compare configurations"
+		return result
+	}
+	if len(changes) == 0 {
+		result.Success, result.Action = true, actionUnchanged
+		return result
+	}
+	result.Action, result.Changes = actionOverwrite, changes
+	if imp.dryRun {
+		result.Success = true
+		return result
+	}
+
+	config := *existing
+	config.ConfigData, config.Remarks, config.Tags = string(item.ConfigData), item.Remarks, tags
+	if config.GroupID, err = imp.groupID(item.Group); err != nil {
+		log.Error().Err(err).Str("group", item.Group).Msg("Error creating group for archive import")
+		result.Error = "Failed to create group"
+		return result
+	}
+	revision, err := saveConfig(imp.c, config, "Overwritten by archive import")
+	if err != nil {
+		log.Error().Err(err).Int64("config_id", existing.ID).Msg("Error executing update for archive import")
+		result.Error = "Failed to update configuration"
+		return result
+	}
+	*existing = config
+
+	details := fmt.Sprintf("Configuration '%s' overwritten by archive import (revision %d)", config.Name, revision)
+	security.LogEvent(imp.c, security.ConfigUpdated, config.ID, details)
+	result.Success = true
+	return result
+}
+
+// archiveDocument is the JSON document an existing and an archived
+// configuration are compared as.
+func archiveDocument(configData json.RawMessage, remarks, group string, tags []string) []byte {
+	if tags == nil {
+		tags = []string{}
+	}
+	doc, _ := json.Marshal(struct {
+		ConfigData json.RawMessage `json:"config_data"`
+		Remarks    string          `json:"remarks"`
+		Group      string          `json:"group"`
+		Tags       []string        `json:"tags"`
+	}{configData, remarks, group, tags})
+	return doc
+}
+
+// groupID returns the ID of the user's group called name, creating it if
+// needed. An empty name means no group.
+func (imp *archiveImport) groupID(name string) (*int64, error) {
+	if name == "" {
+		return nil, nil
+	}
+	for id, existing := range imp.groups {
+		if strings.EqualFold(existing, name) {
+			return &id, nil
+		}
+	}
+	res, err := db.DB.Exec(`INSERT INTO config_groups (user_id, name) VALUES (?, ?)`, imp.userID, name)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	imp.groups[id] = name
+	security.LogEvent(imp.c, security.GroupCreated, id, fmt.Sprintf("Group '%s' created by archive import", name))
+	return &id, nil
+}
+
+// history converts archived revisions, attributing each to the local user
+// with the same username, if there is one.
+func (imp *archiveImport) history(revisions []archive.Revision) ([]db.ConfigRevision, error) {
+	history := make([]db.ConfigRevision, 0, len(revisions))
+	for _, r := range revisions {
+		authorID, ok := imp.authors[r.Author]
+		if !ok && r.Author != "" {
+			var id int64
+			err := db.DB.QueryRow("SELECT id FROM users WHERE username = ?", r.Author).Scan(&id)
+			if err == nil {
+				authorID = &id
+			} else if err != sql.ErrNoRows {
+				return nil, err
+			}
+			imp.authors[r.Author] = authorID
+		}
+		history = append(history, db.ConfigRevision{Name: r.Name, ConfigData: string(r.ConfigData), AuthorID: authorID, Comment: r.Comment, CreatedAt: r.CreatedAt})
+	}
+	return history, nil
+}
@@ -0,0 +1,460 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"k2ray/internal/api/middleware"
+	"k2ray/internal/db"
+	"k2ray/internal/generator"
+	"k2ray/internal/security"
+	"k2ray/internal/v2ray"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// minProbeInterval keeps the observatory from probing the members too often.
+const minProbeInterval = 10 * time.Second
+
+// CreateBalancerPayload defines the structure for creating a balancer.
+type CreateBalancerPayload struct {
+	Name          string  `json:"name" binding:"required,min=1,max=50"`
+	Strategy      string  `json:"strategy" binding:"required,oneof=random leastPing roundRobin"`
+	Members       []int64 `json:"members" binding:"required,min=1,max=50"` // Configuration IDs, in order
+	ProbeURL      string  `json:"probe_url" binding:"omitempty,max=500"`
+	ProbeInterval string  `json:"probe_interval" binding:"omitempty,max=20"` // e.g. "30s" or "1m"
+}
+
+// UpdateBalancerPayload defines the structure for updating a balancer.
+// Omitted fields keep their current value.
+type UpdateBalancerPayload struct {
+	Name          *string  `json:"name" binding:"omitempty,min=1,max=50"`
+	Strategy      *string  `json:"strategy" binding:"omitempty,oneof=random leastPing roundRobin"`
+	Members       *[]int64 `json:"members" binding:"omitempty,min=1,max=50"`  // Replaces all members
+	ProbeURL      *string  `json:"probe_url" binding:"omitempty,max=500"`     // Empty for the default
+	ProbeInterval *string  `json:"probe_interval" binding:"omitempty,max=20"` // Empty for the default
+}
+
+// BalancerResponse is a balancer and whether it is the active one.
+type BalancerResponse struct {
+	db.Balancer
+	Active bool
+}
+
+const balancerColumns = `id, user_id, name, strategy, probe_url, probe_interval, created_at, updated_at`
+
+func scanBalancer(row interface{ Scan(...any) error }, b *db.Balancer) error {
+	return row.Scan(&b.ID, &b.UserID, &b.Name, &b.Strategy, &b.ProbeURL, &b.ProbeInterval, &b.CreatedAt, &b.UpdatedAt)
+}
+
+// getBalancer returns the user's balancer with its members.
+func getBalancer(userID, id any) (db.Balancer, error) {
+	var b db.Balancer
+	row := db.DB.QueryRow("SELECT "+balancerColumns+" FROM balancers WHERE id = ? AND user_id = ?", id, userID)
+	if err := scanBalancer(row, &b); err != nil {
+		return b, err
+	}
+	return b, attachBalancerMembers([]*db.Balancer{&b})
+}
+
+// loadBalancerByID fetches a balancer with its members if it belongs to the
+// authenticated user, writing the error response itself otherwise.
+func loadBalancerByID(c *gin.Context, id any) (db.Balancer, bool) {
+	userID, _ := c.Get(middleware.ContextUserIDKey)
+	b, err := getBalancer(userID, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Balancer not found or access denied"})
+			return b, false
+		}
+		log.Error().Err(err).Any("balancer_id", id).Msg("Error getting balancer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve balancer"})
+		return b, false
+	}
+	return b, true
+}
+
+// attachBalancerMembers loads the member configuration IDs of balancers.
+func attachBalancerMembers(balancers []*db.Balancer) error {
+	if len(balancers) == 0 {
+		return nil
+	}
+	byID := make(map[int64]*db.Balancer, len(balancers))
+	args := make([]any, len(balancers))
+	for i, b := range balancers {
+		b.Members = []int64{}
+		byID[b.ID] = b
+		args[i] = b.ID
+	}
+
+	querySQL := `SELECT balancer_id, configuration_id FROM balancer_members
+		WHERE balancer_id IN (?` + strings.Repeat(",?", len(balancers)-1) + `) ORDER BY position`
+	rows, err := db.DB.Query(querySQL, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var balancerID, configID int64
+		if err := rows.Scan(&balancerID, &configID); err != nil {
+			return err
+		}
+		byID[balancerID].Members = append(byID[balancerID].Members, configID)
+	}
+	return rows.Err()
+}
+
+// activeBalancerID returns the ID of the active balancer, or 0 if none is active.
+func activeBalancerID() (int64, error) {
+	var id int64
+	err := db.DB.QueryRow("SELECT value FROM settings WHERE key = ?", v2ray.ActiveBalancerKey).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// checkBalancer verifies that the user can read every member of b and that the
+// members and probe settings make a valid document, writing a 400 response
+// itself otherwise.
+func checkBalancer(c *gin.Context, userID int64, b db.Balancer) bool {
+	if b.ProbeURL != "" {
+		u, err := url.Parse(b.ProbeURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "probe_url must be an http(s) URL"})
+			return false
+		}
+	}
+	if b.ProbeInterval != "" {
+		interval, err := time.ParseDuration(b.ProbeInterval)
+		if err != nil || interval < minProbeInterval {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("probe_interval must be a duration of at least %s, e.g. 30s or 1m", minProbeInterval)})
+			return false
+		}
+	}
+
+	access, err := db.ConfigAccessFor(userID, b.Members)
+	if err != nil {
+		log.Error().Err(err).Msg("Error checking balancer members")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check balancer members"})
+		return false
+	}
+	members := make([]generator.Member, 0, len(b.Members))
+	for i, id := range b.Members {
+		for _, seen := range b.Members[:i] {
+			if seen == id {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Configuration %d is listed more than once", id)})
+				return false
+			}
+		}
+		if !access[id].Permission.Allows(db.PermissionRead) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Configuration %d not found", id)})
+			return false
+		}
+		var member generator.Member
+		err := db.DB.QueryRow("SELECT name, protocol, config_data FROM configurations WHERE id = ?", id).Scan(&member.Name, &member.Protocol, &member.ConfigData)
+		if err != nil {
+			log.Error().Err(err).Int64("config_id", id).Msg("Error loading balancer member")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check balancer members"})
+			return false
+		}
+		members = append(members, member)
+	}
+
+	options := generator.BalancerOptions{Strategy: b.Strategy, ProbeURL: b.ProbeURL, ProbeInterval: b.ProbeInterval}
+	if _, err := generator.BuildBalanced(members, options, generator.DefaultOptions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid balancer member: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+// setBalancerMembers replaces the members of a balancer, keeping their order.
+func setBalancerMembers(tx *sql.Tx, balancerID int64, configIDs []int64) error {
+	if _, err := tx.Exec("DELETE FROM balancer_members WHERE balancer_id = ?", balancerID); err != nil {
+		return err
+	}
+	for i, configID := range configIDs {
+		if _, err := tx.Exec("INSERT INTO balancer_members (balancer_id, configuration_id, position) VALUES (?, ?, ?)", balancerID, configID, i+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// respondBalancer reads back a balancer and writes it with the given status.
+func respondBalancer(c *gin.Context, status int, userID, id int64) {
+	b, err := getBalancer(userID, id)
+	if err != nil {
+		log.Error().Err(err).Int64("balancer_id", id).Msg("Error reading back balancer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve balancer"})
+		return
+	}
+	activeID, err := activeBalancerID()
+	if err != nil {
+		log.Error().Err(err).Msg("Error reading active balancer")
+	}
+	c.JSON(status, BalancerResponse{Balancer: b, Active: b.ID == activeID})
+}
+
+// CreateBalancer godoc
+// @Summary Create a balancer
+// @Description Groups configurations into a balancer that spreads traffic across them with the random, leastPing or roundRobin strategy.
+// @Description Once activated through POST /system/active-config, the generated V2Ray config holds one outbound per member and an observatory that probes probe_url every probe_interval, so that members that are down are skipped.
+// @Tags Balancers
+// @Accept  json
+// @Produce  json
+// @Param   balancer body CreateBalancerPayload true "Balancer details"
+// @Success 201 {object} BalancerResponse
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload or member"
+// @Failure 409 {object} middleware.ErrorResponse "A balancer with this name already exists"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to create balancer"
+// @Security ApiKeyAuth
+// @Router /balancers [post]
+func CreateBalancer(c *gin.Context) {
+	var payload CreateBalancerPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+
+	userIDVal, _ := c.Get(middleware.ContextUserIDKey)
+	userID := userIDVal.(int64)
+	b := db.Balancer{
+		UserID:        userID,
+		Name:          payload.Name,
+		Strategy:      payload.Strategy,
+		ProbeURL:      payload.ProbeURL,
+		ProbeInterval: payload.ProbeInterval,
+		Members:       payload.Members,
+	}
+	if !checkBalancer(c, userID, b) {
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		log.Error().Err(err).Msg("Error starting transaction for CreateBalancer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create balancer"})
+		return
+	}
+	defer tx.Rollback()
+
+	insertSQL := `INSERT INTO balancers (user_id, name, strategy, probe_url, probe_interval) VALUES (?, ?, ?, ?, ?)`
+	res, err := tx.Exec(insertSQL, userID, b.Name, b.Strategy, b.ProbeURL, b.ProbeInterval)
+	if err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A balancer with this name already exists"})
+			return
+		}
+		log.Error().Err(err).Msg("Error executing SQL for CreateBalancer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create balancer"})
+		return
+	}
+	b.ID, _ = res.LastInsertId()
+	if err := setBalancerMembers(tx, b.ID, b.Members); err != nil {
+		log.Error().Err(err).Int64("balancer_id", b.ID).Msg("Error setting balancer members")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create balancer"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Error().Err(err).Int64("balancer_id", b.ID).Msg("Error committing new balancer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create balancer"})
+		return
+	}
+
+	security.LogEvent(c, security.BalancerCreated, b.ID, fmt.Sprintf("Balancer '%s' created with %d members", b.Name, len(b.Members)))
+	respondBalancer(c, http.StatusCreated, userID, b.ID)
+}
+
+// ListBalancers godoc
+// @Summary List balancers
+// @Description Retrieves the authenticated user's balancers with their members, sorted by name.
+// @Tags Balancers
+// @Produce  json
+// @Success 200 {array} BalancerResponse
+// @Failure 500 {object} middleware.ErrorResponse "Failed to retrieve balancers"
+// @Security ApiKeyAuth
+// @Router /balancers [get]
+func ListBalancers(c *gin.Context) {
+	userID, _ := c.Get(middleware.ContextUserIDKey)
+
+	rows, err := db.DB.Query("SELECT "+balancerColumns+" FROM balancers WHERE user_id = ? ORDER BY name", userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Error querying balancers")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve balancers"})
+		return
+	}
+	defer rows.Close()
+
+	balancers := []BalancerResponse{}
+	for rows.Next() {
+		var b BalancerResponse
+		if err := scanBalancer(rows, &b.Balancer); err != nil {
+			log.Error().Err(err).Msg("Error scanning balancer row")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process balancers"})
+			return
+		}
+		balancers = append(balancers, b)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Msg("Error iterating balancer rows")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process balancers"})
+		return
+	}
+
+	refs := make([]*db.Balancer, len(balancers))
+	for i := range balancers {
+		refs[i] = &balancers[i].Balancer
+	}
+	if err := attachBalancerMembers(refs); err != nil {
+		log.Error().Err(err).Msg("Error loading balancer members")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve balancers"})
+		return
+	}
+	activeID, err := activeBalancerID()
+	if err != nil {
+		log.Error().Err(err).Msg("Error reading active balancer")
+	}
+	for i := range balancers {
+		balancers[i].Active = balancers[i].ID == activeID
+	}
+	c.JSON(http.StatusOK, balancers)
+}
+
+// GetBalancer godoc
+// @Summary Get a balancer
+// @Tags Balancers
+// @Produce  json
+// @Param id path int true "Balancer ID"
+// @Success 200 {object} BalancerResponse
+// @Failure 404 {object} middleware.ErrorResponse "Balancer not found"
+// @Security ApiKeyAuth
+// @Router /balancers/{id} [get]
+func GetBalancer(c *gin.Context) {
+	b, ok := loadBalancerByID(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	respondBalancer(c, http.StatusOK, b.UserID, b.ID)
+}
+
+// UpdateBalancer godoc
+// @Summary Update a balancer
+// @Description Renames a balancer, changes its strategy or probe settings, or replaces its members. Changes to the active balancer apply the next time V2Ray is started.
+// @Tags Balancers
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Balancer ID"
+// @Param   balancer body UpdateBalancerPayload true "Fields to update"
+// @Success 200 {object} BalancerResponse
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload or member"
+// @Failure 404 {object} middleware.ErrorResponse "Balancer not found"
+// @Failure 409 {object} middleware.ErrorResponse "A balancer with this name already exists"
+// @Security ApiKeyAuth
+// @Router /balancers/{id} [put]
+func UpdateBalancer(c *gin.Context) {
+	b, ok := loadBalancerByID(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var payload UpdateBalancerPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+	if payload.Name != nil {
+		b.Name = *payload.Name
+	}
+	if payload.Strategy != nil {
+		b.Strategy = *payload.Strategy
+	}
+	if payload.Members != nil {
+		b.Members = *payload.Members
+	}
+	if payload.ProbeURL != nil {
+		b.ProbeURL = *payload.ProbeURL
+	}
+	if payload.ProbeInterval != nil {
+		b.ProbeInterval = *payload.ProbeInterval
+	}
+	if !checkBalancer(c, b.UserID, b) {
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		log.Error().Err(err).Int64("balancer_id", b.ID).Msg("Error starting transaction for UpdateBalancer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update balancer"})
+		return
+	}
+	defer tx.Rollback()
+
+	updateSQL := `UPDATE balancers SET name = ?, strategy = ?, probe_url = ?, probe_interval = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	if _, err := tx.Exec(updateSQL, b.Name, b.Strategy, b.ProbeURL, b.ProbeInterval, b.ID); err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A balancer with this name already exists"})
+			return
+		}
+		log.Error().Err(err).Int64("balancer_id", b.ID).Msg("Error executing update for balancer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update balancer"})
+		return
+	}
+	if payload.Members != nil {
+		if err := setBalancerMembers(tx, b.ID, b.Members); err != nil {
+			log.Error().Err(err).Int64("balancer_id", b.ID).Msg("Error setting balancer members")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update balancer"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Error().Err(err).Int64("balancer_id", b.ID).Msg("Error committing balancer update")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update balancer"})
+		return
+	}
+
+	security.LogEvent(c, security.BalancerUpdated, b.ID, fmt.Sprintf("Balancer '%s' updated", b.Name))
+	respondBalancer(c, http.StatusOK, b.UserID, b.ID)
+}
+
+// DeleteBalancer godoc
+// @Summary Delete a balancer
+// @Description Deletes a balancer. The member configurations are kept. The active balancer cannot be deleted; activate another configuration or balancer first.
+// @Tags Balancers
+// @Param id path int true "Balancer ID"
+// @Success 204
+// @Failure 404 {object} middleware.ErrorResponse "Balancer not found"
+// @Failure 409 {object} middleware.ErrorResponse "The balancer is active"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to delete balancer"
+// @Security ApiKeyAuth
+// @Router /balancers/{id} [delete]
+func DeleteBalancer(c *gin.Context) {
+	b, ok := loadBalancerByID(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	activeID, err := activeBalancerID()
+	if err != nil {
+		log.Error().Err(err).Msg("Error reading active balancer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete balancer"})
+		return
+	}
+	if activeID == b.ID {
+		c.JSON(http.StatusConflict, gin.H{"error": "The balancer is active; activate another configuration or balancer first"})
+		return
+	}
+
+	if _, err := db.DB.Exec("DELETE FROM balancers WHERE id = ?", b.ID); err != nil {
+		log.Error().Err(err).Int64("balancer_id", b.ID).Msg("Error deleting balancer")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete balancer"})
+		return
+	}
+	security.LogEvent(c, security.BalancerDeleted, b.ID, fmt.Sprintf("Balancer '%s' deleted", b.Name))
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"fmt"
+	"k2ray/internal/api/middleware"
+	"k2ray/internal/archive"
+	"k2ray/internal/export"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// exportFilenames maps each export format to the download filename.
+var exportFilenames = map[export.Format]string{
+	export.FormatBase64:    "k2ray.txt",
+	export.FormatClash:     "k2ray-clash.yaml",
+	export.FormatClashMeta: "k2ray-clash-meta.yaml",
+	export.FormatSingBox:   "k2ray-sing-box.json",
+}
+
+// ExportConfigs godoc
+// @Summary Export configurations for client apps
+// @Description Renders the authenticated user's configurations, or the ones listed in ids, as a base64 share link feed, a Clash or Clash.Meta YAML config, or a sing-box JSON config. Configurations the format cannot express are skipped.
+// @Description The k2ray format is a versioned JSON archive of the configurations with their remarks and groups, optionally their tags and revisions, that POST /configs/import restores on another router.
+// @Tags Configs
+// @Produce  plain
+// @Param format query string false "Export format (base64, clash, clash-meta, sing-box, k2ray)" default(base64)
+// @Param ids query string false "Comma-separated configuration IDs to export (default: all)"
+// @Param include_tags query bool false "k2ray: include each configuration's tags" default(true)
+// @Param include_revisions query bool false "k2ray: include each configuration's revision history" default(false)
+// @Param outbounds_only query bool false "sing-box: render only the outbounds array instead of a full config" default(false)
+// @Param inbound query string false "sing-box: inbound of the full config (mixed, tun)" default(mixed)
+// @Success 200 {string} string "Exported document"
+// @Failure 400 {object} middleware.ErrorResponse "Unknown format, invalid ids or invalid options"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to export configurations"
+// @Security ApiKeyAuth
+// @Router /configs/export [get]
+func ExportConfigs(c *gin.Context) {
+	format := export.Format(c.DefaultQuery("format", string(export.FormatBase64)))
+	filename, ok := exportFilenames[format]
+	if !ok && format != archive.Format {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown export format: " + string(format)})
+		return
+	}
+	opts, err := exportOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var ids map[int64]bool
+	if raw := c.Query("ids"); raw != "" {
+		ids = make(map[int64]bool)
+		for _, part := range strings.Split(raw, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ids must be a comma-separated list of configuration IDs"})
+				return
+			}
+			ids[id] = true
+		}
+	}
+
+	userID, _ := c.Get(middleware.ContextUserIDKey)
+	configs, err := userConfigs(userID.(int64))
+	if err != nil {
+		log.Error().Err(err).Msg("Error loading configurations for export")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export configurations"})
+		return
+	}
+	if ids != nil {
+		selected := configs[:0]
+		for _, config := range configs {
+			if ids[config.ID] {
+				selected = append(selected, config)
+			}
+		}
+		configs = selected
+	}
+
+	if format == archive.Format {
+		exportArchive(c, userID.(int64), configs)
+		return
+	}
+
+	body, contentType, err := export.Render(format, configs, opts)
+	if err != nil {
+		log.Error().Err(err).Str("format", string(format)).Msg("Error rendering export")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export configurations"})
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, contentType, body)
+}
+
+// exportOptions reads the format-specific export options from the query string.
+func exportOptions(c *gin.Context) (export.Options, error) {
+	var opts export.Options
+	if raw := c.Query("outbounds_only"); raw != "" {
+		outboundsOnly, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("outbounds_only must be a boolean")
+		}
+		opts.SingBox.OutboundsOnly = outboundsOnly
+	}
+	opts.SingBox.Inbound = c.Query("inbound")
+	return opts, opts.SingBox.Validate()
+}
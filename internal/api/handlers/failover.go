@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"k2ray/internal/db"
+	"k2ray/internal/failover"
+	"k2ray/internal/security"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// UpdateFailoverPolicyPayload defines the structure for updating the failover
+// policy. Omitted fields keep their current value. Intervals are in seconds.
+type UpdateFailoverPolicyPayload struct {
+	Enabled          *bool    `json:"enabled"`
+	CheckInterval    *int     `json:"check_interval" binding:"omitempty,min=10,max=86400"`
+	FailureThreshold *int     `json:"failure_threshold" binding:"omitempty,min=1,max=100"`
+	Hysteresis       *int     `json:"hysteresis" binding:"omitempty,min=0,max=86400"`
+	CheckHTTP        *bool    `json:"check_http"`
+	NotifyURL        *string  `json:"notify_url" binding:"omitempty,max=2048"` // "" disables notifications
+	Candidates       *[]int64 `json:"candidates" binding:"omitempty,max=20"`   // Replaces the fallback list
+}
+
+// respondFailoverPolicy writes the current failover policy.
+func respondFailoverPolicy(c *gin.Context) {
+	policy, err := db.GetFailoverPolicy()
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting failover policy")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve failover policy"})
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+// GetFailoverPolicy godoc
+// @Summary Get the failover policy
+// @Description Retrieves the failover policy, its fallback candidates and the state of its health checks. Only accessible by admins.
+// @Tags Failover
+// @Produce  json
+// @Success 200 {object} db.FailoverPolicy
+// @Failure 500 {object} middleware.ErrorResponse "Failed to retrieve failover policy"
+// @Security ApiKeyAuth
+// @Router /failover [get]
+func GetFailoverPolicy(c *gin.Context) {
+	respondFailoverPolicy(c)
+}
+
+// UpdateFailoverPolicy godoc
+// @Summary Update the failover policy
+// @Description While enabled, the active configuration is probed every check_interval seconds. After failure_threshold consecutive failures, the active configuration is switched to the fastest healthy candidate and V2Ray is reloaded.
+// @Description No switch happens within hysteresis seconds of the previous one. Every switch is audited and, if notify_url is set, posted to it as JSON. Candidates must be readable by the admin. Only accessible by admins.
+// @Tags Failover
+// @Accept  json
+// @Produce  json
+// @Param   policy body UpdateFailoverPolicyPayload true "Fields to update"
+// @Success 200 {object} db.FailoverPolicy
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload"
+// @Failure 404 {object} middleware.ErrorResponse "Candidate configuration not found or access denied"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to update failover policy"
+// @Security ApiKeyAuth
+// @Router /failover [put]
+func UpdateFailoverPolicy(c *gin.Context) {
+	var payload UpdateFailoverPolicyPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+
+	policy, err := db.GetFailoverPolicy()
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting failover policy")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update failover policy"})
+		return
+	}
+	if payload.Enabled != nil {
+		policy.Enabled = *payload.Enabled
+	}
+	if payload.CheckInterval != nil {
+		policy.CheckInterval = *payload.CheckInterval
+	}
+	if payload.FailureThreshold != nil {
+		policy.FailureThreshold = *payload.FailureThreshold
+	}
+	if payload.Hysteresis != nil {
+		policy.Hysteresis = *payload.Hysteresis
+	}
+	if payload.CheckHTTP != nil {
+		policy.CheckHTTP = *payload.CheckHTTP
+	}
+	if payload.NotifyURL != nil {
+		if *payload.NotifyURL != "" {
+			u, err := url.Parse(*payload.NotifyURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "notify_url must be an http or https URL"})
+				return
+			}
+		}
+		policy.NotifyURL = *payload.NotifyURL
+	}
+	if payload.Candidates != nil {
+		candidates := *payload.Candidates
+		for i, id := range candidates {
+			if slices.Contains(candidates[:i], id) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Configuration %d is listed more than once", id)})
+				return
+			}
+			if _, ok := loadConfigByID(c, id, db.PermissionRead); !ok {
+				return
+			}
+		}
+		policy.Candidates = candidates
+	}
+
+	if err := db.UpdateFailoverPolicy(c.Request.Context(), *policy); err != nil {
+		log.Error().Err(err).Msg("Error updating failover policy")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update failover policy"})
+		return
+	}
+
+	details := fmt.Sprintf("Failover policy updated: enabled=%t, threshold %d, %d candidates", policy.Enabled, policy.FailureThreshold, len(policy.Candidates))
+	security.LogEvent(c, security.FailoverPolicyUpdated, 0, details)
+	respondFailoverPolicy(c)
+}
+
+// CheckFailover godoc
+// @Summary Run a failover health check now
+// @Description Probes the active configuration immediately and, if this check reaches the failure threshold, fails over as a scheduled check would. This works whether or not the policy is enabled. Only accessible by admins.
+// @Tags Failover
+// @Produce  json
+// @Success 200 {object} failover.Result
+// @Failure 409 {object} middleware.ErrorResponse "No single configuration is active"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to run health check"
+// @Security ApiKeyAuth
+// @Router /failover/check [post]
+func CheckFailover(c *gin.Context) {
+	result, err := failover.Check(c.Request.Context(), time.Now())
+	if err != nil {
+		if errors.Is(err, failover.ErrNoActiveConfig) {
+			c.JSON(http.StatusConflict, gin.H{"error": "No single configuration is active; balancers skip failing members on their own"})
+			return
+		}
+		log.Error().Err(err).Msg("Error running failover health check")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run health check"})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"k2ray/internal/geodata"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// UpdateGeoDataPayload selects the data files to update.
+type UpdateGeoDataPayload struct {
+	Files []string `json:"files" binding:"omitempty,max=2"` // geoip.dat and/or geosite.dat; empty for both
+}
+
+// GeoDataCategoriesResponse lists the categories of a data file.
+type GeoDataCategoriesResponse struct {
+	Categories []string `json:"categories"`
+}
+
+// ListGeoData godoc
+// @Summary List GeoIP and GeoSite data files
+// @Description Describes geoip.dat and geosite.dat as installed in V2Ray's asset directory: size, modification time, SHA-256, and the version and time of the last update through k2ray. Files changed outside k2ray have an empty version. Only accessible by admins.
+// @Tags GeoData
+// @Produce  json
+// @Success 200 {array} geodata.File
+// @Failure 500 {object} middleware.ErrorResponse "Failed to read data files"
+// @Security ApiKeyAuth
+// @Router /geodata [get]
+func ListGeoData(c *gin.Context) {
+	files, err := geodata.List()
+	if err != nil {
+		log.Error().Err(err).Msg("Error reading data files")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read data files"})
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+// UpdateGeoData godoc
+// @Summary Update GeoIP and GeoSite data files
+// @Description Downloads the data files from their configured URLs, verifies each against the SHA-256 checksum published next to it, and swaps them into place together. A running V2Ray is reloaded; if it rejects the new files, the previous ones are restored. Only accessible by admins.
+// @Tags GeoData
+// @Accept  json
+// @Produce  json
+// @Param   files body UpdateGeoDataPayload false "Files to update; all of them if omitted"
+// @Success 200 {array} geodata.File
+// @Failure 400 {object} middleware.ErrorResponse "Unknown data file"
+// @Failure 422 {object} middleware.ErrorResponse "Download, verification or reload failed; the previous files are kept"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to update data files"
+// @Security ApiKeyAuth
+// @Router /geodata/update [post]
+func UpdateGeoData(c *gin.Context) {
+	var payload UpdateGeoDataPayload
+	if err := c.ShouldBindJSON(&payload); err != nil && !errors.Is(err, io.EOF) {
+		c.Error(err)
+		return
+	}
+
+	files, err := geodata.Update(c.Request.Context(), payload.Files)
+	if err != nil {
+		var updateErr *geodata.UpdateError
+		switch {
+		case errors.Is(err, geodata.ErrUnknownFile):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.As(err, &updateErr):
+			log.Warn().Err(err).Msg("Data file update failed")
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to update " + updateErr.Error()})
+		default:
+			log.Error().Err(err).Msg("Error updating data files")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update data files"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+// ListGeoDataCategories godoc
+// @Summary List the categories of a data file
+// @Description Lists the codes that can follow geosite: or geoip: in routing rules, such as "cn" or "google", as found in the installed file. Only accessible by admins.
+// @Tags GeoData
+// @Produce  json
+// @Param kind path string true "Data file (geosite or geoip)"
+// @Success 200 {object} GeoDataCategoriesResponse
+// @Failure 404 {object} middleware.ErrorResponse "Data file not installed"
+// @Failure 422 {object} middleware.ErrorResponse "Data file is corrupt"
+// @Security ApiKeyAuth
+// @Router /geodata/{kind}/categories [get]
+func ListGeoDataCategories(c *gin.Context) {
+	categories, err := geodata.FileCategories(c.Param("kind") + ".dat")
+	if err != nil {
+		switch {
+		case errors.Is(err, geodata.ErrUnknownFile):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown data file; use geosite or geoip"})
+		case errors.Is(err, geodata.ErrNotInstalled):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Data file is not installed"})
+		default:
+			log.Error().Err(err).Str("kind", c.Param("kind")).Msg("Error reading data file categories")
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to read data file: " + err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, GeoDataCategoriesResponse{Categories: categories})
+}
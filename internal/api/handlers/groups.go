@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"k2ray/internal/api/middleware"
+	"k2ray/internal/db"
+	"k2ray/internal/security"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// GroupPayload defines the structure for creating or renaming a group.
+type GroupPayload struct {
+	Name string `json:"name" binding:"required,min=1,max=50"`
+}
+
+// GroupResponse is a group with the number of configurations filed under it.
+type GroupResponse struct {
+	db.ConfigGroup
+	Configs int // Number of configurations filed under the group
+}
+
+const groupColumns = `id, user_id, name, created_at, updated_at`
+
+func scanGroup(row interface{ Scan(...any) error }, g *db.ConfigGroup) error {
+	return row.Scan(&g.ID, &g.UserID, &g.Name, &g.CreatedAt, &g.UpdatedAt)
+}
+
+// loadGroup fetches the requested group if it belongs to the authenticated
+// user, writing the error response itself otherwise.
+func loadGroup(c *gin.Context) (db.ConfigGroup, bool) {
+	var g db.ConfigGroup
+	userID, _ := c.Get(middleware.ContextUserIDKey)
+	row := db.DB.QueryRow("SELECT "+groupColumns+" FROM config_groups WHERE id = ? AND user_id = ?", c.Param("id"), userID)
+	if err := scanGroup(row, &g); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Group not found or access denied"})
+			return g, false
+		}
+		log.Error().Err(err).Str("group_id", c.Param("id")).Msg("Error getting group")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve group"})
+		return g, false
+	}
+	return g, true
+}
+
+// checkGroup verifies that groupID, if set, is one of the user's groups,
+// writing the error response itself otherwise.
+func checkGroup(c *gin.Context, userID int64, groupID *int64) bool {
+	if groupID == nil {
+		return true
+	}
+	var exists bool
+	err := db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM config_groups WHERE id = ? AND user_id = ?)", *groupID, userID).Scan(&exists)
+	if err != nil {
+		log.Error().Err(err).Int64("group_id", *groupID).Msg("Error checking group")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve group"})
+		return false
+	}
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Group %d not found", *groupID)})
+		return false
+	}
+	return true
+}
+
+// isUniqueViolation reports whether err is a UNIQUE constraint failure.
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// CreateGroup godoc
+// @Summary Create a configuration group
+// @Description Creates a folder-like group. Each configuration can be filed under at most one group by setting its group_id.
+// @Tags Groups
+// @Accept  json
+// @Produce  json
+// @Param   group body GroupPayload true "Group details"
+// @Success 201 {object} db.ConfigGroup
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload"
+// @Failure 409 {object} middleware.ErrorResponse "A group with this name already exists"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to create group"
+// @Security ApiKeyAuth
+// @Router /groups [post]
+func CreateGroup(c *gin.Context) {
+	var payload GroupPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+
+	userIDVal, _ := c.Get(middleware.ContextUserIDKey)
+	userID := userIDVal.(int64)
+
+	res, err := db.DB.Exec(`INSERT INTO config_groups (user_id, name) VALUES (?, ?)`, userID, payload.Name)
+	if err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A group with this name already exists"})
+			return
+		}
+		log.Error().Err(err).Msg("Error executing SQL for CreateGroup")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group"})
+		return
+	}
+	newID, _ := res.LastInsertId()
+
+	security.LogEvent(c, security.GroupCreated, newID, fmt.Sprintf("Group '%s' created", payload.Name))
+
+	var g db.ConfigGroup
+	if err := scanGroup(db.DB.QueryRow("SELECT "+groupColumns+" FROM config_groups WHERE id = ?", newID), &g); err != nil {
+		log.Error().Err(err).Int64("group_id", newID).Msg("Error reading back new group")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group"})
+		return
+	}
+	c.JSON(http.StatusCreated, g)
+}
+
+// ListGroups godoc
+// @Summary List configuration groups
+// @Description Retrieves the authenticated user's groups, sorted by name, with the number of configurations in each.
+// @Tags Groups
+// @Produce  json
+// @Success 200 {array} GroupResponse
+// @Failure 500 {object} middleware.ErrorResponse "Failed to retrieve groups"
+// @Security ApiKeyAuth
+// @Router /groups [get]
+func ListGroups(c *gin.Context) {
+	userID, _ := c.Get(middleware.ContextUserIDKey)
+
+	querySQL := `SELECT g.id, g.user_id, g.name, g.created_at, g.updated_at, COUNT(c.id) FROM config_groups g
+		LEFT JOIN configurations c ON c.group_id = g.id WHERE g.user_id = ? GROUP BY g.id ORDER BY g.name`
+	rows, err := db.DB.Query(querySQL, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Error querying groups")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve groups"})
+		return
+	}
+	defer rows.Close()
+
+	groups := []GroupResponse{}
+	for rows.Next() {
+		var g GroupResponse
+		if err := rows.Scan(&g.ID, &g.UserID, &g.Name, &g.CreatedAt, &g.UpdatedAt, &g.Configs); err != nil {
+			log.Error().Err(err).Msg("Error scanning group row")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process groups"})
+			return
+		}
+		groups = append(groups, g)
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+// UpdateGroup godoc
+// @Summary Rename a configuration group
+// @Tags Groups
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Group ID"
+// @Param   group body GroupPayload true "New group name"
+// @Success 200 {object} db.ConfigGroup
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload"
+// @Failure 404 {object} middleware.ErrorResponse "Group not found or access denied"
+// @Failure 409 {object} middleware.ErrorResponse "A group with this name already exists"
+// @Security ApiKeyAuth
+// @Router /groups/{id} [put]
+func UpdateGroup(c *gin.Context) {
+	g, ok := loadGroup(c)
+	if !ok {
+		return
+	}
+
+	var payload GroupPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+
+	originalName := g.Name
+	g.Name = payload.Name
+	_, err := db.DB.Exec(`UPDATE config_groups SET name = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, g.Name, g.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A group with this name already exists"})
+			return
+		}
+		log.Error().Err(err).Int64("group_id", g.ID).Msg("Error executing update for group")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update group"})
+		return
+	}
+
+	security.LogEvent(c, security.GroupUpdated, g.ID, fmt.Sprintf("Group '%s' (was '%s') updated", g.Name, originalName))
+	c.JSON(http.StatusOK, g)
+}
+
+// DeleteGroup godoc
+// @Summary Delete a configuration group
+// @Description Deletes the group. Its configurations are kept and become ungrouped.
+// @Tags Groups
+// @Param id path int true "Group ID"
+// @Success 204
+// @Failure 404 {object} middleware.ErrorResponse "Group not found or access denied"
+// @Security ApiKeyAuth
+// @Router /groups/{id} [delete]
+func DeleteGroup(c *gin.Context) {
+	g, ok := loadGroup(c)
+	if !ok {
+		return
+	}
+
+	if _, err := db.DB.Exec(`DELETE FROM config_groups WHERE id = ?`, g.ID); err != nil {
+		log.Error().Err(err).Int64("group_id", g.ID).Msg("Error deleting group")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete group"})
+		return
+	}
+
+	security.LogEvent(c, security.GroupDeleted, g.ID, fmt.Sprintf("Group '%s' deleted", g.Name))
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"k2ray/internal/api/middleware"
+	"k2ray/internal/db"
+	"k2ray/internal/probe"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// ProbeConfigsPayload defines the payload for probing several configurations at once.
+type ProbeConfigsPayload struct {
+	IDs  []int64 `json:"ids" binding:"required,min=1,max=100"`
+	HTTP bool    `json:"http"` // Also measure an HTTP round trip through a test core
+}
+
+// ProbeConfigsResponse lists the results of a bulk probe, in the order of the requested IDs.
+type ProbeConfigsResponse struct {
+	Results []db.ProbeResult `json:"results"`
+}
+
+// ProbeConfig godoc
+// @Summary Probe a configuration's server
+// @Description Measures the TCP connect time to the server of a configuration owned by or shared with the authenticated user, then the TLS handshake time if the server uses TLS. With http=true, an HTTP request is also sent through a short-lived core running the configuration. The result is recorded in the configuration's probe history. Protocols that run over UDP are reported as unsupported.
+// @Tags Configs
+// @Produce  json
+// @Param id path int true "Configuration ID"
+// @Param http query bool false "Also measure an HTTP round trip through a test core" default(false)
+// @Success 200 {object} db.ProbeResult
+// @Failure 404 {object} middleware.ErrorResponse "Configuration not found or access denied"
+// @Security ApiKeyAuth
+// @Router /configs/{id}/probe [post]
+func ProbeConfig(c *gin.Context) {
+	config, ok := loadConfig(c, db.PermissionRead)
+	if !ok {
+		return
+	}
+	withHTTP, _ := strconv.ParseBool(c.Query("http"))
+
+	results := probe.Configs(c.Request.Context(), []db.Configuration{config}, withHTTP)
+	c.JSON(http.StatusOK, results[0])
+}
+
+// ProbeConfigs godoc
+// @Summary Probe several configurations
+// @Description Probes up to 100 configurations owned by or shared with the authenticated user, a few at a time, and records the results. IDs the user cannot access are skipped.
+// @Tags Configs
+// @Accept  json
+// @Produce  json
+// @Param probe body ProbeConfigsPayload true "Configurations to probe"
+// @Success 200 {object} ProbeConfigsResponse
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to retrieve configurations"
+// @Security ApiKeyAuth
+// @Router /configs/probe [post]
+func ProbeConfigs(c *gin.Context) {
+	var payload ProbeConfigsPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+	userID, _ := c.Get(middleware.ContextUserIDKey)
+
+	accessCondition, args := db.ConfigAccessFilter(userID.(int64), "all")
+	for _, id := range payload.IDs {
+		args = append(args, id)
+	}
+	querySQL := "SELECT " + configColumns + " FROM configurations WHERE " + accessCondition +
+		" AND id IN (?" + strings.Repeat(",?", len(payload.IDs)-1) + ")"
+	rows, err := db.DB.Query(querySQL, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("Error loading configurations to probe")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve configurations"})
+		return
+	}
+	defer rows.Close()
+	byID := make(map[int64]db.Configuration, len(payload.IDs))
+	for rows.Next() {
+		var config db.Configuration
+		if err := scanConfig(rows, &config); err != nil {
+			log.Error().Err(err).Msg("Error scanning config row")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve configurations"})
+			return
+		}
+		byID[config.ID] = config
+	}
+	rows.Close()
+
+	configs := make([]db.Configuration, 0, len(byID))
+	for _, id := range payload.IDs {
+		if config, ok := byID[id]; ok {
+			configs = append(configs, config)
+			delete(byID, id) // Probe duplicates once
+		}
+	}
+
+	results := probe.Configs(c.Request.Context(), configs, payload.HTTP)
+	c.JSON(http.StatusOK, ProbeConfigsResponse{Results: results})
+}
+
+// ListConfigProbes godoc
+// @Summary List a configuration's probe history
+// @Description Returns the most recent probe results of a configuration owned by or shared with the authenticated user, newest first.
+// @Tags Configs
+// @Produce  json
+// @Param id path int true "Configuration ID"
+// @Param limit query int false "Number of results to return (at most 100)" default(20)
+// @Success 200 {array} db.ProbeResult
+// @Failure 404 {object} middleware.ErrorResponse "Configuration not found or access denied"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to retrieve probe results"
+// @Security ApiKeyAuth
+// @Router /configs/{id}/probes [get]
+func ListConfigProbes(c *gin.Context) {
+	config, ok := loadConfig(c, db.PermissionRead)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit < 1 || limit > db.MaxProbeHistory {
+		limit = 20
+	}
+
+	results, err := db.ListProbeResults(config.ID, limit)
+	if err != nil {
+		log.Error().Err(err).Int64("config_id", config.ID).Msg("Error listing probe results")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve probe results"})
+		return
+	}
+	c.JSON(http.StatusOK, results)
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"k2ray/internal/api/middleware"
+	"k2ray/internal/db"
+	"k2ray/internal/security"
+	"k2ray/internal/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// saveConfig stores a configuration's new name, config_data, remarks and group
+// together with a revision authored by the authenticated user, and returns the
+// revision number. The tags are replaced as well unless config.Tags is nil.
+func saveConfig(c *gin.Context, config db.Configuration, comment string) (int, error) {
+	userIDVal, _ := c.Get(middleware.ContextUserIDKey)
+	authorID := userIDVal.(int64)
+
+	ctx := c.Request.Context()
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	updateSQL := `UPDATE configurations SET name = ?, config_data = ?, remarks = ?, group_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	if _, err := tx.ExecContext(ctx, updateSQL, config.Name, config.ConfigData, config.Remarks, config.GroupID, config.ID); err != nil {
+		return 0, err
+	}
+	revision, err := db.AddConfigRevision(ctx, tx, config.ID, config.Name, config.ConfigData, &authorID, comment)
+	if err != nil {
+		return 0, err
+	}
+	if config.Tags != nil {
+		if err := db.SetConfigTags(ctx, tx, config.UserID, config.ID, config.Tags); err != nil {
+			return 0, err
+		}
+	}
+	return revision, tx.Commit()
+}
+
+// loadConfig loads the requested configuration if the authenticated user has
+// at least the need permission on it, writing the error response itself when
+// that is not possible.
+func loadConfig(c *gin.Context, need db.Permission) (db.Configuration, bool) {
+	configID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	return loadConfigByID(c, configID, need)
+}
+
+// loadConfigByID is loadConfig for a configuration ID that is not a path
+// parameter. Configurations the user cannot access at all are reported as not
+// found, so their existence is not revealed.
+func loadConfigByID(c *gin.Context, configID int64, need db.Permission) (db.Configuration, bool) {
+	userIDVal, _ := c.Get(middleware.ContextUserIDKey)
+	userID := userIDVal.(int64)
+
+	var config db.Configuration
+	err := scanConfig(db.DB.QueryRow("SELECT "+configColumns+" FROM configurations WHERE id = ?", configID), &config)
+	if err != nil && err != sql.ErrNoRows {
+		log.Error().Err(err).Int64("config_id", configID).Msg("Error getting config")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve configuration"})
+		return config, false
+	}
+	var access db.ConfigAccess
+	if err == nil {
+		accessByID, err := db.ConfigAccessFor(userID, []int64{config.ID})
+		if err != nil {
+			log.Error().Err(err).Int64("config_id", configID).Msg("Error getting config permissions")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve configuration"})
+			return config, false
+		}
+		access = accessByID[config.ID]
+	}
+	if access.Permission == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Configuration not found or access denied"})
+		return config, false
+	}
+	if !access.Permission.Allows(need) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("This configuration is shared with you as %s only", access.Permission)})
+		return config, false
+	}
+	config.Owner, config.Permission = access.Owner, access.Permission
+	return config, true
+}
+
+// loadRevision loads a revision of config, writing the error response itself
+// when that is not possible.
+func loadRevision(c *gin.Context, config db.Configuration, rev string) (*db.ConfigRevision, bool) {
+	revision, err := strconv.Atoi(rev)
+	if err != nil || revision < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision number"})
+		return nil, false
+	}
+	r, err := db.GetConfigRevision(config.ID, revision)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Revision %d not found", revision)})
+			return nil, false
+		}
+		log.Error().Err(err).Int64("config_id", config.ID).Int("revision", revision).Msg("Error getting config revision")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revision"})
+		return nil, false
+	}
+	return r, true
+}
+
+// ListConfigRevisions godoc
+// @Summary List a configuration's revisions
+// @Description Returns every revision of a configuration owned by or shared with the authenticated user, newest first. A revision is recorded on each create, update and restore; revisions written by subscription refreshes have no author.
+// @Tags Configs
+// @Produce  json
+// @Param id path int true "Configuration ID"
+// @Success 200 {array} db.ConfigRevision
+// @Failure 404 {object} middleware.ErrorResponse "Configuration not found or access denied"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to retrieve revisions"
+// @Security ApiKeyAuth
+// @Router /configs/{id}/revisions [get]
+func ListConfigRevisions(c *gin.Context) {
+	config, ok := loadConfig(c, db.PermissionRead)
+	if !ok {
+		return
+	}
+
+	revisions, err := db.ListConfigRevisions(config.ID)
+	if err != nil {
+		log.Error().Err(err).Int64("config_id", config.ID).Msg("Error listing config revisions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revisions"})
+		return
+	}
+	c.JSON(http.StatusOK, revisions)
+}
+
+// RevisionDiffResponse lists the differences between two revisions. Paths are
+// JSON Pointers into a document with "name" and "config_data" members.
+type RevisionDiffResponse struct {
+	From    int                `json:"from"`
+	To      int                `json:"to"`
+	Changes []utils.JSONChange `json:"changes"`
+}
+
+// DiffConfigRevisions godoc
+// @Summary Compare two revisions of a configuration
+// @Description Returns the field-level differences between two revisions of a configuration owned by or shared with the authenticated user, such as "/name" or "/config_data/port".
+// @Tags Configs
+// @Produce  json
+// @Param id path int true "Configuration ID"
+// @Param from query int true "Revision to compare from"
+// @Param to query int true "Revision to compare to"
+// @Success 200 {object} RevisionDiffResponse
+// @Failure 400 {object} middleware.ErrorResponse "Invalid revision number"
+// @Failure 404 {object} middleware.ErrorResponse "Configuration or revision not found"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to compare revisions"
+// @Security ApiKeyAuth
+// @Router /configs/{id}/revisions/diff [get]
+func DiffConfigRevisions(c *gin.Context) {
+	config, ok := loadConfig(c, db.PermissionRead)
+	if !ok {
+		return
+	}
+	from, ok := loadRevision(c, config, c.Query("from"))
+	if !ok {
+		return
+	}
+	to, ok := loadRevision(c, config, c.Query("to"))
+	if !ok {
+		return
+	}
+
+	changes, err := utils.DiffJSON(revisionDocument(from), revisionDocument(to))
+	if err != nil {
+		log.Error().Err(err).Int64("config_id", config.ID).Msg("Error comparing config revisions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compare revisions"})
+		return
+	}
+	c.JSON(http.StatusOK, RevisionDiffResponse{From: from.Revision, To: to.Revision, Changes: changes})
+}
+
+// revisionDocument is the JSON document two revisions are compared as.
+func revisionDocument(r *db.ConfigRevision) []byte {
+	doc, _ := json.Marshal(struct {
+		Name       string          `json:"name"`
+		ConfigData json.RawMessage `json:"config_data"`
+	}{r.Name, json.RawMessage(r.ConfigData)})
+	return doc
+}
+
+// RestoreRevisionPayload defines the optional body for restoring a revision.
+type RestoreRevisionPayload struct {
+	Comment string `json:"comment" binding:"max=200"`
+}
+
+// RestoreConfigRevision godoc
+// @Summary Restore a revision of a configuration
+// @Description Sets a configuration's name and config_data back to those of an earlier revision. The restore is itself recorded as a new revision, so it can be undone. The old config_data must still pass validation.
+// @Tags Configs
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Configuration ID"
+// @Param rev path int true "Revision number"
+// @Param payload body RestoreRevisionPayload false "Comment for the new revision"
+// @Success 200 {object} db.Configuration
+// @Failure 400 {object} middleware.ErrorResponse "Invalid revision number or the revision no longer validates"
+// @Failure 404 {object} middleware.ErrorResponse "Configuration or revision not found"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to restore revision"
+// @Security ApiKeyAuth
+// @Router /configs/{id}/revisions/{rev}/restore [post]
+func RestoreConfigRevision(c *gin.Context) {
+	var payload RestoreRevisionPayload
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.Error(err)
+			return
+		}
+	}
+
+	config, ok := loadConfig(c, db.PermissionWrite)
+	if !ok {
+		return
+	}
+	r, ok := loadRevision(c, config, c.Param("rev"))
+	if !ok {
+		return
+	}
+
+	if _, err := validateAndDecode(config.Protocol, json.RawMessage(r.ConfigData)); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	comment := payload.Comment
+	if comment == "" {
+		comment = fmt.Sprintf("Restored revision %d", r.Revision)
+	}
+	config.Name, config.ConfigData = r.Name, r.ConfigData
+	revision, err := saveConfig(c, config, comment)
+	if err != nil {
+		log.Error().Err(err).Int64("config_id", config.ID).Msg("Error restoring config revision")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore revision"})
+		return
+	}
+
+	details := fmt.Sprintf("Configuration '%s' restored from revision %d (revision %d)", r.Name, r.Revision, revision)
+	security.LogEvent(c, security.ConfigUpdated, config.ID, details)
+
+	config.UpdatedAt = time.Now()
+	if err := attachTags([]*db.Configuration{&config}); err != nil {
+		log.Error().Err(err).Int64("config_id", config.ID).Msg("Error loading config tags")
+	}
+	c.JSON(http.StatusOK, config)
+}
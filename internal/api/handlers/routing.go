@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"k2ray/internal/db"
+	"k2ray/internal/routing"
+	"k2ray/internal/security"
+	"k2ray/internal/v2ray"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// CreateRoutingRulePayload defines the structure for creating a routing rule.
+// Conditions that are left empty are not used; a rule needs at least one.
+type CreateRoutingRulePayload struct {
+	Name        string   `json:"name" binding:"required,min=1,max=100"`
+	Position    int      `json:"position" binding:"min=0"` // 1-based; 0 or past the end appends the rule
+	Enabled     *bool    `json:"enabled"`                  // Defaults to true
+	Domain      []string `json:"domain" binding:"max=1000"`
+	IP          []string `json:"ip" binding:"max=1000"`
+	Port        string   `json:"port" binding:"max=200"`
+	Network     string   `json:"network"`
+	Protocol    []string `json:"protocol"`
+	InboundTag  []string `json:"inbound_tag"`
+	OutboundTag string   `json:"outbound_tag"`
+}
+
+// UpdateRoutingRulePayload defines the structure for updating a routing rule.
+// Omitted fields keep their current value.
+type UpdateRoutingRulePayload struct {
+	Name        *string   `json:"name" binding:"omitempty,min=1,max=100"`
+	Enabled     *bool     `json:"enabled"`
+	Domain      *[]string `json:"domain" binding:"omitempty,max=1000"`
+	IP          *[]string `json:"ip" binding:"omitempty,max=1000"`
+	Port        *string   `json:"port" binding:"omitempty,max=200"`
+	Network     *string   `json:"network"`
+	Protocol    *[]string `json:"protocol"`
+	InboundTag  *[]string `json:"inbound_tag"`
+	OutboundTag *string   `json:"outbound_tag"`
+}
+
+// ReorderRoutingRulesPayload lists every routing rule ID in the new order.
+type ReorderRoutingRulesPayload struct {
+	IDs []int64 `json:"ids" binding:"required"`
+}
+
+// loadRoutingRule fetches the requested routing rule, writing the error
+// response itself when that is not possible.
+func loadRoutingRule(c *gin.Context) (*db.RoutingRule, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Routing rule not found"})
+		return nil, false
+	}
+	rule, err := db.GetRoutingRule(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Routing rule not found"})
+			return nil, false
+		}
+		log.Error().Err(err).Int64("routing_rule_id", id).Msg("Error getting routing rule")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve routing rule"})
+		return nil, false
+	}
+	return rule, true
+}
+
+// validateRoutingRule checks the syntax of rule, writing a 400 response with
+// the invalid fields itself if it is rejected.
+func validateRoutingRule(c *gin.Context, rule db.RoutingRule) bool {
+	if err := routing.Validate(v2ray.RoutingRule(rule)); err != nil {
+		respondValidationError(c, err)
+		return false
+	}
+	return true
+}
+
+// respondRoutingRules writes the current list of routing rules.
+func respondRoutingRules(c *gin.Context) {
+	rules, err := db.ListRoutingRules(false)
+	if err != nil {
+		log.Error().Err(err).Msg("Error listing routing rules")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve routing rules"})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// ListRoutingRules godoc
+// @Summary List routing rules
+// @Description Retrieves the routing rules in the order they are applied. Only accessible by admins.
+// @Tags Routing
+// @Produce  json
+// @Success 200 {array} db.RoutingRule
+// @Failure 500 {object} middleware.ErrorResponse "Failed to retrieve routing rules"
+// @Security ApiKeyAuth
+// @Router /routing/rules [get]
+func ListRoutingRules(c *gin.Context) {
+	respondRoutingRules(c)
+}
+
+// CreateRoutingRule godoc
+// @Summary Create a routing rule
+// @Description Adds a rule that sends the traffic matching all of its conditions to an outbound (proxy, direct or block).
+// @Description Domains may be plain keywords or use the domain:, full:, keyword:, regexp: or geosite: matchers; IPs may be addresses, CIDRs or geoip: lists.
+// @Description Enabled rules are applied in order ahead of the built-in rules the next time V2Ray is started or reloaded. Only accessible by admins.
+// @Tags Routing
+// @Accept  json
+// @Produce  json
+// @Param   rule body CreateRoutingRulePayload true "Routing rule"
+// @Success 201 {object} db.RoutingRule
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload or rule syntax"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to create routing rule"
+// @Security ApiKeyAuth
+// @Router /routing/rules [post]
+func CreateRoutingRule(c *gin.Context) {
+	var payload CreateRoutingRulePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+
+	rule := db.RoutingRule{
+		Position:    payload.Position,
+		Name:        payload.Name,
+		Enabled:     payload.Enabled == nil || *payload.Enabled,
+		Domain:      payload.Domain,
+		IP:          payload.IP,
+		Port:        payload.Port,
+		Network:     payload.Network,
+		Protocol:    payload.Protocol,
+		InboundTag:  payload.InboundTag,
+		OutboundTag: payload.OutboundTag,
+	}
+	if !validateRoutingRule(c, rule) {
+		return
+	}
+
+	id, err := db.CreateRoutingRule(c.Request.Context(), rule)
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating routing rule")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create routing rule"})
+		return
+	}
+	security.LogEvent(c, security.RoutingRuleCreated, id, fmt.Sprintf("Routing rule '%s' created", rule.Name))
+
+	created, err := db.GetRoutingRule(id)
+	if err != nil {
+		log.Error().Err(err).Int64("routing_rule_id", id).Msg("Error reading back new routing rule")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create routing rule"})
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+// GetRoutingRule godoc
+// @Summary Get a routing rule
+// @Tags Routing
+// @Produce  json
+// @Param id path int true "Routing rule ID"
+// @Success 200 {object} db.RoutingRule
+// @Failure 404 {object} middleware.ErrorResponse "Routing rule not found"
+// @Security ApiKeyAuth
+// @Router /routing/rules/{id} [get]
+func GetRoutingRule(c *gin.Context) {
+	if rule, ok := loadRoutingRule(c); ok {
+		c.JSON(http.StatusOK, rule)
+	}
+}
+
+// UpdateRoutingRule godoc
+// @Summary Update a routing rule
+// @Description Changes the name, state or conditions of a routing rule. Use the reorder endpoint to move it. Only accessible by admins.
+// @Tags Routing
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Routing rule ID"
+// @Param   rule body UpdateRoutingRulePayload true "Fields to update"
+// @Success 200 {object} db.RoutingRule
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload or rule syntax"
+// @Failure 404 {object} middleware.ErrorResponse "Routing rule not found"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to update routing rule"
+// @Security ApiKeyAuth
+// @Router /routing/rules/{id} [put]
+func UpdateRoutingRule(c *gin.Context) {
+	rule, ok := loadRoutingRule(c)
+	if !ok {
+		return
+	}
+
+	var payload UpdateRoutingRulePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+	if payload.Name != nil {
+		rule.Name = *payload.Name
+	}
+	if payload.Enabled != nil {
+		rule.Enabled = *payload.Enabled
+	}
+	if payload.Domain != nil {
+		rule.Domain = *payload.Domain
+	}
+	if payload.IP != nil {
+		rule.IP = *payload.IP
+	}
+	if payload.Port != nil {
+		rule.Port = *payload.Port
+	}
+	if payload.Network != nil {
+		rule.Network = *payload.Network
+	}
+	if payload.Protocol != nil {
+		rule.Protocol = *payload.Protocol
+	}
+	if payload.InboundTag != nil {
+		rule.InboundTag = *payload.InboundTag
+	}
+	if payload.OutboundTag != nil {
+		rule.OutboundTag = *payload.OutboundTag
+	}
+	if !validateRoutingRule(c, *rule) {
+		return
+	}
+
+	if err := db.UpdateRoutingRule(c.Request.Context(), *rule); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Routing rule not found"})
+			return
+		}
+		log.Error().Err(err).Int64("routing_rule_id", rule.ID).Msg("Error updating routing rule")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update routing rule"})
+		return
+	}
+	security.LogEvent(c, security.RoutingRuleUpdated, rule.ID, fmt.Sprintf("Routing rule '%s' updated", rule.Name))
+
+	updated, err := db.GetRoutingRule(rule.ID)
+	if err != nil {
+		log.Error().Err(err).Int64("routing_rule_id", rule.ID).Msg("Error reading back routing rule")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update routing rule"})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteRoutingRule godoc
+// @Summary Delete a routing rule
+// @Description Deletes a routing rule; the rules after it move up by one. Only accessible by admins.
+// @Tags Routing
+// @Param id path int true "Routing rule ID"
+// @Success 204
+// @Failure 404 {object} middleware.ErrorResponse "Routing rule not found"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to delete routing rule"
+// @Security ApiKeyAuth
+// @Router /routing/rules/{id} [delete]
+func DeleteRoutingRule(c *gin.Context) {
+	rule, ok := loadRoutingRule(c)
+	if !ok {
+		return
+	}
+
+	if err := db.DeleteRoutingRule(c.Request.Context(), rule.ID); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Routing rule not found"})
+			return
+		}
+		log.Error().Err(err).Int64("routing_rule_id", rule.ID).Msg("Error deleting routing rule")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete routing rule"})
+		return
+	}
+	security.LogEvent(c, security.RoutingRuleDeleted, rule.ID, fmt.Sprintf("Routing rule '%s' deleted", rule.Name))
+	c.Status(http.StatusNoContent)
+}
+
+// ReorderRoutingRules godoc
+// @Summary Reorder routing rules
+// @Description Sets the order in which routing rules are applied. The request must list every rule ID exactly once. Only accessible by admins.
+// @Tags Routing
+// @Accept  json
+// @Produce  json
+// @Param   order body ReorderRoutingRulesPayload true "Rule IDs in the new order"
+// @Success 200 {array} db.RoutingRule
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload or incomplete order"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to reorder routing rules"
+// @Security ApiKeyAuth
+// @Router /routing/rules/reorder [post]
+func ReorderRoutingRules(c *gin.Context) {
+	var payload ReorderRoutingRulesPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := db.ReorderRoutingRules(c.Request.Context(), payload.IDs); err != nil {
+		if errors.Is(err, db.ErrRuleOrderMismatch) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The new order must list every routing rule exactly once"})
+			return
+		}
+		log.Error().Err(err).Msg("Error reordering routing rules")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reorder routing rules"})
+		return
+	}
+	security.LogEvent(c, security.RoutingRulesReordered, 0, fmt.Sprintf("Routing rules reordered: %v", payload.IDs))
+	respondRoutingRules(c)
+}
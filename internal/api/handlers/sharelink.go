@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"k2ray/internal/api/middleware"
+	"k2ray/internal/archive"
+	"k2ray/internal/db"
+	"k2ray/internal/sharelink"
+	"k2ray/internal/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog/log"
+)
+
+// ImportConfigsPayload defines the structure for importing share links.
+type ImportConfigsPayload struct {
+	Links []string `json:"links" binding:"required,min=1,max=100"`
+}
+
+// ImportResult reports the outcome of importing a single share link.
+type ImportResult struct {
+	Index    int               `json:"index"`
+	Success  bool              `json:"success"`
+	Name     string            `json:"name,omitempty"`
+	Protocol string            `json:"protocol,omitempty"`
+	Config   *db.Configuration `json:"config,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+}
+
+// ImportConfigsResponse is the response for a share link import.
+type ImportConfigsResponse struct {
+	Imported int            `json:"imported"`
+	Failed   int            `json:"failed"`
+	Results  []ImportResult `json:"results"`
+}
+
+// ImportConfigs godoc
+// @Summary Import configurations from share links or an archive
+// @Description Parses vmess://, vless://, ss://, trojan://, hysteria2:// and tuic:// share links, validates each one and stores the valid ones for the authenticated user. Each link is reported separately, so one bad link does not fail the whole import.
+// @Description The body may instead be an archive from GET /configs/export?format=k2ray, recognized by its "format" member, in which case the response is an ArchiveImportResponse. Archived configurations are matched to existing ones by name; strategy decides what happens on a match, and dry_run reports what would change without changing anything.
+// @Tags Configs
+// @Accept  json
+// @Produce  json
+// @Param   links body ImportConfigsPayload true "Share links to import, or a k2ray archive"
+// @Param strategy query string false "Archive only: what to do when a configuration with the same name exists (skip, overwrite, rename)" default(skip)
+// @Param dry_run query bool false "Archive only: report what would change without changing anything" default(false)
+// @Success 200 {object} ImportConfigsResponse
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload or archive"
+// @Security ApiKeyAuth
+// @Router /configs/import [post]
+func ImportConfigs(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	userIDVal, _ := c.Get(middleware.ContextUserIDKey)
+	userID := userIDVal.(int64)
+
+	if archive.IsArchive(body) {
+		importArchive(c, userID, body)
+		return
+	}
+	var payload ImportConfigsPayload
+	if err := binding.JSON.BindBody(body, &payload); err != nil {
+		c.Error(err)
+		return
+	}
+
+	resp := ImportConfigsResponse{Results: make([]ImportResult, 0, len(payload.Links))}
+	for i, raw := range payload.Links {
+		result := importLink(c, userID, raw)
+		result.Index = i
+		if result.Success {
+			resp.Imported++
+		} else {
+			resp.Failed++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func importLink(c *gin.Context, userID int64, raw string) ImportResult {
+	link, err := sharelink.Parse(raw)
+	if err != nil {
+		return ImportResult{Error: err.Error()}
+	}
+	result := ImportResult{Name: link.Name, Protocol: link.Protocol}
+
+	configDataBytes, err := json.Marshal(link.ConfigData)
+	if err != nil {
+		result.Error = "Invalid format for config_data"
+		return result
+	}
+	if _, err := validateAndDecode(link.Protocol, configDataBytes); err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			result.Error, result.Details = verr.Msg, verr.Fields
+		} else {
+			result.Error = "An unexpected error occurred during validation"
+		}
+		return result
+	}
+
+	newConfig, err := insertConfig(c, db.Configuration{
+		UserID:     userID,
+		Name:       link.Name,
+		Protocol:   link.Protocol,
+		ConfigData: string(configDataBytes),
+	}, "Imported from share link")
+	if err != nil {
+		log.Error().Err(err).Msg("Error executing SQL for ImportConfigs")
+		result.Error = "Failed to create configuration"
+		return result
+	}
+
+	result.Success = true
+	result.Config = &newConfig
+	return result
+}
+
+// ShareLinkResponse is the response for a configuration's share link.
+type ShareLinkResponse struct {
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+	Link     string `json:"link"`
+}
+
+// GetConfigShareLink godoc
+// @Summary Get a configuration's share link
+// @Description Returns the canonical vmess://, vless://, ss://, trojan://, hysteria2:// or tuic:// share link for a configuration owned by or shared with the authenticated user.
+// @Tags Configs
+// @Produce  json
+// @Param id path int true "Configuration ID"
+// @Success 200 {object} ShareLinkResponse
+// @Failure 404 {object} middleware.ErrorResponse "Configuration not found or access denied"
+// @Failure 422 {object} middleware.ErrorResponse "Configuration cannot be expressed as a share link"
+// @Security ApiKeyAuth
+// @Router /configs/{id}/share [get]
+func GetConfigShareLink(c *gin.Context) {
+	config, link, ok := configShareLink(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, ShareLinkResponse{Name: config.Name, Protocol: config.Protocol, Link: link})
+}
+
+// GetConfigQRCode godoc
+// @Summary Get a configuration's share link as a QR code
+// @Description Returns the configuration's share link encoded as a PNG QR code.
+// @Tags Configs
+// @Produce  png
+// @Param id path int true "Configuration ID"
+// @Param size query int false "Image width and height in pixels (128-1024)" default(256)
+// @Success 200 {file} file "PNG image"
+// @Failure 400 {object} middleware.ErrorResponse "Invalid size"
+// @Failure 404 {object} middleware.ErrorResponse "Configuration not found or access denied"
+// @Failure 422 {object} middleware.ErrorResponse "Configuration cannot be expressed as a share link"
+// @Security ApiKeyAuth
+// @Router /configs/{id}/qr.png [get]
+func GetConfigQRCode(c *gin.Context) {
+	size, err := strconv.Atoi(c.DefaultQuery("size", "256"))
+	if err != nil || size < 128 || size > 1024 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size must be between 128 and 1024"})
+		return
+	}
+
+	_, link, ok := configShareLink(c)
+	if !ok {
+		return
+	}
+
+	png, err := utils.QRCodePNG(link, size)
+	if err != nil {
+		log.Error().Err(err).Str("config_id", c.Param("id")).Msg("Error generating config QR code")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+// configShareLink loads the requested configuration and renders its share link,
+// writing the error response itself when that is not possible.
+func configShareLink(c *gin.Context) (db.Configuration, string, bool) {
+	config, ok := loadConfig(c, db.PermissionRead)
+	if !ok {
+		return config, "", false
+	}
+
+	link, err := sharelink.Format(config.Name, config.Protocol, []byte(config.ConfigData))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Configuration cannot be exported as a share link: " + err.Error()})
+		return config, "", false
+	}
+	return config, link, true
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"k2ray/internal/db"
+	"k2ray/internal/security"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// ShareConfigPayload defines the structure for sharing a configuration with a
+// user or a user group. Exactly one of user and user_group must be set.
+type ShareConfigPayload struct {
+	User       string `json:"user" binding:"required_without=UserGroup,excluded_with=UserGroup"` // Username
+	UserGroup  string `json:"user_group"`                                                        // User group name
+	Permission string `json:"permission" binding:"required,oneof=read write"`
+}
+
+const selectShareSQL = `SELECT s.id, s.configuration_id, s.user_id, u.username, s.user_group_id, g.name, s.permission, s.created_at
+	FROM config_shares s LEFT JOIN users u ON u.id = s.user_id LEFT JOIN user_groups g ON g.id = s.user_group_id`
+
+func scanShare(row interface{ Scan(...any) error }, s *db.ConfigShare) error {
+	return row.Scan(&s.ID, &s.ConfigurationID, &s.UserID, &s.Username, &s.UserGroupID, &s.UserGroup, &s.Permission, &s.CreatedAt)
+}
+
+// attachAccess sets the owner of configs and the user's permission on each.
+func attachAccess(userID int64, configs []*db.Configuration) error {
+	ids := make([]int64, len(configs))
+	for i, config := range configs {
+		ids[i] = config.ID
+	}
+	access, err := db.ConfigAccessFor(userID, ids)
+	if err != nil {
+		return err
+	}
+	for _, config := range configs {
+		config.Owner, config.Permission = access[config.ID].Owner, access[config.ID].Permission
+	}
+	return nil
+}
+
+// ListConfigShares godoc
+// @Summary List a configuration's shares
+// @Description Returns the users and user groups a configuration is shared with. Only the owner can see them.
+// @Tags Configs
+// @Produce  json
+// @Param id path int true "Configuration ID"
+// @Success 200 {array} db.ConfigShare
+// @Failure 403 {object} middleware.ErrorResponse "Configuration is shared with the user"
+// @Failure 404 {object} middleware.ErrorResponse "Configuration not found or access denied"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to retrieve shares"
+// @Security ApiKeyAuth
+// @Router /configs/{id}/shares [get]
+func ListConfigShares(c *gin.Context) {
+	config, ok := loadConfig(c, db.PermissionOwner)
+	if !ok {
+		return
+	}
+
+	rows, err := db.DB.Query(selectShareSQL+" WHERE s.configuration_id = ? ORDER BY s.id", config.ID)
+	if err != nil {
+		log.Error().Err(err).Int64("config_id", config.ID).Msg("Error querying config shares")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve shares"})
+		return
+	}
+	defer rows.Close()
+
+	shares := []db.ConfigShare{}
+	for rows.Next() {
+		var s db.ConfigShare
+		if err := scanShare(rows, &s); err != nil {
+			log.Error().Err(err).Msg("Error scanning config share row")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve shares"})
+			return
+		}
+		shares = append(shares, s)
+	}
+	c.JSON(http.StatusOK, shares)
+}
+
+// ShareConfig godoc
+// @Summary Share a configuration
+// @Description Shares a configuration with a user or a user group. Read access lets them view, export and activate it; write access also lets them edit it and restore revisions. Only the owner can share, delete or change the group and tags of a configuration. Sharing again with the same user or group changes the permission.
+// @Tags Configs
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Configuration ID"
+// @Param   share body ShareConfigPayload true "Who to share with"
+// @Success 201 {object} db.ConfigShare "Shared"
+// @Success 200 {object} db.ConfigShare "Permission changed"
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload or unknown user"
+// @Failure 403 {object} middleware.ErrorResponse "Configuration is shared with the user"
+// @Failure 404 {object} middleware.ErrorResponse "Configuration not found or access denied"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to share configuration"
+// @Security ApiKeyAuth
+// @Router /configs/{id}/shares [post]
+func ShareConfig(c *gin.Context) {
+	config, ok := loadConfig(c, db.PermissionOwner)
+	if !ok {
+		return
+	}
+
+	var payload ShareConfigPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+
+	target, column := payload.User, "user_id"
+	var targetID int64
+	var err error
+	if payload.User != "" {
+		err = db.DB.QueryRow("SELECT id FROM users WHERE username = ?", payload.User).Scan(&targetID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User '%s' not found", payload.User)})
+			return
+		}
+		if err == nil && targetID == config.UserID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A configuration cannot be shared with its owner"})
+			return
+		}
+	} else {
+		target, column = payload.UserGroup, "user_group_id"
+		err = db.DB.QueryRow("SELECT id FROM user_groups WHERE name = ?", payload.UserGroup).Scan(&targetID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User group '%s' not found", payload.UserGroup)})
+			return
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Int64("config_id", config.ID).Msg("Error resolving share target")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share configuration"})
+		return
+	}
+
+	status := http.StatusOK
+	var shareID int64
+	err = db.DB.QueryRow("SELECT id FROM config_shares WHERE configuration_id = ? AND "+column+" = ?", config.ID, targetID).Scan(&shareID)
+	switch err {
+	case nil:
+		_, err = db.DB.Exec("UPDATE config_shares SET permission = ? WHERE id = ?", payload.Permission, shareID)
+	case sql.ErrNoRows:
+		status = http.StatusCreated
+		var res sql.Result
+		res, err = db.DB.Exec("INSERT INTO config_shares (configuration_id, "+column+", permission) VALUES (?, ?, ?)", config.ID, targetID, payload.Permission)
+		if err == nil {
+			shareID, _ = res.LastInsertId()
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Int64("config_id", config.ID).Msg("Error saving config share")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share configuration"})
+		return
+	}
+
+	kind := "user"
+	if column == "user_group_id" {
+		kind = "user group"
+	}
+	details := fmt.Sprintf("Configuration '%s' shared with %s '%s' (%s)", config.Name, kind, target, payload.Permission)
+	security.LogEvent(c, security.ConfigShared, config.ID, details)
+
+	var share db.ConfigShare
+	if err := scanShare(db.DB.QueryRow(selectShareSQL+" WHERE s.id = ?", shareID), &share); err != nil {
+		log.Error().Err(err).Int64("share_id", shareID).Msg("Error reading back config share")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share configuration"})
+		return
+	}
+	c.JSON(status, share)
+}
+
+// UnshareConfig godoc
+// @Summary Stop sharing a configuration
+// @Description Removes a share, revoking the access it granted.
+// @Tags Configs
+// @Param id path int true "Configuration ID"
+// @Param share_id path int true "Share ID"
+// @Success 204
+// @Failure 403 {object} middleware.ErrorResponse "Configuration is shared with the user"
+// @Failure 404 {object} middleware.ErrorResponse "Configuration or share not found"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to remove share"
+// @Security ApiKeyAuth
+// @Router /configs/{id}/shares/{share_id} [delete]
+func UnshareConfig(c *gin.Context) {
+	config, ok := loadConfig(c, db.PermissionOwner)
+	if !ok {
+		return
+	}
+
+	var share db.ConfigShare
+	err := scanShare(db.DB.QueryRow(selectShareSQL+" WHERE s.id = ? AND s.configuration_id = ?", c.Param("share_id"), config.ID), &share)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share not found"})
+			return
+		}
+		log.Error().Err(err).Str("share_id", c.Param("share_id")).Msg("Error getting config share")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove share"})
+		return
+	}
+
+	if _, err := db.DB.Exec("DELETE FROM config_shares WHERE id = ?", share.ID); err != nil {
+		log.Error().Err(err).Int64("share_id", share.ID).Msg("Error deleting config share")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove share"})
+		return
+	}
+
+	target := "a deleted user"
+	if share.Username != nil {
+		target = fmt.Sprintf("user '%s'", *share.Username)
+	} else if share.UserGroup != nil {
+		target = fmt.Sprintf("user group '%s'", *share.UserGroup)
+	}
+	security.LogEvent(c, security.ConfigUnshared, config.ID, fmt.Sprintf("Configuration '%s' no longer shared with %s", config.Name, target))
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"database/sql"
+	"k2ray/internal/api/middleware"
+	"k2ray/internal/db"
+	"k2ray/internal/export"
+	"k2ray/internal/security"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// SubscriptionTokenResponse describes the authenticated user's subscription token.
+type SubscriptionTokenResponse struct {
+	Active     bool       `json:"active"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// RotateSubscriptionTokenResponse contains a newly issued subscription token.
+// The token is only ever shown here; k2ray stores just its hash.
+type RotateSubscriptionTokenResponse struct {
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// GetSubscriptionToken godoc
+// @Summary Get subscription token status
+// @Description Reports whether the authenticated user has a subscription token and when it was issued and last used. The token itself cannot be retrieved again.
+// @Tags Users
+// @Produce  json
+// @Success 200 {object} SubscriptionTokenResponse
+// @Security ApiKeyAuth
+// @Router /users/me/subscription-token [get]
+func GetSubscriptionToken(c *gin.Context) {
+	userID, _ := c.Get(middleware.ContextUserIDKey)
+
+	token, err := db.GetSubscriptionToken(userID.(int64))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, SubscriptionTokenResponse{})
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting subscription token")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve subscription token"})
+		return
+	}
+	c.JSON(http.StatusOK, SubscriptionTokenResponse{Active: true, CreatedAt: &token.CreatedAt, LastUsedAt: token.LastUsedAt})
+}
+
+// RotateSubscriptionToken godoc
+// @Summary Issue a new subscription token
+// @Description Issues a new subscription token for the authenticated user and revokes the previous one. Client apps fetch GET /sub/{token} without a JWT.
+// @Tags Users
+// @Produce  json
+// @Success 201 {object} RotateSubscriptionTokenResponse
+// @Security ApiKeyAuth
+// @Router /users/me/subscription-token/rotate [post]
+func RotateSubscriptionToken(c *gin.Context) {
+	userID, _ := c.Get(middleware.ContextUserIDKey)
+
+	token, err := db.RotateSubscriptionToken(userID.(int64))
+	if err != nil {
+		log.Error().Err(err).Msg("Error rotating subscription token")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue subscription token"})
+		return
+	}
+	security.LogEvent(c, security.SubscriptionTokenRotated, userID.(int64), "Subscription token issued")
+
+	c.JSON(http.StatusCreated, RotateSubscriptionTokenResponse{Token: token, URL: subscriptionURL(c, token)})
+}
+
+// RevokeSubscriptionToken godoc
+// @Summary Revoke the subscription token
+// @Tags Users
+// @Success 204
+// @Failure 404 {object} middleware.ErrorResponse "No subscription token to revoke"
+// @Security ApiKeyAuth
+// @Router /users/me/subscription-token [delete]
+func RevokeSubscriptionToken(c *gin.Context) {
+	userID, _ := c.Get(middleware.ContextUserIDKey)
+
+	revoked, err := db.RevokeSubscriptionToken(userID.(int64))
+	if err != nil {
+		log.Error().Err(err).Msg("Error revoking subscription token")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke subscription token"})
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No subscription token to revoke"})
+		return
+	}
+	security.LogEvent(c, security.SubscriptionTokenRevoked, userID.(int64), "Subscription token revoked")
+
+	c.Status(http.StatusNoContent)
+}
+
+// ServeSubscription godoc
+// @Summary Subscription feed for client apps
+// @Description Returns the token owner's configurations. Authenticated by the token in the path instead of a JWT.
+// @Tags Subscriptions
+// @Produce  plain
+// @Param token path string true "Subscription token"
+// @Param format query string false "Output format (base64, clash, clash-meta, sing-box)" default(base64)
+// @Param outbounds_only query bool false "sing-box: render only the outbounds array instead of a full config" default(false)
+// @Param inbound query string false "sing-box: inbound of the full config (mixed, tun)" default(mixed)
+// @Success 200 {string} string "Subscription document"
+// @Failure 400 {object} middleware.ErrorResponse "Unknown format or invalid options"
+// @Failure 404 {object} middleware.ErrorResponse "Unknown or revoked token"
+// @Router /sub/{token} [get]
+func ServeSubscription(c *gin.Context) {
+	format := export.Format(c.DefaultQuery("format", string(export.FormatBase64)))
+	opts, err := exportOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := db.ResolveSubscriptionToken(c.Param("token"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error resolving subscription token")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load subscription"})
+		return
+	}
+
+	configs, err := userConfigs(userID)
+	if err != nil {
+		log.Error().Err(err).Int64("user_id", userID).Msg("Error loading configurations for subscription")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load subscription"})
+		return
+	}
+
+	body, contentType, err := export.Render(format, configs, opts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, contentType, body)
+}
+
+// userConfigs loads every configuration owned by the user, oldest first.
+func userConfigs(userID int64) ([]db.Configuration, error) {
+	rows, err := db.DB.Query("SELECT "+configColumns+" FROM configurations WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var configs []db.Configuration
+	for rows.Next() {
+		var config db.Configuration
+		if err := scanConfig(rows, &config); err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+	return configs, rows.Err()
+}
+
+// subscriptionURL builds the absolute /sub URL for token as seen by the client.
+func subscriptionURL(c *gin.Context, token string) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + "/sub/" + token
+}
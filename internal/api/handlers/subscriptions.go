@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"k2ray/internal/api/middleware"
+	"k2ray/internal/auth"
+	"k2ray/internal/db"
+	"k2ray/internal/security"
+	"k2ray/internal/subscription"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultRefreshInterval is used when a subscription is created without one, in minutes.
+const defaultRefreshInterval = 60
+
+// CreateSubscriptionPayload defines the structure for registering a subscription feed.
+type CreateSubscriptionPayload struct {
+	Name            string `json:"name" binding:"required,min=3,max=50"`
+	URL             string `json:"url" binding:"required,url,max=2048"`
+	RefreshInterval int    `json:"refresh_interval" binding:"omitempty,min=5,max=10080"` // Minutes
+}
+
+// UpdateSubscriptionPayload defines the structure for updating a subscription feed.
+type UpdateSubscriptionPayload struct {
+	Name            *string `json:"name" binding:"omitempty,min=3,max=50"`
+	URL             *string `json:"url" binding:"omitempty,url,max=2048"`
+	RefreshInterval *int    `json:"refresh_interval" binding:"omitempty,min=5,max=10080"`
+}
+
+// RefreshSubscriptionResponse is the response for a manual subscription refresh.
+type RefreshSubscriptionResponse struct {
+	Result *subscription.Result `json:"result"`
+	Error  string               `json:"error,omitempty"`
+}
+
+const subscriptionColumns = `id, user_id, name, url, refresh_interval, last_refreshed_at, last_error, created_at, updated_at`
+
+func scanSubscription(row interface{ Scan(...any) error }, s *db.Subscription) error {
+	return row.Scan(&s.ID, &s.UserID, &s.Name, &s.URL, &s.RefreshInterval, &s.LastRefreshedAt, &s.LastError, &s.CreatedAt, &s.UpdatedAt)
+}
+
+// loadSubscription fetches the requested subscription if it belongs to the
+// authenticated user, writing the error response itself otherwise.
+func loadSubscription(c *gin.Context) (db.Subscription, bool) {
+	var s db.Subscription
+	userID, _ := c.Get(middleware.ContextUserIDKey)
+	row := db.DB.QueryRow("SELECT "+subscriptionColumns+" FROM subscriptions WHERE id = ? AND user_id = ?", c.Param("id"), userID)
+	if err := scanSubscription(row, &s); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found or access denied"})
+			return s, false
+		}
+		log.Error().Err(err).Str("subscription_id", c.Param("id")).Msg("Error getting subscription")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve subscription"})
+		return s, false
+	}
+	return s, true
+}
+
+// isAdmin reports whether the authenticated user has the admin role.
+func isAdmin(c *gin.Context) bool {
+	claims, _ := c.Get(middleware.ContextUserClaimsKey)
+	userClaims, ok := claims.(*auth.Claims)
+	return ok && userClaims.Role == db.AdminRole
+}
+
+// CreateSubscription godoc
+// @Summary Register a subscription feed
+// @Description Registers a base64 share link feed. The feed is fetched in the background on the next scheduler pass and then every refresh_interval minutes. Only admins may register feeds on private, loopback or link-local addresses.
+// @Tags Subscriptions
+// @Accept  json
+// @Produce  json
+// @Param   subscription body CreateSubscriptionPayload true "Subscription details"
+// @Success 201 {object} db.Subscription
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to create subscription"
+// @Security ApiKeyAuth
+// @Router /subscriptions [post]
+func CreateSubscription(c *gin.Context) {
+	var payload CreateSubscriptionPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+	if err := subscription.ValidateURL(c.Request.Context(), payload.URL, isAdmin(c)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if payload.RefreshInterval == 0 {
+		payload.RefreshInterval = defaultRefreshInterval
+	}
+
+	userIDVal, _ := c.Get(middleware.ContextUserIDKey)
+	userID := userIDVal.(int64)
+
+	res, err := db.DB.Exec(`INSERT INTO subscriptions (user_id, name, url, refresh_interval) VALUES (?, ?, ?, ?)`,
+		userID, payload.Name, payload.URL, payload.RefreshInterval)
+	if err != nil {
+		log.Error().Err(err).Msg("Error executing SQL for CreateSubscription")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create subscription"})
+		return
+	}
+	newID, _ := res.LastInsertId()
+
+	details := fmt.Sprintf("Subscription '%s' created", payload.Name)
+	security.LogEvent(c, security.SubscriptionCreated, newID, details)
+
+	var s db.Subscription
+	if err := scanSubscription(db.DB.QueryRow("SELECT "+subscriptionColumns+" FROM subscriptions WHERE id = ?", newID), &s); err != nil {
+		log.Error().Err(err).Int64("subscription_id", newID).Msg("Error reading back new subscription")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create subscription"})
+		return
+	}
+	c.JSON(http.StatusCreated, s)
+}
+
+// ListSubscriptions godoc
+// @Summary List subscription feeds
+// @Description Retrieves the authenticated user's subscription feeds.
+// @Tags Subscriptions
+// @Produce  json
+// @Success 200 {array} db.Subscription
+// @Failure 500 {object} middleware.ErrorResponse "Failed to retrieve subscriptions"
+// @Security ApiKeyAuth
+// @Router /subscriptions [get]
+func ListSubscriptions(c *gin.Context) {
+	userID, _ := c.Get(middleware.ContextUserIDKey)
+
+	rows, err := db.DB.Query("SELECT "+subscriptionColumns+" FROM subscriptions WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Error querying subscriptions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve subscriptions"})
+		return
+	}
+	defer rows.Close()
+
+	subs := []db.Subscription{}
+	for rows.Next() {
+		var s db.Subscription
+		if err := scanSubscription(rows, &s); err != nil {
+			log.Error().Err(err).Msg("Error scanning subscription row")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process subscriptions"})
+			return
+		}
+		subs = append(subs, s)
+	}
+	c.JSON(http.StatusOK, subs)
+}
+
+// GetSubscription godoc
+// @Summary Get a subscription feed
+// @Tags Subscriptions
+// @Produce  json
+// @Param id path int true "Subscription ID"
+// @Success 200 {object} db.Subscription
+// @Failure 404 {object} middleware.ErrorResponse "Subscription not found or access denied"
+// @Security ApiKeyAuth
+// @Router /subscriptions/{id} [get]
+func GetSubscription(c *gin.Context) {
+	s, ok := loadSubscription(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// UpdateSubscription godoc
+// @Summary Update a subscription feed
+// @Tags Subscriptions
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Subscription ID"
+// @Param   subscription body UpdateSubscriptionPayload true "Fields to update"
+// @Success 200 {object} db.Subscription
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload"
+// @Failure 404 {object} middleware.ErrorResponse "Subscription not found or access denied"
+// @Security ApiKeyAuth
+// @Router /subscriptions/{id} [put]
+func UpdateSubscription(c *gin.Context) {
+	s, ok := loadSubscription(c)
+	if !ok {
+		return
+	}
+
+	var payload UpdateSubscriptionPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+	if payload.Name != nil {
+		s.Name = *payload.Name
+	}
+	if payload.URL != nil {
+		if err := subscription.ValidateURL(c.Request.Context(), *payload.URL, isAdmin(c)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		s.URL = *payload.URL
+	}
+	if payload.RefreshInterval != nil {
+		s.RefreshInterval = *payload.RefreshInterval
+	}
+
+	_, err := db.DB.Exec(`UPDATE subscriptions SET name = ?, url = ?, refresh_interval = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		s.Name, s.URL, s.RefreshInterval, s.ID)
+	if err != nil {
+		log.Error().Err(err).Int64("subscription_id", s.ID).Msg("Error executing update for subscription")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update subscription"})
+		return
+	}
+
+	details := fmt.Sprintf("Subscription '%s' updated", s.Name)
+	security.LogEvent(c, security.SubscriptionUpdated, s.ID, details)
+
+	c.JSON(http.StatusOK, s)
+}
+
+// DeleteSubscription godoc
+// @Summary Delete a subscription feed
+// @Description Deletes the subscription and every configuration it manages.
+// @Tags Subscriptions
+// @Param id path int true "Subscription ID"
+// @Success 204
+// @Failure 404 {object} middleware.ErrorResponse "Subscription not found or access denied"
+// @Security ApiKeyAuth
+// @Router /subscriptions/{id} [delete]
+func DeleteSubscription(c *gin.Context) {
+	s, ok := loadSubscription(c)
+	if !ok {
+		return
+	}
+
+	if err := subscription.Delete(c.Request.Context(), s.ID); err != nil && !errors.Is(err, subscription.ErrNotFound) {
+		log.Error().Err(err).Int64("subscription_id", s.ID).Msg("Error deleting subscription")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete subscription"})
+		return
+	}
+
+	details := fmt.Sprintf("Subscription '%s' deleted", s.Name)
+	security.LogEvent(c, security.SubscriptionDeleted, s.ID, details)
+
+	c.Status(http.StatusNoContent)
+}
+
+// RefreshSubscription godoc
+// @Summary Refresh a subscription feed now
+// @Description Fetches the feed immediately and syncs its configurations. Responds with 502 if the feed could not be fetched or contained no valid links.
+// @Tags Subscriptions
+// @Produce  json
+// @Param id path int true "Subscription ID"
+// @Success 200 {object} RefreshSubscriptionResponse
+// @Failure 404 {object} middleware.ErrorResponse "Subscription not found or access denied"
+// @Failure 502 {object} RefreshSubscriptionResponse "Feed could not be refreshed"
+// @Security ApiKeyAuth
+// @Router /subscriptions/{id}/refresh [post]
+func RefreshSubscription(c *gin.Context) {
+	s, ok := loadSubscription(c)
+	if !ok {
+		return
+	}
+
+	result, err := subscription.Refresh(c.Request.Context(), s.ID)
+	if err != nil {
+		if errors.Is(err, subscription.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found or access denied"})
+			return
+		}
+		c.JSON(http.StatusBadGateway, RefreshSubscriptionResponse{Result: result, Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, RefreshSubscriptionResponse{Result: result})
+}
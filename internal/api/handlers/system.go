@@ -3,10 +3,11 @@ package handlers
 import (
 	"context"
 	"database/sql"
-	"k2ray/internal/api/middleware"
+	"errors"
 	"k2ray/internal/db"
 	"k2ray/internal/redis"
 	"k2ray/internal/system"
+	"k2ray/internal/v2ray"
 	"net/http"
 	"time"
 
@@ -24,11 +25,16 @@ func SystemStatus(c *gin.Context) {
 }
 
 // SetActiveConfigPayload defines the structure for setting the active config.
+// Exactly one of ConfigID and BalancerID must be set.
 type SetActiveConfigPayload struct {
-	ConfigID int64 `json:"config_id" binding:"required"`
+	ConfigID   *int64 `json:"config_id"`
+	BalancerID *int64 `json:"balancer_id"`
 }
 
-// SetActiveConfig sets the system-wide active V2Ray configuration.
+// SetActiveConfig sets the system-wide active V2Ray configuration, or a balancer
+// that spreads traffic across several configurations.
+// It must pass validation first. If V2Ray is running, it is reloaded immediately;
+// should the reload fail, the previous selection is restored as well.
 func SetActiveConfig(c *gin.Context) {
 	var payload SetActiveConfigPayload
 	if err := c.ShouldBindJSON(&payload); err != nil {
@@ -36,39 +42,68 @@ func SetActiveConfig(c *gin.Context) {
 		return
 	}
 
-	userID, _ := c.Get(middleware.ContextUserIDKey)
-
-	var exists bool
-	err := db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM configurations WHERE id = ? AND user_id = ?)", payload.ConfigID, userID).Scan(&exists)
-	if err != nil || !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Configuration not found or access denied"})
+	var sel v2ray.Selection
+	switch {
+	case payload.ConfigID != nil && payload.BalancerID == nil:
+		// Configurations shared read-only may be activated too: activating does not change them.
+		config, ok := loadConfigByID(c, *payload.ConfigID, db.PermissionRead)
+		if !ok {
+			return
+		}
+		sel = v2ray.Selection{Key: ActiveConfigKey, ID: config.ID}
+	case payload.BalancerID != nil && payload.ConfigID == nil:
+		balancer, ok := loadBalancerByID(c, *payload.BalancerID)
+		if !ok {
+			return
+		}
+		// Members may have been unshared or changed since the balancer was saved.
+		if !checkBalancer(c, balancer.UserID, balancer) {
+			return
+		}
+		sel = v2ray.Selection{Key: v2ray.ActiveBalancerKey, ID: balancer.ID}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Exactly one of config_id and balancer_id must be set"})
 		return
 	}
 
-	upsertSQL := `INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value;`
-	_, err = db.DB.Exec(upsertSQL, ActiveConfigKey, payload.ConfigID)
+	result, err := v2ray.Activate(sel)
 	if err != nil {
-		log.Error().Err(err).Msg("Error setting active config")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set active configuration"})
+		// Refuse to activate anything that V2Ray would reject.
+		var invalid *v2ray.InvalidConfigError
+		switch {
+		case errors.As(err, &invalid):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Configuration failed validation", "errors": invalid.Issues})
+		case result != "":
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":  "Failed to reload V2Ray with the new configuration: " + err.Error(),
+				"reload": result,
+			})
+		default:
+			log.Error().Err(err).Msg("Error setting active config")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set active configuration"})
+		}
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Active configuration set successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Active configuration set successfully", "reload": result})
 }
 
-// GetActiveConfig retrieves the currently active V2Ray configuration ID.
+// GetActiveConfig retrieves the currently active V2Ray configuration ID, or the
+// active balancer ID if a balancer is active.
 func GetActiveConfig(c *gin.Context) {
-	var configID int64
-	err := db.DB.QueryRow("SELECT value FROM settings WHERE key = ?", ActiveConfigKey).Scan(&configID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "No active configuration is set"})
+	for _, key := range []string{v2ray.ActiveBalancerKey, ActiveConfigKey} {
+		var id int64
+		err := db.DB.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&id)
+		if err == nil {
+			c.JSON(http.StatusOK, gin.H{key: id})
+			return
+		}
+		if err != sql.ErrNoRows {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get active configuration"})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get active configuration"})
-		return
 	}
-	c.JSON(http.StatusOK, gin.H{"active_config_id": configID})
+	c.JSON(http.StatusNotFound, gin.H{"error": "No active configuration is set"})
 }
 
 // GetSystemInfo is the handler for the /system/info endpoint.
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"k2ray/internal/api/middleware"
+	"k2ray/internal/db"
+	"k2ray/internal/security"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// normalizeTags trims tag names and drops empty and duplicate ones, comparing
+// case-insensitively like the tags table does. It never returns nil, so the
+// result always replaces a configuration's tags.
+func normalizeTags(names []string) []string {
+	tags := []string{}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		key := strings.ToLower(name)
+		if name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		tags = append(tags, name)
+	}
+	return tags
+}
+
+// attachTags loads the tags of configs.
+func attachTags(configs []*db.Configuration) error {
+	ids := make([]int64, len(configs))
+	for i, config := range configs {
+		ids[i] = config.ID
+	}
+	tags, err := db.ConfigTags(ids)
+	if err != nil {
+		return err
+	}
+	for _, config := range configs {
+		config.Tags = tags[config.ID]
+	}
+	return nil
+}
+
+// ListTags godoc
+// @Summary List tags
+// @Description Retrieves the authenticated user's configuration tags with the number of configurations carrying each. Tags are created by setting them on a configuration.
+// @Tags Configs
+// @Produce  json
+// @Success 200 {array} db.Tag
+// @Failure 500 {object} middleware.ErrorResponse "Failed to retrieve tags"
+// @Security ApiKeyAuth
+// @Router /tags [get]
+func ListTags(c *gin.Context) {
+	userIDVal, _ := c.Get(middleware.ContextUserIDKey)
+
+	tags, err := db.ListTags(userIDVal.(int64))
+	if err != nil {
+		log.Error().Err(err).Msg("Error listing tags")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tags"})
+		return
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+// DeleteTag godoc
+// @Summary Delete a tag
+// @Description Deletes a tag and removes it from every configuration carrying it.
+// @Tags Configs
+// @Param id path int true "Tag ID"
+// @Success 204
+// @Failure 404 {object} middleware.ErrorResponse "Tag not found or access denied"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to delete tag"
+// @Security ApiKeyAuth
+// @Router /tags/{id} [delete]
+func DeleteTag(c *gin.Context) {
+	tagID := c.Param("id")
+	userID, _ := c.Get(middleware.ContextUserIDKey)
+
+	var tag db.Tag
+	err := db.DB.QueryRow("SELECT id, name FROM tags WHERE id = ? AND user_id = ?", tagID, userID).Scan(&tag.ID, &tag.Name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found or access denied"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tag for deletion"})
+		return
+	}
+
+	if _, err := db.DB.Exec(`DELETE FROM tags WHERE id = ?`, tag.ID); err != nil {
+		log.Error().Err(err).Int64("tag_id", tag.ID).Msg("Error deleting tag")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag"})
+		return
+	}
+
+	security.LogEvent(c, security.TagDeleted, tag.ID, fmt.Sprintf("Tag '%s' deleted", tag.Name))
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,514 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"k2ray/internal/api/middleware"
+	"k2ray/internal/configtemplate"
+	"k2ray/internal/db"
+	"k2ray/internal/security"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// CreateTemplatePayload defines the structure for creating a config template.
+type CreateTemplatePayload struct {
+	Name       string      `json:"name" binding:"required,min=3,max=50"`
+	Protocol   string      `json:"protocol" binding:"required,oneof=vmess vless shadowsocks trojan hysteria2 tuic socks http wireguard"`
+	ConfigData interface{} `json:"config_data" binding:"required" swaggertype:"object"` // May contain {{placeholders}}
+}
+
+// UpdateTemplatePayload defines the structure for updating a config template.
+type UpdateTemplatePayload struct {
+	Name       *string      `json:"name" binding:"omitempty,min=3,max=50"`
+	ConfigData *interface{} `json:"config_data" swaggertype:"object"`
+	Rerender   bool         `json:"rerender"` // Re-render the configurations instantiated from the template
+}
+
+// TemplateResponse is a template with its placeholders and the number of
+// configurations instantiated from it.
+type TemplateResponse struct {
+	db.ConfigTemplate
+	Placeholders []string
+	Configs      int
+}
+
+// RerenderResult reports the outcome of re-rendering one configuration after
+// its template changed.
+type RerenderResult struct {
+	ConfigID  int64             `json:"config_id"`
+	Name      string            `json:"name"`
+	Success   bool              `json:"success"`
+	Unchanged bool              `json:"unchanged,omitempty"`
+	Revision  int               `json:"revision,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// UpdateTemplateResponse is the response for a template update.
+type UpdateTemplateResponse struct {
+	Template   TemplateResponse `json:"template"`
+	Rerendered []RerenderResult `json:"rerendered"`
+}
+
+// TemplateInstance is one configuration to instantiate from a template.
+type TemplateInstance struct {
+	Name      string         `json:"name" binding:"required,max=100"` // May contain {{placeholders}}
+	Variables map[string]any `json:"variables"`
+}
+
+// InstantiateTemplatePayload defines the structure for instantiating configurations from a template.
+type InstantiateTemplatePayload struct {
+	Instances []TemplateInstance `json:"instances" binding:"required,min=1,max=100,dive"`
+	GroupID   *int64             `json:"group_id"`
+	Tags      []string           `json:"tags" binding:"omitempty,max=20,dive,min=1,max=32,excludes=0x2C"`
+}
+
+// InstantiateTemplateResponse is the response for a template instantiation.
+type InstantiateTemplateResponse struct {
+	Created int            `json:"created"`
+	Failed  int            `json:"failed"`
+	Results []ImportResult `json:"results"`
+}
+
+const templateColumns = `id, user_id, name, protocol, config_data, created_at, updated_at`
+
+func scanTemplate(row interface{ Scan(...any) error }, t *db.ConfigTemplate) error {
+	return row.Scan(&t.ID, &t.UserID, &t.Name, &t.Protocol, &t.ConfigData, &t.CreatedAt, &t.UpdatedAt)
+}
+
+// loadTemplate fetches the requested template if it belongs to the
+// authenticated user, writing the error response itself otherwise.
+func loadTemplate(c *gin.Context) (db.ConfigTemplate, bool) {
+	var t db.ConfigTemplate
+	userID, _ := c.Get(middleware.ContextUserIDKey)
+	row := db.DB.QueryRow("SELECT "+templateColumns+" FROM config_templates WHERE id = ? AND user_id = ?", c.Param("id"), userID)
+	if err := scanTemplate(row, &t); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found or access denied"})
+			return t, false
+		}
+		log.Error().Err(err).Str("template_id", c.Param("id")).Msg("Error getting template")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve template"})
+		return t, false
+	}
+	return t, true
+}
+
+// templateResponse adds the placeholders and usage count to t.
+func templateResponse(t db.ConfigTemplate) (TemplateResponse, error) {
+	resp := TemplateResponse{ConfigTemplate: t}
+	placeholders, err := configtemplate.Parse([]byte(t.ConfigData))
+	if err != nil {
+		return resp, err
+	}
+	resp.Placeholders = placeholders
+	err = db.DB.QueryRow("SELECT COUNT(*) FROM configurations WHERE template_id = ?", t.ID).Scan(&resp.Configs)
+	return resp, err
+}
+
+// CreateTemplate godoc
+// @Summary Create a config template
+// @Description Creates a template whose config_data is a skeleton with {{placeholders}}, such as {"add": "{{server}}", "port": "{{port}}"}. A string that is a single placeholder is replaced by the variable's value with its JSON type; placeholders inside longer strings are replaced by text.
+// @Tags Templates
+// @Accept  json
+// @Produce  json
+// @Param   template body CreateTemplatePayload true "Template details"
+// @Success 201 {object} TemplateResponse
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload"
+// @Failure 409 {object} middleware.ErrorResponse "A template with this name already exists"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to create template"
+// @Security ApiKeyAuth
+// @Router /templates [post]
+func CreateTemplate(c *gin.Context) {
+	var payload CreateTemplatePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+	configDataBytes, err := json.Marshal(payload.ConfigData)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format for config_data"})
+		return
+	}
+	if _, err := configtemplate.Parse(configDataBytes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userIDVal, _ := c.Get(middleware.ContextUserIDKey)
+	userID := userIDVal.(int64)
+
+	res, err := db.DB.Exec(`INSERT INTO config_templates (user_id, name, protocol, config_data) VALUES (?, ?, ?, ?)`,
+		userID, payload.Name, payload.Protocol, string(configDataBytes))
+	if err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A template with this name already exists"})
+			return
+		}
+		log.Error().Err(err).Msg("Error executing SQL for CreateTemplate")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create template"})
+		return
+	}
+	newID, _ := res.LastInsertId()
+
+	details := fmt.Sprintf("Template '%s' created with protocol '%s'", payload.Name, payload.Protocol)
+	security.LogEvent(c, security.TemplateCreated, newID, details)
+
+	var t db.ConfigTemplate
+	if err := scanTemplate(db.DB.QueryRow("SELECT "+templateColumns+" FROM config_templates WHERE id = ?", newID), &t); err != nil {
+		log.Error().Err(err).Int64("template_id", newID).Msg("Error reading back new template")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create template"})
+		return
+	}
+	resp, err := templateResponse(t)
+	if err != nil {
+		log.Error().Err(err).Int64("template_id", newID).Msg("Error describing template")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create template"})
+		return
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// ListTemplates godoc
+// @Summary List config templates
+// @Description Retrieves the authenticated user's config templates, sorted by name.
+// @Tags Templates
+// @Produce  json
+// @Success 200 {array} TemplateResponse
+// @Failure 500 {object} middleware.ErrorResponse "Failed to retrieve templates"
+// @Security ApiKeyAuth
+// @Router /templates [get]
+func ListTemplates(c *gin.Context) {
+	userID, _ := c.Get(middleware.ContextUserIDKey)
+
+	rows, err := db.DB.Query("SELECT "+templateColumns+" FROM config_templates WHERE user_id = ? ORDER BY name", userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Error querying templates")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve templates"})
+		return
+	}
+	defer rows.Close()
+
+	templates := []db.ConfigTemplate{}
+	for rows.Next() {
+		var t db.ConfigTemplate
+		if err := scanTemplate(rows, &t); err != nil {
+			log.Error().Err(err).Msg("Error scanning template row")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process templates"})
+			return
+		}
+		templates = append(templates, t)
+	}
+	rows.Close()
+
+	resp := make([]TemplateResponse, 0, len(templates))
+	for _, t := range templates {
+		r, err := templateResponse(t)
+		if err != nil {
+			log.Error().Err(err).Int64("template_id", t.ID).Msg("Error describing template")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process templates"})
+			return
+		}
+		resp = append(resp, r)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetTemplate godoc
+// @Summary Get a config template
+// @Tags Templates
+// @Produce  json
+// @Param id path int true "Template ID"
+// @Success 200 {object} TemplateResponse
+// @Failure 404 {object} middleware.ErrorResponse "Template not found or access denied"
+// @Security ApiKeyAuth
+// @Router /templates/{id} [get]
+func GetTemplate(c *gin.Context) {
+	t, ok := loadTemplate(c)
+	if !ok {
+		return
+	}
+	resp, err := templateResponse(t)
+	if err != nil {
+		log.Error().Err(err).Int64("template_id", t.ID).Msg("Error describing template")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve template"})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// UpdateTemplate godoc
+// @Summary Update a config template
+// @Description Updates a template's name or config_data skeleton. With rerender set, every configuration instantiated from the template is rendered again with the variables it was created with, validated and saved as a new revision. Configurations that fail to render or validate are left unchanged and reported.
+// @Tags Templates
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Template ID"
+// @Param   template body UpdateTemplatePayload true "Fields to update"
+// @Success 200 {object} UpdateTemplateResponse
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload"
+// @Failure 404 {object} middleware.ErrorResponse "Template not found or access denied"
+// @Failure 409 {object} middleware.ErrorResponse "A template with this name already exists"
+// @Security ApiKeyAuth
+// @Router /templates/{id} [put]
+func UpdateTemplate(c *gin.Context) {
+	t, ok := loadTemplate(c)
+	if !ok {
+		return
+	}
+
+	var payload UpdateTemplatePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+	originalName := t.Name
+	if payload.Name != nil {
+		t.Name = *payload.Name
+	}
+	if payload.ConfigData != nil {
+		configDataBytes, err := json.Marshal(payload.ConfigData)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format for config_data"})
+			return
+		}
+		if _, err := configtemplate.Parse(configDataBytes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		t.ConfigData = string(configDataBytes)
+	}
+
+	_, err := db.DB.Exec(`UPDATE config_templates SET name = ?, config_data = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, t.Name, t.ConfigData, t.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A template with this name already exists"})
+			return
+		}
+		log.Error().Err(err).Int64("template_id", t.ID).Msg("Error executing update for template")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update template"})
+		return
+	}
+
+	details := fmt.Sprintf("Template '%s' (was '%s') updated", t.Name, originalName)
+	security.LogEvent(c, security.TemplateUpdated, t.ID, details)
+
+	resp := UpdateTemplateResponse{Rerendered: []RerenderResult{}}
+	if payload.Rerender {
+		if resp.Rerendered, err = rerenderTemplate(c, t); err != nil {
+			log.Error().Err(err).Int64("template_id", t.ID).Msg("Error re-rendering template configurations")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Template updated, but its configurations could not be re-rendered"})
+			return
+		}
+	}
+	if resp.Template, err = templateResponse(t); err != nil {
+		log.Error().Err(err).Int64("template_id", t.ID).Msg("Error describing template")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve template"})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// rerenderTemplate renders t again for each configuration instantiated from it
+// and saves those whose config_data changed.
+func rerenderTemplate(c *gin.Context, t db.ConfigTemplate) ([]RerenderResult, error) {
+	rows, err := db.DB.Query("SELECT "+configColumns+" FROM configurations WHERE template_id = ? AND user_id = ? ORDER BY id", t.ID, t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	var configs []db.Configuration
+	for rows.Next() {
+		var config db.Configuration
+		if err := scanConfig(rows, &config); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]RerenderResult, 0, len(configs))
+	for _, config := range configs {
+		result := RerenderResult{ConfigID: config.ID, Name: config.Name}
+		var vars map[string]any
+		if config.TemplateVars != nil {
+			json.Unmarshal([]byte(*config.TemplateVars), &vars)
+		}
+		configData, err := renderTemplate(t, vars)
+		if err != nil {
+			result.Error, result.Details = templateError(err)
+			results = append(results, result)
+			continue
+		}
+		if configData == config.ConfigData {
+			result.Success, result.Unchanged = true, true
+			results = append(results, result)
+			continue
+		}
+
+		config.ConfigData = configData
+		revision, err := saveConfig(c, config, fmt.Sprintf("Re-rendered from template '%s'", t.Name))
+		if err != nil {
+			return nil, err
+		}
+		details := fmt.Sprintf("Configuration '%s' re-rendered from template '%s' (revision %d)", config.Name, t.Name, revision)
+		security.LogEvent(c, security.ConfigUpdated, config.ID, details)
+		result.Success, result.Revision = true, revision
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// renderTemplate renders and validates t's config_data with vars.
+func renderTemplate(t db.ConfigTemplate, vars map[string]any) (string, error) {
+	configData, err := configtemplate.Render([]byte(t.ConfigData), vars)
+	if err != nil {
+		return "", err
+	}
+	if _, err := validateAndDecode(t.Protocol, configData); err != nil {
+		return "", err
+	}
+	return string(configData), nil
+}
+
+// templateError turns a renderTemplate error into a message and field details.
+func templateError(err error) (string, map[string]string) {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		return verr.Msg, verr.Fields
+	}
+	var missing *configtemplate.MissingVariablesError
+	if errors.As(err, &missing) {
+		details := make(map[string]string, len(missing.Names))
+		for _, name := range missing.Names {
+			details[name] = "missing variable"
+		}
+		return "Template could not be rendered: " + err.Error(), details
+	}
+	return "Template could not be rendered: " + err.Error(), nil
+}
+
+// DeleteTemplate godoc
+// @Summary Delete a config template
+// @Description Deletes the template. Configurations instantiated from it are kept and no longer linked to it.
+// @Tags Templates
+// @Param id path int true "Template ID"
+// @Success 204
+// @Failure 404 {object} middleware.ErrorResponse "Template not found or access denied"
+// @Security ApiKeyAuth
+// @Router /templates/{id} [delete]
+func DeleteTemplate(c *gin.Context) {
+	t, ok := loadTemplate(c)
+	if !ok {
+		return
+	}
+
+	if _, err := db.DB.Exec(`DELETE FROM config_templates WHERE id = ?`, t.ID); err != nil {
+		log.Error().Err(err).Int64("template_id", t.ID).Msg("Error deleting template")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete template"})
+		return
+	}
+
+	security.LogEvent(c, security.TemplateDeleted, t.ID, fmt.Sprintf("Template '%s' deleted", t.Name))
+	c.Status(http.StatusNoContent)
+}
+
+// InstantiateTemplate godoc
+// @Summary Create configurations from a template
+// @Description Renders the template once per instance with that instance's variables, validates each result and stores the valid ones. Instance names may contain placeholders too. Each instance is reported separately, so one bad variable set does not fail the others.
+// @Tags Templates
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Template ID"
+// @Param   instances body InstantiateTemplatePayload true "Instances to create"
+// @Success 200 {object} InstantiateTemplateResponse
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload"
+// @Failure 404 {object} middleware.ErrorResponse "Template not found or access denied"
+// @Security ApiKeyAuth
+// @Router /templates/{id}/instantiate [post]
+func InstantiateTemplate(c *gin.Context) {
+	t, ok := loadTemplate(c)
+	if !ok {
+		return
+	}
+
+	var payload InstantiateTemplatePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+	if payload.GroupID != nil && *payload.GroupID == 0 {
+		payload.GroupID = nil
+	}
+	if !checkGroup(c, t.UserID, payload.GroupID) {
+		return
+	}
+
+	resp := InstantiateTemplateResponse{Results: make([]ImportResult, 0, len(payload.Instances))}
+	for i, instance := range payload.Instances {
+		result := instantiate(c, t, instance, payload.GroupID, normalizeTags(payload.Tags))
+		result.Index = i
+		if result.Success {
+			resp.Created++
+		} else {
+			resp.Failed++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func instantiate(c *gin.Context, t db.ConfigTemplate, instance TemplateInstance, groupID *int64, tags []string) ImportResult {
+	result := ImportResult{Protocol: t.Protocol}
+	if instance.Variables == nil {
+		instance.Variables = map[string]any{}
+	}
+
+	name, err := configtemplate.RenderString(instance.Name, instance.Variables)
+	if err != nil {
+		result.Error, result.Details = templateError(err)
+		return result
+	}
+	result.Name = name
+	if n := utf8.RuneCountInString(name); n < 3 || n > 50 {
+		result.Error = "Configuration name must be between 3 and 50 characters"
+		return result
+	}
+
+	configData, err := renderTemplate(t, instance.Variables)
+	if err != nil {
+		result.Error, result.Details = templateError(err)
+		return result
+	}
+	vars, _ := json.Marshal(instance.Variables)
+	templateVars := string(vars)
+
+	newConfig, err := insertConfig(c, db.Configuration{
+		UserID:       t.UserID,
+		Name:         name,
+		Protocol:     t.Protocol,
+		ConfigData:   configData,
+		GroupID:      groupID,
+		Tags:         tags,
+		TemplateID:   &t.ID,
+		TemplateVars: &templateVars,
+	}, fmt.Sprintf("Instantiated from template '%s'", t.Name))
+	if err != nil {
+		log.Error().Err(err).Int64("template_id", t.ID).Msg("Error executing SQL for InstantiateTemplate")
+		result.Error = "Failed to create configuration"
+		return result
+	}
+
+	result.Success = true
+	result.Config = &newConfig
+	return result
+}
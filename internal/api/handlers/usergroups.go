@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"k2ray/internal/db"
+	"k2ray/internal/security"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// CreateUserGroupPayload defines the structure for creating a user group.
+type CreateUserGroupPayload struct {
+	Name    string   `json:"name" binding:"required,min=1,max=50"`
+	Members []string `json:"members" binding:"omitempty,max=500,dive,min=1"` // Usernames
+}
+
+// UpdateUserGroupPayload defines the structure for updating a user group.
+type UpdateUserGroupPayload struct {
+	Name    *string   `json:"name" binding:"omitempty,min=1,max=50"`
+	Members *[]string `json:"members" binding:"omitempty,max=500,dive,min=1"` // Replaces all members
+}
+
+const userGroupColumns = `id, name, created_at, updated_at`
+
+func scanUserGroup(row interface{ Scan(...any) error }, g *db.UserGroup) error {
+	return row.Scan(&g.ID, &g.Name, &g.CreatedAt, &g.UpdatedAt)
+}
+
+// getUserGroup returns the user group with its members.
+func getUserGroup(id any) (db.UserGroup, error) {
+	var g db.UserGroup
+	if err := scanUserGroup(db.DB.QueryRow("SELECT "+userGroupColumns+" FROM user_groups WHERE id = ?", id), &g); err != nil {
+		return g, err
+	}
+	return g, attachMembers([]*db.UserGroup{&g})
+}
+
+// loadUserGroup fetches the requested user group with its members, writing
+// the error response itself when that is not possible.
+func loadUserGroup(c *gin.Context) (db.UserGroup, bool) {
+	g, err := getUserGroup(c.Param("id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User group not found"})
+			return g, false
+		}
+		log.Error().Err(err).Str("user_group_id", c.Param("id")).Msg("Error getting user group")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user group"})
+		return g, false
+	}
+	return g, true
+}
+
+// attachMembers loads the member usernames of groups.
+func attachMembers(groups []*db.UserGroup) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	byID := make(map[int64]*db.UserGroup, len(groups))
+	args := make([]any, len(groups))
+	for i, g := range groups {
+		g.Members = []string{}
+		byID[g.ID] = g
+		args[i] = g.ID
+	}
+
+	querySQL := `SELECT m.group_id, u.username FROM user_group_members m JOIN users u ON u.id = m.user_id
+		WHERE m.group_id IN (?` + strings.Repeat(",?", len(groups)-1) + `) ORDER BY u.username`
+	rows, err := db.DB.Query(querySQL, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var username string
+		if err := rows.Scan(&id, &username); err != nil {
+			return err
+		}
+		byID[id].Members = append(byID[id].Members, username)
+	}
+	return rows.Err()
+}
+
+// resolveUsernames returns the user IDs of usernames, writing a 400 response
+// itself if any of them does not exist.
+func resolveUsernames(c *gin.Context, usernames []string) ([]int64, bool) {
+	ids := make([]int64, 0, len(usernames))
+	for _, username := range usernames {
+		var id int64
+		err := db.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&id)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User '%s' not found", username)})
+			return nil, false
+		}
+		if err != nil {
+			log.Error().Err(err).Str("username", username).Msg("Error resolving username")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve group members"})
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	return ids, true
+}
+
+// setMembers replaces the members of a user group.
+func setMembers(tx *sql.Tx, groupID int64, userIDs []int64) error {
+	if _, err := tx.Exec("DELETE FROM user_group_members WHERE group_id = ?", groupID); err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO user_group_members (group_id, user_id) VALUES (?, ?)", groupID, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateUserGroup godoc
+// @Summary Create a user group
+// @Description Creates a group of users that configurations can be shared with. Only accessible by admins.
+// @Tags Users
+// @Accept  json
+// @Produce  json
+// @Param   group body CreateUserGroupPayload true "User group details"
+// @Success 201 {object} db.UserGroup
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload or unknown user"
+// @Failure 409 {object} middleware.ErrorResponse "A user group with this name already exists"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to create user group"
+// @Security ApiKeyAuth
+// @Router /user-groups [post]
+func CreateUserGroup(c *gin.Context) {
+	var payload CreateUserGroupPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+	memberIDs, ok := resolveUsernames(c, payload.Members)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		log.Error().Err(err).Msg("Error starting transaction for CreateUserGroup")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user group"})
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO user_groups (name) VALUES (?)`, payload.Name)
+	if err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A user group with this name already exists"})
+			return
+		}
+		log.Error().Err(err).Msg("Error executing SQL for CreateUserGroup")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user group"})
+		return
+	}
+	newID, _ := res.LastInsertId()
+	if err := setMembers(tx, newID, memberIDs); err != nil || tx.Commit() != nil {
+		log.Error().Err(err).Int64("user_group_id", newID).Msg("Error saving user group members")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user group"})
+		return
+	}
+
+	details := fmt.Sprintf("User group '%s' created with %d members", payload.Name, len(memberIDs))
+	security.LogEvent(c, security.UserGroupCreated, newID, details)
+
+	g, err := getUserGroup(newID)
+	if err != nil {
+		log.Error().Err(err).Int64("user_group_id", newID).Msg("Error reading back new user group")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user group"})
+		return
+	}
+	c.JSON(http.StatusCreated, g)
+}
+
+// ListUserGroups godoc
+// @Summary List user groups
+// @Description Retrieves every user group with its members, sorted by name. Only accessible by admins.
+// @Tags Users
+// @Produce  json
+// @Success 200 {array} db.UserGroup
+// @Failure 500 {object} middleware.ErrorResponse "Failed to retrieve user groups"
+// @Security ApiKeyAuth
+// @Router /user-groups [get]
+func ListUserGroups(c *gin.Context) {
+	rows, err := db.DB.Query("SELECT " + userGroupColumns + " FROM user_groups ORDER BY name")
+	if err != nil {
+		log.Error().Err(err).Msg("Error querying user groups")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user groups"})
+		return
+	}
+	defer rows.Close()
+
+	groups := []db.UserGroup{}
+	for rows.Next() {
+		var g db.UserGroup
+		if err := scanUserGroup(rows, &g); err != nil {
+			log.Error().Err(err).Msg("Error scanning user group row")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user groups"})
+			return
+		}
+		groups = append(groups, g)
+	}
+	rows.Close()
+
+	ptrs := make([]*db.UserGroup, len(groups))
+	for i := range groups {
+		ptrs[i] = &groups[i]
+	}
+	if err := attachMembers(ptrs); err != nil {
+		log.Error().Err(err).Msg("Error loading user group members")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user groups"})
+		return
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+// GetUserGroup godoc
+// @Summary Get a user group
+// @Tags Users
+// @Produce  json
+// @Param id path int true "User group ID"
+// @Success 200 {object} db.UserGroup
+// @Failure 404 {object} middleware.ErrorResponse "User group not found"
+// @Security ApiKeyAuth
+// @Router /user-groups/{id} [get]
+func GetUserGroup(c *gin.Context) {
+	if g, ok := loadUserGroup(c); ok {
+		c.JSON(http.StatusOK, g)
+	}
+}
+
+// UpdateUserGroup godoc
+// @Summary Update a user group
+// @Description Renames a user group or replaces its members. Only accessible by admins.
+// @Tags Users
+// @Accept  json
+// @Produce  json
+// @Param id path int true "User group ID"
+// @Param   group body UpdateUserGroupPayload true "Fields to update"
+// @Success 200 {object} db.UserGroup
+// @Failure 400 {object} middleware.ErrorResponse "Invalid request payload or unknown user"
+// @Failure 404 {object} middleware.ErrorResponse "User group not found"
+// @Failure 409 {object} middleware.ErrorResponse "A user group with this name already exists"
+// @Security ApiKeyAuth
+// @Router /user-groups/{id} [put]
+func UpdateUserGroup(c *gin.Context) {
+	g, ok := loadUserGroup(c)
+	if !ok {
+		return
+	}
+
+	var payload UpdateUserGroupPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.Error(err)
+		return
+	}
+	originalName := g.Name
+	if payload.Name != nil {
+		g.Name = *payload.Name
+	}
+	var memberIDs []int64
+	if payload.Members != nil {
+		if memberIDs, ok = resolveUsernames(c, *payload.Members); !ok {
+			return
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		log.Error().Err(err).Int64("user_group_id", g.ID).Msg("Error starting transaction for UpdateUserGroup")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user group"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE user_groups SET name = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, g.Name, g.ID); err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A user group with this name already exists"})
+			return
+		}
+		log.Error().Err(err).Int64("user_group_id", g.ID).Msg("Error executing update for user group")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user group"})
+		return
+	}
+	if payload.Members != nil {
+		err = setMembers(tx, g.ID, memberIDs)
+	}
+	if err != nil || tx.Commit() != nil {
+		log.Error().Err(err).Int64("user_group_id", g.ID).Msg("Error saving user group members")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user group"})
+		return
+	}
+
+	details := fmt.Sprintf("User group '%s' (was '%s') updated", g.Name, originalName)
+	security.LogEvent(c, security.UserGroupUpdated, g.ID, details)
+
+	if g, err = getUserGroup(g.ID); err != nil {
+		log.Error().Err(err).Int64("user_group_id", g.ID).Msg("Error reading back user group")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user group"})
+		return
+	}
+	c.JSON(http.StatusOK, g)
+}
+
+// DeleteUserGroup godoc
+// @Summary Delete a user group
+// @Description Deletes a user group. Configurations shared with the group are no longer shared with its members. Only accessible by admins.
+// @Tags Users
+// @Param id path int true "User group ID"
+// @Success 204
+// @Failure 404 {object} middleware.ErrorResponse "User group not found"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to delete user group"
+// @Security ApiKeyAuth
+// @Router /user-groups/{id} [delete]
+func DeleteUserGroup(c *gin.Context) {
+	g, ok := loadUserGroup(c)
+	if !ok {
+		return
+	}
+
+	if _, err := db.DB.Exec(`DELETE FROM user_groups WHERE id = ?`, g.ID); err != nil {
+		log.Error().Err(err).Int64("user_group_id", g.ID).Msg("Error deleting user group")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user group"})
+		return
+	}
+
+	security.LogEvent(c, security.UserGroupDeleted, g.ID, fmt.Sprintf("User group '%s' deleted", g.Name))
+	c.Status(http.StatusNoContent)
+}
@@ -1,11 +1,12 @@
 package handlers
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"k2ray/internal/api/middleware"
 	"k2ray/internal/db"
+	"k2ray/internal/protocol"
 	"k2ray/internal/security"
 	"k2ray/internal/v2ray"
 	"math"
@@ -21,136 +22,31 @@ import (
 // CreateConfigPayload defines the structure for creating a new V2Ray config.
 type CreateConfigPayload struct {
 	Name       string      `json:"name" binding:"required,min=3,max=50"`
-	Protocol   string      `json:"protocol" binding:"required,oneof=vmess vless shadowsocks trojan"`
+	Protocol   string      `json:"protocol" binding:"required,oneof=vmess vless shadowsocks trojan hysteria2 tuic socks http wireguard"`
 	ConfigData interface{} `json:"config_data" binding:"required" swaggertype:"object"`
-}
-
-// TransportSettings defines common transport settings for V2Ray protocols.
-type TransportSettings struct {
-	Network      string       `json:"net"` // "tcp", "kcp", "ws", "h2", "quic", "grpc"
-	Security     string       `json:"tls"` // "none", "tls"
-	WsSettings   WsSettings   `json:"wsSettings"`
-	GrpcSettings GrpcSettings `json:"grpcSettings"`
-}
-
-// WsSettings defines WebSocket-specific transport settings.
-type WsSettings struct {
-	Path    string            `json:"path"`
-	Headers map[string]string `json:"headers"`
-}
-
-// GrpcSettings defines gRPC-specific transport settings.
-type GrpcSettings struct {
-	ServiceName string `json:"serviceName"`
-}
-
-// VmessConfigData defines the structure for a VMess config.
-type VmessConfigData struct {
-	V                 string   `json:"v"`
-	Add               string   `json:"add"`
-	Port              any      `json:"port"`
-	ID                string   `json:"id"`
-	Aid               int      `json:"aid"`
-	Type              string   `json:"type"` // Header type
-	Host              string   `json:"host"`
-	Path              string   `json:"path"`
-	TransportSettings `json:","`
-}
-
-// VlessConfigData defines the structure for a VLESS config.
-type VlessConfigData struct {
-	ID                string   `json:"id"`
-	Address           string   `json:"add"`
-	Port              any      `json:"port"`
-	Encryption        string   `json:"encryption"`
-	Flow              string   `json:"flow"`
-	TransportSettings `json:","`
-}
-
-// ShadowsocksConfigData defines the structure for a Shadowsocks config.
-type ShadowsocksConfigData struct {
-	Server     string `json:"server"`
-	ServerPort int    `json:"server_port"`
-	Password   string `json:"password"`
-	Method     string `json:"method"`
-}
-
-// TrojanConfigData defines the structure for a Trojan config.
-type TrojanConfigData struct {
-	Server            string   `json:"server"`
-	ServerPort        int      `json:"server_port"`
-	Password          string   `json:"password"`
-	SNI               string   `json:"sni"`
-	TransportSettings `json:","`
-}
-
-// isValidatable defines an interface for config data structs.
-type isValidatable interface {
-	validate() error
-}
-
-func (c VmessConfigData) validate() error {
-	if c.Add == "" || c.Port == nil || c.ID == "" {
-		return &ValidationError{Msg: "VMess config must include 'add', 'port', and 'id'"}
-	}
-	return nil
-}
-
-func (c VlessConfigData) validate() error {
-	if c.ID == "" || c.Address == "" || c.Port == nil {
-		return &ValidationError{Msg: "VLESS config must include 'id', 'add', and 'port'"}
-	}
-	return nil
-}
-
-func (c ShadowsocksConfigData) validate() error {
-	if c.Server == "" || c.ServerPort == 0 || c.Password == "" || c.Method == "" {
-		return &ValidationError{Msg: "Shadowsocks config must include 'server', 'server_port', 'password', and 'method'"}
-	}
-	return nil
-}
-
-func (c TrojanConfigData) validate() error {
-	if c.Server == "" || c.ServerPort == 0 || c.Password == "" {
-		return &ValidationError{Msg: "Trojan config must include 'server', 'server_port', and 'password'"}
-	}
-	return nil
+	Remarks    string      `json:"remarks" binding:"max=500"`
+	GroupID    *int64      `json:"group_id"`
+	Tags       []string    `json:"tags" binding:"omitempty,max=20,dive,min=1,max=32,excludes=0x2C"`
+	Comment    string      `json:"comment" binding:"max=200"` // Recorded with the first revision
 }
 
 // ValidationError is a custom error type for validation failures.
-type ValidationError struct {
-	Msg string
-}
-
-func (e *ValidationError) Error() string {
-	return e.Msg
-}
+type ValidationError = protocol.ValidationError
 
 // validateAndDecode performs JSON unmarshaling and validation for a given protocol.
-func validateAndDecode(protocol string, data json.RawMessage) (isValidatable, error) {
-	var v isValidatable
-	switch protocol {
-	case "vmess":
-		v = &VmessConfigData{}
-	case "vless":
-		v = &VlessConfigData{}
-	case "shadowsocks":
-		v = &ShadowsocksConfigData{}
-	case "trojan":
-		v = &TrojanConfigData{}
-	default:
-		return nil, &ValidationError{Msg: "Protocol not supported"}
-	}
-
-	if err := json.Unmarshal(data, v); err != nil {
-		return nil, &ValidationError{Msg: "Invalid config_data format: " + err.Error()}
-	}
+func validateAndDecode(protocolName string, data json.RawMessage) (protocol.ConfigData, error) {
+	return protocol.Decode(protocolName, data)
+}
 
-	if err := v.validate(); err != nil {
-		return nil, err
+// respondValidationError reports a validateAndDecode failure, listing each
+// invalid config_data field in the response details.
+func respondValidationError(c *gin.Context, err error) {
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred during validation"})
+		return
 	}
-
-	return v, nil
+	c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: verr.Msg, Details: verr.Fields})
 }
 
 // CreateConfig godoc
@@ -180,52 +76,112 @@ func CreateConfig(c *gin.Context) {
 	}
 
 	if _, err := validateAndDecode(payload.Protocol, configDataBytes); err != nil {
-		if verr, ok := err.(*ValidationError); ok {
-			c.JSON(http.StatusBadRequest, gin.H{"error": verr.Msg})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred during validation"})
-		}
+		respondValidationError(c, err)
 		return
 	}
 
 	userIDVal, _ := c.Get(middleware.ContextUserIDKey)
 	userID := userIDVal.(int64)
 
-	insertSQL := `INSERT INTO configurations (user_id, name, protocol, config_data) VALUES (?, ?, ?, ?)`
-	res, err := db.DB.Exec(insertSQL, userID, payload.Name, payload.Protocol, string(configDataBytes))
-	if err != nil {
-		log.Error().Err(err).Msg("Error executing SQL for CreateConfig")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create configuration"})
+	if payload.GroupID != nil && *payload.GroupID == 0 {
+		payload.GroupID = nil
+	}
+	if !checkGroup(c, userID, payload.GroupID) {
 		return
 	}
 
-	newID, _ := res.LastInsertId()
-
-	// Audit log
-	details := fmt.Sprintf("Configuration '%s' created with protocol '%s'", payload.Name, payload.Protocol)
-	security.LogEvent(c, security.ConfigCreated, newID, details)
-
-	newConfig := db.Configuration{
-		ID:         newID,
+	newConfig, err := insertConfig(c, db.Configuration{
 		UserID:     userID,
 		Name:       payload.Name,
 		Protocol:   payload.Protocol,
 		ConfigData: string(configDataBytes),
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+		Remarks:    payload.Remarks,
+		GroupID:    payload.GroupID,
+		Tags:       normalizeTags(payload.Tags),
+	}, payload.Comment)
+	if err != nil {
+		log.Error().Err(err).Msg("Error executing SQL for CreateConfig")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create configuration"})
+		return
 	}
 	c.JSON(http.StatusCreated, newConfig)
 }
 
+// insertConfig stores an already validated configuration with its tags as
+// revision 1 and records the audit event.
+func insertConfig(c *gin.Context, config db.Configuration, comment string) (db.Configuration, error) {
+	return insertConfigWithHistory(c, config, nil, comment)
+}
+
+// insertConfigWithHistory is insertConfig for a configuration that brings
+// earlier revisions along, such as one imported from an archive. The history
+// is stored first, oldest first, and the configuration itself becomes the
+// latest revision.
+func insertConfigWithHistory(c *gin.Context, config db.Configuration, history []db.ConfigRevision, comment string) (db.Configuration, error) {
+	ctx := c.Request.Context()
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return db.Configuration{}, err
+	}
+	defer tx.Rollback()
+
+	insertSQL := `INSERT INTO configurations (user_id, name, protocol, config_data, remarks, group_id, template_id, template_vars) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := tx.ExecContext(ctx, insertSQL, config.UserID, config.Name, config.Protocol, config.ConfigData, config.Remarks, config.GroupID, config.TemplateID, config.TemplateVars)
+	if err != nil {
+		return db.Configuration{}, err
+	}
+
+	config.ID, _ = res.LastInsertId()
+	for _, r := range history {
+		if _, err := db.AddArchivedConfigRevision(ctx, tx, config.ID, r.Name, r.ConfigData, r.AuthorID, r.Comment, r.CreatedAt); err != nil {
+			return db.Configuration{}, err
+		}
+	}
+	revision, err := db.AddConfigRevision(ctx, tx, config.ID, config.Name, config.ConfigData, &config.UserID, comment)
+	if err != nil {
+		return db.Configuration{}, err
+	}
+	if err := db.SetConfigTags(ctx, tx, config.UserID, config.ID, config.Tags); err != nil {
+		return db.Configuration{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return db.Configuration{}, err
+	}
+
+	// Audit log
+	details := fmt.Sprintf("Configuration '%s' created with protocol '%s' (revision %d)", config.Name, config.Protocol, revision)
+	security.LogEvent(c, security.ConfigCreated, config.ID, details)
+
+	config.CreatedAt, config.UpdatedAt = time.Now(), time.Now()
+	return config, attachTags([]*db.Configuration{&config})
+}
+
+// configColumns lists the configurations columns read by scanConfig.
+const configColumns = `id, user_id, name, protocol, config_data, subscription_id, group_id, remarks, template_id, template_vars, created_at, updated_at`
+
+func scanConfig(row interface{ Scan(...any) error }, c *db.Configuration) error {
+	return row.Scan(&c.ID, &c.UserID, &c.Name, &c.Protocol, &c.ConfigData, &c.SubscriptionID, &c.GroupID, &c.Remarks, &c.TemplateID, &c.TemplateVars, &c.CreatedAt, &c.UpdatedAt)
+}
+
+// ConfigListItem is a configuration as listed by ListConfigs, with the outcome
+// of its most recent probe. Both fields are null if it was never probed. They
+// are untagged like the fields of the embedded configuration, so that a list
+// item uses one key style throughout.
+type ConfigListItem struct {
+	db.Configuration
+	LastLatencyMs   *int64 // TCP connect time
+	LastProbeStatus *string
+}
+
 // PaginatedConfigsResponse is the structured response for a list of configs with pagination.
 type PaginatedConfigsResponse struct {
-	Data       []db.Configuration `json:"data"`
-	Pagination PaginationMeta     `json:"pagination"`
+	Data       []ConfigListItem `json:"data"`
+	Pagination PaginationMeta   `json:"pagination"`
 }
 
 // ListConfigs godoc
 // @Summary List V2Ray configurations
-// @Description Retrieves a paginated list of V2Ray configurations for the authenticated user.
+// @Description Retrieves a paginated list of the V2Ray configurations the authenticated user owns or that are shared with them. Each configuration carries its Owner, the user's Permission (owner, write or read), and the TCP connect time and status of its latest probe (LastLatencyMs and LastProbeStatus). All filters can be combined with each other and with sorting and pagination.
 // @Tags Configs
 // @Accept  json
 // @Produce  json
@@ -235,7 +191,14 @@ type PaginatedConfigsResponse struct {
 // @Param order query string false "Sort order (ASC, DESC)" default(ASC)
 // @Param name query string false "Filter by configuration name (partial match)"
 // @Param protocol query string false "Filter by protocol (vmess, vless, etc.)"
+// @Param subscription_id query int false "Filter by the subscription that manages the configuration"
+// @Param group_id query string false "Filter by group ID, or 'none' for configurations without a group"
+// @Param tags query string false "Comma-separated tag names to filter by (case-insensitive)"
+// @Param tag_mode query string false "Whether configurations must carry all of the tags or any of them (all, any)" default(all)
+// @Param search query string false "Full-text search over name, server address and remarks; every word must match"
+// @Param scope query string false "Whether to list owned configurations, shared ones or both (all, owned, shared)" default(all)
 // @Success 200 {object} PaginatedConfigsResponse
+// @Failure 400 {object} middleware.ErrorResponse "Invalid filter"
 // @Failure 500 {object} middleware.ErrorResponse "Failed to retrieve configurations"
 // @Security ApiKeyAuth
 // @Router /configs [get]
@@ -250,6 +213,12 @@ func ListConfigs(c *gin.Context) {
 	order := strings.ToUpper(c.DefaultQuery("order", "ASC"))
 	filterName := c.Query("name")
 	filterProtocol := c.Query("protocol")
+	filterSubscription := c.Query("subscription_id")
+	filterGroup := c.Query("group_id")
+	filterTags := normalizeTags(strings.Split(c.Query("tags"), ","))
+	tagMode := c.DefaultQuery("tag_mode", "all")
+	search := c.Query("search")
+	scope := c.DefaultQuery("scope", "all")
 
 	// 3. Validate and sanitize inputs
 	if page < 1 {
@@ -265,12 +234,19 @@ func ListConfigs(c *gin.Context) {
 	if !allowedSortColumns[sortBy] {
 		sortBy = "id"
 	}
+	if tagMode != "all" && tagMode != "any" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tag_mode must be 'all' or 'any'"})
+		return
+	}
+	if scope != "all" && scope != "owned" && scope != "shared" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope must be 'all', 'owned' or 'shared'"})
+		return
+	}
 
 	// 4. Build the database query
-	var args []interface{}
+	accessCondition, args := db.ConfigAccessFilter(userID.(int64), scope)
 	queryBuilder := strings.Builder{}
-	queryBuilder.WriteString("FROM configurations WHERE user_id = ?")
-	args = append(args, userID)
+	queryBuilder.WriteString("FROM configurations WHERE " + accessCondition)
 
 	if filterName != "" {
 		queryBuilder.WriteString(" AND name LIKE ?")
@@ -280,6 +256,25 @@ func ListConfigs(c *gin.Context) {
 		queryBuilder.WriteString(" AND protocol = ?")
 		args = append(args, filterProtocol)
 	}
+	if filterSubscription != "" {
+		queryBuilder.WriteString(" AND subscription_id = ?")
+		args = append(args, filterSubscription)
+	}
+	if filterGroup == "none" {
+		queryBuilder.WriteString(" AND group_id IS NULL")
+	} else if filterGroup != "" {
+		queryBuilder.WriteString(" AND group_id = ?")
+		args = append(args, filterGroup)
+	}
+	if len(filterTags) > 0 {
+		condition, tagArgs := db.ConfigTagFilter(userID.(int64), filterTags, tagMode == "any")
+		queryBuilder.WriteString(" AND " + condition)
+		args = append(args, tagArgs...)
+	}
+	if condition, searchArgs := db.ConfigSearchFilter(search); condition != "" {
+		queryBuilder.WriteString(" AND " + condition)
+		args = append(args, searchArgs...)
+	}
 
 	// 5. Get total count for pagination
 	var totalItems int
@@ -293,7 +288,7 @@ func ListConfigs(c *gin.Context) {
 
 	// 6. Execute main query
 	offset := (page - 1) * limit
-	selectQuery := fmt.Sprintf("SELECT id, user_id, name, protocol, config_data, created_at, updated_at %s ORDER BY %s %s LIMIT ? OFFSET ?", queryBuilder.String(), sortBy, order)
+	selectQuery := fmt.Sprintf("SELECT %s %s ORDER BY %s %s LIMIT ? OFFSET ?", configColumns, queryBuilder.String(), sortBy, order)
 	rows, err := db.DB.Query(selectQuery, append(args, limit, offset)...)
 	if err != nil {
 		log.Error().Err(err).Msg("Error querying configurations with pagination")
@@ -305,17 +300,51 @@ func ListConfigs(c *gin.Context) {
 	configs := []db.Configuration{}
 	for rows.Next() {
 		var config db.Configuration
-		if err := rows.Scan(&config.ID, &config.UserID, &config.Name, &config.Protocol, &config.ConfigData, &config.CreatedAt, &config.UpdatedAt); err != nil {
+		if err := scanConfig(rows, &config); err != nil {
 			log.Error().Err(err).Msg("Error scanning config row")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process configurations"})
 			return
 		}
 		configs = append(configs, config)
 	}
+	rows.Close()
+
+	pageConfigs := make([]*db.Configuration, len(configs))
+	for i := range configs {
+		pageConfigs[i] = &configs[i]
+	}
+	if err := attachTags(pageConfigs); err != nil {
+		log.Error().Err(err).Msg("Error loading config tags")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process configurations"})
+		return
+	}
+	if err := attachAccess(userID.(int64), pageConfigs); err != nil {
+		log.Error().Err(err).Msg("Error loading config permissions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process configurations"})
+		return
+	}
+
+	ids := make([]int64, len(configs))
+	for i, config := range configs {
+		ids[i] = config.ID
+	}
+	latest, err := db.LatestProbeResults(ids)
+	if err != nil {
+		log.Error().Err(err).Msg("Error loading config probe results")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process configurations"})
+		return
+	}
+	items := make([]ConfigListItem, len(configs))
+	for i, config := range configs {
+		items[i].Configuration = config
+		if result, ok := latest[config.ID]; ok {
+			items[i].LastLatencyMs, items[i].LastProbeStatus = result.TCPConnectMs, &result.Status
+		}
+	}
 
 	// 7. Construct response
 	response := PaginatedConfigsResponse{
-		Data: configs,
+		Data: items,
 		Pagination: PaginationMeta{
 			TotalItems:   totalItems,
 			TotalPages:   int(math.Ceil(float64(totalItems) / float64(limit))),
@@ -329,7 +358,7 @@ func ListConfigs(c *gin.Context) {
 
 // GetConfig godoc
 // @Summary Get a single V2Ray configuration
-// @Description Retrieves details for a single V2Ray configuration by its ID.
+// @Description Retrieves details for a single V2Ray configuration the authenticated user owns or that is shared with them.
 // @Tags Configs
 // @Accept  json
 // @Produce  json
@@ -340,18 +369,12 @@ func ListConfigs(c *gin.Context) {
 // @Security ApiKeyAuth
 // @Router /configs/{id} [get]
 func GetConfig(c *gin.Context) {
-	configID := c.Param("id")
-	userID, _ := c.Get(middleware.ContextUserIDKey)
-
-	querySQL := `SELECT id, user_id, name, protocol, config_data, created_at, updated_at FROM configurations WHERE id = ? AND user_id = ?`
-	var config db.Configuration
-	err := db.DB.QueryRow(querySQL, configID, userID).Scan(&config.ID, &config.UserID, &config.Name, &config.Protocol, &config.ConfigData, &config.CreatedAt, &config.UpdatedAt)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Configuration not found or access denied"})
-			return
-		}
-		log.Error().Err(err).Str("config_id", configID).Int64("user_id", userID.(int64)).Msg("Error getting config")
+	config, ok := loadConfig(c, db.PermissionRead)
+	if !ok {
+		return
+	}
+	if err := attachTags([]*db.Configuration{&config}); err != nil {
+		log.Error().Err(err).Int64("config_id", config.ID).Msg("Error loading config tags")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve configuration"})
 		return
 	}
@@ -361,13 +384,17 @@ func GetConfig(c *gin.Context) {
 
 // UpdateConfigPayload defines the structure for updating a V2Ray config.
 type UpdateConfigPayload struct {
-	Name       *string      `json:"name" binding:"min=3,max=50"`
+	Name       *string      `json:"name" binding:"omitempty,min=3,max=50"`
 	ConfigData *interface{} `json:"config_data" swaggertype:"object"`
+	Remarks    *string      `json:"remarks" binding:"omitempty,max=500"`
+	GroupID    *int64       `json:"group_id"`                                                        // 0 removes the config from its group
+	Tags       *[]string    `json:"tags" binding:"omitempty,max=20,dive,min=1,max=32,excludes=0x2C"` // Replaces all tags
+	Comment    string       `json:"comment" binding:"max=200"`                                       // Recorded with the new revision
 }
 
 // UpdateConfig godoc
 // @Summary Update a V2Ray configuration
-// @Description Updates a specific V2Ray configuration for the authenticated user.
+// @Description Updates a V2Ray configuration the authenticated user owns or that is shared with them for writing. Every update is recorded as a new revision. Only the owner can change the group and tags.
 // @Tags Configs
 // @Accept  json
 // @Produce  json
@@ -375,22 +402,15 @@ type UpdateConfigPayload struct {
 // @Param config body UpdateConfigPayload true "Updated Configuration Details"
 // @Success 200 {object} db.Configuration
 // @Failure 400 {object} middleware.ErrorResponse "Invalid request payload or config data"
+// @Failure 403 {object} middleware.ErrorResponse "Configuration is shared read-only"
 // @Failure 404 {object} middleware.ErrorResponse "Configuration not found or access denied"
 // @Failure 500 {object} middleware.ErrorResponse "Failed to update configuration"
 // @Security ApiKeyAuth
 // @Router /configs/{id} [put]
 func UpdateConfig(c *gin.Context) {
 	configID := c.Param("id")
-	userID, _ := c.Get(middleware.ContextUserIDKey)
-
-	var existingConfig db.Configuration
-	err := db.DB.QueryRow("SELECT id, user_id, name, protocol, config_data, created_at, updated_at FROM configurations WHERE id = ? AND user_id = ?", configID, userID).Scan(&existingConfig.ID, &existingConfig.UserID, &existingConfig.Name, &existingConfig.Protocol, &existingConfig.ConfigData, &existingConfig.CreatedAt, &existingConfig.UpdatedAt)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Configuration not found or access denied"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve configuration for update"})
+	existingConfig, ok := loadConfig(c, db.PermissionWrite)
+	if !ok {
 		return
 	}
 
@@ -399,6 +419,11 @@ func UpdateConfig(c *gin.Context) {
 		c.Error(err)
 		return
 	}
+	// Groups and tags belong to the owner, so only they can change them.
+	if (payload.GroupID != nil || payload.Tags != nil) && existingConfig.Permission != db.PermissionOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can change a configuration's group or tags"})
+		return
+	}
 
 	originalName := existingConfig.Name
 	if payload.Name != nil {
@@ -413,28 +438,40 @@ func UpdateConfig(c *gin.Context) {
 		}
 
 		if _, err := validateAndDecode(existingConfig.Protocol, configDataBytes); err != nil {
-			if verr, ok := err.(*ValidationError); ok {
-				c.JSON(http.StatusBadRequest, gin.H{"error": verr.Msg})
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "An unexpected error occurred during validation"})
-			}
+			respondValidationError(c, err)
 			return
 		}
 		existingConfig.ConfigData = string(configDataBytes)
 	}
+	if payload.Remarks != nil {
+		existingConfig.Remarks = *payload.Remarks
+	}
+	if payload.GroupID != nil {
+		existingConfig.GroupID = payload.GroupID
+		if *payload.GroupID == 0 {
+			existingConfig.GroupID = nil
+		}
+		if !checkGroup(c, existingConfig.UserID, existingConfig.GroupID) {
+			return
+		}
+	}
+	if payload.Tags != nil {
+		existingConfig.Tags = normalizeTags(*payload.Tags)
+	}
 
-	updateSQL := `UPDATE configurations SET name = ?, config_data = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ?`
-	_, err = db.DB.Exec(updateSQL, existingConfig.Name, existingConfig.ConfigData, configID, userID)
+	revision, err := saveConfig(c, existingConfig, payload.Comment)
 	if err != nil {
 		log.Error().Err(err).Str("config_id", configID).Msg("Error executing update for config")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update configuration"})
 		return
 	}
+	if err := attachTags([]*db.Configuration{&existingConfig}); err != nil {
+		log.Error().Err(err).Str("config_id", configID).Msg("Error loading config tags")
+	}
 
 	// Audit log
-	configIDInt, _ := strconv.ParseInt(configID, 10, 64)
-	details := fmt.Sprintf("Configuration '%s' (was '%s') updated", existingConfig.Name, originalName)
-	security.LogEvent(c, security.ConfigUpdated, configIDInt, details)
+	details := fmt.Sprintf("Configuration '%s' (was '%s') updated (revision %d)", existingConfig.Name, originalName, revision)
+	security.LogEvent(c, security.ConfigUpdated, existingConfig.ID, details)
 
 	c.JSON(http.StatusOK, existingConfig)
 }
@@ -489,55 +526,92 @@ func BulkDeleteConfigs(c *gin.Context) {
 
 // DeleteConfig godoc
 // @Summary Delete a V2Ray configuration
-// @Description Deletes a single V2Ray configuration by its ID.
+// @Description Deletes a single V2Ray configuration by its ID. Only the owner can delete a configuration.
 // @Tags Configs
 // @Accept  json
 // @Produce  json
 // @Param id path int true "Configuration ID"
 // @Success 204 "No Content"
+// @Failure 403 {object} middleware.ErrorResponse "Configuration is shared with the user"
 // @Failure 404 {object} middleware.ErrorResponse "Configuration not found or access denied"
 // @Failure 500 {object} middleware.ErrorResponse "Failed to delete configuration"
 // @Security ApiKeyAuth
 // @Router /configs/{id} [delete]
 func DeleteConfig(c *gin.Context) {
-	configID := c.Param("id")
-	userID, _ := c.Get(middleware.ContextUserIDKey)
-
-	var configName string
-	err := db.DB.QueryRow("SELECT name FROM configurations WHERE id = ? AND user_id = ?", configID, userID).Scan(&configName)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Configuration not found or access denied"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve configuration for deletion"})
+	config, ok := loadConfig(c, db.PermissionOwner)
+	if !ok {
 		return
 	}
 
-	deleteSQL := `DELETE FROM configurations WHERE id = ? AND user_id = ?`
-	_, err = db.DB.Exec(deleteSQL, configID, userID)
+	deleteSQL := `DELETE FROM configurations WHERE id = ?`
+	_, err := db.DB.Exec(deleteSQL, config.ID)
 	if err != nil {
-		log.Error().Err(err).Str("config_id", configID).Msg("Error deleting config")
+		log.Error().Err(err).Int64("config_id", config.ID).Msg("Error deleting config")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete configuration"})
 		return
 	}
 
 	// Audit log
-	configIDInt, _ := strconv.ParseInt(configID, 10, 64)
-	details := fmt.Sprintf("Configuration '%s' deleted", configName)
-	security.LogEvent(c, security.ConfigDeleted, configIDInt, details)
+	details := fmt.Sprintf("Configuration '%s' deleted", config.Name)
+	security.LogEvent(c, security.ConfigDeleted, config.ID, details)
 
 	c.Status(http.StatusNoContent)
 }
 
+// ValidateConfigResponse is the result of validating a stored configuration.
+type ValidateConfigResponse struct {
+	Valid  bool                `json:"valid"`
+	Errors []v2ray.ConfigIssue `json:"errors"`
+}
+
+// ValidateConfig godoc
+// @Summary Validate a V2Ray configuration
+// @Description Generates the V2Ray document for a stored configuration and runs the V2Ray config test against it without applying it.
+// @Tags Configs
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Configuration ID"
+// @Success 200 {object} ValidateConfigResponse
+// @Failure 404 {object} middleware.ErrorResponse "Configuration not found or access denied"
+// @Failure 500 {object} middleware.ErrorResponse "Failed to validate configuration"
+// @Security ApiKeyAuth
+// @Router /configs/{id}/validate [post]
+func ValidateConfig(c *gin.Context) {
+	configID := c.Param("id")
+	config, ok := loadConfig(c, db.PermissionRead)
+	if !ok {
+		return
+	}
+
+	response := ValidateConfigResponse{Valid: true, Errors: []v2ray.ConfigIssue{}}
+	if err := v2ray.ValidateStored(config.Protocol, []byte(config.ConfigData)); err != nil {
+		var invalid *v2ray.InvalidConfigError
+		if !errors.As(err, &invalid) {
+			log.Error().Err(err).Str("config_id", configID).Msg("Error running config validation")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate configuration"})
+			return
+		}
+		response.Valid = false
+		response.Errors = invalid.Issues
+	}
+	c.JSON(http.StatusOK, response)
+}
+
 // --- V2Ray Process Management Handlers ---
 
 func StartV2Ray(c *gin.Context) {
 	if err := v2ray.Start(); err != nil {
+		var invalid *v2ray.InvalidConfigError
+		if errors.As(err, &invalid) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Configuration failed validation", "errors": invalid.Issues})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "V2Ray service started successfully (mocked)."})
+	_, pid := v2ray.Status()
+	security.LogEvent(c, security.V2RayStarted, 0, fmt.Sprintf("V2Ray process started with pid %d", pid))
+	c.JSON(http.StatusOK, gin.H{"message": "V2Ray service started successfully.", "pid": pid})
 }
 
 func StopV2Ray(c *gin.Context) {
@@ -545,17 +619,22 @@ func StopV2Ray(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "V2Ray service stopped successfully (mocked)."})
+	security.LogEvent(c, security.V2RayStopped, 0, "V2Ray process stopped")
+	c.JSON(http.StatusOK, gin.H{"message": "V2Ray service stopped successfully."})
 }
 
 func GetV2RayStatus(c *gin.Context) {
-	isRunning, pid := v2ray.Status()
-	status := "stopped"
-	if isRunning {
-		status = "running"
+	info := v2ray.Info()
+	status := info.State
+	if status == v2ray.StateStopped && info.Crashed {
+		status = "crashed"
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"status": status,
-		"pid":    pid,
+		"status":           status,
+		"pid":              info.PID,
+		"exit_code":        info.ExitCode,
+		"last_exit_reason": info.LastExitReason,
+		"restart_count":    info.RestartCount,
+		"uptime_seconds":   int64(info.Uptime.Seconds()),
 	})
-}
\ No newline at end of file
+}
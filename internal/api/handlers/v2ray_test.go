@@ -2,21 +2,37 @@ package handlers_test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"image/png"
 	"k2ray/internal/api"
+	"k2ray/internal/api/handlers"
+	"k2ray/internal/api/middleware"
+	"k2ray/internal/archive"
 	"k2ray/internal/config"
 	"k2ray/internal/db"
+	"k2ray/internal/failover"
+	"k2ray/internal/geodata"
+	"k2ray/internal/sharelink"
 	"k2ray/internal/system"
 	"k2ray/internal/utils"
+	"k2ray/internal/v2ray"
+	"k2ray/internal/v2ray/v2raytest"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var testRouter *gin.Engine
@@ -37,9 +53,20 @@ func TestMain(m *testing.M) {
 	dbPath := tmpfile.Name()
 	tmpfile.Close()
 
+	binDir, err := os.MkdirTemp("", "test_handlers_bin_*")
+	if err != nil {
+		log.Fatalf("Failed to create temp bin dir: %v", err)
+	}
+	fakeBinary, err := v2raytest.WriteFakeBinary(binDir)
+	if err != nil {
+		log.Fatalf("Failed to write fake v2ray binary: %v", err)
+	}
+
 	config.LoadConfig("")
 	config.AppConfig.DatabaseURL = dbPath
 	config.AppConfig.JWTSecret = "a-very-secure-test-secret"
+	config.AppConfig.V2RayExecutable = fakeBinary
+	config.AppConfig.V2RayConfigPath = filepath.Join(binDir, "config.json")
 
 	db.InitDB()
 
@@ -64,6 +91,7 @@ func TestMain(m *testing.M) {
 
 	db.DB.Close()
 	os.Remove(dbPath)
+	os.RemoveAll(binDir)
 	os.Exit(code)
 }
 
@@ -149,7 +177,7 @@ func TestV2rayConfigCRUD(t *testing.T) {
 	var createdConfig db.Configuration
 
 	// 1. Create
-	configPayload := `{"name": "My Server", "protocol": "vmess", "config_data": {"v": "2", "add": "test.com", "port": 443, "id": "some-uuid-crud"}}`
+	configPayload := `{"name": "My Server", "protocol": "vmess", "config_data": {"v": "2", "add": "test.com", "port": 443, "id": "2c6384b8-5717-5d09-b53d-2bfa1ffe1a3b"}}`
 	createReq, _ := http.NewRequest(http.MethodPost, "/api/v1/configs", bytes.NewBufferString(configPayload))
 	createReq.Header.Set("Authorization", "Bearer "+accessToken)
 	createW := httptest.NewRecorder()
@@ -207,7 +235,7 @@ func TestV2rayConfigCRUD(t *testing.T) {
 func TestV2rayAccessControl(t *testing.T) {
 	// User 1 creates a config
 	user1Token, _ := loginAs(t, "user1", "password123")
-	configPayload := `{"name": "User 1s Secret", "protocol": "vmess", "config_data": {"v": "2", "add": "user1.com", "port": 443, "id": "some-uuid-acl"}}`
+	configPayload := `{"name": "User 1s Secret", "protocol": "vmess", "config_data": {"v": "2", "add": "user1.com", "port": 443, "id": "6694ea3f-7e11-51cc-8a5b-fd03ff6c9ad8"}}`
 	createReq, _ := http.NewRequest(http.MethodPost, "/api/v1/configs", bytes.NewBufferString(configPayload))
 	createReq.Header.Set("Authorization", "Bearer "+user1Token)
 	createW := httptest.NewRecorder()
@@ -246,7 +274,7 @@ func TestVlessConfig(t *testing.T) {
 	accessToken, _ := loginAs(t, "user1", "password123")
 
 	t.Run("Create VLESS Config - Success", func(t *testing.T) {
-		configPayload := `{"name": "My VLESS Server", "protocol": "vless", "config_data": {"id": "some-uuid", "add": "vless.server.com", "port": 443, "encryption": "none"}}`
+		configPayload := `{"name": "My VLESS Server", "protocol": "vless", "config_data": {"id": "2f757390-26fa-5220-b0a6-f36bc8585abe", "add": "vless.server.com", "port": 443, "encryption": "none"}}`
 		req, _ := http.NewRequest(http.MethodPost, "/api/v1/configs", bytes.NewBufferString(configPayload))
 		req.Header.Set("Authorization", "Bearer "+accessToken)
 		w := httptest.NewRecorder()
@@ -352,7 +380,7 @@ func TestV2RayProcessEndpoints(t *testing.T) {
 	assert.Equal(t, "stopped", statusResponse["status"])
 
 	// 2. Create a config to use
-	configPayload := `{"name": "My Active Server", "protocol": "vmess", "config_data": {"v": "2", "add": "active.com", "port": 443, "id": "uuid-for-active"}}`
+	configPayload := `{"name": "My Active Server", "protocol": "vmess", "config_data": {"v": "2", "add": "active.com", "port": 443, "id": "8c66c006-4c5d-538f-8f90-691002a0923e"}}`
 	createReq, _ := http.NewRequest(http.MethodPost, "/api/v1/configs", bytes.NewBufferString(configPayload))
 	createReq.Header.Set("Authorization", "Bearer "+accessToken)
 	createW := httptest.NewRecorder()
@@ -384,6 +412,7 @@ func TestV2RayProcessEndpoints(t *testing.T) {
 	assert.Equal(t, http.StatusOK, statusW2.Code)
 	json.Unmarshal(statusW2.Body.Bytes(), &statusResponse)
 	assert.Equal(t, "running", statusResponse["status"])
+	assert.NotZero(t, statusResponse["pid"])
 
 	// 6. Stop V2Ray
 	stopReq, _ := http.NewRequest(http.MethodPost, "/api/v1/v2ray/stop", nil)
@@ -413,7 +442,7 @@ func TestConfigValidationWithAdvancedFields(t *testing.T) {
 		{
 			name: "Create VMess with WebSocket",
 			payload: `{"name": "VMess WS", "protocol": "vmess", "config_data": {
-                "add": "ws.server.com", "port": 443, "id": "some-uuid", "aid": 0,
+                "add": "ws.server.com", "port": 443, "id": "2f757390-26fa-5220-b0a6-f36bc8585abe", "aid": 0,
                 "net": "ws", "tls": "tls", "wsSettings": {"path": "/chat"}
             }}`,
 			expectedStatus: http.StatusCreated,
@@ -421,7 +450,7 @@ func TestConfigValidationWithAdvancedFields(t *testing.T) {
 		{
 			name: "Create VLESS with gRPC",
 			payload: `{"name": "VLESS gRPC", "protocol": "vless", "config_data": {
-                "add": "grpc.server.com", "port": 443, "id": "some-uuid",
+                "add": "grpc.server.com", "port": 443, "id": "2f757390-26fa-5220-b0a6-f36bc8585abe",
                 "net": "grpc", "tls": "tls", "grpcSettings": {"serviceName": "my-service"}
             }}`,
 			expectedStatus: http.StatusCreated,
@@ -434,6 +463,57 @@ func TestConfigValidationWithAdvancedFields(t *testing.T) {
             }}`,
 			expectedStatus: http.StatusCreated,
 		},
+		{
+			name: "Create Hysteria2 with obfs",
+			payload: `{"name": "Hysteria2", "protocol": "hysteria2", "config_data": {
+                "server": "hy2.server.com", "server_port": 443, "password": "pass",
+                "obfs": "salamander", "obfs_password": "salt", "up_mbps": 50, "down_mbps": 200
+            }}`,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name: "Create TUIC with BBR",
+			payload: `{"name": "TUIC v5", "protocol": "tuic", "config_data": {
+                "server": "tuic.server.com", "server_port": 443, "uuid": "2f757390-26fa-5220-b0a6-f36bc8585abe", "password": "pass",
+                "congestion_control": "bbr", "alpn": ["h3"]
+            }}`,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name: "Invalid TUIC - Unknown congestion control",
+			payload: `{"name": "Bad TUIC", "protocol": "tuic", "config_data": {
+                "server": "tuic.server.com", "server_port": 443, "uuid": "2f757390-26fa-5220-b0a6-f36bc8585abe", "password": "pass",
+                "congestion_control": "vegas"
+            }}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Create SOCKS upstream with auth",
+			payload: `{"name": "Corp SOCKS", "protocol": "socks", "config_data": {
+                "server": "proxy.corp.com", "server_port": 1080, "username": "alice", "password": "pass"
+            }}`,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name: "Create WireGuard peer",
+			payload: `{"name": "WireGuard", "protocol": "wireguard", "config_data": {
+                "server": "wg.server.com", "server_port": 51820,
+                "secret_key": "K7gNU3sdo+OL0wNhqoVWhr3g6s1xYv72ol/pe/Unols=",
+                "public_key": "L/wdBjh++Lt6NDErbGw/aRVQaEUIw85+44iTdaGNb6A=",
+                "address": ["10.0.0.2/32"], "reserved": [0, 0, 0], "mtu": 1420
+            }}`,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name: "Invalid WireGuard - Bad reserved bytes",
+			payload: `{"name": "Bad WireGuard", "protocol": "wireguard", "config_data": {
+                "server": "wg.server.com", "server_port": 51820,
+                "secret_key": "K7gNU3sdo+OL0wNhqoVWhr3g6s1xYv72ol/pe/Unols=",
+                "public_key": "L/wdBjh++Lt6NDErbGw/aRVQaEUIw85+44iTdaGNb6A=",
+                "address": ["10.0.0.2/32"], "reserved": [0, 0]
+            }}`,
+			expectedStatus: http.StatusBadRequest,
+		},
 		{
 			name:           "Invalid VMess - Missing ID",
 			payload:        `{"name": "Invalid VMess", "protocol": "vmess", "config_data": {"add": "test.com"}}`,
@@ -451,3 +531,1450 @@ func TestConfigValidationWithAdvancedFields(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigValidationDetails(t *testing.T) {
+	accessToken, _ := loginAs(t, "user1", "password123")
+
+	payload := `{"name": "Bad VMess", "protocol": "vmess", "config_data": {
+        "add": "bad host", "port": "99999", "id": "not-a-uuid", "net": "ws"
+    }}`
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/configs", bytes.NewBufferString(payload))
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	var resp middleware.ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "Invalid VMess config: 4 fields failed validation", resp.Error)
+	assert.Equal(t, map[string]string{
+		"add":             "must be a hostname or IP address",
+		"port":            "must be a port between 1 and 65535",
+		"id":              "must be a UUID",
+		"wsSettings.path": "is required for the ws transport",
+	}, resp.Details)
+}
+
+func TestSetActiveConfigReload(t *testing.T) {
+	accessToken, _ := loginAs(t, "user1", "password123")
+
+	createConfig := func(payload string) db.Configuration {
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/configs", bytes.NewBufferString(payload))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code, "Failed to create config. Body: "+w.Body.String())
+		var created db.Configuration
+		json.Unmarshal(w.Body.Bytes(), &created)
+		return created
+	}
+	setActive := func(id int64) (int, map[string]any) {
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/system/active-config", bytes.NewBufferString(fmt.Sprintf(`{"config_id": %d}`, id)))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		var body map[string]any
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+	doRequest := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+
+	good := createConfig(`{"name": "Reload Good", "protocol": "vmess", "config_data": {"add": "good.com", "port": 443, "id": "6a88a032-a0e0-5b7d-8447-6d552954c423"}}`)
+	broken := createConfig(`{"name": "Reload Broken", "protocol": "vmess", "config_data": {"add": "fake-crash.test", "port": 443, "id": "1fe90aa0-be2f-5db2-b676-1e798a959ff7"}}`)
+
+	// While stopped, activating a config does not reload anything.
+	code, body := setActive(good.ID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "not_running", body["reload"])
+
+	assert.Equal(t, http.StatusOK, doRequest(http.MethodPost, "/api/v1/v2ray/start").Code)
+
+	// Re-activating the running config reloads it.
+	code, body = setActive(good.ID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "applied", body["reload"])
+
+	// A broken config is rolled back and the previous active config is kept.
+	code, body = setActive(broken.ID)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, "rolled_back", body["reload"])
+
+	activeW := doRequest(http.MethodGet, "/api/v1/system/active-config")
+	var active map[string]int64
+	json.Unmarshal(activeW.Body.Bytes(), &active)
+	assert.Equal(t, good.ID, active["active_config_id"])
+
+	assert.Equal(t, http.StatusOK, doRequest(http.MethodPost, "/api/v1/v2ray/stop").Code)
+}
+
+func TestValidateConfigEndpoint(t *testing.T) {
+	accessToken, _ := loginAs(t, "user1", "password123")
+
+	createConfig := func(payload string) db.Configuration {
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/configs", bytes.NewBufferString(payload))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code, "Failed to create config. Body: "+w.Body.String())
+		var created db.Configuration
+		json.Unmarshal(w.Body.Bytes(), &created)
+		return created
+	}
+	validate := func(id int64) (int, map[string]any) {
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/api/v1/configs/%d/validate", id), nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		var body map[string]any
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	valid := createConfig(`{"name": "Validate Good", "protocol": "vmess", "config_data": {"add": "good.com", "port": 443, "id": "c35d7f0e-23f2-52f7-90a8-5d9a58cc5682"}}`)
+	invalid := createConfig(`{"name": "Validate Bad", "protocol": "vmess", "config_data": {"add": "fake-invalid.test", "port": 443, "id": "519e621c-c9a8-5f30-a488-1e1ec833bcfd"}}`)
+
+	code, body := validate(valid.ID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, body["valid"])
+	assert.Empty(t, body["errors"])
+
+	code, body = validate(invalid.ID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, body["valid"])
+	if errs, ok := body["errors"].([]any); assert.True(t, ok) && assert.Len(t, errs, 1) {
+		issue := errs[0].(map[string]any)
+		assert.EqualValues(t, 3, issue["line"])
+		assert.Contains(t, issue["message"], "fake-invalid")
+	}
+
+	code, _ = validate(999999)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// An invalid config cannot be activated.
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/system/active-config", bytes.NewBufferString(fmt.Sprintf(`{"config_id": %d}`, invalid.ID)))
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "fake-invalid")
+
+	// Nor can V2Ray be started with one that was activated before it broke.
+	_, err := db.DB.Exec(`INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`, v2ray.ActiveConfigKey, invalid.ID)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.DB.Exec(`DELETE FROM settings WHERE key = ?`, v2ray.ActiveConfigKey) })
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/v2ray/start", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var startBody map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &startBody))
+	assert.Equal(t, "Configuration failed validation", startBody["error"])
+	if errs, ok := startBody["errors"].([]any); assert.True(t, ok) && assert.Len(t, errs, 1) {
+		assert.Contains(t, errs[0].(map[string]any)["message"], "fake-invalid")
+	}
+}
+
+func TestImportConfigs(t *testing.T) {
+	accessToken, _ := loginAs(t, "user1", "password123")
+
+	vmess := "vmess://" + base64.StdEncoding.EncodeToString([]byte(`{"v":"2","ps":"Imported VMess","add":"vmess.example.com","port":"443","id":"b831381d-6324-4d53-ad4f-8cda48b30811","aid":"0","net":"ws","type":"none","host":"cdn.example.com","path":"/ws","tls":"tls"}`))
+	links := []string{
+		vmess,
+		"vless://b831381d-6324-4d53-ad4f-8cda48b30811@vless.example.com:443?encryption=none&security=tls&sni=vless.example.com&type=grpc&serviceName=grpc#Imported%20VLESS",
+		"ss://" + base64.RawURLEncoding.EncodeToString([]byte("aes-256-gcm:secret")) + "@ss.example.com:8388#Imported%20SS",
+		"trojan://secret@trojan.example.com:443?security=tls&sni=trojan.example.com&type=ws&path=%2Ftrojan#Imported%20Trojan",
+		"trojan://@missing-password.example.com:443",
+		"http://not-a-share-link",
+	}
+	payload, _ := json.Marshal(map[string]any{"links": links})
+
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/configs/import", bytes.NewBuffer(payload))
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp handlers.ImportConfigsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 4, resp.Imported)
+	assert.Equal(t, 2, resp.Failed)
+	require.Len(t, resp.Results, len(links))
+
+	for i, r := range resp.Results[:4] {
+		assert.Equal(t, i, r.Index)
+		assert.True(t, r.Success, r.Error)
+		require.NotNil(t, r.Config)
+		assert.NotZero(t, r.Config.ID)
+	}
+	assert.Equal(t, "Imported VMess", resp.Results[0].Name)
+	assert.Equal(t, "vmess", resp.Results[0].Protocol)
+	assert.Equal(t, "Imported SS", resp.Results[2].Config.Name)
+	assert.Equal(t, "shadowsocks", resp.Results[2].Config.Protocol)
+	assert.JSONEq(t, `{"server":"ss.example.com","server_port":8388,"password":"secret","method":"aes-256-gcm"}`, resp.Results[2].Config.ConfigData)
+	assert.False(t, resp.Results[4].Success)
+	assert.NotEmpty(t, resp.Results[4].Error)
+	assert.False(t, resp.Results[5].Success)
+	assert.Contains(t, resp.Results[5].Error, "unsupported")
+
+	// Imported configs are ordinary configs owned by the caller.
+	req, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/configs/%d", resp.Results[1].Config.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// An empty list is rejected.
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/configs/import", bytes.NewBufferString(`{"links": []}`))
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestConfigShareLinkRoundTrip(t *testing.T) {
+	accessToken, _ := loginAs(t, "user1", "password123")
+
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+	importLinks := func(links ...string) []handlers.ImportResult {
+		payload, _ := json.Marshal(map[string]any{"links": links})
+		w := do(http.MethodPost, "/api/v1/configs/import", payload)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp handlers.ImportConfigsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Zero(t, resp.Failed, w.Body.String())
+		return resp.Results
+	}
+
+	originals := importLinks(
+		"vmess://"+base64.StdEncoding.EncodeToString([]byte(`{"v":"2","ps":"Share VMess","add":"vmess.example.com","port":"443","id":"b831381d-6324-4d53-ad4f-8cda48b30811","aid":"0","net":"grpc","path":"svc","tls":"tls"}`)),
+		"vless://b831381d-6324-4d53-ad4f-8cda48b30811@vless.example.com:443?encryption=none&security=tls&sni=vless.example.com&type=ws&path=%2Fws&host=cdn.example.com#Share%20VLESS",
+		"ss://"+base64.RawURLEncoding.EncodeToString([]byte("aes-128-gcm:secret"))+"@ss.example.com:8388#Share%20SS",
+		"trojan://secret@trojan.example.com:443?security=tls&sni=trojan.example.com#Share%20Trojan",
+	)
+
+	for _, original := range originals {
+		w := do(http.MethodGet, fmt.Sprintf("/api/v1/configs/%d/share", original.Config.ID), nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var share handlers.ShareLinkResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &share))
+		assert.Equal(t, original.Config.Name, share.Name)
+		assert.Equal(t, original.Config.Protocol, share.Protocol)
+
+		reimported := importLinks(share.Link)[0]
+		assert.Equal(t, original.Config.Name, reimported.Config.Name)
+		assert.Equal(t, original.Config.Protocol, reimported.Config.Protocol)
+		assert.Equal(t, original.Config.ConfigData, reimported.Config.ConfigData)
+	}
+
+	t.Run("QR code", func(t *testing.T) {
+		w := do(http.MethodGet, fmt.Sprintf("/api/v1/configs/%d/qr.png?size=300", originals[0].Config.ID), nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+		img, err := png.Decode(w.Body)
+		require.NoError(t, err)
+		assert.Equal(t, 300, img.Bounds().Dx())
+		assert.Equal(t, 300, img.Bounds().Dy())
+
+		w = do(http.MethodGet, fmt.Sprintf("/api/v1/configs/%d/qr.png?size=10", originals[0].Config.ID), nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Access control", func(t *testing.T) {
+		user2Token, _ := loginAs(t, "user2", "password456")
+		for _, path := range []string{"share", "qr.png"} {
+			req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/configs/%d/%s", originals[0].Config.ID, path), nil)
+			req.Header.Set("Authorization", "Bearer "+user2Token)
+			w := httptest.NewRecorder()
+			testRouter.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusNotFound, w.Code)
+		}
+	})
+}
+
+func TestSubscriptionEndpoints(t *testing.T) {
+	accessToken, _ := loginAs(t, "user1", "password123")
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(sharelink.EncodeFeed([]string{
+			"trojan://secret@a.example.com:443?security=tls#Feed%20A",
+			"trojan://secret@b.example.com:443?security=tls#Feed%20B",
+		})))
+	}))
+	defer feed.Close()
+
+	do := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(accessToken, http.MethodPost, "/api/v1/subscriptions", `{"name": "Provider", "url": "ftp://example.com/feed"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do(accessToken, http.MethodPost, "/api/v1/subscriptions", fmt.Sprintf(`{"name": "Provider", "url": %q}`, feed.URL))
+	assert.Equal(t, http.StatusBadRequest, w.Code, "feeds on loopback addresses are refused")
+	assert.Contains(t, w.Body.String(), "private, loopback or link-local")
+
+	// Admins may subscribe to feeds on their own network.
+	createTestUser("subadmin", "password789")
+	_, err := db.DB.Exec(`UPDATE users SET role = 'admin' WHERE username = 'subadmin'`)
+	require.NoError(t, err)
+	accessToken, _ = loginAs(t, "subadmin", "password789")
+
+	w = do(accessToken, http.MethodPost, "/api/v1/subscriptions", fmt.Sprintf(`{"name": "Provider", "url": %q}`, feed.URL))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var sub db.Subscription
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sub))
+	assert.Equal(t, 60, sub.RefreshInterval)
+	assert.Nil(t, sub.LastRefreshedAt)
+	subPath := fmt.Sprintf("/api/v1/subscriptions/%d", sub.ID)
+
+	w = do(accessToken, http.MethodPost, subPath+"/refresh", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"added":2`)
+
+	w = do(accessToken, http.MethodGet, fmt.Sprintf("/api/v1/configs?subscription_id=%d", sub.ID), "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list handlers.PaginatedConfigsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Data, 2)
+	for _, cfg := range list.Data {
+		require.NotNil(t, cfg.SubscriptionID)
+		assert.Equal(t, sub.ID, *cfg.SubscriptionID)
+	}
+
+	w = do(accessToken, http.MethodPut, subPath, `{"refresh_interval": 15}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = do(accessToken, http.MethodGet, subPath, "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sub))
+	assert.Equal(t, 15, sub.RefreshInterval)
+	assert.NotNil(t, sub.LastRefreshedAt)
+
+	user2Token, _ := loginAs(t, "user2", "password456")
+	assert.Equal(t, http.StatusNotFound, do(user2Token, http.MethodGet, subPath, "").Code)
+	assert.Equal(t, http.StatusNotFound, do(user2Token, http.MethodPost, subPath+"/refresh", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(user2Token, http.MethodDelete, subPath, "").Code)
+
+	w = do(accessToken, http.MethodDelete, subPath, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = do(accessToken, http.MethodGet, fmt.Sprintf("/api/v1/configs?subscription_id=%d", sub.ID), "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Empty(t, list.Data)
+}
+
+func TestSubscriptionTokenEndpoints(t *testing.T) {
+	createTestUser("subuser", "password789")
+	accessToken, _ := loginAs(t, "subuser", "password789")
+
+	do := func(method, path string, auth bool) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		if auth {
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+
+	payload, _ := json.Marshal(map[string]any{"links": []string{
+		"trojan://secret@trojan.example.com:443?security=tls&type=ws&path=%2Fws#Sub%20Trojan",
+		"ss://" + base64.RawURLEncoding.EncodeToString([]byte("aes-256-gcm:secret")) + "@ss.example.com:8388#Sub%20SS",
+	}})
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/configs/import", bytes.NewBuffer(payload))
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	testRouter.ServeHTTP(httptest.NewRecorder(), req)
+
+	w := do(http.MethodGet, "/api/v1/users/me/subscription-token", true)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"active": false}`, w.Body.String())
+
+	w = do(http.MethodPost, "/api/v1/users/me/subscription-token/rotate", true)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var issued handlers.RotateSubscriptionTokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &issued))
+	require.NotEmpty(t, issued.Token)
+	assert.True(t, strings.HasSuffix(issued.URL, "/sub/"+issued.Token), issued.URL)
+	subPath := "/sub/" + issued.Token
+
+	t.Run("base64 feed without JWT", func(t *testing.T) {
+		w := do(http.MethodGet, subPath, false)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		decoded, err := base64.StdEncoding.DecodeString(w.Body.String())
+		require.NoError(t, err)
+		links := strings.Split(string(decoded), "\n")
+		require.Len(t, links, 2)
+		assert.True(t, strings.HasPrefix(links[0], "trojan://"))
+		assert.True(t, strings.HasPrefix(links[1], "ss://"))
+	})
+
+	t.Run("clash", func(t *testing.T) {
+		w := do(http.MethodGet, subPath+"?format=clash", false)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "yaml")
+		assert.Contains(t, w.Body.String(), "name: Sub Trojan")
+	})
+
+	t.Run("sing-box", func(t *testing.T) {
+		w := do(http.MethodGet, subPath+"?format=sing-box", false)
+		require.Equal(t, http.StatusOK, w.Code)
+		var doc map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+		assert.Contains(t, doc, "outbounds")
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, subPath+"?format=xml", false).Code)
+	})
+
+	w = do(http.MethodGet, "/api/v1/users/me/subscription-token", true)
+	var status handlers.SubscriptionTokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.True(t, status.Active)
+	assert.NotNil(t, status.LastUsedAt)
+
+	// Rotation revokes the old token.
+	w = do(http.MethodPost, "/api/v1/users/me/subscription-token/rotate", true)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var rotated handlers.RotateSubscriptionTokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rotated))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, subPath, false).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/sub/"+rotated.Token, false).Code)
+
+	// Revocation disables the feed.
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/v1/users/me/subscription-token", true).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/sub/"+rotated.Token, false).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/v1/users/me/subscription-token", true).Code)
+
+	// The management endpoints still require a JWT.
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/v1/users/me/subscription-token/rotate", false).Code)
+}
+
+func TestExportConfigs(t *testing.T) {
+	createTestUser("exportuser", "password789")
+	accessToken, _ := loginAs(t, "exportuser", "password789")
+
+	do := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+
+	payload, _ := json.Marshal(map[string]any{"links": []string{
+		"trojan://secret@trojan.example.com:443?security=tls&type=grpc&serviceName=svc#Export%20Trojan",
+		"vless://b831381d-6324-4d53-ad4f-8cda48b30811@vless.example.com:443?security=tls&type=ws&path=%2Fws#Export%20VLESS",
+	}})
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/configs/import", bytes.NewBuffer(payload))
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	var imported handlers.ImportConfigsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &imported))
+	require.Equal(t, 2, imported.Imported)
+
+	w = do("/api/v1/configs/export?format=clash")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Type"), "yaml")
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "k2ray-clash.yaml")
+	assert.Contains(t, w.Body.String(), "name: Export Trojan")
+	assert.Contains(t, w.Body.String(), "grpc-service-name: svc")
+	assert.NotContains(t, w.Body.String(), "type: vless")
+
+	w = do("/api/v1/configs/export?format=clash-meta")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "type: vless")
+	assert.Contains(t, w.Body.String(), "type: url-test")
+
+	w = do(fmt.Sprintf("/api/v1/configs/export?format=clash-meta&ids=%d", imported.Results[1].Config.ID))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "Export Trojan")
+	assert.Contains(t, w.Body.String(), "Export VLESS")
+
+	w = do("/api/v1/configs/export?format=sing-box")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "k2ray-sing-box.json")
+	var full map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &full))
+	assert.Contains(t, full, "inbounds")
+	assert.Contains(t, full, "dns")
+
+	w = do("/api/v1/configs/export?format=sing-box&outbounds_only=true")
+	require.Equal(t, http.StatusOK, w.Code)
+	var outboundsOnly map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &outboundsOnly))
+	assert.Len(t, outboundsOnly, 1)
+	assert.Contains(t, outboundsOnly, "outbounds")
+
+	w = do("/api/v1/configs/export?format=sing-box&inbound=tun")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"type": "tun"`)
+
+	assert.Equal(t, http.StatusBadRequest, do("/api/v1/configs/export?format=sing-box&inbound=socks").Code)
+	assert.Equal(t, http.StatusBadRequest, do("/api/v1/configs/export?format=sing-box&outbounds_only=maybe").Code)
+	assert.Equal(t, http.StatusBadRequest, do("/api/v1/configs/export?format=xml").Code)
+	assert.Equal(t, http.StatusBadRequest, do("/api/v1/configs/export?format=clash&ids=1,abc").Code)
+
+	// Other users' configurations are never exported.
+	user2Token, _ := loginAs(t, "user2", "password456")
+	req, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/configs/export?format=clash&ids=%d", imported.Results[0].Config.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+user2Token)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "Export Trojan")
+}
+
+func TestConfigRevisions(t *testing.T) {
+	accessToken, _ := loginAs(t, "user1", "password123")
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		var reader *bytes.Buffer
+		if body != "" {
+			reader = bytes.NewBufferString(body)
+		} else {
+			reader = &bytes.Buffer{}
+		}
+		req, _ := http.NewRequest(method, path, reader)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/v1/configs", `{"name": "Revisioned", "protocol": "vmess", "comment": "first", "config_data": {"add": "one.example.com", "port": 443, "id": "2c6384b8-5717-5d09-b53d-2bfa1ffe1a3b"}}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var config db.Configuration
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &config))
+	base := fmt.Sprintf("/api/v1/configs/%d/revisions", config.ID)
+
+	w = do(http.MethodPut, fmt.Sprintf("/api/v1/configs/%d", config.ID), `{"name": "Revisioned 2", "comment": "move", "config_data": {"add": "two.example.com", "port": 443, "id": "2c6384b8-5717-5d09-b53d-2bfa1ffe1a3b"}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = do(http.MethodGet, base, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var revisions []db.ConfigRevision
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &revisions))
+	require.Len(t, revisions, 2)
+	assert.Equal(t, 2, revisions[0].Revision)
+	assert.Equal(t, "move", revisions[0].Comment)
+	assert.Equal(t, "first", revisions[1].Comment)
+	require.NotNil(t, revisions[1].Author)
+	assert.Equal(t, "user1", *revisions[1].Author)
+
+	// Diff
+	w = do(http.MethodGet, base+"/diff?from=1&to=2", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var diff handlers.RevisionDiffResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &diff))
+	assert.Equal(t, []utils.JSONChange{
+		{Op: "replace", Path: "/config_data/add", Old: "one.example.com", New: "two.example.com"},
+		{Op: "replace", Path: "/name", Old: "Revisioned", New: "Revisioned 2"},
+	}, diff.Changes)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, base+"/diff?from=1", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, base+"/diff?from=1&to=9", "").Code)
+
+	// Restore, with and without a body
+	w = do(http.MethodPost, base+"/1/restore", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var restored db.Configuration
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &restored))
+	assert.Equal(t, "Revisioned", restored.Name)
+	assert.Contains(t, restored.ConfigData, "one.example.com")
+
+	w = do(http.MethodPost, base+"/2/restore", `{"comment": "back again"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, base+"/9/restore", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, base+"/abc/restore", "").Code)
+
+	w = do(http.MethodGet, base, "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &revisions))
+	require.Len(t, revisions, 4)
+	assert.Equal(t, "back again", revisions[0].Comment)
+	assert.Equal(t, "Revisioned 2", revisions[0].Name)
+	assert.Equal(t, "Restored revision 1", revisions[1].Comment)
+
+	// Other users cannot see or restore the history.
+	user2Token, _ := loginAs(t, "user2", "password456")
+	req, _ := http.NewRequest(http.MethodGet, base, nil)
+	req.Header.Set("Authorization", "Bearer "+user2Token)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestConfigTagsGroupsAndSearch(t *testing.T) {
+	createTestUser("taguser", "password789")
+	accessToken, _ := loginAs(t, "taguser", "password789")
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+	list := func(query string) []string {
+		w := do(http.MethodGet, "/api/v1/configs?"+query, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp handlers.PaginatedConfigsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		names := []string{}
+		for _, config := range resp.Data {
+			names = append(names, config.Name)
+		}
+		return names
+	}
+
+	w := do(http.MethodPost, "/api/v1/groups", `{"name": "Europe"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var group db.ConfigGroup
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &group))
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/api/v1/groups", `{"name": "europe"}`).Code)
+
+	create := func(name, server, remarks string, groupID int64, tags ...string) db.Configuration {
+		payload, _ := json.Marshal(map[string]any{
+			"name": name, "protocol": "trojan", "remarks": remarks, "group_id": groupID, "tags": tags,
+			"config_data": map[string]any{"server": server, "server_port": 443, "password": "secret"},
+		})
+		w := do(http.MethodPost, "/api/v1/configs", string(payload))
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var config db.Configuration
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &config))
+		return config
+	}
+	fra := create("Frankfurt", "fra.example.com", "Hetzner dedicated", group.ID, "DE", "hetzner", "de")
+	assert.Equal(t, []string{"DE", "hetzner"}, fra.Tags)
+	require.NotNil(t, fra.GroupID)
+	create("Amsterdam", "ams.example.com", "", group.ID, "NL", "streaming")
+	nyc := create("New York", "nyc.example.net", "backup node", 0, "US", "streaming")
+	assert.Nil(t, nyc.GroupID)
+
+	w = do(http.MethodGet, fmt.Sprintf("/api/v1/configs/%d", fra.ID), "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var got db.Configuration
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, []string{"DE", "hetzner"}, got.Tags)
+	assert.Equal(t, "Hetzner dedicated", got.Remarks)
+
+	// Filters combine with each other, sorting and pagination.
+	assert.Equal(t, []string{"Amsterdam", "New York"}, list("tags=streaming&sort_by=name"))
+	assert.Equal(t, []string{"Frankfurt"}, list("tags=de,HETZNER"))
+	assert.Empty(t, list("tags=de,streaming"))
+	assert.Equal(t, []string{"New York", "Frankfurt", "Amsterdam"}, list("tags=de,streaming&tag_mode=any&sort_by=name&order=desc"))
+	assert.Equal(t, []string{"Frankfurt"}, list("tags=de,streaming&tag_mode=any&sort_by=name&order=desc&limit=1&page=2"))
+	assert.Equal(t, []string{"Amsterdam", "Frankfurt"}, list(fmt.Sprintf("group_id=%d&sort_by=name", group.ID)))
+	assert.Equal(t, []string{"New York"}, list("group_id=none"))
+	assert.Equal(t, []string{"Frankfurt"}, list("search=hetz"))
+	assert.Equal(t, []string{"New York"}, list("search=nyc+backup"))
+	assert.Equal(t, []string{"Amsterdam", "Frankfurt"}, list("search=example.com&sort_by=name"))
+	assert.Equal(t, []string{"Amsterdam"}, list(fmt.Sprintf("search=example&tags=streaming&group_id=%d", group.ID)))
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/api/v1/configs?tags=de&tag_mode=some", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, fmt.Sprintf("/api/v1/configs/%d", fra.ID), `{"group_id": 999999}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, fmt.Sprintf("/api/v1/configs/%d", fra.ID), `{"tags": ["a,b"]}`).Code)
+
+	// Updating replaces the tags and can move the config out of its group.
+	w = do(http.MethodPut, fmt.Sprintf("/api/v1/configs/%d", fra.ID), `{"tags": ["streaming"], "group_id": 0, "remarks": "moved"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, []string{"streaming"}, got.Tags)
+	assert.Nil(t, got.GroupID)
+	assert.Equal(t, []string{"Frankfurt", "New York"}, list("group_id=none&sort_by=name"))
+	assert.Equal(t, []string{"Frankfurt"}, list("search=moved"))
+
+	// Tags and groups can be listed and deleted.
+	w = do(http.MethodGet, "/api/v1/tags", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var tags []db.Tag
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tags))
+	counts := map[string]int{}
+	for _, tag := range tags {
+		counts[tag.Name] = tag.Configs
+	}
+	assert.Equal(t, map[string]int{"DE": 0, "hetzner": 0, "NL": 1, "streaming": 3, "US": 1}, counts)
+	for _, tag := range tags {
+		if tag.Name == "streaming" {
+			assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, fmt.Sprintf("/api/v1/tags/%d", tag.ID), "").Code)
+		}
+	}
+	assert.Empty(t, list("tags=streaming"))
+
+	w = do(http.MethodGet, "/api/v1/groups", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var groups []handlers.GroupResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &groups))
+	require.Len(t, groups, 1)
+	assert.Equal(t, 1, groups[0].Configs)
+
+	assert.Equal(t, http.StatusOK, do(http.MethodPut, fmt.Sprintf("/api/v1/groups/%d", group.ID), `{"name": "EU"}`).Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, fmt.Sprintf("/api/v1/groups/%d", group.ID), "").Code)
+	assert.Equal(t, []string{"Amsterdam", "Frankfurt", "New York"}, list("group_id=none&sort_by=name"))
+
+	// Other users see none of it.
+	user2Token, _ := loginAs(t, "user2", "password456")
+	req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("/api/v1/tags/%d", tags[0].ID), nil)
+	req.Header.Set("Authorization", "Bearer "+user2Token)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestConfigTemplates(t *testing.T) {
+	createTestUser("templateuser", "password789")
+	accessToken, _ := loginAs(t, "templateuser", "password789")
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+	getConfig := func(id int64) db.Configuration {
+		w := do(http.MethodGet, fmt.Sprintf("/api/v1/configs/%d", id), "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var config db.Configuration
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &config))
+		return config
+	}
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/v1/templates", `{"name": "Broken", "protocol": "trojan", "config_data": [1]}`).Code)
+
+	w := do(http.MethodPost, "/api/v1/templates", `{"name": "Trojan node", "protocol": "trojan",
+		"config_data": {"server": "{{host}}.example.com", "server_port": "{{port}}", "password": "{{password}}"}}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var tmpl handlers.TemplateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tmpl))
+	assert.Equal(t, []string{"host", "password", "port"}, tmpl.Placeholders)
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/api/v1/templates", `{"name": "Trojan node", "protocol": "trojan", "config_data": {}}`).Code)
+
+	// Each instance succeeds or fails on its own.
+	w = do(http.MethodPost, fmt.Sprintf("/api/v1/templates/%d/instantiate", tmpl.ID), `{"tags": ["fleet"], "instances": [
+		{"name": "Node {{host}}", "variables": {"host": "fra", "port": 443, "password": "one"}},
+		{"name": "Node {{host}}", "variables": {"host": "ams", "port": 8443, "password": "two"}},
+		{"name": "Node {{host}}", "variables": {"host": "bad", "port": 70000, "password": "three"}},
+		{"name": "Node {{host}}", "variables": {"host": "nyc"}}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var inst handlers.InstantiateTemplateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &inst))
+	assert.Equal(t, 2, inst.Created)
+	assert.Equal(t, 2, inst.Failed)
+	require.Len(t, inst.Results, 4)
+	assert.Equal(t, "Node fra", inst.Results[0].Name)
+	assert.Contains(t, inst.Results[2].Details, "server_port")
+	assert.Contains(t, inst.Results[3].Error, "missing variables: password, port")
+
+	fra := getConfig(inst.Results[0].Config.ID)
+	assert.JSONEq(t, `{"server": "fra.example.com", "server_port": 443, "password": "one"}`, fra.ConfigData)
+	assert.Equal(t, []string{"fleet"}, fra.Tags)
+	require.NotNil(t, fra.TemplateID)
+	assert.Equal(t, tmpl.ID, *fra.TemplateID)
+
+	// Editing the template re-renders the linked configurations as new revisions.
+	w = do(http.MethodPut, fmt.Sprintf("/api/v1/templates/%d", tmpl.ID), `{"rerender": true,
+		"config_data": {"server": "{{host}}.example.net", "server_port": "{{port}}", "password": "{{password}}", "sni": "{{host}}.example.net"}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var updated handlers.UpdateTemplateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.Equal(t, 2, updated.Template.Configs)
+	require.Len(t, updated.Rerendered, 2)
+	for _, result := range updated.Rerendered {
+		assert.True(t, result.Success, result.Error)
+		assert.Equal(t, 2, result.Revision)
+	}
+	fra = getConfig(fra.ID)
+	assert.JSONEq(t, `{"server": "fra.example.net", "server_port": 443, "password": "one", "sni": "fra.example.net"}`, fra.ConfigData)
+
+	w = do(http.MethodGet, fmt.Sprintf("/api/v1/configs/%d/revisions", fra.ID), "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var revisions []db.ConfigRevision
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &revisions))
+	require.Len(t, revisions, 2)
+	assert.Equal(t, "Re-rendered from template 'Trojan node'", revisions[0].Comment)
+
+	// Without rerender the configurations are left alone.
+	w = do(http.MethodPut, fmt.Sprintf("/api/v1/templates/%d", tmpl.ID), `{"config_data": {"server": "{{host}}", "server_port": "{{port}}", "password": "{{password}}"}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, getConfig(fra.ID).ConfigData, "fra.example.net")
+
+	// Other users cannot see or use the template.
+	user2Token, _ := loginAs(t, "user2", "password456")
+	req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/api/v1/templates/%d/instantiate", tmpl.ID), bytes.NewBufferString(`{"instances": [{"name": "Stolen"}]}`))
+	req.Header.Set("Authorization", "Bearer "+user2Token)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Deleting the template keeps its configurations but unlinks them.
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, fmt.Sprintf("/api/v1/templates/%d", tmpl.ID), "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, fmt.Sprintf("/api/v1/templates/%d", tmpl.ID), "").Code)
+	fra = getConfig(fra.ID)
+	assert.Nil(t, fra.TemplateID)
+	assert.Nil(t, fra.TemplateVars)
+}
+
+func TestConfigSharing(t *testing.T) {
+	createTestUser("shareowner", "password789")
+	createTestUser("sharereader", "password789")
+	createTestUser("sharewriter", "password789")
+	createTestUser("shareadmin", "password789")
+	_, err := db.DB.Exec(`UPDATE users SET role = 'admin' WHERE username = 'shareadmin'`)
+	require.NoError(t, err)
+
+	tokens := map[string]string{}
+	for _, name := range []string{"shareowner", "sharereader", "sharewriter", "shareadmin"} {
+		tokens[name], _ = loginAs(t, name, "password789")
+	}
+	do := func(user, method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+tokens[user])
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("shareowner", http.MethodPost, "/api/v1/configs", `{"name": "Team node", "protocol": "trojan", "tags": ["team"],
+		"config_data": {"server": "team.example.com", "server_port": 443, "password": "secret"}}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var config db.Configuration
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &config))
+	configPath := fmt.Sprintf("/api/v1/configs/%d", config.ID)
+
+	// Before sharing, nobody else can see the configuration.
+	assert.Equal(t, http.StatusNotFound, do("sharereader", http.MethodGet, configPath, "").Code)
+
+	// User groups are managed by admins.
+	assert.Equal(t, http.StatusForbidden, do("shareowner", http.MethodPost, "/api/v1/user-groups", `{"name": "Editors"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("shareadmin", http.MethodPost, "/api/v1/user-groups", `{"name": "Editors", "members": ["nobody"]}`).Code)
+	w = do("shareadmin", http.MethodPost, "/api/v1/user-groups", `{"name": "Editors", "members": ["sharewriter"]}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var group db.UserGroup
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &group))
+	assert.Equal(t, []string{"sharewriter"}, group.Members)
+
+	// Only the owner can share, and only with existing users and groups.
+	assert.Equal(t, http.StatusNotFound, do("sharereader", http.MethodPost, configPath+"/shares", `{"user": "sharereader", "permission": "write"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("shareowner", http.MethodPost, configPath+"/shares", `{"user": "nobody", "permission": "read"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("shareowner", http.MethodPost, configPath+"/shares", `{"user": "shareowner", "permission": "read"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("shareowner", http.MethodPost, configPath+"/shares", `{"user": "sharereader", "user_group": "Editors", "permission": "read"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("shareowner", http.MethodPost, configPath+"/shares", `{"user": "sharereader", "permission": "admin"}`).Code)
+
+	w = do("shareowner", http.MethodPost, configPath+"/shares", `{"user": "sharereader", "permission": "write"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var readerShare db.ConfigShare
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &readerShare))
+	w = do("shareowner", http.MethodPost, configPath+"/shares", `{"user": "sharereader", "permission": "read"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = do("shareowner", http.MethodPost, configPath+"/shares", `{"user_group": "Editors", "permission": "write"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	w = do("shareowner", http.MethodGet, configPath+"/shares", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var shares []db.ConfigShare
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &shares))
+	require.Len(t, shares, 2)
+	assert.Equal(t, "sharereader", *shares[0].Username)
+	assert.Equal(t, db.PermissionRead, shares[0].Permission)
+	assert.Equal(t, "Editors", *shares[1].UserGroup)
+
+	// Shared configurations are listed with their owner and the user's permission.
+	listed := func(user, query string) []handlers.ConfigListItem {
+		w := do(user, http.MethodGet, "/api/v1/configs?"+query, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp handlers.PaginatedConfigsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp.Data
+	}
+	configs := listed("sharereader", "")
+	require.Len(t, configs, 1)
+	assert.Equal(t, "shareowner", configs[0].Owner)
+	assert.Equal(t, db.PermissionRead, configs[0].Permission)
+	assert.Equal(t, []string{"team"}, configs[0].Tags)
+	assert.Empty(t, listed("sharereader", "scope=owned"))
+	assert.Len(t, listed("sharewriter", "scope=shared"), 1)
+	configs = listed("shareowner", "")
+	require.Len(t, configs, 1)
+	assert.Equal(t, db.PermissionOwner, configs[0].Permission)
+	assert.Empty(t, listed("shareowner", "scope=shared"))
+	assert.Equal(t, http.StatusBadRequest, do("shareowner", http.MethodGet, "/api/v1/configs?scope=everything", "").Code)
+
+	// Readers can view and activate, but not edit or delete.
+	w = do("sharereader", http.MethodGet, configPath, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusOK, do("sharereader", http.MethodGet, configPath+"/revisions", "").Code)
+	assert.Equal(t, http.StatusForbidden, do("sharereader", http.MethodPut, configPath, `{"name": "Renamed"}`).Code)
+	assert.Equal(t, http.StatusForbidden, do("sharereader", http.MethodDelete, configPath, "").Code)
+	assert.Equal(t, http.StatusForbidden, do("sharereader", http.MethodPost, configPath+"/revisions/1/restore", "").Code)
+	w = do("sharereader", http.MethodPost, "/api/v1/system/active-config", fmt.Sprintf(`{"config_id": %d}`, config.ID))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Writers, here through their group, can edit but not delete, reshare or retag.
+	w = do("sharewriter", http.MethodPut, configPath, `{"name": "Renamed node"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var updated db.Configuration
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.Equal(t, "Renamed node", updated.Name)
+	assert.Equal(t, db.PermissionWrite, updated.Permission)
+	assert.Equal(t, http.StatusForbidden, do("sharewriter", http.MethodPut, configPath, `{"tags": ["mine"]}`).Code)
+	assert.Equal(t, http.StatusForbidden, do("sharewriter", http.MethodDelete, configPath, "").Code)
+	assert.Equal(t, http.StatusForbidden, do("sharewriter", http.MethodGet, configPath+"/shares", "").Code)
+
+	w = do("shareowner", http.MethodGet, configPath+"/revisions", "")
+	var revisions []db.ConfigRevision
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &revisions))
+	require.NotEmpty(t, revisions)
+	assert.Equal(t, "sharewriter", *revisions[0].Author)
+
+	// Removing the user from the group revokes their access.
+	w = do("shareadmin", http.MethodPut, fmt.Sprintf("/api/v1/user-groups/%d", group.ID), `{"members": []}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusNotFound, do("sharewriter", http.MethodGet, configPath, "").Code)
+
+	// Unsharing revokes access too.
+	assert.Equal(t, http.StatusNoContent, do("shareowner", http.MethodDelete, fmt.Sprintf("%s/shares/%d", configPath, readerShare.ID), "").Code)
+	assert.Equal(t, http.StatusNotFound, do("shareowner", http.MethodDelete, fmt.Sprintf("%s/shares/%d", configPath, readerShare.ID), "").Code)
+	assert.Equal(t, http.StatusNotFound, do("sharereader", http.MethodGet, configPath, "").Code)
+
+	assert.Equal(t, http.StatusNoContent, do("shareadmin", http.MethodDelete, fmt.Sprintf("/api/v1/user-groups/%d", group.ID), "").Code)
+	w = do("shareowner", http.MethodGet, configPath+"/shares", "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &shares))
+	assert.Empty(t, shares)
+	assert.Equal(t, http.StatusNoContent, do("shareowner", http.MethodDelete, configPath, "").Code)
+}
+
+func TestConfigArchive(t *testing.T) {
+	createTestUser("archivesrc", "password789")
+	createTestUser("archivedst", "password789")
+	srcToken, _ := loginAs(t, "archivesrc", "password789")
+	dstToken, _ := loginAs(t, "archivedst", "password789")
+
+	do := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+	importArchive := func(query string, a *archive.Archive) handlers.ArchiveImportResponse {
+		body, _ := json.Marshal(a)
+		w := do(dstToken, http.MethodPost, "/api/v1/configs/import?"+query, string(body))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp handlers.ArchiveImportResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	names := func() []string {
+		w := do(dstToken, http.MethodGet, "/api/v1/configs?sort_by=name", "")
+		var resp handlers.PaginatedConfigsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		names := []string{}
+		for _, config := range resp.Data {
+			names = append(names, config.Name)
+		}
+		return names
+	}
+
+	w := do(srcToken, http.MethodPost, "/api/v1/groups", `{"name": "Europe"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var group db.ConfigGroup
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &group))
+	w = do(srcToken, http.MethodPost, "/api/v1/configs", fmt.Sprintf(`{"name": "Frankfurt", "protocol": "trojan", "remarks": "primary",
+		"group_id": %d, "tags": ["de"], "config_data": {"server": "fra.example.com", "server_port": 443, "password": "one"}}`, group.ID))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var fra db.Configuration
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &fra))
+	w = do(srcToken, http.MethodPut, fmt.Sprintf("/api/v1/configs/%d", fra.ID), `{"config_data": {"server": "fra.example.com", "server_port": 8443, "password": "one"}, "comment": "new port"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = do(srcToken, http.MethodPost, "/api/v1/configs", `{"name": "Amsterdam", "protocol": "trojan", "config_data": {"server": "ams.example.com", "server_port": 443, "password": "two"}}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	// Tags are included by default, revisions only on request.
+	w = do(srcToken, http.MethodGet, "/api/v1/configs/export?format=k2ray", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	plain, err := archive.Parse(w.Body.Bytes())
+	require.NoError(t, err)
+	require.Len(t, plain.Configs, 2)
+	assert.Equal(t, []string{"de"}, plain.Configs[0].Tags)
+	assert.Equal(t, "Europe", plain.Configs[0].Group)
+	assert.Empty(t, plain.Configs[0].Revisions)
+
+	w = do(srcToken, http.MethodGet, "/api/v1/configs/export?format=k2ray&include_revisions=true&include_tags=false", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "k2ray-archive.json")
+	full, err := archive.Parse(w.Body.Bytes())
+	require.NoError(t, err)
+	assert.Empty(t, full.Configs[0].Tags)
+	require.Len(t, full.Configs[0].Revisions, 2)
+	assert.Equal(t, "new port", full.Configs[0].Revisions[1].Comment)
+	assert.Equal(t, "archivesrc", full.Configs[0].Revisions[0].Author)
+	assert.Equal(t, http.StatusBadRequest, do(srcToken, http.MethodGet, "/api/v1/configs/export?format=k2ray&include_revisions=maybe", "").Code)
+
+	// A dry run reports what would happen without changing anything.
+	resp := importArchive("dry_run=true", full)
+	assert.True(t, resp.DryRun)
+	assert.Equal(t, 2, resp.Created)
+	assert.Empty(t, names())
+
+	// Importing recreates the configurations with their group and history.
+	resp = importArchive("", full)
+	assert.Equal(t, 2, resp.Created, resp)
+	assert.Equal(t, []string{"Amsterdam", "Frankfurt"}, names())
+	imported := resp.Results[0].ConfigID
+	w = do(dstToken, http.MethodGet, fmt.Sprintf("/api/v1/configs/%d/revisions", imported), "")
+	var revisions []db.ConfigRevision
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &revisions))
+	require.Len(t, revisions, 3)
+	assert.Equal(t, "Imported from archive", revisions[0].Comment)
+	assert.Equal(t, "new port", revisions[1].Comment)
+	require.NotNil(t, revisions[1].Author, "authors are matched to local users by username")
+	assert.Equal(t, "archivesrc", *revisions[1].Author)
+	w = do(dstToken, http.MethodGet, "/api/v1/groups", "")
+	assert.Contains(t, w.Body.String(), "Europe")
+
+	// Importing again skips by default.
+	resp = importArchive("", full)
+	assert.Equal(t, 2, resp.Skipped)
+
+	// Overwriting reports the changes, and leaves identical configurations alone.
+	plain.Configs[0].ConfigData = json.RawMessage(`{"server": "fra2.example.com", "server_port": 8443, "password": "one"}`)
+	resp = importArchive("strategy=overwrite&dry_run=true", plain)
+	assert.Equal(t, 1, resp.Overwritten)
+	assert.Equal(t, 1, resp.Unchanged)
+	paths := []string{}
+	for _, change := range resp.Results[0].Changes {
+		paths = append(paths, change.Path)
+	}
+	assert.Equal(t, []string{"/config_data/server", "/tags/0"}, paths)
+
+	resp = importArchive("strategy=overwrite", plain)
+	assert.Equal(t, 1, resp.Overwritten)
+	w = do(dstToken, http.MethodGet, fmt.Sprintf("/api/v1/configs/%d", imported), "")
+	var got db.Configuration
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Contains(t, got.ConfigData, "fra2.example.com")
+	assert.Equal(t, []string{"de"}, got.Tags)
+
+	// Renaming imports copies next to the originals.
+	resp = importArchive("strategy=rename", plain)
+	assert.Equal(t, 2, resp.Created)
+	assert.Equal(t, "Frankfurt (2)", resp.Results[0].NewName)
+	assert.Equal(t, []string{"Amsterdam", "Amsterdam (2)", "Frankfurt", "Frankfurt (2)"}, names())
+
+	// Invalid configurations fail individually; bad archives and options fail the request.
+	plain.Configs[1].ConfigData = json.RawMessage(`{"server": "ams.example.com"}`)
+	resp = importArchive("strategy=rename", plain)
+	assert.Equal(t, 1, resp.Failed)
+	assert.NotEmpty(t, resp.Results[1].Details)
+	assert.Equal(t, http.StatusBadRequest, do(dstToken, http.MethodPost, "/api/v1/configs/import?strategy=merge", `{"format": "k2ray", "version": 1, "configs": []}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(dstToken, http.MethodPost, "/api/v1/configs/import", `{"format": "k2ray", "version": 99, "configs": []}`).Code)
+}
+
+func TestRoutingRules(t *testing.T) {
+	createTestUser("routingadmin", "password789")
+	_, err := db.DB.Exec(`UPDATE users SET role = 'admin' WHERE username = 'routingadmin'`)
+	require.NoError(t, err)
+	adminToken, _ := loginAs(t, "routingadmin", "password789")
+	userToken, _ := loginAs(t, "user1", "password123")
+	t.Cleanup(func() { db.DB.Exec(`DELETE FROM routing_rules`) })
+
+	do := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+	create := func(body string) db.RoutingRule {
+		w := do(adminToken, http.MethodPost, "/api/v1/routing/rules", body)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var rule db.RoutingRule
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rule))
+		return rule
+	}
+	list := func(w *httptest.ResponseRecorder) []string {
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var rules []db.RoutingRule
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rules))
+		names := []string{}
+		for _, rule := range rules {
+			names = append(names, rule.Name)
+		}
+		return names
+	}
+
+	// Routing applies to the whole server, so only admins manage it.
+	assert.Equal(t, http.StatusForbidden, do(userToken, http.MethodGet, "/api/v1/routing/rules", "").Code)
+
+	direct := create(`{"name": "Direct", "domain": ["domain:example.com", "full:www.example.org"], "outbound_tag": "direct"}`)
+	assert.True(t, direct.Enabled)
+	assert.Equal(t, 1, direct.Position)
+	assert.Equal(t, []string{}, direct.IP)
+	block := create(`{"name": "Block", "ip": ["geoip:cn"], "network": "tcp,udp", "outbound_tag": "block"}`)
+	ads := create(`{"name": "Ads", "position": 1, "domain": ["geosite:category-ads-all"], "outbound_tag": "block", "enabled": false}`)
+	assert.Equal(t, []string{"Ads", "Direct", "Block"}, list(do(adminToken, http.MethodGet, "/api/v1/routing/rules", "")))
+
+	// Rule syntax is validated field by field.
+	w := do(adminToken, http.MethodPost, "/api/v1/routing/rules", `{"name": "Bad", "domain": ["regexp:(", "ext:geo.dat"], "port": "70000", "outbound_tag": "tor"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	var errResp middleware.ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Len(t, errResp.Details, 4, errResp.Details)
+	assert.Equal(t, http.StatusBadRequest, do(adminToken, http.MethodPost, "/api/v1/routing/rules", `{"name": "Empty", "outbound_tag": "direct"}`).Code)
+
+	// Updates merge into the existing rule and are validated as a whole.
+	w = do(adminToken, http.MethodPut, fmt.Sprintf("/api/v1/routing/rules/%d", ads.ID), `{"enabled": true, "inbound_tag": ["socks-in"]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var updated db.RoutingRule
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.True(t, updated.Enabled)
+	assert.Equal(t, []string{"geosite:category-ads-all"}, updated.Domain)
+	assert.Equal(t, []string{"socks-in"}, updated.InboundTag)
+	assert.Equal(t, http.StatusBadRequest, do(adminToken, http.MethodPut, fmt.Sprintf("/api/v1/routing/rules/%d", ads.ID), `{"domain": [], "inbound_tag": []}`).Code)
+	assert.Equal(t, http.StatusNotFound, do(adminToken, http.MethodPut, "/api/v1/routing/rules/999999", `{"enabled": true}`).Code)
+
+	// Reordering must list every rule exactly once.
+	assert.Equal(t, http.StatusBadRequest, do(adminToken, http.MethodPost, "/api/v1/routing/rules/reorder", fmt.Sprintf(`{"ids": [%d, %d]}`, block.ID, ads.ID)).Code)
+	w = do(adminToken, http.MethodPost, "/api/v1/routing/rules/reorder", fmt.Sprintf(`{"ids": [%d, %d, %d]}`, block.ID, ads.ID, direct.ID))
+	assert.Equal(t, []string{"Block", "Ads", "Direct"}, list(w))
+
+	w = do(adminToken, http.MethodDelete, fmt.Sprintf("/api/v1/routing/rules/%d", ads.ID), "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, http.StatusNotFound, do(adminToken, http.MethodGet, fmt.Sprintf("/api/v1/routing/rules/%d", ads.ID), "").Code)
+	w = do(adminToken, http.MethodGet, fmt.Sprintf("/api/v1/routing/rules/%d", direct.ID), "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.Equal(t, 2, updated.Position)
+}
+
+func TestBalancers(t *testing.T) {
+	createTestUser("balanceruser", "password789")
+	token, _ := loginAs(t, "balanceruser", "password789")
+	otherToken, _ := loginAs(t, "user2", "password456")
+
+	do := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+	createConfig := func(token, name, server string) int64 {
+		w := do(token, http.MethodPost, "/api/v1/configs", fmt.Sprintf(`{"name": %q, "protocol": "trojan", "config_data": {"server": %q, "server_port": 443, "password": "secret"}}`, name, server))
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var config db.Configuration
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &config))
+		return config.ID
+	}
+	decode := func(w *httptest.ResponseRecorder) handlers.BalancerResponse {
+		var b handlers.BalancerResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &b), w.Body.String())
+		return b
+	}
+	fra := createConfig(token, "Balanced Frankfurt", "fra.example.com")
+	ams := createConfig(token, "Balanced Amsterdam", "ams.example.com")
+	foreign := createConfig(otherToken, "Not Mine", "other.example.com")
+
+	w := do(token, http.MethodPost, "/api/v1/balancers", fmt.Sprintf(`{"name": "Europe", "strategy": "leastPing", "members": [%d, %d]}`, fra, ams))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	balancer := decode(w)
+	assert.Equal(t, []int64{fra, ams}, balancer.Members)
+	assert.False(t, balancer.Active)
+
+	for name, body := range map[string]string{
+		"unknown strategy":  fmt.Sprintf(`{"name": "Bad", "strategy": "fastest", "members": [%d]}`, fra),
+		"no members":        `{"name": "Bad", "strategy": "random", "members": []}`,
+		"foreign member":    fmt.Sprintf(`{"name": "Bad", "strategy": "random", "members": [%d, %d]}`, fra, foreign),
+		"duplicate member":  fmt.Sprintf(`{"name": "Bad", "strategy": "random", "members": [%d, %d]}`, fra, fra),
+		"short interval":    fmt.Sprintf(`{"name": "Bad", "strategy": "random", "members": [%d], "probe_interval": "1s"}`, fra),
+		"invalid probe url": fmt.Sprintf(`{"name": "Bad", "strategy": "random", "members": [%d], "probe_url": "ftp://example.com"}`, fra),
+	} {
+		assert.Equal(t, http.StatusBadRequest, do(token, http.MethodPost, "/api/v1/balancers", body).Code, name)
+	}
+	assert.Equal(t, http.StatusConflict, do(token, http.MethodPost, "/api/v1/balancers", fmt.Sprintf(`{"name": "Europe", "strategy": "random", "members": [%d]}`, fra)).Code)
+
+	path := fmt.Sprintf("/api/v1/balancers/%d", balancer.ID)
+	assert.Equal(t, http.StatusNotFound, do(otherToken, http.MethodGet, path, "").Code, "balancers are private to their owner")
+	w = do(token, http.MethodPut, path, fmt.Sprintf(`{"strategy": "roundRobin", "members": [%d, %d], "probe_interval": "30s"}`, ams, fra))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	balancer = decode(w)
+	assert.Equal(t, "roundRobin", balancer.Strategy)
+	assert.Equal(t, []int64{ams, fra}, balancer.Members)
+	assert.Equal(t, "Europe", balancer.Name)
+
+	// A balancer is activated in place of a single configuration.
+	assert.Equal(t, http.StatusBadRequest, do(token, http.MethodPost, "/api/v1/system/active-config", fmt.Sprintf(`{"config_id": %d, "balancer_id": %d}`, fra, balancer.ID)).Code)
+	assert.Equal(t, http.StatusNotFound, do(otherToken, http.MethodPost, "/api/v1/system/active-config", fmt.Sprintf(`{"balancer_id": %d}`, balancer.ID)).Code)
+	w = do(token, http.MethodPost, "/api/v1/system/active-config", fmt.Sprintf(`{"balancer_id": %d}`, balancer.ID))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = do(token, http.MethodGet, "/api/v1/system/active-config", "")
+	assert.JSONEq(t, fmt.Sprintf(`{"active_balancer_id": %d}`, balancer.ID), w.Body.String())
+	assert.True(t, decode(do(token, http.MethodGet, path, "")).Active)
+	assert.Equal(t, http.StatusConflict, do(token, http.MethodDelete, path, "").Code, "the active balancer cannot be deleted")
+
+	// Deleting a member removes it from the balancer.
+	assert.Equal(t, http.StatusNoContent, do(token, http.MethodDelete, fmt.Sprintf("/api/v1/configs/%d", ams), "").Code)
+	assert.Equal(t, []int64{fra}, decode(do(token, http.MethodGet, path, "")).Members)
+
+	w = do(token, http.MethodPost, "/api/v1/system/active-config", fmt.Sprintf(`{"config_id": %d}`, fra))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = do(token, http.MethodGet, "/api/v1/system/active-config", "")
+	assert.JSONEq(t, fmt.Sprintf(`{"active_config_id": %d}`, fra), w.Body.String())
+	assert.Equal(t, http.StatusNoContent, do(token, http.MethodDelete, path, "").Code)
+	assert.Equal(t, http.StatusNotFound, do(token, http.MethodGet, path, "").Code)
+}
+
+func TestConfigProbes(t *testing.T) {
+	createTestUser("probeuser", "password789")
+	token, _ := loginAs(t, "probeuser", "password789")
+	otherToken, _ := loginAs(t, "user2", "password456")
+
+	do := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+	createConfig := func(token, name string, port int) int64 {
+		w := do(token, http.MethodPost, "/api/v1/configs", fmt.Sprintf(`{"name": %q, "protocol": "socks", "config_data": {"server": "127.0.0.1", "server_port": %d}}`, name, port))
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var config db.Configuration
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &config))
+		return config.ID
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed.Close()
+
+	up := createConfig(token, "Probe Up", listener.Addr().(*net.TCPAddr).Port)
+	down := createConfig(token, "Probe Down", closed.Addr().(*net.TCPAddr).Port)
+	createConfig(token, "Probe Never", 1080)
+	foreign := createConfig(otherToken, "Probe Foreign", 1080)
+
+	w := do(token, http.MethodPost, fmt.Sprintf("/api/v1/configs/%d/probe", up), "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var result db.ProbeResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, "ok", result.Status)
+	assert.NotNil(t, result.TCPConnectMs)
+	assert.Equal(t, http.StatusNotFound, do(token, http.MethodPost, fmt.Sprintf("/api/v1/configs/%d/probe", foreign), "").Code)
+
+	w = do(token, http.MethodPost, "/api/v1/configs/probe", fmt.Sprintf(`{"ids": [%d, %d, %d, %d]}`, down, foreign, up, down))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var bulk handlers.ProbeConfigsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &bulk))
+	require.Len(t, bulk.Results, 2, "inaccessible and duplicate IDs are skipped")
+	assert.Equal(t, down, bulk.Results[0].ConfigurationID)
+	assert.Equal(t, "unreachable", bulk.Results[0].Status)
+	assert.Equal(t, up, bulk.Results[1].ConfigurationID)
+	assert.Equal(t, http.StatusBadRequest, do(token, http.MethodPost, "/api/v1/configs/probe", `{"ids": []}`).Code)
+
+	w = do(token, http.MethodGet, fmt.Sprintf("/api/v1/configs/%d/probes", up), "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var history []db.ProbeResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	assert.Len(t, history, 2)
+
+	// The list carries the latest probe of each configuration.
+	w = do(token, http.MethodGet, "/api/v1/configs?name=Probe&scope=owned", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var list struct {
+		Data []map[string]any `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	byName := map[string]map[string]any{}
+	for _, item := range list.Data {
+		byName[item["Name"].(string)] = item
+	}
+	require.Len(t, byName, 3)
+	assert.Equal(t, "ok", byName["Probe Up"]["LastProbeStatus"])
+	assert.NotNil(t, byName["Probe Up"]["LastLatencyMs"])
+	assert.Equal(t, "unreachable", byName["Probe Down"]["LastProbeStatus"])
+	assert.Nil(t, byName["Probe Down"]["LastLatencyMs"])
+	assert.Contains(t, byName["Probe Never"], "LastProbeStatus")
+	assert.Nil(t, byName["Probe Never"]["LastProbeStatus"])
+	for key := range byName["Probe Up"] {
+		assert.NotContains(t, key, "_", "list items use the keys of the configuration they embed")
+	}
+}
+
+func TestFailoverPolicy(t *testing.T) {
+	createTestUser("failoveradmin", "password789")
+	_, err := db.DB.Exec(`UPDATE users SET role = 'admin' WHERE username = 'failoveradmin'`)
+	require.NoError(t, err)
+	adminToken, _ := loginAs(t, "failoveradmin", "password789")
+	userToken, _ := loginAs(t, "user1", "password123")
+
+	do := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+	createConfig := func(token, name string, port int) int64 {
+		w := do(token, http.MethodPost, "/api/v1/configs", fmt.Sprintf(`{"name": %q, "protocol": "socks", "config_data": {"server": "127.0.0.1", "server_port": %d}}`, name, port))
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var config db.Configuration
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &config))
+		return config.ID
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	active := createConfig(adminToken, "Failover Active", port)
+	fallback := createConfig(adminToken, "Failover Fallback", port)
+	foreign := createConfig(userToken, "Failover Foreign", port)
+
+	assert.Equal(t, http.StatusForbidden, do(userToken, http.MethodGet, "/api/v1/failover", "").Code)
+	w := do(adminToken, http.MethodGet, "/api/v1/failover", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var policy db.FailoverPolicy
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &policy))
+	assert.False(t, policy.Enabled)
+	assert.Equal(t, 3, policy.FailureThreshold)
+
+	w = do(adminToken, http.MethodPut, "/api/v1/failover", fmt.Sprintf(`{"enabled": true, "failure_threshold": 2, "notify_url": "https://hooks.example.com/k2ray", "candidates": [%d]}`, fallback))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &policy))
+	assert.True(t, policy.Enabled)
+	assert.Equal(t, 2, policy.FailureThreshold)
+	assert.Equal(t, 60, policy.CheckInterval, "omitted fields are kept")
+	assert.Equal(t, []int64{fallback}, policy.Candidates)
+
+	assert.Equal(t, http.StatusBadRequest, do(adminToken, http.MethodPut, "/api/v1/failover", `{"check_interval": 5}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(adminToken, http.MethodPut, "/api/v1/failover", `{"notify_url": "ftp://example.com"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(adminToken, http.MethodPut, "/api/v1/failover", fmt.Sprintf(`{"candidates": [%d, %d]}`, fallback, fallback)).Code)
+	assert.Equal(t, http.StatusNotFound, do(adminToken, http.MethodPut, "/api/v1/failover", fmt.Sprintf(`{"candidates": [%d]}`, foreign)).Code)
+
+	w = do(adminToken, http.MethodPut, "/api/v1/failover", `{"enabled": false, "notify_url": ""}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &policy))
+	assert.Empty(t, policy.NotifyURL)
+	assert.Equal(t, []int64{fallback}, policy.Candidates)
+
+	_, err = db.DB.Exec(`INSERT INTO settings (key, value) VALUES ('active_config_id', ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`, active)
+	require.NoError(t, err)
+	w = do(adminToken, http.MethodPost, "/api/v1/failover/check", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var result failover.Result
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, active, result.ConfigID)
+	assert.Equal(t, "ok", result.Probe.Status)
+	assert.Nil(t, result.SwitchedTo)
+}
+
+func TestGeoData(t *testing.T) {
+	createTestUser("geodataadmin", "password789")
+	_, err := db.DB.Exec(`UPDATE users SET role = 'admin' WHERE username = 'geodataadmin'`)
+	require.NoError(t, err)
+	adminToken, _ := loginAs(t, "geodataadmin", "password789")
+	userToken, _ := loginAs(t, "user1", "password123")
+
+	do := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+
+	// Each list holds one entry per category, with the code in field 1.
+	geosite := []byte("\x0a\x08\x0a\x06google\x0a\x04\x0a\x02cn")
+	geoip := []byte("\x0a\x04\x0a\x02cn")
+	checksums := map[string]string{}
+	for name, data := range map[string][]byte{"geosite.dat": geosite, "geoip.dat": geoip} {
+		sum := sha256.Sum256(data)
+		checksums[name] = hex.EncodeToString(sum[:])
+	}
+	fixtures := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch name := strings.TrimPrefix(r.URL.Path, "/"); name {
+		case "geosite.dat":
+			w.Write(geosite)
+		case "geoip.dat":
+			w.Write(geoip)
+		case "geosite.dat.sha256sum", "geoip.dat.sha256sum":
+			fmt.Fprintf(w, "%s  %s\n", checksums[strings.TrimSuffix(name, ".sha256sum")], name)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer fixtures.Close()
+
+	original := *config.AppConfig
+	t.Cleanup(func() { *config.AppConfig = original })
+	config.AppConfig.GeoDataDir = t.TempDir()
+	config.AppConfig.GeoIPURL = fixtures.URL + "/geoip.dat"
+	config.AppConfig.GeoSiteURL = fixtures.URL + "/missing/geosite.dat"
+	originalReload := geodata.Reload
+	t.Cleanup(func() { geodata.Reload = originalReload })
+	geodata.Reload = func() (v2ray.ReloadResult, error) { return v2ray.ReloadNotRunning, nil }
+
+	assert.Equal(t, http.StatusForbidden, do(userToken, http.MethodGet, "/api/v1/geodata", "").Code)
+	w := do(adminToken, http.MethodGet, "/api/v1/geodata", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var files []geodata.File
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &files))
+	require.Len(t, files, 2)
+	assert.False(t, files[1].Installed)
+	assert.Equal(t, http.StatusNotFound, do(adminToken, http.MethodGet, "/api/v1/geodata/geosite/categories", "").Code)
+
+	w = do(adminToken, http.MethodPost, "/api/v1/geodata/update", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "geosite.dat")
+	assert.Equal(t, http.StatusBadRequest, do(adminToken, http.MethodPost, "/api/v1/geodata/update", `{"files": ["other.dat"]}`).Code)
+
+	config.AppConfig.GeoSiteURL = fixtures.URL + "/geosite.dat"
+	w = do(adminToken, http.MethodPost, "/api/v1/geodata/update", `{"files": ["geosite.dat"]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &files))
+	assert.False(t, files[0].Installed, "only the requested file is updated")
+	assert.True(t, files[1].Installed)
+	assert.Equal(t, checksums["geosite.dat"], files[1].SHA256)
+	assert.NotEmpty(t, files[1].Version)
+
+	w = do(adminToken, http.MethodGet, "/api/v1/geodata/geosite/categories", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"categories": ["cn", "google"]}`, w.Body.String())
+	assert.Equal(t, http.StatusNotFound, do(adminToken, http.MethodGet, "/api/v1/geodata/other/categories", "").Code)
+	assert.Equal(t, http.StatusForbidden, do(userToken, http.MethodGet, "/api/v1/geodata/geosite/categories", "").Code)
+}
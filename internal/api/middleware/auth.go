@@ -19,6 +19,8 @@ const (
 	ContextTokenExpiresAtKey = "expires_at"
 	// ContextUserIDKey is the key for storing the user's ID.
 	ContextUserIDKey = "user_id"
+	// ContextUserClaimsKey is the key for storing the token's claims, which AdminRequired reads the role from.
+	ContextUserClaimsKey = "user_claims"
 )
 
 // AuthMiddleware creates a Gin middleware for authenticating requests via JWT.
@@ -60,6 +62,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Set(ContextUsernameKey, claims.Username)
 		c.Set(ContextTokenJTIKey, claims.ID)
 		c.Set(ContextTokenExpiresAtKey, claims.ExpiresAt.Time)
+		c.Set(ContextUserClaimsKey, claims)
 
 		// Continue to the next handler.
 		c.Next()
@@ -12,7 +12,7 @@ import (
 func AdminRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the user claims from the context, which should have been set by AuthMiddleware
-		claims, exists := c.Get("user_claims")
+		claims, exists := c.Get(ContextUserClaimsKey)
 		if !exists {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User claims not found"})
 			return
@@ -23,6 +23,13 @@ func SetupRouter(router *gin.Engine, enableRateLimiter bool) {
 	// Health check endpoint - public, no prefix
 	router.GET("/health", handlers.HealthCheck)
 
+	// Subscription feed for client apps - public, authenticated by the token in the path
+	if enableRateLimiter {
+		router.GET("/sub/:token", middleware.RateLimiterMiddleware("30-M"), handlers.ServeSubscription)
+	} else {
+		router.GET("/sub/:token", handlers.ServeSubscription)
+	}
+
 	// All API routes will be prefixed with /api/v1
 	apiV1 := router.Group("/api/v1")
 	apiV1.Use(middleware.SecurityHeadersMiddleware()) // Apply security headers to all /api/v1 routes
@@ -62,6 +69,9 @@ func SetupRouter(router *gin.Engine, enableRateLimiter bool) {
 			userRoutes := protected.Group("/users")
 			{
 				userRoutes.GET("/me", handlers.GetMe)
+				userRoutes.GET("/me/subscription-token", handlers.GetSubscriptionToken)
+				userRoutes.POST("/me/subscription-token/rotate", handlers.RotateSubscriptionToken)
+				userRoutes.DELETE("/me/subscription-token", handlers.RevokeSubscriptionToken)
 
 				// User management routes (for admins)
 				adminUserRoutes := userRoutes.Group("/")
@@ -76,6 +86,47 @@ func SetupRouter(router *gin.Engine, enableRateLimiter bool) {
 				}
 			}
 
+			// User groups that configurations can be shared with (for admins)
+			userGroupRoutes := protected.Group("/user-groups")
+			userGroupRoutes.Use(middleware.AdminRequired())
+			{
+				userGroupRoutes.POST("", handlers.CreateUserGroup)
+				userGroupRoutes.GET("", handlers.ListUserGroups)
+				userGroupRoutes.GET("/:id", handlers.GetUserGroup)
+				userGroupRoutes.PUT("/:id", handlers.UpdateUserGroup)
+				userGroupRoutes.DELETE("/:id", handlers.DeleteUserGroup)
+			}
+
+			// Routing rules merged into the generated V2Ray config (for admins)
+			routingRuleRoutes := protected.Group("/routing/rules")
+			routingRuleRoutes.Use(middleware.AdminRequired())
+			{
+				routingRuleRoutes.POST("", handlers.CreateRoutingRule)
+				routingRuleRoutes.GET("", handlers.ListRoutingRules)
+				routingRuleRoutes.POST("/reorder", handlers.ReorderRoutingRules)
+				routingRuleRoutes.GET("/:id", handlers.GetRoutingRule)
+				routingRuleRoutes.PUT("/:id", handlers.UpdateRoutingRule)
+				routingRuleRoutes.DELETE("/:id", handlers.DeleteRoutingRule)
+			}
+
+			// Automatic failover of the active configuration (for admins)
+			failoverRoutes := protected.Group("/failover")
+			failoverRoutes.Use(middleware.AdminRequired())
+			{
+				failoverRoutes.GET("", handlers.GetFailoverPolicy)
+				failoverRoutes.PUT("", handlers.UpdateFailoverPolicy)
+				failoverRoutes.POST("/check", handlers.CheckFailover)
+			}
+
+			// GeoIP and GeoSite data files used by routing rules (for admins)
+			geoDataRoutes := protected.Group("/geodata")
+			geoDataRoutes.Use(middleware.AdminRequired())
+			{
+				geoDataRoutes.GET("", handlers.ListGeoData)
+				geoDataRoutes.POST("/update", handlers.UpdateGeoData)
+				geoDataRoutes.GET("/:kind/categories", handlers.ListGeoDataCategories)
+			}
+
 			configRoutes := protected.Group("/configs")
 			{
 				configRoutes.POST("", handlers.CreateConfig)
@@ -83,7 +134,67 @@ func SetupRouter(router *gin.Engine, enableRateLimiter bool) {
 				configRoutes.GET("/:id", handlers.GetConfig)
 				configRoutes.PUT("/:id", handlers.UpdateConfig)
 				configRoutes.DELETE("/:id", handlers.DeleteConfig)
+				configRoutes.POST("/:id/validate", handlers.ValidateConfig)
+				configRoutes.GET("/:id/share", handlers.GetConfigShareLink)
+				configRoutes.GET("/:id/qr.png", handlers.GetConfigQRCode)
+				configRoutes.POST("/:id/probe", handlers.ProbeConfig)
+				configRoutes.GET("/:id/probes", handlers.ListConfigProbes)
+				configRoutes.GET("/:id/revisions", handlers.ListConfigRevisions)
+				configRoutes.GET("/:id/revisions/diff", handlers.DiffConfigRevisions)
+				configRoutes.POST("/:id/revisions/:rev/restore", handlers.RestoreConfigRevision)
+				configRoutes.GET("/:id/shares", handlers.ListConfigShares)
+				configRoutes.POST("/:id/shares", handlers.ShareConfig)
+				configRoutes.DELETE("/:id/shares/:share_id", handlers.UnshareConfig)
 				configRoutes.POST("/bulk-delete", handlers.BulkDeleteConfigs)
+				configRoutes.POST("/probe", handlers.ProbeConfigs)
+				configRoutes.POST("/import", handlers.ImportConfigs)
+				configRoutes.GET("/export", handlers.ExportConfigs)
+			}
+
+			// Configuration organization routes
+			groupRoutes := protected.Group("/groups")
+			{
+				groupRoutes.POST("", handlers.CreateGroup)
+				groupRoutes.GET("", handlers.ListGroups)
+				groupRoutes.PUT("/:id", handlers.UpdateGroup)
+				groupRoutes.DELETE("/:id", handlers.DeleteGroup)
+			}
+			tagRoutes := protected.Group("/tags")
+			{
+				tagRoutes.GET("", handlers.ListTags)
+				tagRoutes.DELETE("/:id", handlers.DeleteTag)
+			}
+
+			// Config template routes
+			templateRoutes := protected.Group("/templates")
+			{
+				templateRoutes.POST("", handlers.CreateTemplate)
+				templateRoutes.GET("", handlers.ListTemplates)
+				templateRoutes.GET("/:id", handlers.GetTemplate)
+				templateRoutes.PUT("/:id", handlers.UpdateTemplate)
+				templateRoutes.DELETE("/:id", handlers.DeleteTemplate)
+				templateRoutes.POST("/:id/instantiate", handlers.InstantiateTemplate)
+			}
+
+			// Balancer routes
+			balancerRoutes := protected.Group("/balancers")
+			{
+				balancerRoutes.POST("", handlers.CreateBalancer)
+				balancerRoutes.GET("", handlers.ListBalancers)
+				balancerRoutes.GET("/:id", handlers.GetBalancer)
+				balancerRoutes.PUT("/:id", handlers.UpdateBalancer)
+				balancerRoutes.DELETE("/:id", handlers.DeleteBalancer)
+			}
+
+			// Subscription feed routes
+			subscriptionRoutes := protected.Group("/subscriptions")
+			{
+				subscriptionRoutes.POST("", handlers.CreateSubscription)
+				subscriptionRoutes.GET("", handlers.ListSubscriptions)
+				subscriptionRoutes.GET("/:id", handlers.GetSubscription)
+				subscriptionRoutes.PUT("/:id", handlers.UpdateSubscription)
+				subscriptionRoutes.DELETE("/:id", handlers.DeleteSubscription)
+				subscriptionRoutes.POST("/:id/refresh", handlers.RefreshSubscription)
 			}
 
 			// Protected system routes
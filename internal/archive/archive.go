@@ -0,0 +1,103 @@
+// Package archive defines the portable k2ray archive, a versioned JSON
+// document holding a user's configurations, used to move setups between
+// routers.
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Format is the value of an archive's "format" member, and the name of the
+	// export format that produces it.
+	Format = "k2ray"
+	// Version is the archive version written by this release. Archives with a
+	// higher version are rejected, since they may carry data that would be
+	// silently dropped.
+	Version = 1
+)
+
+// Archive is a portable collection of configurations.
+type Archive struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	Configs    []Config  `json:"configs"`
+}
+
+// Config is one configuration in an archive. Tags and Revisions are only
+// present when they were requested at export time.
+type Config struct {
+	Name       string          `json:"name"`
+	Protocol   string          `json:"protocol"`
+	ConfigData json.RawMessage `json:"config_data" swaggertype:"object"`
+	Remarks    string          `json:"remarks,omitempty"`
+	Group      string          `json:"group,omitempty"`
+	Tags       []string        `json:"tags,omitempty"`
+	Revisions  []Revision      `json:"revisions,omitempty"` // Oldest first
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// Revision is a snapshot from a configuration's history.
+type Revision struct {
+	Revision   int             `json:"revision"`
+	Name       string          `json:"name"`
+	ConfigData json.RawMessage `json:"config_data" swaggertype:"object"`
+	Author     string          `json:"author,omitempty"` // Username on the exporting router
+	Comment    string          `json:"comment,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// New returns an empty archive of the current version.
+func New() *Archive {
+	return &Archive{Format: Format, Version: Version, ExportedAt: time.Now().UTC(), Configs: []Config{}}
+}
+
+// IsArchive reports whether body looks like an archive, that is a JSON object
+// with a "format" member, as opposed to another JSON document.
+func IsArchive(body []byte) bool {
+	var probe struct {
+		Format *string `json:"format"`
+	}
+	return json.Unmarshal(body, &probe) == nil && probe.Format != nil
+}
+
+// Parse decodes and checks an archive.
+func Parse(body []byte) (*Archive, error) {
+	var a Archive
+	if err := json.Unmarshal(body, &a); err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+	if a.Format != Format {
+		return nil, fmt.Errorf("unsupported archive format %q", a.Format)
+	}
+	if a.Version < 1 {
+		return nil, errors.New("archive has no version")
+	}
+	if a.Version > Version {
+		return nil, fmt.Errorf("archive version %d is newer than the supported version %d", a.Version, Version)
+	}
+	return &a, nil
+}
+
+// RenameCopy returns name with the lowest " (n)" suffix, n >= 2, for which
+// taken reports false. The result is kept within maxLen characters by
+// shortening name.
+func RenameCopy(name string, maxLen int, taken func(string) bool) string {
+	for n := 2; ; n++ {
+		suffix := " (" + strconv.Itoa(n) + ")"
+		base := []rune(name)
+		if keep := maxLen - utf8.RuneCountInString(suffix); len(base) > keep {
+			base = base[:max(keep, 0)]
+		}
+		if candidate := string(base) + suffix; !taken(candidate) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package archive_test
+
+import (
+	"k2ray/internal/archive"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	a, err := archive.Parse([]byte(`{"format": "k2ray", "version": 1, "configs": [{"name": "Node", "protocol": "trojan", "config_data": {"server": "a"}}]}`))
+	require.NoError(t, err)
+	require.Len(t, a.Configs, 1)
+	assert.JSONEq(t, `{"server": "a"}`, string(a.Configs[0].ConfigData))
+
+	_, err = archive.Parse([]byte(`{"format": "k2ray", "version": 2, "configs": []}`))
+	assert.EqualError(t, err, "archive version 2 is newer than the supported version 1")
+	_, err = archive.Parse([]byte(`{"format": "k2ray", "configs": []}`))
+	assert.Error(t, err)
+	_, err = archive.Parse([]byte(`{"format": "other", "version": 1}`))
+	assert.Error(t, err)
+	_, err = archive.Parse([]byte(`[1]`))
+	assert.Error(t, err)
+
+	assert.True(t, archive.IsArchive([]byte(`{"format": "k2ray"}`)))
+	assert.False(t, archive.IsArchive([]byte(`{"links": ["vmess://x"]}`)))
+	assert.False(t, archive.IsArchive([]byte(`not json`)))
+}
+
+func TestRenameCopy(t *testing.T) {
+	taken := map[string]bool{"Node (2)": true, "Node (3)": true}
+	isTaken := func(name string) bool { return taken[name] }
+	assert.Equal(t, "Node (4)", archive.RenameCopy("Node", 50, isTaken))
+
+	long := strings.Repeat("x", 50)
+	renamed := archive.RenameCopy(long, 50, isTaken)
+	assert.Len(t, renamed, 50)
+	assert.True(t, strings.HasSuffix(renamed, "x (2)"))
+}
@@ -11,9 +11,14 @@ import (
 // Config holds the application configuration.
 // Using a struct provides type safety and a single source of truth for config values.
 type Config struct {
-	DatabaseURL string
-	JWTSecret   string
-	AppName     string
+	DatabaseURL     string
+	JWTSecret       string
+	AppName         string
+	V2RayExecutable string
+	V2RayConfigPath string
+	GeoDataDir      string // Directory V2Ray loads geoip.dat and geosite.dat from
+	GeoIPURL        string
+	GeoSiteURL      string
 }
 
 // AppConfig is a singleton instance of the Config struct.
@@ -44,9 +49,14 @@ func LoadConfig(path string) {
 		}
 
 		AppConfig = &Config{
-			DatabaseURL: getEnv("DATABASE_URL", "./k2ray.db"),
-			JWTSecret:   getEnv("JWT_SECRET", "default-secret-please-change"),
-			AppName:     getEnv("APP_NAME", "k2ray"),
+			DatabaseURL:     getEnv("DATABASE_URL", "./k2ray.db"),
+			JWTSecret:       getEnv("JWT_SECRET", "default-secret-please-change"),
+			AppName:         getEnv("APP_NAME", "k2ray"),
+			V2RayExecutable: getEnv("V2RAY_EXECUTABLE", "/usr/bin/v2ray"),
+			V2RayConfigPath: getEnv("V2RAY_CONFIG_PATH", "/tmp/k2ray_config.json"),
+			GeoDataDir:      getEnv("V2RAY_LOCATION_ASSET", "/usr/local/share/v2ray"),
+			GeoIPURL:        getEnv("GEOIP_URL", "https://github.com/v2fly/geoip/releases/latest/download/geoip.dat"),
+			GeoSiteURL:      getEnv("GEOSITE_URL", "https://github.com/v2fly/domain-list-community/releases/latest/download/dlc.dat"),
 		}
 	})
 }
@@ -39,6 +39,9 @@ func TestLoadConfig_Fallback(t *testing.T) {
 	// Ensure env vars are not set
 	os.Unsetenv("DATABASE_URL")
 	os.Unsetenv("JWT_SECRET")
+	os.Unsetenv("V2RAY_EXECUTABLE")
+	os.Unsetenv("V2RAY_CONFIG_PATH")
+	os.Unsetenv("V2RAY_LOCATION_ASSET")
 
 	// Load config from a non-existent path to trigger fallback
 	config.LoadConfig("non-existent-file.env")
@@ -47,4 +50,7 @@ func TestLoadConfig_Fallback(t *testing.T) {
 	assert.NotNil(t, config.AppConfig)
 	assert.Equal(t, "./k2ray.db", config.AppConfig.DatabaseURL)
 	assert.Equal(t, "default-secret-please-change", config.AppConfig.JWTSecret)
+	assert.Equal(t, "/usr/bin/v2ray", config.AppConfig.V2RayExecutable)
+	assert.Equal(t, "/tmp/k2ray_config.json", config.AppConfig.V2RayConfigPath)
+	assert.Equal(t, "/usr/local/share/v2ray", config.AppConfig.GeoDataDir)
 }
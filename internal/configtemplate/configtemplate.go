@@ -0,0 +1,180 @@
+// Package configtemplate renders config_data skeletons containing
+// {{placeholders}} into concrete configurations.
+package configtemplate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// placeholderPattern matches {{name}}, allowing spaces inside the braces.
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// MissingVariablesError is returned when a template uses variables that were
+// not provided.
+type MissingVariablesError struct {
+	Names []string
+}
+
+func (e *MissingVariablesError) Error() string {
+	return "missing variables: " + strings.Join(e.Names, ", ")
+}
+
+// Parse checks that skeleton is a JSON object and returns the names of the
+// placeholders in its string values, sorted and without duplicates.
+func Parse(skeleton []byte) ([]string, error) {
+	tree, err := decodeObject(skeleton)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	walkStrings(tree, func(s string) {
+		for _, m := range placeholderPattern.FindAllStringSubmatch(s, -1) {
+			seen[m[1]] = true
+		}
+	})
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Render substitutes vars into skeleton and returns the resulting JSON. A
+// string value that is a single placeholder, like "{{port}}", is replaced by
+// the variable's value as is, so numbers, booleans and arrays keep their type.
+// Placeholders within longer strings are replaced by the variable's text,
+// which requires a string, number or boolean. Unused variables are ignored.
+func Render(skeleton []byte, vars map[string]any) ([]byte, error) {
+	if err := checkVariables(skeleton, vars); err != nil {
+		return nil, err
+	}
+	tree, err := decodeObject(skeleton)
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := render(tree, vars)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(rendered)
+}
+
+// RenderString substitutes vars into the placeholders of s, such as a
+// configuration name, by their text.
+func RenderString(s string, vars map[string]any) (string, error) {
+	var missing []string
+	var renderErr error
+	out := placeholderPattern.ReplaceAllStringFunc(s, func(m string) string {
+		name := placeholderPattern.FindStringSubmatch(m)[1]
+		value, ok := vars[name]
+		if !ok {
+			missing = append(missing, name)
+			return m
+		}
+		text, err := variableText(name, value)
+		if err != nil && renderErr == nil {
+			renderErr = err
+		}
+		return text
+	})
+	if len(missing) > 0 {
+		return "", &MissingVariablesError{Names: missing}
+	}
+	return out, renderErr
+}
+
+func decodeObject(skeleton []byte) (map[string]any, error) {
+	var tree map[string]any
+	dec := json.NewDecoder(bytes.NewReader(skeleton))
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil || tree == nil {
+		return nil, errors.New("template config_data must be a JSON object")
+	}
+	return tree, nil
+}
+
+// checkVariables reports every variable the skeleton uses that vars lacks.
+func checkVariables(skeleton []byte, vars map[string]any) error {
+	names, err := Parse(skeleton)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, name := range names {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingVariablesError{Names: missing}
+	}
+	return nil
+}
+
+func walkStrings(v any, fn func(string)) {
+	switch t := v.(type) {
+	case string:
+		fn(t)
+	case map[string]any:
+		for _, child := range t {
+			walkStrings(child, fn)
+		}
+	case []any:
+		for _, child := range t {
+			walkStrings(child, fn)
+		}
+	}
+}
+
+func render(v any, vars map[string]any) (any, error) {
+	switch t := v.(type) {
+	case string:
+		if m := placeholderPattern.FindStringSubmatch(t); m != nil && m[0] == t {
+			return vars[m[1]], nil
+		}
+		return RenderString(t, vars)
+	case map[string]any:
+		for key, child := range t {
+			rendered, err := render(child, vars)
+			if err != nil {
+				return nil, err
+			}
+			t[key] = rendered
+		}
+	case []any:
+		for i, child := range t {
+			rendered, err := render(child, vars)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = rendered
+		}
+	}
+	return v, nil
+}
+
+// variableText formats a variable for substitution within a longer string.
+func variableText(name string, value any) (string, error) {
+	switch t := value.(type) {
+	case string:
+		return t, nil
+	case json.Number:
+		return t.String(), nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(t), nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	default:
+		return "", fmt.Errorf("variable %q must be a string, number or boolean to be used inside text", name)
+	}
+}
@@ -0,0 +1,73 @@
+package configtemplate
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const skeleton = `{
+	"add": "{{server}}",
+	"port": "{{ port }}",
+	"id": "{{uuid}}",
+	"aid": 0,
+	"net": "ws",
+	"wsSettings": {"path": "/ws/{{server}}", "headers": {"Host": "{{server}}"}},
+	"alpn": ["h2", "{{alpn}}"]
+}`
+
+func TestParse(t *testing.T) {
+	names, err := Parse([]byte(skeleton))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alpn", "port", "server", "uuid"}, names)
+
+	for _, invalid := range []string{`[]`, `"{{x}}"`, `null`, `{`} {
+		_, err := Parse([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestRender(t *testing.T) {
+	var vars map[string]any
+	require.NoError(t, json.Unmarshal([]byte(`{"server": "a.example.com", "port": 443, "uuid": "b831381d-6324-4d53-ad4f-8cda48b30811", "alpn": "http/1.1", "unused": [1]}`), &vars))
+
+	out, err := Render([]byte(skeleton), vars)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"add": "a.example.com",
+		"port": 443,
+		"id": "b831381d-6324-4d53-ad4f-8cda48b30811",
+		"aid": 0,
+		"net": "ws",
+		"wsSettings": {"path": "/ws/a.example.com", "headers": {"Host": "a.example.com"}},
+		"alpn": ["h2", "http/1.1"]
+	}`, string(out))
+
+	// Whole-value placeholders keep the variable's type.
+	out, err = Render([]byte(`{"alpn": "{{alpn}}", "tls": "{{tls}}"}`), map[string]any{"alpn": []any{"h2"}, "tls": true})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"alpn": ["h2"], "tls": true}`, string(out))
+
+	_, err = Render([]byte(skeleton), map[string]any{"server": "a.example.com"})
+	var missing *MissingVariablesError
+	require.ErrorAs(t, err, &missing)
+	assert.Equal(t, []string{"alpn", "port", "uuid"}, missing.Names)
+
+	_, err = Render([]byte(`{"path": "/ws/{{path}}"}`), map[string]any{"path": []any{"a"}})
+	assert.ErrorContains(t, err, `variable "path" must be a string, number or boolean`)
+}
+
+func TestRenderString(t *testing.T) {
+	name, err := RenderString("{{country}} #{{n}}", map[string]any{"country": "DE", "n": float64(2)})
+	require.NoError(t, err)
+	assert.Equal(t, "DE #2", name)
+
+	name, err = RenderString("Plain name", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Plain name", name)
+
+	_, err = RenderString("{{country}}", nil)
+	assert.EqualError(t, err, "missing variables: country")
+}
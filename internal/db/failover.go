@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// GetFailoverPolicy returns the failover policy with its candidates.
+func GetFailoverPolicy() (*FailoverPolicy, error) {
+	var p FailoverPolicy
+	querySQL := `SELECT enabled, check_interval, failure_threshold, hysteresis, check_http, notify_url,
+		consecutive_failures, last_checked_at, last_switched_at, updated_at FROM failover_policy WHERE id = 1`
+	err := DB.QueryRow(querySQL).Scan(&p.Enabled, &p.CheckInterval, &p.FailureThreshold, &p.Hysteresis, &p.CheckHTTP, &p.NotifyURL,
+		&p.ConsecutiveFailures, &p.LastCheckedAt, &p.LastSwitchedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(`SELECT configuration_id FROM failover_candidates ORDER BY position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	p.Candidates = []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		p.Candidates = append(p.Candidates, id)
+	}
+	return &p, rows.Err()
+}
+
+// UpdateFailoverPolicy stores the settings and candidates of p. The health
+// check state is left unchanged.
+func UpdateFailoverPolicy(ctx context.Context, p FailoverPolicy) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	updateSQL := `UPDATE failover_policy SET enabled = ?, check_interval = ?, failure_threshold = ?, hysteresis = ?,
+		check_http = ?, notify_url = ?, updated_at = CURRENT_TIMESTAMP WHERE id = 1`
+	if _, err := tx.ExecContext(ctx, updateSQL, p.Enabled, p.CheckInterval, p.FailureThreshold, p.Hysteresis, p.CheckHTTP, p.NotifyURL); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM failover_candidates`); err != nil {
+		return err
+	}
+	for i, id := range p.Candidates {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO failover_candidates (configuration_id, position) VALUES (?, ?)`, id, i+1); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RecordFailoverCheck stores the outcome of a health check. switchedAt is nil
+// unless the check switched the active configuration.
+func RecordFailoverCheck(ctx context.Context, consecutiveFailures int, checkedAt time.Time, switchedAt *time.Time) error {
+	updateSQL := `UPDATE failover_policy SET consecutive_failures = ?, last_checked_at = ?,
+		last_switched_at = COALESCE(?, last_switched_at) WHERE id = 1`
+	_, err := DB.ExecContext(ctx, updateSQL, consecutiveFailures, checkedAt, switchedAt)
+	return err
+}
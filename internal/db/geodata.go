@@ -0,0 +1,31 @@
+package db
+
+import "context"
+
+// ListGeoDataFiles returns the data files installed through k2ray, by name.
+func ListGeoDataFiles() (map[string]GeoDataFile, error) {
+	rows, err := DB.Query(`SELECT name, version, sha256, source_url, updated_at FROM geodata_files`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := map[string]GeoDataFile{}
+	for rows.Next() {
+		var f GeoDataFile
+		if err := rows.Scan(&f.Name, &f.Version, &f.SHA256, &f.SourceURL, &f.UpdatedAt); err != nil {
+			return nil, err
+		}
+		files[f.Name] = f
+	}
+	return files, rows.Err()
+}
+
+// SaveGeoDataFile records f as the installed version of its data file.
+func SaveGeoDataFile(ctx context.Context, f GeoDataFile) error {
+	upsertSQL := `INSERT INTO geodata_files (name, version, sha256, source_url) VALUES (?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET version = excluded.version, sha256 = excluded.sha256,
+		source_url = excluded.source_url, updated_at = CURRENT_TIMESTAMP`
+	_, err := DB.ExecContext(ctx, upsertSQL, f.Name, f.Version, f.SHA256, f.SourceURL)
+	return err
+}
@@ -0,0 +1,11 @@
+-- +migrate Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TRIGGER trg_users_delete_subscriptions;
+DROP TRIGGER trg_subscriptions_delete;
+DROP INDEX idx_configurations_subscription;
+DELETE FROM configurations WHERE subscription_id IS NOT NULL;
+ALTER TABLE configurations DROP COLUMN "subscription_key";
+ALTER TABLE configurations DROP COLUMN "subscription_id";
+DROP INDEX idx_subscriptions_user_id;
+DROP TABLE subscriptions;
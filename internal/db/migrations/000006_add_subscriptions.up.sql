@@ -0,0 +1,37 @@
+-- +migrate Up
+-- SQL in this section is executed when the migration is applied.
+
+-- Subscription feeds registered by users. refresh_interval is in minutes.
+CREATE TABLE subscriptions (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "user_id" INTEGER NOT NULL,
+    "name" TEXT NOT NULL,
+    "url" TEXT NOT NULL,
+    "refresh_interval" INTEGER NOT NULL DEFAULT 60,
+    "last_refreshed_at" TIMESTAMP,
+    "last_error" TEXT,
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_subscriptions_user_id ON subscriptions (user_id);
+
+-- Configurations imported from a subscription remember where they came from.
+-- subscription_key identifies the entry within its feed so refreshes can upsert it.
+ALTER TABLE configurations ADD COLUMN "subscription_id" INTEGER REFERENCES subscriptions(id) ON DELETE CASCADE;
+ALTER TABLE configurations ADD COLUMN "subscription_key" TEXT;
+
+CREATE UNIQUE INDEX idx_configurations_subscription ON configurations (subscription_id, subscription_key);
+
+-- Delete the configurations of deleted subscriptions, and the subscriptions of
+-- deleted users.
+CREATE TRIGGER trg_subscriptions_delete AFTER DELETE ON subscriptions
+BEGIN
+    DELETE FROM configurations WHERE subscription_id = OLD.id;
+END;
+
+CREATE TRIGGER trg_users_delete_subscriptions AFTER DELETE ON users
+BEGIN
+    DELETE FROM subscriptions WHERE user_id = OLD.id;
+END;
@@ -0,0 +1,4 @@
+-- +migrate Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE subscription_tokens;
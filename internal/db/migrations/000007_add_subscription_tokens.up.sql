@@ -0,0 +1,12 @@
+-- +migrate Up
+-- SQL in this section is executed when the migration is applied.
+
+-- Each user has at most one subscription token, used by client apps to fetch
+-- GET /sub/:token without a JWT. Only the SHA-256 hash of the token is stored.
+CREATE TABLE subscription_tokens (
+    "user_id" INTEGER NOT NULL PRIMARY KEY,
+    "token_hash" TEXT NOT NULL UNIQUE,
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "last_used_at" TIMESTAMP,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
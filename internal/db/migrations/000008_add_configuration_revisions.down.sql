@@ -0,0 +1,5 @@
+-- +migrate Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TRIGGER trg_configurations_delete_revisions;
+DROP TABLE configuration_revisions;
@@ -0,0 +1,30 @@
+-- +migrate Up
+-- SQL in this section is executed when the migration is applied.
+
+-- Every create and update of a configuration stores a full snapshot, numbered
+-- from 1 per configuration. author_id is NULL for changes made by the system,
+-- such as subscription refreshes.
+CREATE TABLE configuration_revisions (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "configuration_id" INTEGER NOT NULL,
+    "revision" INTEGER NOT NULL,
+    "name" TEXT NOT NULL,
+    "config_data" TEXT NOT NULL,
+    "author_id" INTEGER,
+    "comment" TEXT NOT NULL DEFAULT '',
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(configuration_id) REFERENCES configurations(id) ON DELETE CASCADE,
+    FOREIGN KEY(author_id) REFERENCES users(id) ON DELETE SET NULL,
+    UNIQUE(configuration_id, revision)
+);
+
+-- Existing configurations start their history at revision 1.
+INSERT INTO configuration_revisions (configuration_id, revision, name, config_data, author_id, comment, created_at)
+SELECT id, 1, name, config_data, CASE WHEN subscription_id IS NULL THEN user_id END, 'Initial revision', updated_at
+FROM configurations;
+
+-- Delete a configuration's revisions with it.
+CREATE TRIGGER trg_configurations_delete_revisions AFTER DELETE ON configurations
+BEGIN
+    DELETE FROM configuration_revisions WHERE configuration_id = OLD.id;
+END;
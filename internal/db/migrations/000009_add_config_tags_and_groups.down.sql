@@ -0,0 +1,12 @@
+-- +migrate Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TRIGGER trg_config_groups_delete;
+DROP TRIGGER trg_tags_delete;
+DROP TRIGGER trg_configurations_delete_tags;
+DROP INDEX idx_configurations_group_id;
+ALTER TABLE configurations DROP COLUMN "remarks";
+ALTER TABLE configurations DROP COLUMN "group_id";
+DROP TABLE configuration_tags;
+DROP TABLE tags;
+DROP TABLE config_groups;
@@ -0,0 +1,54 @@
+-- +migrate Up
+-- SQL in this section is executed when the migration is applied.
+
+-- Groups are folders: each configuration is filed under at most one group.
+CREATE TABLE config_groups (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "user_id" INTEGER NOT NULL,
+    "name" TEXT NOT NULL COLLATE NOCASE,
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(user_id, name)
+);
+
+-- Tags are per user and matched case-insensitively.
+CREATE TABLE tags (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "user_id" INTEGER NOT NULL,
+    "name" TEXT NOT NULL COLLATE NOCASE,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(user_id, name)
+);
+
+CREATE TABLE configuration_tags (
+    "configuration_id" INTEGER NOT NULL,
+    "tag_id" INTEGER NOT NULL,
+    PRIMARY KEY(configuration_id, tag_id),
+    FOREIGN KEY(configuration_id) REFERENCES configurations(id) ON DELETE CASCADE,
+    FOREIGN KEY(tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_configuration_tags_tag_id ON configuration_tags (tag_id);
+
+ALTER TABLE configurations ADD COLUMN "group_id" INTEGER REFERENCES config_groups(id) ON DELETE SET NULL;
+ALTER TABLE configurations ADD COLUMN "remarks" TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_configurations_group_id ON configurations (group_id);
+
+-- Delete the tag links of deleted configurations and tags, and unfile the
+-- configurations of deleted groups.
+CREATE TRIGGER trg_configurations_delete_tags AFTER DELETE ON configurations
+BEGIN
+    DELETE FROM configuration_tags WHERE configuration_id = OLD.id;
+END;
+
+CREATE TRIGGER trg_tags_delete AFTER DELETE ON tags
+BEGIN
+    DELETE FROM configuration_tags WHERE tag_id = OLD.id;
+END;
+
+CREATE TRIGGER trg_config_groups_delete AFTER DELETE ON config_groups
+BEGIN
+    UPDATE configurations SET group_id = NULL WHERE group_id = OLD.id;
+END;
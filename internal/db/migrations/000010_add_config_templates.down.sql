@@ -0,0 +1,8 @@
+-- +migrate Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TRIGGER trg_config_templates_delete;
+DROP INDEX idx_configurations_template_id;
+ALTER TABLE configurations DROP COLUMN "template_vars";
+ALTER TABLE configurations DROP COLUMN "template_id";
+DROP TABLE config_templates;
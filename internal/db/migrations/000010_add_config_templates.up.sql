@@ -0,0 +1,27 @@
+-- +migrate Up
+-- SQL in this section is executed when the migration is applied.
+
+-- Templates hold a config_data skeleton with {{placeholders}}.
+CREATE TABLE config_templates (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "user_id" INTEGER NOT NULL,
+    "name" TEXT NOT NULL,
+    "protocol" TEXT NOT NULL,
+    "config_data" TEXT NOT NULL,
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(user_id, name)
+);
+
+-- Configurations instantiated from a template remember it and the variables
+-- they were rendered with, so they can be re-rendered when it changes.
+ALTER TABLE configurations ADD COLUMN "template_id" INTEGER REFERENCES config_templates(id) ON DELETE SET NULL;
+ALTER TABLE configurations ADD COLUMN "template_vars" TEXT;
+
+CREATE INDEX idx_configurations_template_id ON configurations (template_id);
+
+CREATE TRIGGER trg_config_templates_delete AFTER DELETE ON config_templates
+BEGIN
+    UPDATE configurations SET template_id = NULL, template_vars = NULL WHERE template_id = OLD.id;
+END;
@@ -0,0 +1,9 @@
+-- +migrate Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TRIGGER trg_users_delete_shares;
+DROP TRIGGER trg_user_groups_delete;
+DROP TRIGGER trg_configurations_delete_shares;
+DROP TABLE config_shares;
+DROP TABLE user_group_members;
+DROP TABLE user_groups;
@@ -0,0 +1,60 @@
+-- +migrate Up
+-- SQL in this section is executed when the migration is applied.
+
+-- User groups are managed by admins and let a configuration be shared with
+-- several users at once.
+CREATE TABLE user_groups (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "name" TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE user_group_members (
+    "group_id" INTEGER NOT NULL,
+    "user_id" INTEGER NOT NULL,
+    PRIMARY KEY(group_id, user_id),
+    FOREIGN KEY(group_id) REFERENCES user_groups(id) ON DELETE CASCADE,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_group_members_user_id ON user_group_members (user_id);
+
+-- Each share grants one user or one user group read or write access to a
+-- configuration.
+CREATE TABLE config_shares (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "configuration_id" INTEGER NOT NULL,
+    "user_id" INTEGER,
+    "user_group_id" INTEGER,
+    "permission" TEXT NOT NULL CHECK ("permission" IN ('read', 'write')),
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(configuration_id) REFERENCES configurations(id) ON DELETE CASCADE,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY(user_group_id) REFERENCES user_groups(id) ON DELETE CASCADE,
+    CHECK (("user_id" IS NULL) <> ("user_group_id" IS NULL))
+);
+
+CREATE UNIQUE INDEX idx_config_shares_user ON config_shares (configuration_id, user_id) WHERE user_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_config_shares_user_group ON config_shares (configuration_id, user_group_id) WHERE user_group_id IS NOT NULL;
+CREATE INDEX idx_config_shares_user_id ON config_shares (user_id);
+CREATE INDEX idx_config_shares_user_group_id ON config_shares (user_group_id);
+
+-- Delete the shares of deleted configurations, user groups and users, and the
+-- memberships of deleted user groups and users.
+CREATE TRIGGER trg_configurations_delete_shares AFTER DELETE ON configurations
+BEGIN
+    DELETE FROM config_shares WHERE configuration_id = OLD.id;
+END;
+
+CREATE TRIGGER trg_user_groups_delete AFTER DELETE ON user_groups
+BEGIN
+    DELETE FROM config_shares WHERE user_group_id = OLD.id;
+    DELETE FROM user_group_members WHERE group_id = OLD.id;
+END;
+
+CREATE TRIGGER trg_users_delete_shares AFTER DELETE ON users
+BEGIN
+    DELETE FROM config_shares WHERE user_id = OLD.id;
+    DELETE FROM user_group_members WHERE user_id = OLD.id;
+END;
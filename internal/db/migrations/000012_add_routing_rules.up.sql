@@ -0,0 +1,23 @@
+-- +migrate Up
+-- SQL in this section is executed when the migration is applied.
+
+-- Routing rules are merged into the document written for V2Ray, in ascending
+-- position, ahead of the built-in rules. List conditions are JSON arrays of
+-- strings; an empty array or string means the condition is not used.
+CREATE TABLE routing_rules (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "position" INTEGER NOT NULL,
+    "name" TEXT NOT NULL,
+    "enabled" BOOLEAN NOT NULL DEFAULT 1,
+    "domain" TEXT NOT NULL DEFAULT '[]',
+    "ip" TEXT NOT NULL DEFAULT '[]',
+    "port" TEXT NOT NULL DEFAULT '',
+    "network" TEXT NOT NULL DEFAULT '',
+    "protocol" TEXT NOT NULL DEFAULT '[]',
+    "inbound_tag" TEXT NOT NULL DEFAULT '[]',
+    "outbound_tag" TEXT NOT NULL,
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_routing_rules_position ON routing_rules (position);
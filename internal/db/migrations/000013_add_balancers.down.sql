@@ -0,0 +1,8 @@
+-- +migrate Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DELETE FROM settings WHERE key = 'active_balancer_id';
+DROP TRIGGER trg_balancers_delete;
+DROP TRIGGER trg_configurations_delete_balancer_members;
+DROP TABLE balancer_members;
+DROP TABLE balancers;
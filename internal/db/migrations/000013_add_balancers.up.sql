@@ -0,0 +1,42 @@
+-- +migrate Up
+-- SQL in this section is executed when the migration is applied.
+
+-- A balancer spreads traffic across several configurations. When it is
+-- activated through the active_balancer_id setting, the generated V2Ray config
+-- holds one outbound per member and an observatory probing them.
+CREATE TABLE balancers (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "user_id" INTEGER NOT NULL,
+    "name" TEXT NOT NULL,
+    "strategy" TEXT NOT NULL CHECK ("strategy" IN ('random', 'leastPing', 'roundRobin')),
+    "probe_url" TEXT NOT NULL DEFAULT '',
+    "probe_interval" TEXT NOT NULL DEFAULT '',
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(user_id, name)
+);
+
+CREATE TABLE balancer_members (
+    "balancer_id" INTEGER NOT NULL,
+    "configuration_id" INTEGER NOT NULL,
+    "position" INTEGER NOT NULL,
+    PRIMARY KEY(balancer_id, configuration_id),
+    FOREIGN KEY(balancer_id) REFERENCES balancers(id) ON DELETE CASCADE,
+    FOREIGN KEY(configuration_id) REFERENCES configurations(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_balancer_members_configuration_id ON balancer_members (configuration_id);
+
+-- Delete the memberships of deleted configurations and balancers, and the
+-- active_balancer_id setting of a deleted balancer.
+CREATE TRIGGER trg_configurations_delete_balancer_members AFTER DELETE ON configurations
+BEGIN
+    DELETE FROM balancer_members WHERE configuration_id = OLD.id;
+END;
+
+CREATE TRIGGER trg_balancers_delete AFTER DELETE ON balancers
+BEGIN
+    DELETE FROM balancer_members WHERE balancer_id = OLD.id;
+    DELETE FROM settings WHERE key = 'active_balancer_id' AND value = CAST(OLD.id AS TEXT);
+END;
@@ -0,0 +1,25 @@
+-- +migrate Up
+-- SQL in this section is executed when the migration is applied.
+
+-- Each probe of a configuration's server records the time to open a TCP
+-- connection, complete the TLS handshake and, optionally, make an HTTP request
+-- through a test core. Timings that were not measured are NULL.
+CREATE TABLE probe_results (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "configuration_id" INTEGER NOT NULL,
+    "status" TEXT NOT NULL CHECK ("status" IN ('ok', 'unreachable', 'tls_failed', 'http_failed', 'unsupported', 'invalid')),
+    "tcp_connect_ms" INTEGER,
+    "tls_handshake_ms" INTEGER,
+    "http_ms" INTEGER,
+    "error" TEXT NOT NULL DEFAULT '',
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(configuration_id) REFERENCES configurations(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_probe_results_configuration_id ON probe_results (configuration_id, id);
+
+-- Delete a configuration's probe results with it.
+CREATE TRIGGER trg_configurations_delete_probe_results AFTER DELETE ON configurations
+BEGIN
+    DELETE FROM probe_results WHERE configuration_id = OLD.id;
+END;
@@ -0,0 +1,6 @@
+-- +migrate Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TRIGGER trg_configurations_delete_failover_candidates;
+DROP TABLE failover_candidates;
+DROP TABLE failover_policy;
@@ -0,0 +1,33 @@
+-- +migrate Up
+-- SQL in this section is executed when the migration is applied.
+
+-- The failover policy health-checks the active configuration and, after
+-- failure_threshold consecutive failures, switches to the best healthy
+-- candidate. There is a single, system-wide policy. Intervals are in seconds.
+CREATE TABLE failover_policy (
+    "id" INTEGER NOT NULL PRIMARY KEY CHECK ("id" = 1),
+    "enabled" BOOLEAN NOT NULL DEFAULT 0,
+    "check_interval" INTEGER NOT NULL DEFAULT 60,
+    "failure_threshold" INTEGER NOT NULL DEFAULT 3,
+    "hysteresis" INTEGER NOT NULL DEFAULT 300,
+    "check_http" BOOLEAN NOT NULL DEFAULT 0,
+    "notify_url" TEXT NOT NULL DEFAULT '',
+    "consecutive_failures" INTEGER NOT NULL DEFAULT 0,
+    "last_checked_at" TIMESTAMP,
+    "last_switched_at" TIMESTAMP,
+    "updated_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO failover_policy ("id") VALUES (1);
+
+CREATE TABLE failover_candidates (
+    "configuration_id" INTEGER NOT NULL PRIMARY KEY,
+    "position" INTEGER NOT NULL,
+    FOREIGN KEY(configuration_id) REFERENCES configurations(id) ON DELETE CASCADE
+);
+
+-- Remove a deleted configuration from the failover candidates.
+CREATE TRIGGER trg_configurations_delete_failover_candidates AFTER DELETE ON configurations
+BEGIN
+    DELETE FROM failover_candidates WHERE configuration_id = OLD.id;
+END;
@@ -0,0 +1,12 @@
+-- +migrate Up
+-- SQL in this section is executed when the migration is applied.
+
+-- Records the GeoIP and GeoSite data files installed through k2ray, so that
+-- their version and origin are known. The files themselves live on disk.
+CREATE TABLE geodata_files (
+    "name" TEXT NOT NULL PRIMARY KEY CHECK ("name" IN ('geoip.dat', 'geosite.dat')),
+    "version" TEXT NOT NULL,
+    "sha256" TEXT NOT NULL,
+    "source_url" TEXT NOT NULL,
+    "updated_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -13,6 +13,15 @@ const (
 	RoleUser  UserRole = "user"
 )
 
+// Permission is the access a user has to a configuration.
+type Permission string
+
+const (
+	PermissionRead  Permission = "read"
+	PermissionWrite Permission = "write"
+	PermissionOwner Permission = "owner"
+)
+
 // User represents a user in the system.
 type User struct {
 	ID                     int64
@@ -26,15 +35,172 @@ type User struct {
 
 // Configuration represents a V2Ray configuration stored in the database.
 type Configuration struct {
+	ID             int64
+	UserID         int64
+	Name           string
+	Protocol       string
+	ConfigData     string // Stored as a JSON string
+	SubscriptionID *int64 // Set when the config is managed by a subscription
+	GroupID        *int64 // The group the config is filed under, if any
+	Remarks        string
+	Tags           []string   // Loaded from configuration_tags
+	TemplateID     *int64     // Set when the config was instantiated from a template
+	TemplateVars   *string    // JSON object of the variables it was rendered with
+	Owner          string     // Username of the owner
+	Permission     Permission // The requesting user's access to the config
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// ConfigRevision is a snapshot of a configuration's name and config_data,
+// recorded on every create, update and restore.
+type ConfigRevision struct {
+	ID              int64
+	ConfigurationID int64
+	Revision        int
+	Name            string
+	ConfigData      string
+	AuthorID        *int64  // Nil for changes made by the system
+	Author          *string // Username of the author, if known
+	Comment         string
+	CreatedAt       time.Time
+}
+
+// ConfigTemplate is a config_data skeleton with {{placeholders}} that
+// configurations can be instantiated from.
+type ConfigTemplate struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	Protocol   string
+	ConfigData string // JSON object with placeholders
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// ConfigShare grants a user or a user group access to a configuration.
+type ConfigShare struct {
+	ID              int64
+	ConfigurationID int64
+	UserID          *int64 // Set when shared with a user
+	Username        *string
+	UserGroupID     *int64 // Set when shared with a user group
+	UserGroup       *string
+	Permission      Permission // read or write
+	CreatedAt       time.Time
+}
+
+// UserGroup is an admin-managed set of users that configurations can be
+// shared with.
+type UserGroup struct {
+	ID        int64
+	Name      string
+	Members   []string // Usernames, sorted
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// RoutingRule sends the traffic that matches all of its conditions to an
+// outbound. Enabled rules are applied in ascending Position.
+type RoutingRule struct {
 	ID          int64
-	UserID      int64
+	Position    int
 	Name        string
-	Protocol    string
-	ConfigData  string // Stored as a JSON string
+	Enabled     bool
+	Domain      []string
+	IP          []string
+	Port        string
+	Network     string
+	Protocol    []string
+	InboundTag  []string
+	OutboundTag string
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
 
+// Balancer spreads traffic across several configurations. The generated
+// document holds one outbound per member and probes them to skip the ones
+// that are down.
+type Balancer struct {
+	ID            int64
+	UserID        int64
+	Name          string
+	Strategy      string  // random, leastPing or roundRobin
+	ProbeURL      string  // Empty for the default
+	ProbeInterval string  // Empty for the default
+	Members       []int64 // Configuration IDs, in order
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// ProbeResult records one measurement of how quickly a configuration's server
+// could be reached. Timings that were not measured are nil.
+type ProbeResult struct {
+	ID              int64
+	ConfigurationID int64
+	Status          string // ok, unreachable, tls_failed, http_failed, unsupported or invalid
+	TCPConnectMs    *int64
+	TLSHandshakeMs  *int64
+	HTTPMs          *int64 // Round trip through a test core, if requested
+	Error           string
+	CreatedAt       time.Time
+}
+
+// FailoverPolicy controls the automatic replacement of the active
+// configuration when its server stops responding. Intervals are in seconds.
+type FailoverPolicy struct {
+	Enabled             bool
+	CheckInterval       int     // Time between health checks of the active configuration
+	FailureThreshold    int     // Consecutive failed checks before switching
+	Hysteresis          int     // Minimum time between two switches
+	CheckHTTP           bool    // Also send an HTTP request through a test core
+	NotifyURL           string  // Webhook called on every switch; empty for none
+	Candidates          []int64 // Fallback configuration IDs, in order of preference
+	ConsecutiveFailures int
+	LastCheckedAt       *time.Time
+	LastSwitchedAt      *time.Time
+	UpdatedAt           time.Time
+}
+
+// GeoDataFile records a GeoIP or GeoSite data file installed through k2ray.
+type GeoDataFile struct {
+	Name      string // geoip.dat or geosite.dat
+	Version   string
+	SHA256    string // Hex digest of the installed file
+	SourceURL string
+	UpdatedAt time.Time
+}
+
+// ConfigGroup is a folder that configurations can be filed under.
+type ConfigGroup struct {
+	ID        int64
+	UserID    int64
+	Name      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Tag is a user-defined label for configurations.
+type Tag struct {
+	ID      int64
+	Name    string
+	Configs int // Number of configurations carrying the tag
+}
+
+// Subscription represents a subscription feed whose share links are kept in
+// sync with the user's configurations.
+type Subscription struct {
+	ID              int64
+	UserID          int64
+	Name            string
+	URL             string
+	RefreshInterval int // Minutes between refreshes
+	LastRefreshedAt *time.Time
+	LastError       *string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
 // Log represents a system or application log entry.
 type Log struct {
 	ID        int64
@@ -42,4 +208,4 @@ type Log struct {
 	Message   string
 	Source    sql.NullString
 	CreatedAt time.Time
-}
\ No newline at end of file
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"strings"
+)
+
+// MaxProbeHistory is the number of probe results kept per configuration;
+// older ones are deleted as new ones are recorded.
+const MaxProbeHistory = 100
+
+const selectProbeResultSQL = `SELECT id, configuration_id, status, tcp_connect_ms, tls_handshake_ms, http_ms, error, created_at FROM probe_results`
+
+func scanProbeResult(row interface{ Scan(...any) error }, r *ProbeResult) error {
+	return row.Scan(&r.ID, &r.ConfigurationID, &r.Status, &r.TCPConnectMs, &r.TLSHandshakeMs, &r.HTTPMs, &r.Error, &r.CreatedAt)
+}
+
+// AddProbeResult records r, filling in its ID and CreatedAt, and trims the
+// configuration's history to MaxProbeHistory results.
+func AddProbeResult(ctx context.Context, r *ProbeResult) error {
+	insertSQL := `INSERT INTO probe_results (configuration_id, status, tcp_connect_ms, tls_handshake_ms, http_ms, error)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id, created_at`
+	err := DB.QueryRowContext(ctx, insertSQL, r.ConfigurationID, r.Status, r.TCPConnectMs, r.TLSHandshakeMs, r.HTTPMs, r.Error).Scan(&r.ID, &r.CreatedAt)
+	if err != nil {
+		return err
+	}
+	pruneSQL := `DELETE FROM probe_results WHERE configuration_id = ? AND id NOT IN
+		(SELECT id FROM probe_results WHERE configuration_id = ? ORDER BY id DESC LIMIT ?)`
+	_, err = DB.ExecContext(ctx, pruneSQL, r.ConfigurationID, r.ConfigurationID, MaxProbeHistory)
+	return err
+}
+
+// ListProbeResults returns up to limit of a configuration's probe results, newest first.
+func ListProbeResults(configID int64, limit int) ([]ProbeResult, error) {
+	rows, err := DB.Query(selectProbeResultSQL+` WHERE configuration_id = ? ORDER BY id DESC LIMIT ?`, configID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []ProbeResult{}
+	for rows.Next() {
+		var r ProbeResult
+		if err := scanProbeResult(rows, &r); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// LatestProbeResults returns the newest probe result of each of the given
+// configurations. Configurations that were never probed are left out.
+func LatestProbeResults(configIDs []int64) (map[int64]ProbeResult, error) {
+	latest := make(map[int64]ProbeResult, len(configIDs))
+	if len(configIDs) == 0 {
+		return latest, nil
+	}
+	args := make([]any, len(configIDs))
+	for i, id := range configIDs {
+		args[i] = id
+	}
+
+	querySQL := selectProbeResultSQL + ` WHERE id IN (SELECT MAX(id) FROM probe_results
+		WHERE configuration_id IN (?` + strings.Repeat(",?", len(configIDs)-1) + `) GROUP BY configuration_id)`
+	rows, err := DB.Query(querySQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r ProbeResult
+		if err := scanProbeResult(rows, &r); err != nil {
+			return nil, err
+		}
+		latest[r.ConfigurationID] = r
+	}
+	return latest, rows.Err()
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Querier is implemented by both *sql.DB and *sql.Tx, so revisions and tags
+// can be written inside the transaction that changes the configuration.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// AddConfigRevision records a snapshot of a configuration and returns its
+// revision number, one higher than the configuration's latest revision.
+// authorID is nil for changes made by the system.
+func AddConfigRevision(ctx context.Context, q Querier, configID int64, name, configData string, authorID *int64, comment string) (int, error) {
+	insertSQL := `INSERT INTO configuration_revisions (configuration_id, revision, name, config_data, author_id, comment)
+		SELECT ?, COALESCE(MAX(revision), 0) + 1, ?, ?, ?, ? FROM configuration_revisions WHERE configuration_id = ?
+		RETURNING revision`
+	var revision int
+	err := q.QueryRowContext(ctx, insertSQL, configID, name, configData, authorID, comment, configID).Scan(&revision)
+	return revision, err
+}
+
+// AddArchivedConfigRevision is AddConfigRevision for a snapshot taken
+// elsewhere, such as a revision from an imported archive, which keeps its
+// original timestamp.
+func AddArchivedConfigRevision(ctx context.Context, q Querier, configID int64, name, configData string, authorID *int64, comment string, createdAt time.Time) (int, error) {
+	insertSQL := `INSERT INTO configuration_revisions (configuration_id, revision, name, config_data, author_id, comment, created_at)
+		SELECT ?, COALESCE(MAX(revision), 0) + 1, ?, ?, ?, ?, ? FROM configuration_revisions WHERE configuration_id = ?
+		RETURNING revision`
+	var revision int
+	err := q.QueryRowContext(ctx, insertSQL, configID, name, configData, authorID, comment, createdAt.UTC(), configID).Scan(&revision)
+	return revision, err
+}
+
+const selectRevisionSQL = `SELECT r.id, r.configuration_id, r.revision, r.name, r.config_data, r.author_id, u.username, r.comment, r.created_at
+	FROM configuration_revisions r LEFT JOIN users u ON u.id = r.author_id`
+
+func scanConfigRevision(row interface{ Scan(...any) error }, r *ConfigRevision) error {
+	return row.Scan(&r.ID, &r.ConfigurationID, &r.Revision, &r.Name, &r.ConfigData, &r.AuthorID, &r.Author, &r.Comment, &r.CreatedAt)
+}
+
+// ListConfigRevisions returns the revisions of a configuration, newest first.
+func ListConfigRevisions(configID int64) ([]ConfigRevision, error) {
+	rows, err := DB.Query(selectRevisionSQL+` WHERE r.configuration_id = ? ORDER BY r.revision DESC`, configID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []ConfigRevision{}
+	for rows.Next() {
+		var r ConfigRevision
+		if err := scanConfigRevision(rows, &r); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// GetConfigRevision returns a single revision of a configuration, or
+// sql.ErrNoRows if it does not exist.
+func GetConfigRevision(configID int64, revision int) (*ConfigRevision, error) {
+	var r ConfigRevision
+	row := DB.QueryRow(selectRevisionSQL+` WHERE r.configuration_id = ? AND r.revision = ?`, configID, revision)
+	if err := scanConfigRevision(row, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
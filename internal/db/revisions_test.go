@@ -0,0 +1,50 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"k2ray/internal/db"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigRevisions(t *testing.T) {
+	ctx := context.Background()
+	res, err := db.DB.Exec(`INSERT INTO configurations (user_id, name, protocol, config_data) VALUES (1, 'rev', 'vmess', '{}')`)
+	require.NoError(t, err)
+	configID, _ := res.LastInsertId()
+
+	adminID := int64(1)
+	rev, err := db.AddConfigRevision(ctx, db.DB, configID, "rev", `{"a":1}`, &adminID, "first")
+	require.NoError(t, err)
+	assert.Equal(t, 1, rev)
+	rev, err = db.AddConfigRevision(ctx, db.DB, configID, "rev2", `{"a":2}`, nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, rev)
+
+	revisions, err := db.ListConfigRevisions(configID)
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, 2, revisions[0].Revision)
+	assert.Nil(t, revisions[0].AuthorID)
+	assert.Nil(t, revisions[0].Author)
+	assert.Equal(t, 1, revisions[1].Revision)
+	require.NotNil(t, revisions[1].Author)
+	assert.Equal(t, "admin", *revisions[1].Author)
+	assert.Equal(t, "first", revisions[1].Comment)
+
+	r, err := db.GetConfigRevision(configID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, r.ConfigData)
+	_, err = db.GetConfigRevision(configID, 3)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// Deleting the configuration removes its history.
+	_, err = db.DB.Exec(`DELETE FROM configurations WHERE id = ?`, configID)
+	require.NoError(t, err)
+	revisions, err = db.ListConfigRevisions(configID)
+	require.NoError(t, err)
+	assert.Empty(t, revisions)
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+)
+
+// ErrRuleOrderMismatch is returned by ReorderRoutingRules when the given IDs
+// are not exactly the IDs of the existing rules.
+var ErrRuleOrderMismatch = errors.New("the new order must list every routing rule exactly once")
+
+const routingRuleColumns = `id, position, name, enabled, domain, ip, port, network, protocol, inbound_tag, outbound_tag, created_at, updated_at`
+
+func scanRoutingRule(row interface{ Scan(...any) error }, r *RoutingRule) error {
+	var domain, ip, proto, inboundTag string
+	err := row.Scan(&r.ID, &r.Position, &r.Name, &r.Enabled, &domain, &ip, &r.Port, &r.Network, &proto, &inboundTag, &r.OutboundTag, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	for _, list := range []struct {
+		data string
+		dst  *[]string
+	}{{domain, &r.Domain}, {ip, &r.IP}, {proto, &r.Protocol}, {inboundTag, &r.InboundTag}} {
+		*list.dst = []string{}
+		if err := json.Unmarshal([]byte(list.data), list.dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalLists encodes the list conditions of r for storage.
+func marshalLists(r RoutingRule) (domain, ip, proto, inboundTag string) {
+	encode := func(values []string) string {
+		if values == nil {
+			values = []string{}
+		}
+		data, _ := json.Marshal(values) // A []string always marshals.
+		return string(data)
+	}
+	return encode(r.Domain), encode(r.IP), encode(r.Protocol), encode(r.InboundTag)
+}
+
+// ListRoutingRules returns the routing rules in the order they are applied.
+// If enabledOnly is set, disabled rules are left out.
+func ListRoutingRules(enabledOnly bool) ([]RoutingRule, error) {
+	querySQL := `SELECT ` + routingRuleColumns + ` FROM routing_rules`
+	if enabledOnly {
+		querySQL += ` WHERE enabled = 1`
+	}
+	rows, err := DB.Query(querySQL + ` ORDER BY position, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []RoutingRule{}
+	for rows.Next() {
+		var r RoutingRule
+		if err := scanRoutingRule(rows, &r); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// GetRoutingRule returns a single routing rule, or sql.ErrNoRows if it does
+// not exist.
+func GetRoutingRule(id int64) (*RoutingRule, error) {
+	var r RoutingRule
+	if err := scanRoutingRule(DB.QueryRow(`SELECT `+routingRuleColumns+` FROM routing_rules WHERE id = ?`, id), &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// CreateRoutingRule inserts r at r.Position, moving the rules at and after it
+// down by one, and returns its ID. A Position outside 1..count+1 appends the
+// rule to the end.
+func CreateRoutingRule(ctx context.Context, r RoutingRule) (int64, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM routing_rules`).Scan(&count); err != nil {
+		return 0, err
+	}
+	if r.Position < 1 || r.Position > count+1 {
+		r.Position = count + 1
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE routing_rules SET position = position + 1 WHERE position >= ?`, r.Position); err != nil {
+		return 0, err
+	}
+
+	domain, ip, proto, inboundTag := marshalLists(r)
+	insertSQL := `INSERT INTO routing_rules (position, name, enabled, domain, ip, port, network, protocol, inbound_tag, outbound_tag)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := tx.ExecContext(ctx, insertSQL, r.Position, r.Name, r.Enabled, domain, ip, r.Port, r.Network, proto, inboundTag, r.OutboundTag)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// UpdateRoutingRule replaces the name, state and conditions of the rule with
+// r.ID. Its position is left unchanged; use ReorderRoutingRules to move it.
+// It returns sql.ErrNoRows if the rule does not exist.
+func UpdateRoutingRule(ctx context.Context, r RoutingRule) error {
+	domain, ip, proto, inboundTag := marshalLists(r)
+	updateSQL := `UPDATE routing_rules SET name = ?, enabled = ?, domain = ?, ip = ?, port = ?, network = ?, protocol = ?,
+		inbound_tag = ?, outbound_tag = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	res, err := DB.ExecContext(ctx, updateSQL, r.Name, r.Enabled, domain, ip, r.Port, r.Network, proto, inboundTag, r.OutboundTag, r.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteRoutingRule removes a rule and closes the gap it leaves in the order.
+// It returns sql.ErrNoRows if the rule does not exist.
+func DeleteRoutingRule(ctx context.Context, id int64) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var position int
+	if err := tx.QueryRowContext(ctx, `DELETE FROM routing_rules WHERE id = ? RETURNING position`, id).Scan(&position); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE routing_rules SET position = position - 1 WHERE position > ?`, position); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReorderRoutingRules renumbers the rules so that they are applied in the
+// order of ids, which must list every rule exactly once.
+func ReorderRoutingRules(ctx context.Context, ids []int64) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM routing_rules ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	existing := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		existing = append(existing, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+	if !slices.Equal(sorted, existing) {
+		return ErrRuleOrderMismatch
+	}
+	for i, id := range ids {
+		if _, err := tx.ExecContext(ctx, `UPDATE routing_rules SET position = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, i+1, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
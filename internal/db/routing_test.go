@@ -0,0 +1,60 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"k2ray/internal/db"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutingRules(t *testing.T) {
+	ctx := context.Background()
+	t.Cleanup(func() { db.DB.Exec(`DELETE FROM routing_rules`) })
+
+	names := func(enabledOnly bool) []string {
+		rules, err := db.ListRoutingRules(enabledOnly)
+		require.NoError(t, err)
+		names := []string{}
+		for i, r := range rules {
+			if !enabledOnly {
+				assert.Equal(t, i+1, r.Position, "positions stay contiguous")
+			}
+			names = append(names, r.Name)
+		}
+		return names
+	}
+
+	a, err := db.CreateRoutingRule(ctx, db.RoutingRule{Name: "a", Enabled: true, Domain: []string{"geosite:cn"}, OutboundTag: "direct"})
+	require.NoError(t, err)
+	b, err := db.CreateRoutingRule(ctx, db.RoutingRule{Name: "b", Enabled: false, Port: "53", OutboundTag: "direct"})
+	require.NoError(t, err)
+	c, err := db.CreateRoutingRule(ctx, db.RoutingRule{Name: "c", Position: 1, Enabled: true, IP: []string{"geoip:cn"}, OutboundTag: "block"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "a", "b"}, names(false))
+	assert.Equal(t, []string{"c", "a"}, names(true))
+
+	rule, err := db.GetRoutingRule(a)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"geosite:cn"}, rule.Domain)
+	assert.Equal(t, []string{}, rule.IP)
+
+	rule.Name, rule.Domain = "a2", []string{"full:example.com"}
+	require.NoError(t, db.UpdateRoutingRule(ctx, *rule))
+	rule, err = db.GetRoutingRule(a)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"full:example.com"}, rule.Domain)
+	assert.Equal(t, 2, rule.Position, "updates do not move the rule")
+	assert.ErrorIs(t, db.UpdateRoutingRule(ctx, db.RoutingRule{ID: 999}), sql.ErrNoRows)
+
+	assert.ErrorIs(t, db.ReorderRoutingRules(ctx, []int64{a, b}), db.ErrRuleOrderMismatch)
+	assert.ErrorIs(t, db.ReorderRoutingRules(ctx, []int64{a, b, b}), db.ErrRuleOrderMismatch)
+	require.NoError(t, db.ReorderRoutingRules(ctx, []int64{b, a, c}))
+	assert.Equal(t, []string{"b", "a2", "c"}, names(false))
+
+	require.NoError(t, db.DeleteRoutingRule(ctx, b))
+	assert.Equal(t, []string{"a2", "c"}, names(false))
+	assert.ErrorIs(t, db.DeleteRoutingRule(ctx, b), sql.ErrNoRows)
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// searchFTS reports whether configuration search is backed by the FTS5 index.
+// SQLite is only built with FTS5 under the sqlite_fts5 build tag; without it,
+// search falls back to LIKE matching over the same columns.
+var searchFTS bool
+
+// configServerSQL extracts the server address from the config_data of row,
+// which is "configurations", "NEW" or "OLD". VMess and VLESS call it "add",
+// every other protocol "server".
+func configServerSQL(row string) string {
+	return fmt.Sprintf(`CASE WHEN json_valid(%[1]s.config_data) THEN COALESCE(json_extract(%[1]s.config_data, '$.add'), json_extract(%[1]s.config_data, '$.server'), '') ELSE '' END`, row)
+}
+
+// setupConfigSearch creates the configurations_fts index and the triggers that
+// keep it in sync, then rebuilds it. It runs on every start rather than as a
+// migration because FTS5 may not be available.
+func setupConfigSearch(db *sql.DB) error {
+	_, err := db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS configurations_fts USING fts5(name, server, remarks)`)
+	if err != nil {
+		if !strings.Contains(err.Error(), "no such module: fts5") {
+			return err
+		}
+		log.Warn().Msg("SQLite was built without FTS5, configuration search falls back to LIKE matching. Build with -tags sqlite_fts5 to enable it.")
+		searchFTS = false
+		// Triggers left behind by an FTS5 build would make every write fail.
+		_, err = db.Exec(`DROP TRIGGER IF EXISTS trg_configurations_fts_insert;
+			DROP TRIGGER IF EXISTS trg_configurations_fts_update;
+			DROP TRIGGER IF EXISTS trg_configurations_fts_delete;`)
+		return err
+	}
+
+	setupSQL := fmt.Sprintf(`
+		CREATE TRIGGER IF NOT EXISTS trg_configurations_fts_insert AFTER INSERT ON configurations
+		BEGIN
+			INSERT INTO configurations_fts (rowid, name, server, remarks) VALUES (NEW.id, NEW.name, %[1]s, NEW.remarks);
+		END;
+		CREATE TRIGGER IF NOT EXISTS trg_configurations_fts_update AFTER UPDATE OF name, config_data, remarks ON configurations
+		BEGIN
+			DELETE FROM configurations_fts WHERE rowid = OLD.id;
+			INSERT INTO configurations_fts (rowid, name, server, remarks) VALUES (NEW.id, NEW.name, %[1]s, NEW.remarks);
+		END;
+		CREATE TRIGGER IF NOT EXISTS trg_configurations_fts_delete AFTER DELETE ON configurations
+		BEGIN
+			DELETE FROM configurations_fts WHERE rowid = OLD.id;
+		END;
+		DELETE FROM configurations_fts;
+		INSERT INTO configurations_fts (rowid, name, server, remarks) SELECT id, name, %[2]s, remarks FROM configurations;`,
+		configServerSQL("NEW"), configServerSQL("configurations"))
+	if _, err := db.Exec(setupSQL); err != nil {
+		return err
+	}
+	searchFTS = true
+	return nil
+}
+
+// ConfigSearchFilter returns a condition on the configurations table that
+// matches rows containing every word of query in their name, server address
+// or remarks, together with its arguments. Words match as prefixes of indexed
+// tokens with FTS5 and as substrings otherwise. It returns an empty condition
+// if query has no words.
+func ConfigSearchFilter(query string) (string, []any) {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return "", nil
+	}
+
+	if searchFTS {
+		terms := make([]string, len(words))
+		for i, w := range words {
+			terms[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"*`
+		}
+		return "id IN (SELECT rowid FROM configurations_fts WHERE configurations_fts MATCH ?)", []any{strings.Join(terms, " ")}
+	}
+
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	server := configServerSQL("configurations")
+	conditions := make([]string, len(words))
+	var args []any
+	for i, w := range words {
+		pattern := "%" + escaper.Replace(w) + "%"
+		conditions[i] = fmt.Sprintf(`(name LIKE ? ESCAPE '\' OR %s LIKE ? ESCAPE '\' OR remarks LIKE ? ESCAPE '\')`, server)
+		args = append(args, pattern, pattern, pattern)
+	}
+	return strings.Join(conditions, " AND "), args
+}
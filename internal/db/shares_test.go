@@ -0,0 +1,83 @@
+package db_test
+
+import (
+	"k2ray/internal/db"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigAccess(t *testing.T) {
+	insertUser := func(name string) int64 {
+		res, err := db.DB.Exec(`INSERT INTO users (username, password_hash) VALUES (?, 'x')`, name)
+		require.NoError(t, err)
+		id, _ := res.LastInsertId()
+		t.Cleanup(func() { db.DB.Exec(`DELETE FROM users WHERE id = ?`, id) })
+		return id
+	}
+	reader, writer, stranger := insertUser("share-reader"), insertUser("share-writer"), insertUser("share-stranger")
+
+	shared := insertTestConfig(t, "share-a", `{}`, "")
+	private := insertTestConfig(t, "share-b", `{}`, "")
+
+	res, err := db.DB.Exec(`INSERT INTO user_groups (name) VALUES ('share-team')`)
+	require.NoError(t, err)
+	groupID, _ := res.LastInsertId()
+	_, err = db.DB.Exec(`INSERT INTO user_group_members (group_id, user_id) VALUES (?, ?)`, groupID, writer)
+	require.NoError(t, err)
+
+	_, err = db.DB.Exec(`INSERT INTO config_shares (configuration_id, user_id, permission) VALUES (?, ?, 'read'), (?, ?, 'read')`, shared, reader, shared, writer)
+	require.NoError(t, err)
+	_, err = db.DB.Exec(`INSERT INTO config_shares (configuration_id, user_group_id, permission) VALUES (?, ?, 'write')`, shared, groupID)
+	require.NoError(t, err)
+	_, err = db.DB.Exec(`INSERT INTO config_shares (configuration_id, user_id, user_group_id, permission) VALUES (?, ?, ?, 'read')`, shared, reader, groupID)
+	assert.Error(t, err, "a share names either a user or a group")
+
+	access, err := db.ConfigAccessFor(1, []int64{shared, private, 999})
+	require.NoError(t, err)
+	assert.Equal(t, db.ConfigAccess{Owner: "admin", Permission: db.PermissionOwner}, access[shared])
+	assert.Equal(t, db.PermissionOwner, access[private].Permission)
+	assert.NotContains(t, access, int64(999))
+
+	access, err = db.ConfigAccessFor(reader, []int64{shared, private})
+	require.NoError(t, err)
+	assert.Equal(t, db.PermissionRead, access[shared].Permission)
+	assert.Equal(t, db.Permission(""), access[private].Permission)
+
+	access, err = db.ConfigAccessFor(writer, []int64{shared})
+	require.NoError(t, err)
+	assert.Equal(t, db.PermissionWrite, access[shared].Permission, "the strongest share wins")
+
+	assert.True(t, db.PermissionWrite.Allows(db.PermissionRead))
+	assert.False(t, db.PermissionRead.Allows(db.PermissionWrite))
+	assert.False(t, db.PermissionWrite.Allows(db.PermissionOwner))
+	assert.False(t, db.Permission("").Allows(db.PermissionRead))
+
+	ids := func(userID int64, scope string) []int64 {
+		condition, args := db.ConfigAccessFilter(userID, scope)
+		rows, err := db.DB.Query(`SELECT id FROM configurations WHERE `+condition+` AND name LIKE 'share-%' ORDER BY id`, args...)
+		require.NoError(t, err)
+		defer rows.Close()
+		found := []int64{}
+		for rows.Next() {
+			var id int64
+			require.NoError(t, rows.Scan(&id))
+			found = append(found, id)
+		}
+		return found
+	}
+	assert.Equal(t, []int64{shared, private}, ids(1, "all"))
+	assert.Empty(t, ids(1, "shared"))
+	assert.Equal(t, []int64{shared}, ids(writer, "all"))
+	assert.Equal(t, []int64{shared}, ids(writer, "shared"))
+	assert.Empty(t, ids(writer, "owned"))
+	assert.Empty(t, ids(stranger, "all"))
+
+	// Deleting the group revokes the access it granted.
+	_, err = db.DB.Exec(`DELETE FROM user_groups WHERE id = ?`, groupID)
+	require.NoError(t, err)
+	access, err = db.ConfigAccessFor(writer, []int64{shared})
+	require.NoError(t, err)
+	assert.Equal(t, db.PermissionRead, access[shared].Permission)
+}
@@ -54,6 +54,10 @@ func InitDB() {
 			log.Fatal().Err(err).Msg("Fatal error running database migrations")
 		}
 		log.Info().Msg("Database migrations completed successfully.")
+
+		if err := setupConfigSearch(DB); err != nil {
+			log.Fatal().Err(err).Msg("Fatal error setting up configuration search")
+		}
 	})
 }
 
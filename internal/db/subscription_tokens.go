@@ -0,0 +1,75 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// SubscriptionToken describes a user's subscription token. The token itself is
+// only returned once, when it is created.
+type SubscriptionToken struct {
+	UserID     int64
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+func hashSubscriptionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RotateSubscriptionToken issues a new subscription token for the user,
+// replacing (and thereby revoking) any previous one.
+func RotateSubscriptionToken(userID int64) (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	upsertSQL := `INSERT INTO subscription_tokens (user_id, token_hash, created_at, last_used_at) VALUES (?, ?, ?, NULL)
+		ON CONFLICT(user_id) DO UPDATE SET token_hash = excluded.token_hash, created_at = excluded.created_at, last_used_at = NULL`
+	if _, err := DB.Exec(upsertSQL, userID, hashSubscriptionToken(token), time.Now().UTC()); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RevokeSubscriptionToken deletes the user's subscription token. It reports
+// whether there was a token to revoke.
+func RevokeSubscriptionToken(userID int64) (bool, error) {
+	result, err := DB.Exec(`DELETE FROM subscription_tokens WHERE user_id = ?`, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetSubscriptionToken returns the metadata of the user's subscription token,
+// or sql.ErrNoRows if the user has none.
+func GetSubscriptionToken(userID int64) (*SubscriptionToken, error) {
+	t := &SubscriptionToken{UserID: userID}
+	querySQL := `SELECT created_at, last_used_at FROM subscription_tokens WHERE user_id = ?`
+	if err := DB.QueryRow(querySQL, userID).Scan(&t.CreatedAt, &t.LastUsedAt); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ResolveSubscriptionToken returns the ID of the user owning token and records
+// the use. It returns sql.ErrNoRows for unknown or revoked tokens.
+func ResolveSubscriptionToken(token string) (int64, error) {
+	var userID int64
+	hash := hashSubscriptionToken(token)
+	if err := DB.QueryRow(`SELECT user_id FROM subscription_tokens WHERE token_hash = ?`, hash).Scan(&userID); err != nil {
+		return 0, err
+	}
+	if _, err := DB.Exec(`UPDATE subscription_tokens SET last_used_at = ? WHERE token_hash = ?`, time.Now().UTC(), hash); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
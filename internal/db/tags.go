@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"strings"
+)
+
+// SetConfigTags replaces the tags of a configuration, creating the user's tags
+// that do not exist yet. Tag names are matched case-insensitively.
+func SetConfigTags(ctx context.Context, q Querier, userID, configID int64, names []string) error {
+	if _, err := q.ExecContext(ctx, `DELETE FROM configuration_tags WHERE configuration_id = ?`, configID); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := q.ExecContext(ctx, `INSERT INTO tags (user_id, name) VALUES (?, ?) ON CONFLICT(user_id, name) DO NOTHING`, userID, name); err != nil {
+			return err
+		}
+		insertSQL := `INSERT OR IGNORE INTO configuration_tags (configuration_id, tag_id) SELECT ?, id FROM tags WHERE user_id = ? AND name = ?`
+		if _, err := q.ExecContext(ctx, insertSQL, configID, userID, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConfigTags returns the tag names of each of the given configurations,
+// sorted by name. Configurations without tags map to an empty slice.
+func ConfigTags(configIDs []int64) (map[int64][]string, error) {
+	tags := make(map[int64][]string, len(configIDs))
+	if len(configIDs) == 0 {
+		return tags, nil
+	}
+	args := make([]any, len(configIDs))
+	for i, id := range configIDs {
+		tags[id] = []string{}
+		args[i] = id
+	}
+
+	querySQL := `SELECT ct.configuration_id, t.name FROM configuration_tags ct JOIN tags t ON t.id = ct.tag_id
+		WHERE ct.configuration_id IN (?` + strings.Repeat(",?", len(configIDs)-1) + `) ORDER BY t.name`
+	rows, err := DB.Query(querySQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		tags[id] = append(tags[id], name)
+	}
+	return tags, rows.Err()
+}
+
+// ListTags returns the user's tags with the number of configurations carrying
+// each, sorted by name.
+func ListTags(userID int64) ([]Tag, error) {
+	querySQL := `SELECT t.id, t.name, COUNT(ct.configuration_id) FROM tags t LEFT JOIN configuration_tags ct ON ct.tag_id = t.id
+		WHERE t.user_id = ? GROUP BY t.id ORDER BY t.name`
+	rows, err := DB.Query(querySQL, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.Configs); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// ConfigTagFilter returns a condition on the configurations table that matches
+// rows carrying all of the user's tags in names, or any of them if matchAny is
+// set, together with its arguments.
+func ConfigTagFilter(userID int64, names []string, matchAny bool) (string, []any) {
+	args := []any{userID}
+	for _, name := range names {
+		args = append(args, name)
+	}
+	condition := `id IN (SELECT ct.configuration_id FROM configuration_tags ct JOIN tags t ON t.id = ct.tag_id
+		WHERE t.user_id = ? AND t.name IN (?` + strings.Repeat(",?", len(names)-1) + `)`
+	if matchAny {
+		return condition + ")", args
+	}
+	// Tag names are unique per user, so carrying all of them means matching len(names) distinct tags.
+	return condition + ` GROUP BY ct.configuration_id HAVING COUNT(DISTINCT t.id) = ?)`, append(args, len(names))
+}
@@ -0,0 +1,103 @@
+package db_test
+
+import (
+	"context"
+	"k2ray/internal/db"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// insertTestConfig stores a configuration for user 1 and returns its ID.
+func insertTestConfig(t *testing.T, name, configData, remarks string) int64 {
+	res, err := db.DB.Exec(`INSERT INTO configurations (user_id, name, protocol, config_data, remarks) VALUES (1, ?, 'trojan', ?, ?)`, name, configData, remarks)
+	require.NoError(t, err)
+	id, _ := res.LastInsertId()
+	t.Cleanup(func() { db.DB.Exec(`DELETE FROM configurations WHERE id = ?`, id) })
+	return id
+}
+
+// filterConfigs returns the IDs of user 1's configurations matching condition.
+func filterConfigs(t *testing.T, condition string, args []any) []int64 {
+	rows, err := db.DB.Query(`SELECT id FROM configurations WHERE user_id = 1 AND `+condition+` ORDER BY id`, args...)
+	require.NoError(t, err)
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		require.NoError(t, rows.Scan(&id))
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestConfigTags(t *testing.T) {
+	ctx := context.Background()
+	a := insertTestConfig(t, "tag-a", `{}`, "")
+	b := insertTestConfig(t, "tag-b", `{}`, "")
+	c := insertTestConfig(t, "tag-c", `{}`, "")
+
+	require.NoError(t, db.SetConfigTags(ctx, db.DB, 1, a, []string{"DE", "hetzner"}))
+	require.NoError(t, db.SetConfigTags(ctx, db.DB, 1, b, []string{"de", "streaming"}))
+	require.NoError(t, db.SetConfigTags(ctx, db.DB, 1, c, []string{"US"}))
+
+	tags, err := db.ConfigTags([]int64{a, b, c, 999})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"DE", "hetzner"}, tags[a])
+	assert.Equal(t, []string{"DE", "streaming"}, tags[b], "tag names are matched case-insensitively")
+	assert.Equal(t, []string{}, tags[999])
+
+	list, err := db.ListTags(1)
+	require.NoError(t, err)
+	require.Len(t, list, 4)
+	assert.Equal(t, "DE", list[0].Name)
+	assert.Equal(t, 2, list[0].Configs)
+
+	condition, args := db.ConfigTagFilter(1, []string{"de", "hetzner"}, false)
+	assert.Equal(t, []int64{a}, filterConfigs(t, condition, args))
+	condition, args = db.ConfigTagFilter(1, []string{"hetzner", "us"}, true)
+	assert.Equal(t, []int64{a, c}, filterConfigs(t, condition, args))
+	condition, args = db.ConfigTagFilter(2, []string{"de"}, true)
+	assert.Empty(t, filterConfigs(t, condition, args), "tags are per user")
+
+	// Replacing the tags removes the old ones.
+	require.NoError(t, db.SetConfigTags(ctx, db.DB, 1, a, []string{}))
+	tags, err = db.ConfigTags([]int64{a})
+	require.NoError(t, err)
+	assert.Empty(t, tags[a])
+
+	_, err = db.DB.Exec(`DELETE FROM tags WHERE user_id = 1`)
+	require.NoError(t, err)
+	tags, err = db.ConfigTags([]int64{b})
+	require.NoError(t, err)
+	assert.Empty(t, tags[b], "deleting a tag removes it from its configurations")
+}
+
+func TestConfigSearchFilter(t *testing.T) {
+	de := insertTestConfig(t, "Frankfurt 1", `{"server": "fra1.example.com", "server_port": 443}`, "Hetzner dedicated")
+	us := insertTestConfig(t, "New York", `{"add": "nyc.example.net", "port": 443}`, "")
+	insertTestConfig(t, "Broken", `not json`, "")
+
+	search := func(query string) []int64 {
+		condition, args := db.ConfigSearchFilter(query)
+		require.NotEmpty(t, condition)
+		return filterConfigs(t, condition, args)
+	}
+
+	assert.Equal(t, []int64{de}, search("frankfurt"))
+	assert.Equal(t, []int64{de, us}, search("exam"), "server addresses are searched")
+	assert.Equal(t, []int64{us}, search("nyc"))
+	assert.Equal(t, []int64{de}, search("hetz fra"), "every word must match")
+	assert.Empty(t, search("hetzner york"))
+	assert.Empty(t, search(`"%_`), "special characters are matched literally")
+
+	// The index follows updates.
+	_, err := db.DB.Exec(`UPDATE configurations SET remarks = 'backup' WHERE id = ?`, us)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{us}, search("backup"))
+
+	condition, args := db.ConfigSearchFilter("   ")
+	assert.Empty(t, condition)
+	assert.Nil(t, args)
+}
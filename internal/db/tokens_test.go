@@ -1,6 +1,7 @@
 package db_test
 
 import (
+	"database/sql"
 	"k2ray/internal/config"
 	"k2ray/internal/db"
 	"os"
@@ -64,7 +65,7 @@ func TestCleanupExpiredTokens(t *testing.T) {
 	expiredJTI := "expired-jti"
 	validJTI := "valid-jti"
 	expiredTime := time.Now().Add(-1 * time.Hour) // Expired 1 hour ago
-	validTime := time.Now().Add(1 * time.Hour)   // Expires in 1 hour
+	validTime := time.Now().Add(1 * time.Hour)    // Expires in 1 hour
 
 	err = db.BlocklistToken(expiredJTI, expiredTime)
 	assert.NoError(t, err)
@@ -95,3 +96,47 @@ func TestCleanupExpiredTokens(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, isBlocklisted, "Valid token should not have been removed")
 }
+
+func TestSubscriptionTokens(t *testing.T) {
+	res, err := db.DB.Exec(`INSERT INTO users (username, password_hash) VALUES ('sub-token-user', 'x')`)
+	assert.NoError(t, err)
+	userID, _ := res.LastInsertId()
+
+	// No token yet.
+	_, err = db.GetSubscriptionToken(userID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	token, err := db.RotateSubscriptionToken(userID)
+	assert.NoError(t, err)
+	assert.Len(t, token, 32)
+
+	info, err := db.GetSubscriptionToken(userID)
+	assert.NoError(t, err)
+	assert.Nil(t, info.LastUsedAt)
+
+	resolved, err := db.ResolveSubscriptionToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, userID, resolved)
+	info, err = db.GetSubscriptionToken(userID)
+	assert.NoError(t, err)
+	assert.NotNil(t, info.LastUsedAt)
+
+	// Rotating invalidates the previous token.
+	rotated, err := db.RotateSubscriptionToken(userID)
+	assert.NoError(t, err)
+	assert.NotEqual(t, token, rotated)
+	_, err = db.ResolveSubscriptionToken(token)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = db.ResolveSubscriptionToken(rotated)
+	assert.NoError(t, err)
+
+	// Revoking removes it entirely.
+	revoked, err := db.RevokeSubscriptionToken(userID)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	_, err = db.ResolveSubscriptionToken(rotated)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	revoked, err = db.RevokeSubscriptionToken(userID)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+}
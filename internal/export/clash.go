@@ -0,0 +1,244 @@
+package export
+
+import (
+	"bytes"
+	"k2ray/internal/db"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ClashProxyGroup is the name of the selector group that lists every proxy.
+	ClashProxyGroup = "PROXY"
+	// ClashAutoGroup is the name of the url-test group that picks the fastest proxy.
+	ClashAutoGroup = "AUTO"
+
+	clashTestURL      = "http://www.gstatic.com/generate_204"
+	clashTestInterval = 300 // seconds
+	clashTolerance    = 50  // milliseconds
+)
+
+// clashReserved are the group names of the document and Clash's built-in
+// policies, which proxies cannot be named.
+var clashReserved = []string{ClashProxyGroup, ClashAutoGroup, "DIRECT", "REJECT", "GLOBAL"}
+
+// clashRules sends private and loopback traffic direct and everything else
+// through the selector group.
+var clashRules = []string{
+	"DOMAIN-SUFFIX,local,DIRECT",
+	"IP-CIDR,127.0.0.0/8,DIRECT,no-resolve",
+	"IP-CIDR,10.0.0.0/8,DIRECT,no-resolve",
+	"IP-CIDR,172.16.0.0/12,DIRECT,no-resolve",
+	"IP-CIDR,192.168.0.0/16,DIRECT,no-resolve",
+	"IP-CIDR6,::1/128,DIRECT,no-resolve",
+	"IP-CIDR6,fc00::/7,DIRECT,no-resolve",
+	"MATCH," + ClashProxyGroup,
+}
+
+// clashMetaOnly lists the protocols only Clash.Meta understands.
+var clashMetaOnly = map[string]bool{"vless": true, "hysteria2": true, "tuic": true}
+
+type clashConfig struct {
+	MixedPort   int               `yaml:"mixed-port"`
+	AllowLAN    bool              `yaml:"allow-lan"`
+	Mode        string            `yaml:"mode"`
+	LogLevel    string            `yaml:"log-level"`
+	Proxies     []clashProxy      `yaml:"proxies"`
+	ProxyGroups []clashProxyGroup `yaml:"proxy-groups"`
+	Rules       []string          `yaml:"rules"`
+}
+
+type clashProxy struct {
+	Name       string         `yaml:"name"`
+	Type       string         `yaml:"type"`
+	Server     string         `yaml:"server"`
+	Port       int            `yaml:"port"`
+	UUID       string         `yaml:"uuid,omitempty"`
+	AlterID    *int           `yaml:"alterId,omitempty"`
+	Cipher     string         `yaml:"cipher,omitempty"`
+	Password   string         `yaml:"password,omitempty"` // trojan, ss
+	Flow       string         `yaml:"flow,omitempty"`
+	Plugin     string         `yaml:"plugin,omitempty"`
+	PluginOpts map[string]any `yaml:"plugin-opts,omitempty"`
+	UDP        bool           `yaml:"udp"`
+	TLS        bool           `yaml:"tls,omitempty"`
+	ServerName string         `yaml:"servername,omitempty"`
+	SNI        string         `yaml:"sni,omitempty"`
+	Network    string         `yaml:"network,omitempty"`
+	WSOpts     *clashWSOpts   `yaml:"ws-opts,omitempty"`
+	GRPCOpts   *clashGRPCOpts `yaml:"grpc-opts,omitempty"`
+
+	// Clash.Meta only
+	ClientFingerprint    string            `yaml:"client-fingerprint,omitempty"`
+	RealityOpts          *clashRealityOpts `yaml:"reality-opts,omitempty"`
+	Up                   int               `yaml:"up,omitempty"`   // Mbps
+	Down                 int               `yaml:"down,omitempty"` // Mbps
+	Obfs                 string            `yaml:"obfs,omitempty"`
+	ObfsPassword         string            `yaml:"obfs-password,omitempty"`
+	CongestionController string            `yaml:"congestion-controller,omitempty"`
+	UDPRelayMode         string            `yaml:"udp-relay-mode,omitempty"`
+	ALPN                 []string          `yaml:"alpn,omitempty"`
+	SkipCertVerify       bool              `yaml:"skip-cert-verify,omitempty"`
+	Fingerprint          string            `yaml:"fingerprint,omitempty"` // certificate SHA-256
+}
+
+type clashRealityOpts struct {
+	PublicKey string `yaml:"public-key"`
+	ShortID   string `yaml:"short-id,omitempty"`
+}
+
+type clashWSOpts struct {
+	Path    string            `yaml:"path,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+type clashGRPCOpts struct {
+	ServiceName string `yaml:"grpc-service-name,omitempty"`
+}
+
+type clashProxyGroup struct {
+	Name      string   `yaml:"name"`
+	Type      string   `yaml:"type"`
+	Proxies   []string `yaml:"proxies"`
+	URL       string   `yaml:"url,omitempty"`
+	Interval  int      `yaml:"interval,omitempty"`
+	Tolerance int      `yaml:"tolerance,omitempty"`
+}
+
+// Clash renders configs as a complete Clash configuration: a mixed proxy port,
+// the proxies, a PROXY selector and an AUTO url-test group, and a basic rule
+// set. Original Clash has no VLESS, Hysteria2 or TUIC support, so those
+// configurations are only included when meta is set, for Clash.Meta (mihomo)
+// clients.
+func Clash(configs []db.Configuration, meta bool) ([]byte, error) {
+	doc := clashConfig{
+		MixedPort: 7890,
+		Mode:      "rule",
+		LogLevel:  "info",
+		Proxies:   []clashProxy{},
+		Rules:     clashRules,
+	}
+
+	names := []string{}
+	for _, n := range nodes(configs, clashReserved...) {
+		if clashMetaOnly[n.Protocol] && !meta {
+			log.Warn().Str("name", n.Name).Str("protocol", n.Protocol).Msg("Skipping configuration whose protocol Clash does not support")
+			continue
+		}
+		doc.Proxies = append(doc.Proxies, newClashProxy(n))
+		names = append(names, n.Name)
+	}
+
+	selector := append([]string{ClashAutoGroup}, names...)
+	doc.ProxyGroups = []clashProxyGroup{
+		{Name: ClashProxyGroup, Type: "select", Proxies: append(selector, "DIRECT")},
+		{Name: ClashAutoGroup, Type: "url-test", Proxies: urlTestProxies(names), URL: clashTestURL, Interval: clashTestInterval, Tolerance: clashTolerance},
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// urlTestProxies returns names, or DIRECT if there are none, since Clash
+// rejects groups without members.
+func urlTestProxies(names []string) []string {
+	if len(names) == 0 {
+		return []string{"DIRECT"}
+	}
+	return names
+}
+
+func newClashProxy(n *node) clashProxy {
+	p := clashProxy{Name: n.Name, Server: n.Server, Port: n.Port, UDP: true}
+
+	switch n.Protocol {
+	case "vmess":
+		p.Type, p.UUID, p.Cipher = "vmess", n.UUID, "auto"
+		p.AlterID = &n.AlterID
+		p.ServerName = n.SNI
+	case "vless":
+		p.Type, p.UUID, p.Flow = "vless", n.UUID, n.Flow
+		p.ServerName = n.SNI
+		if n.Reality != nil {
+			p.ClientFingerprint = n.Reality.Fingerprint
+			p.RealityOpts = &clashRealityOpts{PublicKey: n.Reality.PublicKey, ShortID: n.Reality.ShortID}
+		}
+	case "trojan":
+		p.Type, p.Password, p.SNI = "trojan", n.Password, n.SNI
+	case "shadowsocks":
+		p.Type, p.Cipher, p.Password = "ss", n.Method, n.Password
+		p.Plugin, p.PluginOpts = clashPlugin(n.Plugin, n.PluginOpts)
+		return p
+	case "hysteria2":
+		p.Type, p.Password = "hysteria2", n.Password
+		p.Obfs, p.ObfsPassword = n.Obfs, n.ObfsPassword
+		p.Up, p.Down = n.UpMbps, n.DownMbps
+		setClashQUICTLS(&p, n)
+		return p
+	case "tuic":
+		p.Type, p.UUID, p.Password = "tuic", n.UUID, n.Password
+		p.CongestionController, p.UDPRelayMode = n.CongestionControl, n.UDPRelayMode
+		setClashQUICTLS(&p, n)
+		return p
+	}
+
+	// Trojan always uses TLS in Clash, so the flag is only written for vmess/vless.
+	p.TLS = n.TLS && n.Protocol != "trojan"
+	if n.Network != "tcp" {
+		p.Network = n.Network
+	}
+	switch n.Network {
+	case "ws":
+		p.WSOpts = &clashWSOpts{Path: n.Path}
+		if n.Host != "" {
+			p.WSOpts.Headers = map[string]string{"Host": n.Host}
+		}
+	case "grpc":
+		p.GRPCOpts = &clashGRPCOpts{ServiceName: n.ServiceName}
+	}
+	return p
+}
+
+// setClashQUICTLS sets the TLS options of the QUIC-based proxies, which are
+// always encrypted and so carry no tls flag.
+func setClashQUICTLS(p *clashProxy, n *node) {
+	p.SNI, p.SkipCertVerify, p.ALPN = n.SNI, n.Insecure, n.ALPN
+	p.Fingerprint = strings.ToLower(strings.ReplaceAll(n.PinSHA256, ":", ""))
+}
+
+// clashPlugin maps SIP002 plugin names and options onto Clash's plugin fields.
+// Clash names simple-obfs "obfs" and takes its options as a map.
+func clashPlugin(plugin, opts string) (string, map[string]any) {
+	if plugin == "" {
+		return "", nil
+	}
+	options := make(map[string]any)
+	for _, opt := range strings.Split(opts, ";") {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "":
+		case "obfs":
+			options["mode"] = value
+		case "obfs-host":
+			options["host"] = value
+		case "tls":
+			options["tls"] = true
+		default:
+			options[key] = value
+		}
+	}
+	switch plugin {
+	case "obfs-local", "simple-obfs":
+		plugin = "obfs"
+	}
+	return plugin, options
+}
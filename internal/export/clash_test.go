@@ -0,0 +1,98 @@
+package export_test
+
+import (
+	"flag"
+	"k2ray/internal/db"
+	"k2ray/internal/export"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// goldenConfigs covers every protocol and transport the exporters support.
+var goldenConfigs = []db.Configuration{
+	{ID: 1, Name: "VMess WS", Protocol: "vmess", ConfigData: `{"v":"2","add":"vmess.example.com","port":"443","id":"b831381d-6324-4d53-ad4f-8cda48b30811","aid":0,"host":"cdn.example.com","path":"/ray","net":"ws","tls":"tls"}`},
+	{ID: 2, Name: "VMess gRPC", Protocol: "vmess", ConfigData: `{"add":"grpc.example.com","port":443,"id":"b831381d-6324-4d53-ad4f-8cda48b30811","aid":0,"net":"grpc","tls":"tls","grpcSettings":{"serviceName":"tunnel"}}`},
+	{ID: 3, Name: "VLESS Vision", Protocol: "vless", ConfigData: `{"id":"b831381d-6324-4d53-ad4f-8cda48b30811","add":"vless.example.com","port":443,"encryption":"none","flow":"xtls-rprx-vision","sni":"sni.example.com","net":"tcp","tls":"tls"}`},
+	{ID: 4, Name: "VLESS WS", Protocol: "vless", ConfigData: `{"id":"b831381d-6324-4d53-ad4f-8cda48b30811","add":"vless-ws.example.com","port":443,"net":"ws","tls":"tls","wsSettings":{"path":"/vless","headers":{"Host":"cdn.example.com"}}}`},
+	{ID: 8, Name: "VLESS REALITY", Protocol: "vless", ConfigData: `{"id":"b831381d-6324-4d53-ad4f-8cda48b30811","add":"reality.example.com","port":443,"flow":"xtls-rprx-vision","realitySettings":{"serverName":"www.microsoft.com","publicKey":"uWhDlWI62pIgIWKYqjhL55xvC6_2CtcG8yDprrqtxDk","shortId":"6ba85179e30d4fc2"},"net":"tcp","tls":"reality"}`},
+	{ID: 5, Name: "Trojan gRPC", Protocol: "trojan", ConfigData: `{"server":"trojan.example.com","server_port":443,"password":"secret","sni":"real.example.com","net":"grpc","grpcSettings":{"serviceName":"trojan-grpc"}}`},
+	{ID: 6, Name: "SS", Protocol: "shadowsocks", ConfigData: `{"server":"ss.example.com","server_port":8388,"password":"secret","method":"chacha20-ietf-poly1305"}`},
+	{ID: 7, Name: "SS obfs", Protocol: "shadowsocks", ConfigData: `{"server":"ss-obfs.example.com","server_port":8388,"password":"secret","method":"aes-256-gcm","plugin":"obfs-local;obfs=http;obfs-host=www.bing.com"}`},
+	{ID: 9, Name: "Hysteria2", Protocol: "hysteria2", ConfigData: `{"server":"hy2.example.com","server_port":443,"password":"secret","obfs":"salamander","obfs_password":"obfs-secret","up_mbps":50,"down_mbps":200,"sni":"real.example.com","alpn":["h3"],"pin_sha256":"2E:2F:5B:F0:5B:4F:4F:BC:8A:2F:3B:0F:EA:89:B5:F5:B1:AC:6D:54:CE:53:E4:2A:8B:A4:F9:D6:A8:F4:A3:C9"}`},
+	{ID: 10, Name: "TUIC", Protocol: "tuic", ConfigData: `{"server":"tuic.example.com","server_port":443,"uuid":"b831381d-6324-4d53-ad4f-8cda48b30811","password":"secret","congestion_control":"bbr","udp_relay_mode":"native","sni":"tuic.example.com","insecure":true,"alpn":["h3"]}`},
+}
+
+// checkGolden compares got with testdata/name, rewriting it with -update.
+func checkGolden(t *testing.T, name string, got []byte) []byte {
+	t.Helper()
+	golden := filepath.Join("testdata", name)
+	if *update {
+		require.NoError(t, os.MkdirAll("testdata", 0755))
+		require.NoError(t, os.WriteFile(golden, got, 0644))
+	}
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+	return want
+}
+
+func TestClashGolden(t *testing.T) {
+	for _, tc := range []struct {
+		golden string
+		meta   bool
+	}{
+		{"clash.golden.yaml", false},
+		{"clash-meta.golden.yaml", true},
+	} {
+		t.Run(tc.golden, func(t *testing.T) {
+			got, err := export.Clash(goldenConfigs, tc.meta)
+			require.NoError(t, err)
+			checkGolden(t, tc.golden, got)
+
+			var doc map[string]any
+			require.NoError(t, yaml.Unmarshal(got, &doc), "output must be valid YAML")
+		})
+	}
+}
+
+func TestClashGroups(t *testing.T) {
+	got, err := export.Clash(goldenConfigs, false)
+	require.NoError(t, err)
+
+	var doc struct {
+		Proxies []struct {
+			Name string `yaml:"name"`
+			Type string `yaml:"type"`
+		} `yaml:"proxies"`
+		Groups []struct {
+			Name    string   `yaml:"name"`
+			Type    string   `yaml:"type"`
+			Proxies []string `yaml:"proxies"`
+		} `yaml:"proxy-groups"`
+		Rules []string `yaml:"rules"`
+	}
+	require.NoError(t, yaml.Unmarshal(got, &doc))
+
+	for _, p := range doc.Proxies {
+		assert.NotContains(t, []string{"vless", "hysteria2", "tuic"}, p.Type, "plain Clash has no VLESS, Hysteria2 or TUIC")
+	}
+	require.Len(t, doc.Groups, 2)
+	assert.Equal(t, "select", doc.Groups[0].Type)
+	assert.Equal(t, export.ClashAutoGroup, doc.Groups[0].Proxies[0])
+	assert.Equal(t, "url-test", doc.Groups[1].Type)
+	assert.Len(t, doc.Groups[1].Proxies, len(doc.Proxies))
+	assert.Equal(t, "MATCH,"+export.ClashProxyGroup, doc.Rules[len(doc.Rules)-1])
+
+	// Without any proxies the groups must still be valid.
+	got, err = export.Clash(nil, true)
+	require.NoError(t, err)
+	require.NoError(t, yaml.Unmarshal(got, &doc))
+	assert.Equal(t, []string{"DIRECT"}, doc.Groups[1].Proxies)
+}
@@ -0,0 +1,61 @@
+package export
+
+import (
+	"fmt"
+	"k2ray/internal/db"
+	"k2ray/internal/sharelink"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Format identifies an export format.
+type Format string
+
+const (
+	// FormatBase64 is a base64 share link feed, the common subscription format.
+	FormatBase64 Format = "base64"
+	// FormatClash is a Clash YAML configuration.
+	FormatClash Format = "clash"
+	// FormatClashMeta is a Clash.Meta (mihomo) YAML configuration, which adds VLESS.
+	FormatClashMeta Format = "clash-meta"
+	// FormatSingBox is a sing-box JSON configuration.
+	FormatSingBox Format = "sing-box"
+)
+
+// Options carries format-specific rendering options. Formats ignore the fields
+// that do not apply to them.
+type Options struct {
+	SingBox SingBoxOptions
+}
+
+// Render renders configs in the given format and returns the document together
+// with its content type.
+func Render(format Format, configs []db.Configuration, opts Options) ([]byte, string, error) {
+	switch format {
+	case FormatBase64, "":
+		return Feed(configs), "text/plain; charset=utf-8", nil
+	case FormatClash, FormatClashMeta:
+		body, err := Clash(configs, format == FormatClashMeta)
+		return body, "text/yaml; charset=utf-8", err
+	case FormatSingBox:
+		body, err := SingBox(configs, opts.SingBox)
+		return body, "application/json; charset=utf-8", err
+	default:
+		return nil, "", fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// Feed renders configs as a base64 share link feed, skipping (and logging) any
+// that cannot be expressed as a share link.
+func Feed(configs []db.Configuration) []byte {
+	links := make([]string, 0, len(configs))
+	for _, cfg := range configs {
+		link, err := sharelink.Format(cfg.Name, cfg.Protocol, []byte(cfg.ConfigData))
+		if err != nil {
+			log.Warn().Err(err).Int64("config_id", cfg.ID).Msg("Skipping configuration that cannot be exported")
+			continue
+		}
+		links = append(links, link)
+	}
+	return []byte(sharelink.EncodeFeed(links))
+}
@@ -0,0 +1,106 @@
+package export_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"k2ray/internal/db"
+	"k2ray/internal/export"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+var testConfigs = []db.Configuration{
+	{ID: 1, Name: "VMess WS", Protocol: "vmess", ConfigData: `{"v":"2","add":"vmess.example.com","port":443,"id":"b831381d-6324-4d53-ad4f-8cda48b30811","aid":0,"host":"cdn.example.com","path":"/ws","net":"ws","tls":"tls"}`},
+	{ID: 2, Name: "Trojan", Protocol: "trojan", ConfigData: `{"server":"trojan.example.com","server_port":443,"password":"secret"}`},
+	{ID: 3, Name: "Trojan", Protocol: "trojan", ConfigData: `{"server":"trojan2.example.com","server_port":443,"password":"secret"}`},
+	{ID: 4, Name: "Broken", Protocol: "vmess", ConfigData: `{"add":"broken.example.com"}`},
+}
+
+func TestRenderBase64(t *testing.T) {
+	body, contentType, err := export.Render(export.FormatBase64, testConfigs, export.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", contentType)
+
+	decoded, err := base64.StdEncoding.DecodeString(string(body))
+	require.NoError(t, err)
+	links := strings.Split(string(decoded), "\n")
+	require.Len(t, links, 3, "the broken config is skipped")
+	assert.True(t, strings.HasPrefix(links[0], "vmess://"))
+	assert.True(t, strings.HasPrefix(links[1], "trojan://"))
+}
+
+func TestRenderSingBox(t *testing.T) {
+	opts := export.Options{SingBox: export.SingBoxOptions{OutboundsOnly: true}}
+	body, contentType, err := export.Render(export.FormatSingBox, testConfigs, opts)
+	require.NoError(t, err)
+	assert.Equal(t, "application/json; charset=utf-8", contentType)
+
+	var doc map[string][]map[string]any
+	require.NoError(t, json.Unmarshal(body, &doc))
+	require.Len(t, doc, 1, "only the outbounds are rendered")
+	outbounds := doc["outbounds"]
+	require.Len(t, outbounds, 6)
+	assert.Equal(t, "selector", outbounds[0]["type"])
+	assert.Equal(t, []any{"auto", "VMess WS", "Trojan", "Trojan (2)", "direct"}, outbounds[0]["outbounds"])
+	assert.Equal(t, "urltest", outbounds[1]["type"])
+	assert.Equal(t, "vmess", outbounds[2]["type"])
+	assert.Equal(t, map[string]any{"enabled": true}, outbounds[3]["tls"], "trojan defaults to TLS")
+	assert.Equal(t, "direct", outbounds[5]["type"])
+}
+
+// trojanConfig is an exportable configuration with the given name.
+func trojanConfig(id int64, name string) db.Configuration {
+	return db.Configuration{ID: id, Name: name, Protocol: "trojan", ConfigData: `{"server":"trojan.example.com","server_port":443,"password":"secret"}`}
+}
+
+func TestRenderUniqueNames(t *testing.T) {
+	configs := []db.Configuration{
+		trojanConfig(1, "a"), trojanConfig(2, "a"), trojanConfig(3, "a (2)"),
+		trojanConfig(4, "proxy"), trojanConfig(5, "auto"), trojanConfig(6, "direct"),
+		trojanConfig(7, "PROXY"), trojanConfig(8, "AUTO"), trojanConfig(9, "DIRECT"),
+	}
+
+	t.Run("sing-box", func(t *testing.T) {
+		opts := export.Options{SingBox: export.SingBoxOptions{OutboundsOnly: true}}
+		body, _, err := export.Render(export.FormatSingBox, configs, opts)
+		require.NoError(t, err)
+		var doc struct {
+			Outbounds []struct{ Tag string } `json:"outbounds"`
+		}
+		require.NoError(t, json.Unmarshal(body, &doc))
+		var tags []string
+		for _, o := range doc.Outbounds {
+			tags = append(tags, o.Tag)
+		}
+		assert.Equal(t, []string{
+			"proxy", "auto",
+			"a", "a (2)", "a (2) (2)", "proxy (2)", "auto (2)", "direct (2)", "PROXY", "AUTO", "DIRECT",
+			"direct",
+		}, tags, "proxies take neither each other's names nor the generated tags")
+	})
+
+	t.Run("Clash", func(t *testing.T) {
+		body, _, err := export.Render(export.FormatClash, configs, export.Options{})
+		require.NoError(t, err)
+		var doc struct {
+			Proxies []struct{ Name string } `yaml:"proxies"`
+		}
+		require.NoError(t, yaml.Unmarshal(body, &doc))
+		var names []string
+		for _, p := range doc.Proxies {
+			names = append(names, p.Name)
+		}
+		assert.Equal(t, []string{
+			"a", "a (2)", "a (2) (2)", "proxy", "auto", "direct", "PROXY (2)", "AUTO (2)", "DIRECT (2)",
+		}, names, "proxies take neither each other's names nor the group and policy names")
+	})
+}
+
+func TestRenderUnknownFormat(t *testing.T) {
+	_, _, err := export.Render("xml", testConfigs, export.Options{})
+	assert.Error(t, err)
+}
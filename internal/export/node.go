@@ -0,0 +1,166 @@
+// Package export renders stored configurations in the formats that client apps
+// import: share link feeds, Clash YAML and sing-box JSON.
+package export
+
+import (
+	"fmt"
+	"k2ray/internal/db"
+	"k2ray/internal/protocol"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// node is a configuration flattened into the fields every client format needs.
+type node struct {
+	Name     string
+	Protocol string
+	Server   string
+	Port     int
+
+	UUID     string // vmess, vless
+	AlterID  int    // vmess
+	Flow     string // vless
+	Password string // trojan, shadowsocks
+	Method   string // shadowsocks
+
+	// Plugin and PluginOpts split a SIP002 plugin string such as
+	// "obfs-local;obfs=http" into "obfs-local" and "obfs=http".
+	Plugin     string
+	PluginOpts string
+
+	TLS bool
+	SNI string
+
+	// Reality is set for VLESS REALITY, which also sets TLS and SNI.
+	Reality *protocol.RealitySettings
+
+	Network     string // "tcp", "ws" or "grpc"
+	Path        string // ws
+	Host        string // ws
+	ServiceName string // grpc
+
+	// QUIC-based protocols always set TLS.
+	Insecure          bool
+	ALPN              []string
+	PinSHA256         string // hex, may contain colons
+	Obfs              string // hysteria2
+	ObfsPassword      string // hysteria2
+	UpMbps            int    // hysteria2
+	DownMbps          int    // hysteria2
+	CongestionControl string // tuic
+	UDPRelayMode      string // tuic
+}
+
+// newNode decodes a stored configuration into a node.
+func newNode(cfg db.Configuration) (*node, error) {
+	data, err := protocol.Decode(cfg.Protocol, []byte(cfg.ConfigData))
+	if err != nil {
+		return nil, err
+	}
+
+	n := &node{Name: cfg.Name, Protocol: cfg.Protocol}
+	var transport protocol.TransportSettings
+	switch c := data.(type) {
+	case *protocol.VmessConfigData:
+		if n.Port, err = protocol.ParsePort(c.Port); err != nil {
+			return nil, err
+		}
+		n.Server, n.UUID, n.AlterID = c.Add, c.ID, c.Aid
+		transport = c.TransportSettings
+		// Top-level host/path are the v2rayN spelling of the ws options.
+		n.Host, n.Path, n.SNI = c.Host, c.Path, c.Host
+	case *protocol.VlessConfigData:
+		if n.Port, err = protocol.ParsePort(c.Port); err != nil {
+			return nil, err
+		}
+		n.Server, n.UUID, n.Flow, n.SNI = c.Address, c.ID, c.Flow, c.SNI
+		transport = c.TransportSettings
+		if transport.Security == protocol.SecurityReality {
+			reality := c.RealitySettings
+			if reality.Fingerprint == "" {
+				reality.Fingerprint = "chrome"
+			}
+			n.Reality, n.SNI = &reality, reality.ServerName
+		}
+	case *protocol.TrojanConfigData:
+		n.Server, n.Port, n.Password, n.SNI = c.Server, c.ServerPort, c.Password, c.SNI
+		transport = c.TransportSettings
+		if transport.Security == "" {
+			transport.Security = "tls"
+		}
+	case *protocol.ShadowsocksConfigData:
+		n.Server, n.Port, n.Password, n.Method = c.Server, c.ServerPort, c.Password, c.Method
+		n.Plugin, n.PluginOpts, _ = strings.Cut(c.Plugin, ";")
+		return n, nil
+	case *protocol.Hysteria2ConfigData:
+		n.Server, n.Port, n.Password = c.Server, c.ServerPort, c.Password
+		n.Obfs, n.ObfsPassword = c.Obfs, c.ObfsPassword
+		n.UpMbps, n.DownMbps = c.UpMbps, c.DownMbps
+		n.setQUICTLS(c.QUICTLSSettings)
+		return n, nil
+	case *protocol.TUICConfigData:
+		n.Server, n.Port, n.UUID, n.Password = c.Server, c.ServerPort, c.UUID, c.Password
+		n.CongestionControl, n.UDPRelayMode = c.CongestionControl, c.UDPRelayMode
+		n.setQUICTLS(c.QUICTLSSettings)
+		return n, nil
+	default:
+		return nil, fmt.Errorf("protocol %q cannot be exported", cfg.Protocol)
+	}
+
+	n.TLS = transport.Security == protocol.SecurityTLS || transport.Security == protocol.SecurityReality
+	n.Network = transport.Network
+	if n.Network == "" {
+		n.Network = "tcp"
+	}
+	switch n.Network {
+	case "ws":
+		if transport.WsSettings.Path != "" {
+			n.Path = transport.WsSettings.Path
+		}
+		if host := transport.WsSettings.Headers["Host"]; host != "" {
+			n.Host = host
+		}
+	case "grpc":
+		n.ServiceName = transport.GrpcSettings.ServiceName
+	case "tcp":
+	default:
+		return nil, fmt.Errorf("transport %q cannot be exported", n.Network)
+	}
+	if n.SNI == "" && n.TLS {
+		n.SNI = n.Host
+	}
+	return n, nil
+}
+
+func (n *node) setQUICTLS(t protocol.QUICTLSSettings) {
+	n.TLS, n.SNI, n.Insecure = true, t.SNI, t.Insecure
+	n.ALPN, n.PinSHA256 = t.ALPN, t.PinSHA256
+}
+
+// nodes converts configs, skipping (and logging) any that cannot be exported.
+// Names are made unique because both Clash and sing-box refer to proxies by
+// name: a name already taken by an earlier proxy or by one of the reserved
+// names the exporter uses for its own groups gets the first free " (N)" suffix.
+func nodes(configs []db.Configuration, reserved ...string) []*node {
+	var out []*node
+	used := make(map[string]bool, len(configs)+len(reserved))
+	for _, name := range reserved {
+		used[name] = true
+	}
+	for _, cfg := range configs {
+		n, err := newNode(cfg)
+		if err != nil {
+			log.Warn().Err(err).Int64("config_id", cfg.ID).Msg("Skipping configuration that cannot be exported")
+			continue
+		}
+		name := n.Name
+		for i := 2; used[name]; i++ {
+			name = fmt.Sprintf("%s (%d)", n.Name, i)
+		}
+		n.Name = name
+		used[name] = true
+		out = append(out, n)
+	}
+	return out
+}
@@ -0,0 +1,286 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"k2ray/internal/db"
+)
+
+const (
+	// SingBoxSelectorTag is the tag of the selector outbound that lists every proxy.
+	SingBoxSelectorTag = "proxy"
+	// SingBoxAutoTag is the tag of the urltest outbound that picks the fastest proxy.
+	SingBoxAutoTag = "auto"
+
+	// SingBoxInboundMixed is a local HTTP/SOCKS proxy inbound.
+	SingBoxInboundMixed = "mixed"
+	// SingBoxInboundTun is a TUN inbound that captures all system traffic.
+	SingBoxInboundTun = "tun"
+
+	singBoxMixedPort     = 2080
+	singBoxTunAddress    = "172.19.0.1/30"
+	singBoxRemoteDNS     = "https://1.1.1.1/dns-query"
+	singBoxTestInterval  = "5m"
+	singBoxTestTolerance = 50
+	singBoxDirectTag     = "direct"
+	singBoxRemoteDNSTag  = "remote"
+	singBoxLocalDNSTag   = "local"
+)
+
+// SingBoxOptions controls the shape of the sing-box document.
+type SingBoxOptions struct {
+	// OutboundsOnly renders just the outbounds array, for merging into an
+	// existing sing-box config.
+	OutboundsOnly bool
+	// Inbound is SingBoxInboundMixed (the default) or SingBoxInboundTun.
+	Inbound string
+}
+
+// Validate reports whether the options describe a document SingBox can render.
+func (o SingBoxOptions) Validate() error {
+	switch o.Inbound {
+	case "", SingBoxInboundMixed, SingBoxInboundTun:
+		return nil
+	default:
+		return fmt.Errorf("unknown sing-box inbound %q (expected %s or %s)", o.Inbound, SingBoxInboundMixed, SingBoxInboundTun)
+	}
+}
+
+type singBoxConfig struct {
+	Log       *singBoxLog       `json:"log,omitempty"`
+	DNS       *singBoxDNS       `json:"dns,omitempty"`
+	Inbounds  []singBoxInbound  `json:"inbounds,omitempty"`
+	Outbounds []singBoxOutbound `json:"outbounds"`
+	Route     *singBoxRoute     `json:"route,omitempty"`
+}
+
+type singBoxLog struct {
+	Level string `json:"level"`
+}
+
+type singBoxDNS struct {
+	Servers []singBoxDNSServer `json:"servers"`
+	Rules   []singBoxDNSRule   `json:"rules"`
+	Final   string             `json:"final"`
+}
+
+type singBoxDNSServer struct {
+	Tag     string `json:"tag"`
+	Address string `json:"address"`
+	Detour  string `json:"detour,omitempty"`
+}
+
+type singBoxDNSRule struct {
+	Outbound string `json:"outbound"`
+	Server   string `json:"server"`
+}
+
+type singBoxInbound struct {
+	Type        string   `json:"type"`
+	Tag         string   `json:"tag"`
+	Listen      string   `json:"listen,omitempty"`      // mixed
+	ListenPort  int      `json:"listen_port,omitempty"` // mixed
+	Address     []string `json:"address,omitempty"`     // tun
+	AutoRoute   bool     `json:"auto_route,omitempty"`  // tun
+	StrictRoute bool     `json:"strict_route,omitempty"`
+	Stack       string   `json:"stack,omitempty"`
+}
+
+type singBoxRoute struct {
+	Rules               []singBoxRouteRule `json:"rules"`
+	Final               string             `json:"final"`
+	AutoDetectInterface bool               `json:"auto_detect_interface"`
+}
+
+type singBoxRouteRule struct {
+	Protocol    string `json:"protocol,omitempty"`
+	IPIsPrivate bool   `json:"ip_is_private,omitempty"`
+	Action      string `json:"action,omitempty"`
+	Outbound    string `json:"outbound,omitempty"`
+}
+
+type singBoxOutbound struct {
+	Type       string            `json:"type"`
+	Tag        string            `json:"tag"`
+	Server     string            `json:"server,omitempty"`
+	ServerPort int               `json:"server_port,omitempty"`
+	UUID       string            `json:"uuid,omitempty"`
+	Security   string            `json:"security,omitempty"`
+	AlterID    int               `json:"alter_id,omitempty"`
+	Flow       string            `json:"flow,omitempty"`
+	Method     string            `json:"method,omitempty"`
+	Password   string            `json:"password,omitempty"`
+	Plugin     string            `json:"plugin,omitempty"`
+	PluginOpts string            `json:"plugin_opts,omitempty"`
+	TLS        *singBoxTLS       `json:"tls,omitempty"`
+	Transport  *singBoxTransport `json:"transport,omitempty"`
+
+	// hysteria2 and tuic
+	UpMbps            int          `json:"up_mbps,omitempty"`
+	DownMbps          int          `json:"down_mbps,omitempty"`
+	Obfs              *singBoxObfs `json:"obfs,omitempty"`
+	CongestionControl string       `json:"congestion_control,omitempty"`
+	UDPRelayMode      string       `json:"udp_relay_mode,omitempty"`
+
+	// selector and urltest
+	Outbounds []string `json:"outbounds,omitempty"`
+	Default   string   `json:"default,omitempty"`
+	URL       string   `json:"url,omitempty"`
+	Interval  string   `json:"interval,omitempty"`
+	Tolerance int      `json:"tolerance,omitempty"`
+}
+
+type singBoxObfs struct {
+	Type     string `json:"type"`
+	Password string `json:"password"`
+}
+
+type singBoxTLS struct {
+	Enabled    bool            `json:"enabled"`
+	ServerName string          `json:"server_name,omitempty"`
+	Insecure   bool            `json:"insecure,omitempty"`
+	ALPN       []string        `json:"alpn,omitempty"`
+	UTLS       *singBoxUTLS    `json:"utls,omitempty"`
+	Reality    *singBoxReality `json:"reality,omitempty"`
+}
+
+type singBoxUTLS struct {
+	Enabled     bool   `json:"enabled"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type singBoxReality struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key"`
+	ShortID   string `json:"short_id,omitempty"`
+}
+
+type singBoxTransport struct {
+	Type        string            `json:"type"`
+	Path        string            `json:"path,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ServiceName string            `json:"service_name,omitempty"`
+}
+
+// SingBox renders configs as a sing-box config. The proxies sit behind a
+// selector whose first choice is a urltest group; the full document adds an
+// inbound, DNS that resolves remotely through the selector, and a route that
+// keeps private addresses direct.
+func SingBox(configs []db.Configuration, opts SingBoxOptions) ([]byte, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	var proxies []singBoxOutbound
+	for _, n := range nodes(configs, SingBoxSelectorTag, SingBoxAutoTag, singBoxDirectTag) {
+		proxies = append(proxies, newSingBoxOutbound(n))
+		names = append(names, n.Name)
+	}
+
+	selector := singBoxOutbound{
+		Type:      "selector",
+		Tag:       SingBoxSelectorTag,
+		Outbounds: append(append([]string{SingBoxAutoTag}, names...), singBoxDirectTag),
+		Default:   SingBoxAutoTag,
+	}
+	// urltest, like Clash's url-test, refuses to start without members.
+	members := names
+	if len(members) == 0 {
+		members = []string{singBoxDirectTag}
+	}
+	auto := singBoxOutbound{
+		Type:      "urltest",
+		Tag:       SingBoxAutoTag,
+		Outbounds: members,
+		URL:       clashTestURL,
+		Interval:  singBoxTestInterval,
+		Tolerance: singBoxTestTolerance,
+	}
+	outbounds := append([]singBoxOutbound{selector, auto}, proxies...)
+	outbounds = append(outbounds, singBoxOutbound{Type: "direct", Tag: singBoxDirectTag})
+
+	doc := singBoxConfig{Outbounds: outbounds}
+	if !opts.OutboundsOnly {
+		doc.Log = &singBoxLog{Level: "info"}
+		doc.DNS = &singBoxDNS{
+			Servers: []singBoxDNSServer{
+				{Tag: singBoxRemoteDNSTag, Address: singBoxRemoteDNS, Detour: SingBoxSelectorTag},
+				{Tag: singBoxLocalDNSTag, Address: "local"},
+			},
+			// Proxy server names must resolve without going through a proxy.
+			Rules: []singBoxDNSRule{{Outbound: "any", Server: singBoxLocalDNSTag}},
+			Final: singBoxRemoteDNSTag,
+		}
+		doc.Inbounds = []singBoxInbound{newSingBoxInbound(opts.Inbound)}
+		doc.Route = &singBoxRoute{
+			Rules: []singBoxRouteRule{
+				{Action: "sniff"},
+				{Protocol: "dns", Action: "hijack-dns"},
+				{IPIsPrivate: true, Outbound: singBoxDirectTag},
+			},
+			Final:               SingBoxSelectorTag,
+			AutoDetectInterface: true,
+		}
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func newSingBoxInbound(kind string) singBoxInbound {
+	if kind == SingBoxInboundTun {
+		return singBoxInbound{
+			Type:        "tun",
+			Tag:         "tun-in",
+			Address:     []string{singBoxTunAddress},
+			AutoRoute:   true,
+			StrictRoute: true,
+			Stack:       "system",
+		}
+	}
+	return singBoxInbound{Type: "mixed", Tag: "mixed-in", Listen: "127.0.0.1", ListenPort: singBoxMixedPort}
+}
+
+func newSingBoxOutbound(n *node) singBoxOutbound {
+	o := singBoxOutbound{Type: n.Protocol, Tag: n.Name, Server: n.Server, ServerPort: n.Port}
+
+	switch n.Protocol {
+	case "vmess":
+		o.UUID, o.Security, o.AlterID = n.UUID, "auto", n.AlterID
+	case "vless":
+		o.UUID, o.Flow = n.UUID, n.Flow
+	case "trojan":
+		o.Password = n.Password
+	case "shadowsocks":
+		o.Method, o.Password = n.Method, n.Password
+		o.Plugin, o.PluginOpts = n.Plugin, n.PluginOpts
+		return o
+	case "hysteria2":
+		o.Password, o.UpMbps, o.DownMbps = n.Password, n.UpMbps, n.DownMbps
+		if n.Obfs != "" {
+			o.Obfs = &singBoxObfs{Type: n.Obfs, Password: n.ObfsPassword}
+		}
+	case "tuic":
+		o.UUID, o.Password = n.UUID, n.Password
+		o.CongestionControl, o.UDPRelayMode = n.CongestionControl, n.UDPRelayMode
+	}
+
+	if n.TLS {
+		// sing-box cannot pin certificates by hash, so PinSHA256 is dropped.
+		o.TLS = &singBoxTLS{Enabled: true, ServerName: n.SNI, Insecure: n.Insecure, ALPN: n.ALPN}
+		if n.Reality != nil {
+			// sing-box requires uTLS for REALITY.
+			o.TLS.UTLS = &singBoxUTLS{Enabled: true, Fingerprint: n.Reality.Fingerprint}
+			o.TLS.Reality = &singBoxReality{Enabled: true, PublicKey: n.Reality.PublicKey, ShortID: n.Reality.ShortID}
+		}
+	}
+	switch n.Network {
+	case "ws":
+		o.Transport = &singBoxTransport{Type: "ws", Path: n.Path}
+		if n.Host != "" {
+			o.Transport.Headers = map[string]string{"Host": n.Host}
+		}
+	case "grpc":
+		o.Transport = &singBoxTransport{Type: "grpc", ServiceName: n.ServiceName}
+	}
+	return o
+}
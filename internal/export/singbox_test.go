@@ -0,0 +1,87 @@
+package export_test
+
+import (
+	"encoding/json"
+	"k2ray/internal/export"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSingBoxGolden(t *testing.T) {
+	for _, tc := range []struct {
+		golden string
+		opts   export.SingBoxOptions
+	}{
+		{"sing-box.golden.json", export.SingBoxOptions{}},
+		{"sing-box-tun.golden.json", export.SingBoxOptions{Inbound: export.SingBoxInboundTun}},
+		{"sing-box-outbounds.golden.json", export.SingBoxOptions{OutboundsOnly: true}},
+	} {
+		t.Run(tc.golden, func(t *testing.T) {
+			got, err := export.SingBox(goldenConfigs, tc.opts)
+			require.NoError(t, err)
+			checkGolden(t, tc.golden, got)
+
+			var doc map[string]any
+			require.NoError(t, json.Unmarshal(got, &doc), "output must be valid JSON")
+		})
+	}
+}
+
+func TestSingBoxGroups(t *testing.T) {
+	got, err := export.SingBox(goldenConfigs, export.SingBoxOptions{})
+	require.NoError(t, err)
+
+	var doc struct {
+		Outbounds []struct {
+			Type      string   `json:"type"`
+			Tag       string   `json:"tag"`
+			Outbounds []string `json:"outbounds"`
+			Default   string   `json:"default"`
+		} `json:"outbounds"`
+		Route struct {
+			Final string `json:"final"`
+		} `json:"route"`
+		DNS struct {
+			Servers []struct {
+				Tag    string `json:"tag"`
+				Detour string `json:"detour"`
+			} `json:"servers"`
+		} `json:"dns"`
+	}
+	require.NoError(t, json.Unmarshal(got, &doc))
+
+	tags := make(map[string]bool)
+	for _, o := range doc.Outbounds {
+		tags[o.Tag] = true
+	}
+	for _, o := range doc.Outbounds {
+		for _, member := range o.Outbounds {
+			assert.True(t, tags[member], "%s references unknown outbound %q", o.Tag, member)
+		}
+	}
+
+	selector, auto := doc.Outbounds[0], doc.Outbounds[1]
+	assert.Equal(t, "selector", selector.Type)
+	assert.Equal(t, export.SingBoxAutoTag, selector.Default)
+	assert.Equal(t, "urltest", auto.Type)
+	assert.Len(t, auto.Outbounds, len(goldenConfigs))
+	assert.Equal(t, export.SingBoxSelectorTag, doc.Route.Final)
+	for _, server := range doc.DNS.Servers {
+		assert.True(t, server.Detour == "" || tags[server.Detour], "DNS server %s detours through unknown outbound %q", server.Tag, server.Detour)
+	}
+
+	empty, err := export.SingBox(nil, export.SingBoxOptions{OutboundsOnly: true})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"outbounds": [
+		{"type": "selector", "tag": "proxy", "outbounds": ["auto", "direct"], "default": "auto"},
+		{"type": "urltest", "tag": "auto", "outbounds": ["direct"], "url": "http://www.gstatic.com/generate_204", "interval": "5m", "tolerance": 50},
+		{"type": "direct", "tag": "direct"}
+	]}`, string(empty))
+}
+
+func TestSingBoxInvalidInbound(t *testing.T) {
+	_, err := export.SingBox(goldenConfigs, export.SingBoxOptions{Inbound: "socks"})
+	assert.Error(t, err)
+}
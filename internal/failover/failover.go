@@ -0,0 +1,279 @@
+// Package failover health-checks the active configuration and replaces it with
+// a healthy fallback when its server stops responding.
+package failover
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"k2ray/internal/db"
+	"k2ray/internal/probe"
+	"k2ray/internal/security"
+	"k2ray/internal/v2ray"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// TickInterval is how often the scheduler looks whether a health check is due.
+	TickInterval = 10 * time.Second
+
+	// Client is the HTTP client used to call the notification webhook.
+	Client = &http.Client{Timeout: 10 * time.Second}
+
+	// ErrNoActiveConfig is returned by Check when no single configuration is
+	// active. Balancers skip members that are down on their own.
+	ErrNoActiveConfig = errors.New("no configuration is active")
+
+	checkMu sync.Mutex
+)
+
+// Result describes the outcome of a health check.
+type Result struct {
+	ConfigID            int64              `json:"config_id"` // The configuration that was checked
+	Probe               db.ProbeResult     `json:"probe"`
+	ConsecutiveFailures int                `json:"consecutive_failures"`
+	SwitchedTo          *int64             `json:"switched_to,omitempty"`
+	Reload              v2ray.ReloadResult `json:"reload,omitempty"`
+	Message             string             `json:"message,omitempty"` // Why no switch happened despite failures
+}
+
+// Notification is the JSON body posted to the policy's notify_url on a switch.
+type Notification struct {
+	Event      string    `json:"event"` // Always "failover"
+	FromID     int64     `json:"from_config_id"`
+	FromName   string    `json:"from_config_name"`
+	ToID       int64     `json:"to_config_id"`
+	ToName     string    `json:"to_config_name"`
+	Reason     string    `json:"reason"` // Error of the last failed health check
+	SwitchedAt time.Time `json:"switched_at"`
+}
+
+// StartScheduler runs the health checks of an enabled policy in the background
+// until ctx is cancelled.
+func StartScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(TickInterval)
+		defer ticker.Stop()
+
+		for {
+			CheckDue(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// CheckDue runs a health check if the policy is enabled and its check
+// interval has elapsed at now. Failures are logged.
+func CheckDue(ctx context.Context, now time.Time) {
+	policy, err := db.GetFailoverPolicy()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load failover policy")
+		return
+	}
+	if !policy.Enabled {
+		return
+	}
+	if policy.LastCheckedAt != nil && now.Before(policy.LastCheckedAt.Add(time.Duration(policy.CheckInterval)*time.Second)) {
+		return
+	}
+	if _, err := Check(ctx, now); err != nil && !errors.Is(err, ErrNoActiveConfig) {
+		log.Error().Err(err).Msg("Failover health check failed")
+	}
+}
+
+// Check probes the active configuration. Once FailureThreshold consecutive
+// checks have failed, the candidates are probed and the active configuration
+// is switched to the fastest healthy one, V2Ray is reloaded, and the switch
+// is audited and notified. No switch happens within Hysteresis seconds of the
+// previous one, so that two bad servers cannot be flapped between.
+func Check(ctx context.Context, now time.Time) (*Result, error) {
+	checkMu.Lock()
+	defer checkMu.Unlock()
+
+	policy, err := db.GetFailoverPolicy()
+	if err != nil {
+		return nil, err
+	}
+	sel, ok, err := v2ray.ActiveSelection()
+	if err != nil {
+		return nil, err
+	}
+	if !ok || sel.Key != v2ray.ActiveConfigKey {
+		return nil, ErrNoActiveConfig
+	}
+	activeID := sel.ID
+	active, err := loadConfigs([]int64{activeID})
+	if err != nil {
+		return nil, err
+	}
+	if len(active) == 0 {
+		return nil, ErrNoActiveConfig
+	}
+
+	result := &Result{ConfigID: activeID}
+	result.Probe = probe.Configs(ctx, active, policy.CheckHTTP)[0]
+	if result.Probe.Status == probe.StatusOK {
+		return result, db.RecordFailoverCheck(ctx, 0, now, nil)
+	}
+
+	result.ConsecutiveFailures = policy.ConsecutiveFailures + 1
+	switch {
+	case result.ConsecutiveFailures < policy.FailureThreshold:
+	case policy.LastSwitchedAt != nil && now.Before(policy.LastSwitchedAt.Add(time.Duration(policy.Hysteresis)*time.Second)):
+		result.Message = "The previous switch is too recent"
+	default:
+		if err := switchActive(ctx, policy, active[0], result, now); err != nil {
+			return nil, err
+		}
+	}
+
+	var switchedAt *time.Time
+	if result.SwitchedTo != nil {
+		switchedAt = &now
+		result.ConsecutiveFailures = 0
+	}
+	return result, db.RecordFailoverCheck(ctx, result.ConsecutiveFailures, now, switchedAt)
+}
+
+// switchActive activates the fastest healthy candidate that V2Ray accepts,
+// recording the outcome in result.
+func switchActive(ctx context.Context, policy *db.FailoverPolicy, from db.Configuration, result *Result, now time.Time) error {
+	candidateIDs := slices.DeleteFunc(slices.Clone(policy.Candidates), func(id int64) bool { return id == from.ID })
+	candidates, err := loadConfigs(candidateIDs)
+	if err != nil {
+		return err
+	}
+
+	probes := probe.Configs(ctx, candidates, policy.CheckHTTP)
+	healthy := []int{}
+	for i, p := range probes {
+		if p.Status == probe.StatusOK {
+			healthy = append(healthy, i)
+		}
+	}
+	// Stable, so that equally fast candidates are tried in the order of preference.
+	slices.SortStableFunc(healthy, func(a, b int) int { return int(latency(probes[a]) - latency(probes[b])) })
+
+	current := v2ray.Selection{Key: v2ray.ActiveConfigKey, ID: from.ID}
+	for _, i := range healthy {
+		to := candidates[i]
+		reload, err := v2ray.Switch(current, v2ray.Selection{Key: v2ray.ActiveConfigKey, ID: to.ID})
+		var invalid *v2ray.InvalidConfigError
+		switch {
+		case errors.Is(err, v2ray.ErrSelectionChanged):
+			result.Message = "The active configuration was changed during the check"
+			return nil
+		case errors.As(err, &invalid) || (err != nil && reload != ""):
+			// Switch has restored the failing configuration; try the next candidate.
+			log.Warn().Err(err).Int64("config_id", to.ID).Msg("Failover candidate was rejected by V2Ray")
+			continue
+		case err != nil:
+			return err
+		}
+
+		result.SwitchedTo, result.Reload = &to.ID, reload
+		details := fmt.Sprintf("Failover switched the active configuration from '%s' (ID %d) to '%s' (ID %d) after %d failed health checks: %s",
+			from.Name, from.ID, to.Name, to.ID, result.ConsecutiveFailures, result.Probe.Error)
+		security.LogEvent(nil, security.FailoverSwitched, to.ID, details)
+		notify(ctx, policy.NotifyURL, Notification{
+			Event:      "failover",
+			FromID:     from.ID,
+			FromName:   from.Name,
+			ToID:       to.ID,
+			ToName:     to.Name,
+			Reason:     result.Probe.Error,
+			SwitchedAt: now.UTC(),
+		})
+		return nil
+	}
+
+	result.Message = "No healthy candidate is available"
+	log.Warn().Int64("config_id", from.ID).Int("failures", result.ConsecutiveFailures).Msg("Active configuration is failing and no failover candidate is healthy")
+	return nil
+}
+
+// latency is the time a probe took to reach the server.
+func latency(p db.ProbeResult) int64 {
+	var total int64
+	for _, ms := range []*int64{p.TCPConnectMs, p.TLSHandshakeMs, p.HTTPMs} {
+		if ms != nil {
+			total += *ms
+		}
+	}
+	return total
+}
+
+// notify posts n to url, if set. Failures are logged.
+func notify(ctx context.Context, url string, n Notification) {
+	if url == "" {
+		return
+	}
+	body, _ := json.Marshal(n) // Notification always marshals.
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid failover notification URL")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "k2ray")
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to send failover notification")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Error().Int("status", resp.StatusCode).Msg("Failover notification was not accepted")
+	}
+}
+
+// loadConfigs returns the configurations with the given IDs, in that order.
+// IDs that do not exist are left out.
+func loadConfigs(ids []int64) ([]db.Configuration, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	querySQL := `SELECT id, name, protocol, config_data FROM configurations WHERE id IN (?` + strings.Repeat(",?", len(ids)-1) + `)`
+	rows, err := db.DB.Query(querySQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[int64]db.Configuration, len(ids))
+	for rows.Next() {
+		var c db.Configuration
+		if err := rows.Scan(&c.ID, &c.Name, &c.Protocol, &c.ConfigData); err != nil {
+			return nil, err
+		}
+		byID[c.ID] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	configs := make([]db.Configuration, 0, len(byID))
+	for _, id := range ids {
+		if c, ok := byID[id]; ok {
+			configs = append(configs, c)
+		}
+	}
+	return configs, nil
+}
@@ -0,0 +1,257 @@
+package failover_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"k2ray/internal/config"
+	"k2ray/internal/db"
+	"k2ray/internal/failover"
+	"k2ray/internal/probe"
+	"k2ray/internal/utils"
+	"k2ray/internal/v2ray"
+	"k2ray/internal/v2ray/v2raytest"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	tmpfile, err := os.CreateTemp("", "test_failover_*.db")
+	if err != nil {
+		log.Fatalf("Failed to create temp db file: %v", err)
+	}
+	dbPath := tmpfile.Name()
+	tmpfile.Close()
+
+	binDir, err := os.MkdirTemp("", "test_failover_bin_*")
+	if err != nil {
+		log.Fatalf("Failed to create temp bin dir: %v", err)
+	}
+	fakeBinary, err := v2raytest.WriteFakeBinary(binDir)
+	if err != nil {
+		log.Fatalf("Failed to write fake v2ray binary: %v", err)
+	}
+
+	config.LoadConfig("")
+	config.AppConfig.DatabaseURL = dbPath
+	config.AppConfig.V2RayExecutable = fakeBinary
+	db.InitDB()
+	probe.Timeout = 2 * time.Second
+
+	code := m.Run()
+
+	db.DB.Close()
+	os.Remove(dbPath)
+	os.RemoveAll(binDir)
+	os.Exit(code)
+}
+
+// server accepts connections until it is closed.
+func server(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return l
+}
+
+func createConfig(t *testing.T, userID int64, name string, addr net.Addr) int64 {
+	return createConfigWithUser(t, userID, name, addr, "")
+}
+
+// createConfigWithUser creates a SOCKS configuration that logs in as username,
+// which makes the fake V2Ray reject it if it contains "fake-invalid".
+func createConfigWithUser(t *testing.T, userID int64, name string, addr net.Addr, username string) int64 {
+	data := fmt.Sprintf(`{"server":"127.0.0.1","server_port":%d,"username":%q,"password":"secret"}`, addr.(*net.TCPAddr).Port, username)
+	if username == "" {
+		data = fmt.Sprintf(`{"server":"127.0.0.1","server_port":%d}`, addr.(*net.TCPAddr).Port)
+	}
+	res, err := db.DB.Exec(`INSERT INTO configurations (user_id, name, protocol, config_data) VALUES (?, ?, 'socks', ?)`, userID, name, data)
+	require.NoError(t, err)
+	id, _ := res.LastInsertId()
+	return id
+}
+
+func activeConfigID(t *testing.T) int64 {
+	var id int64
+	require.NoError(t, db.DB.QueryRow(`SELECT value FROM settings WHERE key = ?`, v2ray.ActiveConfigKey).Scan(&id))
+	return id
+}
+
+func TestFailover(t *testing.T) {
+	ctx := context.Background()
+	hashedPassword, _ := utils.HashPassword("password")
+	res, err := db.DB.Exec(`INSERT INTO users (username, password_hash) VALUES (?, ?)`, t.Name(), hashedPassword)
+	require.NoError(t, err)
+	userID, _ := res.LastInsertId()
+
+	var mu sync.Mutex
+	var notifications []failover.Notification
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n failover.Notification
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&n))
+		mu.Lock()
+		notifications = append(notifications, n)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+	sent := func() []failover.Notification {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]failover.Notification(nil), notifications...)
+	}
+
+	primaryServer := server(t)
+	primary := createConfig(t, userID, "Primary", primaryServer.Addr())
+	deadServer := server(t)
+	dead := createConfig(t, userID, "Dead", deadServer.Addr())
+	deadServer.Close()
+	secondServer := server(t)
+	second := createConfig(t, userID, "Second", secondServer.Addr())
+	policy := db.FailoverPolicy{
+		Enabled: true, CheckInterval: 60, FailureThreshold: 2, Hysteresis: 3600,
+		NotifyURL: webhook.URL, Candidates: []int64{primary, dead, second},
+	}
+	require.NoError(t, db.UpdateFailoverPolicy(ctx, policy))
+	_, err = db.DB.Exec(`INSERT INTO settings (key, value) VALUES (?, ?)`, v2ray.ActiveConfigKey, primary)
+	require.NoError(t, err)
+
+	now := time.Now()
+	result, err := failover.Check(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, probe.StatusOK, result.Probe.Status)
+	assert.Zero(t, result.ConsecutiveFailures)
+
+	// The first failure is tolerated, the second one switches to the first
+	// healthy candidate.
+	primaryServer.Close()
+	result, err = failover.Check(ctx, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, probe.StatusUnreachable, result.Probe.Status)
+	assert.Equal(t, 1, result.ConsecutiveFailures)
+	assert.Nil(t, result.SwitchedTo)
+	assert.Equal(t, primary, activeConfigID(t))
+
+	result, err = failover.Check(ctx, now.Add(2*time.Minute))
+	require.NoError(t, err)
+	require.NotNil(t, result.SwitchedTo, result.Message)
+	assert.Equal(t, second, *result.SwitchedTo)
+	assert.Equal(t, v2ray.ReloadNotRunning, result.Reload)
+	assert.Equal(t, second, activeConfigID(t))
+	require.Len(t, sent(), 1)
+	assert.Equal(t, primary, sent()[0].FromID)
+	assert.Equal(t, "Second", sent()[0].ToName)
+	assert.NotEmpty(t, sent()[0].Reason)
+
+	state, err := db.GetFailoverPolicy()
+	require.NoError(t, err)
+	assert.Zero(t, state.ConsecutiveFailures)
+	require.NotNil(t, state.LastSwitchedAt)
+
+	// Within the hysteresis window, failures are counted but not acted on.
+	third := createConfig(t, userID, "Third", server(t).Addr())
+	policy.Candidates = append(policy.Candidates, third)
+	require.NoError(t, db.UpdateFailoverPolicy(ctx, policy))
+	secondServer.Close()
+	for i := 1; i <= 3; i++ {
+		result, err = failover.Check(ctx, now.Add(time.Duration(2+i)*time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, i, result.ConsecutiveFailures)
+		assert.Nil(t, result.SwitchedTo)
+	}
+	assert.Equal(t, second, activeConfigID(t))
+
+	result, err = failover.Check(ctx, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, result.SwitchedTo, result.Message)
+	assert.Equal(t, third, *result.SwitchedTo)
+	assert.Len(t, sent(), 2)
+
+	// Without a healthy candidate, the active configuration is kept.
+	_, err = db.DB.Exec(`UPDATE settings SET value = ? WHERE key = ?`, dead, v2ray.ActiveConfigKey)
+	require.NoError(t, err)
+	require.NoError(t, db.UpdateFailoverPolicy(ctx, db.FailoverPolicy{
+		Enabled: true, CheckInterval: 60, FailureThreshold: 1, Hysteresis: 0, Candidates: []int64{primary, second},
+	}))
+	result, err = failover.Check(ctx, now.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Nil(t, result.SwitchedTo)
+	assert.NotEmpty(t, result.Message)
+	assert.Equal(t, dead, activeConfigID(t))
+
+	// Candidates that fail validation are skipped, even while V2Ray is stopped.
+	rejected := createConfigWithUser(t, userID, "Rejected", server(t).Addr(), "fake-invalid")
+	fallback := createConfig(t, userID, "Fallback", server(t).Addr())
+	require.NoError(t, db.UpdateFailoverPolicy(ctx, db.FailoverPolicy{
+		Enabled: true, CheckInterval: 60, FailureThreshold: 1, Hysteresis: 0, Candidates: []int64{rejected, fallback},
+	}))
+	result, err = failover.Check(ctx, now.Add(4*time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, result.SwitchedTo, result.Message)
+	assert.Equal(t, fallback, *result.SwitchedTo)
+	assert.Equal(t, fallback, activeConfigID(t))
+
+	// Balancers are not failed over.
+	_, err = db.DB.Exec(`INSERT INTO settings (key, value) VALUES (?, '1')`, v2ray.ActiveBalancerKey)
+	require.NoError(t, err)
+	defer db.DB.Exec(`DELETE FROM settings WHERE key = ?`, v2ray.ActiveBalancerKey)
+	_, err = failover.Check(ctx, now.Add(5*time.Hour))
+	assert.ErrorIs(t, err, failover.ErrNoActiveConfig)
+}
+
+func TestCheckDue(t *testing.T) {
+	ctx := context.Background()
+	t.Cleanup(func() { db.DB.Exec(`DELETE FROM settings WHERE key = ?`, v2ray.ActiveConfigKey) })
+	hashedPassword, _ := utils.HashPassword("password")
+	res, err := db.DB.Exec(`INSERT INTO users (username, password_hash) VALUES (?, ?)`, t.Name(), hashedPassword)
+	require.NoError(t, err)
+	userID, _ := res.LastInsertId()
+
+	active := createConfig(t, userID, "Checked", server(t).Addr())
+	_, err = db.DB.Exec(`INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`, v2ray.ActiveConfigKey, active)
+	require.NoError(t, err)
+	_, err = db.DB.Exec(`UPDATE failover_policy SET last_checked_at = NULL`)
+	require.NoError(t, err)
+
+	lastChecked := func() *time.Time {
+		policy, err := db.GetFailoverPolicy()
+		require.NoError(t, err)
+		return policy.LastCheckedAt
+	}
+
+	require.NoError(t, db.UpdateFailoverPolicy(ctx, db.FailoverPolicy{CheckInterval: 60, FailureThreshold: 3}))
+	now := time.Now()
+	failover.CheckDue(ctx, now)
+	assert.Nil(t, lastChecked(), "disabled policies are not checked")
+
+	require.NoError(t, db.UpdateFailoverPolicy(ctx, db.FailoverPolicy{Enabled: true, CheckInterval: 60, FailureThreshold: 3}))
+	failover.CheckDue(ctx, now)
+	require.NotNil(t, lastChecked())
+	assert.WithinDuration(t, now, *lastChecked(), time.Second)
+
+	failover.CheckDue(ctx, now.Add(30*time.Second))
+	assert.WithinDuration(t, now, *lastChecked(), time.Second, "not due before the interval elapses")
+	failover.CheckDue(ctx, now.Add(time.Minute))
+	assert.WithinDuration(t, now.Add(time.Minute), *lastChecked(), time.Second)
+
+	history, err := db.ListProbeResults(active, 10)
+	require.NoError(t, err)
+	assert.Len(t, history, 2, "health checks are recorded as probes")
+}
@@ -0,0 +1,116 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Balancer strategies.
+const (
+	StrategyRandom     = "random"
+	StrategyLeastPing  = "leastPing"
+	StrategyRoundRobin = "roundRobin"
+)
+
+// Strategies lists the accepted balancer strategies.
+var Strategies = []string{StrategyRandom, StrategyLeastPing, StrategyRoundRobin}
+
+// MemberTagPrefix starts the tag of every member outbound of a balanced
+// document; the members are numbered from 1 in order.
+const MemberTagPrefix = "proxy-"
+
+// Observatory defaults used when BalancerOptions leaves them empty.
+const (
+	DefaultProbeURL      = "https://www.gstatic.com/generate_204"
+	DefaultProbeInterval = "1m"
+)
+
+// Member is a stored configuration taking part in a balancer.
+type Member struct {
+	Name       string // Used in error messages
+	Protocol   string
+	ConfigData []byte
+}
+
+// BalancerOptions controls how a balanced document spreads traffic across its members.
+type BalancerOptions struct {
+	Strategy      string
+	ProbeURL      string
+	ProbeInterval string // Duration between probes, e.g. "30s" or "1m"
+}
+
+// Balancer is a single entry of the "balancers" list of the "routing" section.
+type Balancer struct {
+	Tag         string           `json:"tag"`
+	Selector    []string         `json:"selector"`
+	Strategy    BalancerStrategy `json:"strategy"`
+	FallbackTag string           `json:"fallbackTag,omitempty"`
+}
+
+// BalancerStrategy is the "strategy" object of a balancer.
+type BalancerStrategy struct {
+	Type string `json:"type"`
+}
+
+// Observatory is the "observatory" section, which probes the member outbounds
+// so that the balancer can skip the ones that are down.
+type Observatory struct {
+	SubjectSelector []string `json:"subjectSelector"`
+	ProbeURL        string   `json:"probeURL"`
+	ProbeInterval   string   `json:"probeInterval"`
+}
+
+// BuildBalanced assembles a document with one proxy outbound per member, tagged
+// MemberTagPrefix followed by its number, and a balancer tagged TagProxy over them.
+// Rules sending traffic to TagProxy go through the balancer, as does all traffic
+// no rule matches. If every member is down, the first one is used.
+func BuildBalanced(members []Member, balancer BalancerOptions, opts Options) (*Config, error) {
+	if len(members) == 0 {
+		return nil, errors.New("a balancer needs at least one member")
+	}
+
+	outbounds := make([]Outbound, 0, len(members)+2)
+	for i, member := range members {
+		outbound, err := NewOutbound(fmt.Sprintf("%s%d", MemberTagPrefix, i+1), member.Protocol, member.ConfigData)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", member.Name, err)
+		}
+		outbounds = append(outbounds, outbound)
+	}
+	outbounds = append(outbounds, Outbound{Tag: TagDirect, Protocol: "freedom"}, Outbound{Tag: TagBlock, Protocol: "blackhole"})
+
+	routing := buildRouting(opts.Rules)
+	for i := range routing.Rules {
+		if routing.Rules[i].OutboundTag == TagProxy {
+			routing.Rules[i].OutboundTag = ""
+			routing.Rules[i].BalancerTag = TagProxy
+		}
+	}
+	routing.Rules = append(routing.Rules, RoutingRule{Type: "field", Network: "tcp,udp", BalancerTag: TagProxy})
+	routing.Balancers = []Balancer{{
+		Tag:         TagProxy,
+		Selector:    []string{MemberTagPrefix},
+		Strategy:    BalancerStrategy{Type: balancer.Strategy},
+		FallbackTag: MemberTagPrefix + "1",
+	}}
+
+	observatory := &Observatory{
+		SubjectSelector: []string{MemberTagPrefix},
+		ProbeURL:        balancer.ProbeURL,
+		ProbeInterval:   balancer.ProbeInterval,
+	}
+	if observatory.ProbeURL == "" {
+		observatory.ProbeURL = DefaultProbeURL
+	}
+	if observatory.ProbeInterval == "" {
+		observatory.ProbeInterval = DefaultProbeInterval
+	}
+
+	return &Config{
+		Log:         LogConfig{LogLevel: opts.LogLevel},
+		Inbounds:    defaultInbounds(opts),
+		Outbounds:   outbounds,
+		Routing:     routing,
+		Observatory: observatory,
+	}, nil
+}
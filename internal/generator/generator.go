@@ -0,0 +1,224 @@
+// Package generator turns stored configurations into complete v2ray-core JSON documents.
+package generator
+
+import (
+	"encoding/json"
+)
+
+// Well-known outbound tags used in generated documents.
+const (
+	TagProxy  = "proxy"
+	TagDirect = "direct"
+	TagBlock  = "block"
+)
+
+// Inbound tags used in generated documents.
+const (
+	TagSocksIn    = "socks-in"
+	TagHTTPIn     = "http-in"
+	TagDokodemoIn = "dokodemo-in"
+)
+
+// Options controls the parts of the document that do not come from the stored configuration.
+type Options struct {
+	LogLevel      string
+	ListenAddress string
+	SocksPort     int
+	HTTPPort      int
+	DokodemoPort  int
+	// Rules are user-defined routing rules, applied ahead of the default rules.
+	Rules []RoutingRule
+}
+
+// DefaultOptions are used by Generate.
+var DefaultOptions = Options{
+	LogLevel:      "warning",
+	ListenAddress: "127.0.0.1",
+	SocksPort:     10808,
+	HTTPPort:      10809,
+	DokodemoPort:  12345,
+}
+
+// Config is a v2ray-core JSON configuration document.
+type Config struct {
+	Log       LogConfig  `json:"log"`
+	Inbounds  []Inbound  `json:"inbounds"`
+	Outbounds []Outbound `json:"outbounds"`
+	Routing   Routing    `json:"routing"`
+	// Observatory is only set for balanced documents.
+	Observatory *Observatory `json:"observatory,omitempty"`
+}
+
+// LogConfig is the "log" section.
+type LogConfig struct {
+	LogLevel string `json:"loglevel"`
+}
+
+// Inbound is a single entry of the "inbounds" section.
+type Inbound struct {
+	Tag            string          `json:"tag"`
+	Protocol       string          `json:"protocol"`
+	Listen         string          `json:"listen,omitempty"`
+	Port           int             `json:"port"`
+	Settings       any             `json:"settings,omitempty"`
+	Sniffing       *Sniffing       `json:"sniffing,omitempty"`
+	StreamSettings *StreamSettings `json:"streamSettings,omitempty"`
+}
+
+// Sniffing enables destination sniffing on an inbound.
+type Sniffing struct {
+	Enabled      bool     `json:"enabled"`
+	DestOverride []string `json:"destOverride"`
+}
+
+// Outbound is a single entry of the "outbounds" section.
+type Outbound struct {
+	Tag            string          `json:"tag"`
+	Protocol       string          `json:"protocol"`
+	Settings       any             `json:"settings,omitempty"`
+	StreamSettings *StreamSettings `json:"streamSettings,omitempty"`
+}
+
+// StreamSettings describes the transport of an inbound or outbound.
+type StreamSettings struct {
+	Network         string           `json:"network,omitempty"`
+	Security        string           `json:"security,omitempty"`
+	TLSSettings     *TLSSettings     `json:"tlsSettings,omitempty"`
+	RealitySettings *RealitySettings `json:"realitySettings,omitempty"`
+	TCPSettings     *TCPSettings     `json:"tcpSettings,omitempty"`
+	WSSettings      *WSSettings      `json:"wsSettings,omitempty"`
+	GRPCSettings    *GRPCSettings    `json:"grpcSettings,omitempty"`
+	Sockopt         *Sockopt         `json:"sockopt,omitempty"`
+}
+
+// TLSSettings is the "tlsSettings" object.
+type TLSSettings struct {
+	ServerName string `json:"serverName,omitempty"`
+}
+
+// RealitySettings is the "realitySettings" object used by Xray-based cores for
+// VLESS REALITY.
+type RealitySettings struct {
+	ServerName  string `json:"serverName"`
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"publicKey"`
+	ShortID     string `json:"shortId,omitempty"`
+	SpiderX     string `json:"spiderX,omitempty"`
+}
+
+// TCPSettings is the "tcpSettings" object.
+type TCPSettings struct {
+	Header TCPHeader `json:"header"`
+}
+
+// TCPHeader selects the TCP header obfuscation type.
+type TCPHeader struct {
+	Type string `json:"type"`
+}
+
+// WSSettings is the "wsSettings" object.
+type WSSettings struct {
+	Path    string            `json:"path,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// GRPCSettings is the "grpcSettings" object.
+type GRPCSettings struct {
+	ServiceName string `json:"serviceName"`
+}
+
+// Sockopt is the "sockopt" object.
+type Sockopt struct {
+	TProxy string `json:"tproxy,omitempty"`
+}
+
+// Routing is the "routing" section.
+type Routing struct {
+	DomainStrategy string        `json:"domainStrategy"`
+	Rules          []RoutingRule `json:"rules"`
+	Balancers      []Balancer    `json:"balancers,omitempty"`
+}
+
+// RoutingRule is a single field rule of the "routing" section.
+type RoutingRule struct {
+	Type        string   `json:"type"`
+	Domain      []string `json:"domain,omitempty"`
+	IP          []string `json:"ip,omitempty"`
+	Port        string   `json:"port,omitempty"`
+	Network     string   `json:"network,omitempty"`
+	Protocol    []string `json:"protocol,omitempty"`
+	InboundTag  []string `json:"inboundTag,omitempty"`
+	OutboundTag string   `json:"outboundTag,omitempty"`
+	BalancerTag string   `json:"balancerTag,omitempty"`
+}
+
+// Generate builds a complete document for a stored configuration using DefaultOptions
+// and returns it as indented JSON.
+func Generate(protocol string, configData []byte) ([]byte, error) {
+	cfg, err := Build(protocol, configData, DefaultOptions)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Marshal()
+}
+
+// Build assembles the document for a stored configuration: local inbounds, the proxy
+// outbound built from the stored fields, direct/block outbounds, and routing made of
+// opts.Rules followed by the default rules.
+func Build(protocol string, configData []byte, opts Options) (*Config, error) {
+	proxy, err := NewOutbound(TagProxy, protocol, configData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Config{
+		Log:       LogConfig{LogLevel: opts.LogLevel},
+		Inbounds:  defaultInbounds(opts),
+		Outbounds: []Outbound{proxy, {Tag: TagDirect, Protocol: "freedom"}, {Tag: TagBlock, Protocol: "blackhole"}},
+		Routing:   buildRouting(opts.Rules),
+	}, nil
+}
+
+// Marshal renders the document as indented JSON.
+func (c *Config) Marshal() ([]byte, error) {
+	return json.MarshalIndent(c, "", "  ")
+}
+
+func defaultInbounds(opts Options) []Inbound {
+	sniffing := &Sniffing{Enabled: true, DestOverride: []string{"http", "tls"}}
+	return []Inbound{
+		{
+			Tag:      TagSocksIn,
+			Protocol: "socks",
+			Listen:   opts.ListenAddress,
+			Port:     opts.SocksPort,
+			Settings: map[string]any{"auth": "noauth", "udp": true},
+			Sniffing: sniffing,
+		},
+		{
+			Tag:      TagHTTPIn,
+			Protocol: "http",
+			Listen:   opts.ListenAddress,
+			Port:     opts.HTTPPort,
+			Sniffing: sniffing,
+		},
+		{
+			Tag:            TagDokodemoIn,
+			Protocol:       "dokodemo-door",
+			Port:           opts.DokodemoPort,
+			Settings:       map[string]any{"network": "tcp,udp", "followRedirect": true},
+			Sniffing:       sniffing,
+			StreamSettings: &StreamSettings{Sockopt: &Sockopt{TProxy: "redirect"}},
+		},
+	}
+}
+
+func buildRouting(rules []RoutingRule) Routing {
+	merged := make([]RoutingRule, 0, len(rules)+1)
+	for _, rule := range rules {
+		rule.Type = "field"
+		merged = append(merged, rule)
+	}
+	merged = append(merged, RoutingRule{Type: "field", IP: []string{"geoip:private"}, OutboundTag: TagDirect})
+	return Routing{DomainStrategy: "IPIfNonMatch", Rules: merged}
+}
@@ -0,0 +1,150 @@
+package generator_test
+
+import (
+	"flag"
+	"k2ray/internal/generator"
+	"k2ray/internal/protocol"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestGenerateGolden(t *testing.T) {
+	testCases := []struct {
+		name       string
+		protocol   string
+		configData string
+	}{
+		{
+			name:       "vmess",
+			protocol:   "vmess",
+			configData: `{"v": "2", "add": "vmess.example.com", "port": "443", "id": "b831381d-6324-4d53-ad4f-8cda48b30811", "aid": 0, "host": "cdn.example.com", "path": "/ray", "net": "ws", "tls": "tls"}`,
+		},
+		{
+			name:       "vless",
+			protocol:   "vless",
+			configData: `{"id": "b831381d-6324-4d53-ad4f-8cda48b30811", "add": "vless.example.com", "port": 443, "net": "grpc", "tls": "tls", "grpcSettings": {"serviceName": "tunnel"}}`,
+		},
+		{
+			name:       "vless-reality",
+			protocol:   "vless",
+			configData: `{"id": "b831381d-6324-4d53-ad4f-8cda48b30811", "add": "vless.example.com", "port": 443, "flow": "xtls-rprx-vision", "net": "tcp", "tls": "reality", "realitySettings": {"serverName": "www.microsoft.com", "publicKey": "uWhDlWI62pIgIWKYqjhL55xvC6_2CtcG8yDprrqtxDk", "shortId": "6ba85179e30d4fc2"}}`,
+		},
+		{
+			name:       "shadowsocks",
+			protocol:   "shadowsocks",
+			configData: `{"server": "ss.example.com", "server_port": 8388, "password": "secret", "method": "chacha20-ietf-poly1305"}`,
+		},
+		{
+			name:       "trojan",
+			protocol:   "trojan",
+			configData: `{"server": "trojan.example.com", "server_port": 443, "password": "secret", "sni": "real.example.com"}`,
+		},
+		{
+			name:       "socks",
+			protocol:   "socks",
+			configData: `{"server": "proxy.corp.example.com", "server_port": 1080, "username": "alice", "password": "secret"}`,
+		},
+		{
+			name:       "http",
+			protocol:   "http",
+			configData: `{"server": "proxy.corp.example.com", "server_port": 3128}`,
+		},
+		{
+			name:       "wireguard",
+			protocol:   "wireguard",
+			configData: `{"server": "wg.example.com", "server_port": 51820, "secret_key": "K7gNU3sdo+OL0wNhqoVWhr3g6s1xYv72ol/pe/Unols=", "public_key": "L/wdBjh++Lt6NDErbGw/aRVQaEUIw85+44iTdaGNb6A=", "pre_shared_key": "Iz7fpYyEhHcS6RFXJTOQfcRnccKvAizqB2a0ItRPMeU=", "address": ["10.0.0.2/32", "fd00::2/128"], "reserved": [1, 2, 3], "mtu": 1420}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := generator.Generate(tc.protocol, []byte(tc.configData))
+			require.NoError(t, err)
+
+			golden := filepath.Join("testdata", tc.name+".golden.json")
+			if *update {
+				require.NoError(t, os.WriteFile(golden, append(got, '\n'), 0644))
+			}
+			want, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(want), string(got))
+		})
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	_, err := generator.Generate("wireguard-ish", []byte(`{}`))
+	assert.Error(t, err, "Unknown protocols should be rejected")
+
+	_, err = generator.Generate("vmess", []byte(`{"add": "example.com", "port": 443}`))
+	assert.Error(t, err, "Configs failing validation should be rejected")
+
+	_, err = generator.Generate("vmess", []byte(`{"add": "example.com", "port": "https", "id": "some-id"}`))
+	assert.Error(t, err, "Non-numeric ports should be rejected")
+
+	_, err = generator.Generate("hysteria2", []byte(`{"server": "hy2.example.com", "server_port": 443, "password": "secret"}`))
+	assert.ErrorContains(t, err, "not supported by the V2Ray core", "QUIC-based protocols cannot run on V2Ray")
+
+	_, err = generator.Generate("shadowsocks", []byte(`{"server": "ss.example.com", "server_port": 8388, "password": "secret", "method": "aes-256-gcm", "plugin": "obfs-local;obfs=http"}`))
+	var verr *protocol.ValidationError
+	require.ErrorAs(t, err, &verr, "SIP003 plugins cannot run on V2Ray")
+	assert.Contains(t, verr.Fields, "plugin")
+}
+
+func TestBuildRoutingRules(t *testing.T) {
+	opts := generator.DefaultOptions
+	opts.Rules = []generator.RoutingRule{
+		{Domain: []string{"geosite:category-ads-all"}, OutboundTag: generator.TagBlock},
+		{IP: []string{"geoip:cn"}, Port: "443", OutboundTag: generator.TagDirect},
+	}
+	cfg, err := generator.Build("trojan", []byte(`{"server": "trojan.example.com", "server_port": 443, "password": "secret"}`), opts)
+	require.NoError(t, err)
+
+	rules := cfg.Routing.Rules
+	require.Len(t, rules, 3)
+	assert.Equal(t, []string{"geosite:category-ads-all"}, rules[0].Domain)
+	assert.Equal(t, "443", rules[1].Port)
+	assert.Equal(t, []string{"geoip:private"}, rules[2].IP, "default rules come after the user's")
+	for _, rule := range rules {
+		assert.Equal(t, "field", rule.Type)
+	}
+}
+
+func TestBuildBalancedGolden(t *testing.T) {
+	members := []generator.Member{
+		{Name: "Frankfurt", Protocol: "trojan", ConfigData: []byte(`{"server": "fra.example.com", "server_port": 443, "password": "secret"}`)},
+		{Name: "Amsterdam", Protocol: "vmess", ConfigData: []byte(`{"add": "ams.example.com", "port": 443, "id": "b831381d-6324-4d53-ad4f-8cda48b30811", "tls": "tls"}`)},
+	}
+	opts := generator.DefaultOptions
+	opts.Rules = []generator.RoutingRule{
+		{Domain: []string{"geosite:netflix"}, OutboundTag: generator.TagProxy},
+		{IP: []string{"geoip:cn"}, OutboundTag: generator.TagDirect},
+	}
+	cfg, err := generator.BuildBalanced(members, generator.BalancerOptions{Strategy: generator.StrategyLeastPing, ProbeInterval: "30s"}, opts)
+	require.NoError(t, err)
+	got, err := cfg.Marshal()
+	require.NoError(t, err)
+
+	golden := filepath.Join("testdata", "balanced.golden.json")
+	if *update {
+		require.NoError(t, os.WriteFile(golden, append(got, '\n'), 0644))
+	}
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(want), string(got))
+}
+
+func TestBuildBalancedErrors(t *testing.T) {
+	_, err := generator.BuildBalanced(nil, generator.BalancerOptions{Strategy: generator.StrategyRandom}, generator.DefaultOptions)
+	assert.Error(t, err, "A balancer without members should be rejected")
+
+	members := []generator.Member{{Name: "Broken", Protocol: "vmess", ConfigData: []byte(`{"add": "example.com", "port": 443}`)}}
+	_, err = generator.BuildBalanced(members, generator.BalancerOptions{Strategy: generator.StrategyRandom}, generator.DefaultOptions)
+	assert.ErrorContains(t, err, "Broken", "Errors should name the failing member")
+}
@@ -0,0 +1,277 @@
+package generator
+
+import (
+	"k2ray/internal/protocol"
+	"net"
+	"strconv"
+)
+
+// vnextSettings is the outbound "settings" object for VMess and VLESS.
+type vnextSettings struct {
+	Vnext []vnextServer `json:"vnext"`
+}
+
+type vnextServer struct {
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	Users   []any  `json:"users"`
+}
+
+type vmessUser struct {
+	ID       string `json:"id"`
+	AlterID  int    `json:"alterId"`
+	Security string `json:"security"`
+}
+
+type vlessUser struct {
+	ID         string `json:"id"`
+	Encryption string `json:"encryption"`
+	Flow       string `json:"flow,omitempty"`
+}
+
+// serversSettings is the outbound "settings" object for Shadowsocks and Trojan.
+type serversSettings struct {
+	Servers []server `json:"servers"`
+}
+
+type server struct {
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+	Method   string `json:"method,omitempty"`
+	Password string `json:"password"`
+}
+
+// proxyServersSettings is the outbound "settings" object for SOCKS and HTTP.
+type proxyServersSettings struct {
+	Servers []proxyServer `json:"servers"`
+}
+
+type proxyServer struct {
+	Address string      `json:"address"`
+	Port    int         `json:"port"`
+	Users   []proxyUser `json:"users,omitempty"`
+}
+
+type proxyUser struct {
+	User string `json:"user"`
+	Pass string `json:"pass"`
+}
+
+// wireguardSettings is the outbound "settings" object for WireGuard, in the
+// Xray format.
+type wireguardSettings struct {
+	SecretKey string          `json:"secretKey"`
+	Address   []string        `json:"address"`
+	Peers     []wireguardPeer `json:"peers"`
+	Reserved  []int           `json:"reserved,omitempty"`
+	MTU       int             `json:"mtu,omitempty"`
+}
+
+type wireguardPeer struct {
+	Endpoint     string `json:"endpoint"`
+	PublicKey    string `json:"publicKey"`
+	PreSharedKey string `json:"preSharedKey,omitempty"`
+}
+
+// NewOutbound builds a tagged outbound from a stored configuration.
+func NewOutbound(tag, protocolName string, configData []byte) (Outbound, error) {
+	decoded, err := protocol.Decode(protocolName, configData)
+	if err != nil {
+		return Outbound{}, err
+	}
+
+	switch c := decoded.(type) {
+	case *protocol.VmessConfigData:
+		port, err := protocol.ParsePort(c.Port)
+		if err != nil {
+			return Outbound{}, err
+		}
+		return Outbound{
+			Tag:      tag,
+			Protocol: protocol.Vmess,
+			Settings: vnextSettings{Vnext: []vnextServer{{
+				Address: c.Add,
+				Port:    port,
+				Users:   []any{vmessUser{ID: c.ID, AlterID: c.Aid, Security: "auto"}},
+			}}},
+			StreamSettings: streamSettings(c.TransportSettings, transportHints{
+				host:       c.Host,
+				path:       c.Path,
+				headerType: c.Type,
+				serverName: c.Host,
+			}),
+		}, nil
+
+	case *protocol.VlessConfigData:
+		port, err := protocol.ParsePort(c.Port)
+		if err != nil {
+			return Outbound{}, err
+		}
+		encryption := c.Encryption
+		if encryption == "" {
+			encryption = "none"
+		}
+		return Outbound{
+			Tag:      tag,
+			Protocol: protocol.Vless,
+			Settings: vnextSettings{Vnext: []vnextServer{{
+				Address: c.Address,
+				Port:    port,
+				Users:   []any{vlessUser{ID: c.ID, Encryption: encryption, Flow: c.Flow}},
+			}}},
+			StreamSettings: streamSettings(c.TransportSettings, transportHints{serverName: c.SNI, reality: c.RealitySettings}),
+		}, nil
+
+	case *protocol.ShadowsocksConfigData:
+		if c.Plugin != "" {
+			// V2Ray cannot run SIP003 plugins, and without the plugin the
+			// server would not understand the connection.
+			return Outbound{}, &protocol.ValidationError{
+				Msg:    "Shadowsocks plugins are not supported by the V2Ray core; export the configuration for sing-box or Clash.Meta instead",
+				Fields: map[string]string{"plugin": "is not supported by the V2Ray core"},
+			}
+		}
+		return Outbound{
+			Tag:      tag,
+			Protocol: protocol.Shadowsocks,
+			Settings: serversSettings{Servers: []server{{
+				Address:  c.Server,
+				Port:     c.ServerPort,
+				Method:   c.Method,
+				Password: c.Password,
+			}}},
+		}, nil
+
+	case *protocol.TrojanConfigData:
+		transport := c.TransportSettings
+		if transport.Security == "" {
+			// Trojan is always carried over TLS.
+			transport.Security = "tls"
+		}
+		return Outbound{
+			Tag:      tag,
+			Protocol: protocol.Trojan,
+			Settings: serversSettings{Servers: []server{{
+				Address:  c.Server,
+				Port:     c.ServerPort,
+				Password: c.Password,
+			}}},
+			StreamSettings: streamSettings(transport, transportHints{serverName: c.SNI}),
+		}, nil
+
+	case *protocol.SocksConfigData:
+		return Outbound{
+			Tag:      tag,
+			Protocol: protocol.Socks,
+			Settings: proxyServersSettings{Servers: []proxyServer{newProxyServer(c.Server, c.ServerPort, c.Username, c.Password)}},
+		}, nil
+
+	case *protocol.HTTPConfigData:
+		return Outbound{
+			Tag:      tag,
+			Protocol: protocol.HTTP,
+			Settings: proxyServersSettings{Servers: []proxyServer{newProxyServer(c.Server, c.ServerPort, c.Username, c.Password)}},
+		}, nil
+
+	case *protocol.WireGuardConfigData:
+		return Outbound{
+			Tag:      tag,
+			Protocol: protocol.WireGuard,
+			Settings: wireguardSettings{
+				SecretKey: c.SecretKey,
+				Address:   c.Address,
+				Peers: []wireguardPeer{{
+					Endpoint:     net.JoinHostPort(c.Server, strconv.Itoa(c.ServerPort)),
+					PublicKey:    c.PublicKey,
+					PreSharedKey: c.PreSharedKey,
+				}},
+				Reserved: c.Reserved,
+				MTU:      c.MTU,
+			},
+		}, nil
+
+	case *protocol.Hysteria2ConfigData, *protocol.TUICConfigData:
+		// V2Ray has no QUIC-based proxy outbounds; these configurations can only
+		// be exported for sing-box or Clash.Meta.
+		return Outbound{}, &protocol.ValidationError{Msg: "Protocol " + protocolName + " is not supported by the V2Ray core; export it for sing-box or Clash.Meta instead"}
+	}
+
+	return Outbound{}, &protocol.ValidationError{Msg: "Protocol not supported"}
+}
+
+func newProxyServer(address string, port int, username, password string) proxyServer {
+	s := proxyServer{Address: address, Port: port}
+	if username != "" {
+		s.Users = []proxyUser{{User: username, Pass: password}}
+	}
+	return s
+}
+
+// transportHints carries protocol-specific fields that feed into streamSettings.
+type transportHints struct {
+	host       string // Host header for ws
+	path       string // ws path or gRPC service name, from share-link style configs
+	headerType string // TCP header type
+	serverName string // TLS server name
+	reality    protocol.RealitySettings
+}
+
+// streamSettings converts the stored TransportSettings into a v2ray streamSettings object.
+func streamSettings(t protocol.TransportSettings, hints transportHints) *StreamSettings {
+	network := t.Network
+	if network == "" {
+		network = "tcp"
+	}
+	security := "none"
+	if t.Security == protocol.SecurityTLS || t.Security == protocol.SecurityReality {
+		security = t.Security
+	}
+
+	ss := &StreamSettings{Network: network, Security: security}
+	if security == protocol.SecurityTLS && hints.serverName != "" {
+		ss.TLSSettings = &TLSSettings{ServerName: hints.serverName}
+	}
+	if security == protocol.SecurityReality {
+		fingerprint := hints.reality.Fingerprint
+		if fingerprint == "" {
+			// The core refuses REALITY without a uTLS fingerprint.
+			fingerprint = "chrome"
+		}
+		ss.RealitySettings = &RealitySettings{
+			ServerName:  hints.reality.ServerName,
+			Fingerprint: fingerprint,
+			PublicKey:   hints.reality.PublicKey,
+			ShortID:     hints.reality.ShortID,
+			SpiderX:     hints.reality.SpiderX,
+		}
+	}
+
+	switch network {
+	case "tcp":
+		if hints.headerType != "" && hints.headerType != "none" {
+			ss.TCPSettings = &TCPSettings{Header: TCPHeader{Type: hints.headerType}}
+		}
+	case "ws":
+		ws := &WSSettings{Path: t.WsSettings.Path}
+		if ws.Path == "" {
+			ws.Path = hints.path
+		}
+		if len(t.WsSettings.Headers) > 0 || hints.host != "" {
+			ws.Headers = make(map[string]string, len(t.WsSettings.Headers)+1)
+			for k, v := range t.WsSettings.Headers {
+				ws.Headers[k] = v
+			}
+			if _, ok := ws.Headers["Host"]; !ok && hints.host != "" {
+				ws.Headers["Host"] = hints.host
+			}
+		}
+		ss.WSSettings = ws
+	case "grpc":
+		serviceName := t.GrpcSettings.ServiceName
+		if serviceName == "" {
+			serviceName = hints.path
+		}
+		ss.GRPCSettings = &GRPCSettings{ServiceName: serviceName}
+	}
+	return ss
+}
@@ -0,0 +1,90 @@
+package geodata
+
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+	"strings"
+)
+
+// errMalformed is returned for data that is not a GeoIP or GeoSite list.
+var errMalformed = errors.New("not a valid GeoIP or GeoSite data file")
+
+// Categories returns the sorted, lower-cased codes of the entries of a
+// geoip.dat or geosite.dat file, such as "cn" or "google". Both files are
+// protobuf lists whose entries carry their code in field 1, so no schema is
+// needed to read them.
+func Categories(data []byte) ([]string, error) {
+	var categories []string
+	err := eachField(data, func(num int, value []byte) error {
+		if num != 1 {
+			return nil
+		}
+		var code string
+		err := eachField(value, func(num int, value []byte) error {
+			if num == 1 {
+				code = strings.ToLower(string(value))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if code == "" {
+			return errMalformed
+		}
+		categories = append(categories, code)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(categories) == 0 {
+		return nil, errMalformed
+	}
+	slices.Sort(categories)
+	return slices.Compact(categories), nil
+}
+
+// eachField calls fn with the number and contents of every length-delimited
+// field of a protobuf message. Other fields are skipped.
+func eachField(data []byte, fn func(num int, value []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errMalformed
+		}
+		data = data[n:]
+
+		switch key & 7 {
+		case 0: // varint
+			if _, n = binary.Uvarint(data); n <= 0 {
+				return errMalformed
+			}
+			data = data[n:]
+		case 1: // 64-bit
+			if len(data) < 8 {
+				return errMalformed
+			}
+			data = data[8:]
+		case 5: // 32-bit
+			if len(data) < 4 {
+				return errMalformed
+			}
+			data = data[4:]
+		case 2: // length-delimited
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return errMalformed
+			}
+			value := data[n : n+int(size)]
+			data = data[n+int(size):]
+			if err := fn(int(key>>3), value); err != nil {
+				return err
+			}
+		default:
+			return errMalformed
+		}
+	}
+	return nil
+}
@@ -0,0 +1,418 @@
+// Package geodata manages the GeoIP and GeoSite data files that routing rules
+// with geoip: and geosite: conditions are matched against.
+package geodata
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"k2ray/internal/config"
+	"k2ray/internal/db"
+	"k2ray/internal/security"
+	"k2ray/internal/v2ray"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Data file names, as V2Ray expects them in its asset directory.
+const (
+	GeoIP   = "geoip.dat"
+	GeoSite = "geosite.dat"
+)
+
+// Names lists the managed data files.
+var Names = []string{GeoIP, GeoSite}
+
+var (
+	// Client is the HTTP client used to download data files and checksums.
+	Client = &http.Client{Timeout: 5 * time.Minute}
+
+	// MaxFileSize bounds the size of a downloaded data file.
+	MaxFileSize int64 = 256 << 20
+
+	// Reload applies the new data files to a running V2Ray process.
+	Reload = v2ray.Reload
+
+	// Start brings V2Ray back up with the previous data files if a failed
+	// reload left it stopped.
+	Start = v2ray.Start
+
+	// ErrUnknownFile is returned for names other than those in Names.
+	ErrUnknownFile = errors.New("unknown data file")
+
+	// ErrNotInstalled is returned by FileCategories for files that do not exist.
+	ErrNotInstalled = errors.New("data file is not installed")
+
+	updateMu sync.Mutex
+)
+
+// releaseTag matches the tag in the URL of a GitHub release asset.
+var releaseTag = regexp.MustCompile(`/releases/download/([^/]+)/`)
+
+// File describes an installed data file.
+type File struct {
+	Name      string     `json:"name"`
+	Installed bool       `json:"installed"`
+	Version   string     `json:"version"` // Empty if the file was not installed through k2ray or has changed since
+	SHA256    string     `json:"sha256,omitempty"`
+	Size      int64      `json:"size"`
+	ModTime   *time.Time `json:"mtime,omitempty"`
+	SourceURL string     `json:"source_url"` // Where updates are downloaded from
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// UpdateError is returned by Update when a file could not be downloaded,
+// failed verification, or was rejected by V2Ray. The previous files are in
+// place when it is returned.
+type UpdateError struct {
+	Name string
+	Err  error
+}
+
+func (e *UpdateError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Name, e.Err)
+}
+
+func (e *UpdateError) Unwrap() error {
+	return e.Err
+}
+
+// sourceURL returns the configured download URL of a data file.
+func sourceURL(name string) string {
+	if name == GeoIP {
+		return config.AppConfig.GeoIPURL
+	}
+	return config.AppConfig.GeoSiteURL
+}
+
+// path returns where V2Ray loads a data file from.
+func path(name string) string {
+	return filepath.Join(config.AppConfig.GeoDataDir, name)
+}
+
+// List describes every managed data file as it is on disk.
+func List() ([]File, error) {
+	recorded, err := db.ListGeoDataFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]File, 0, len(Names))
+	for _, name := range Names {
+		f := File{Name: name, SourceURL: sourceURL(name)}
+		info, err := os.Stat(path(name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			f.Installed, f.Size = true, info.Size()
+			modTime := info.ModTime().UTC()
+			f.ModTime = &modTime
+			if f.SHA256, err = hashFile(path(name)); err != nil {
+				return nil, err
+			}
+			if r, ok := recorded[name]; ok && r.SHA256 == f.SHA256 {
+				f.Version, f.UpdatedAt = r.Version, &r.UpdatedAt
+			}
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// FileCategories returns the categories of an installed data file.
+func FileCategories(name string) ([]string, error) {
+	if !slices.Contains(Names, name) {
+		return nil, ErrUnknownFile
+	}
+	data, err := os.ReadFile(path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotInstalled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Categories(data)
+}
+
+// download holds a verified data file waiting to be swapped in.
+type download struct {
+	name    string
+	tmp     string // Temporary file next to the destination
+	version string
+	sum     string
+}
+
+// Update downloads the named data files, or all of them if names is empty,
+// verifies each against its published SHA-256 checksum and swaps them into
+// place together. If V2Ray is running it is reloaded, and should it reject the
+// new files the previous ones are restored.
+func Update(ctx context.Context, names []string) ([]File, error) {
+	if len(names) == 0 {
+		names = Names
+	}
+	for _, name := range names {
+		if !slices.Contains(Names, name) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownFile, name)
+		}
+	}
+
+	updateMu.Lock()
+	defer updateMu.Unlock()
+
+	if err := os.MkdirAll(config.AppConfig.GeoDataDir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create data directory: %w", err)
+	}
+
+	var downloads []download
+	defer func() {
+		for _, d := range downloads {
+			os.Remove(d.tmp)
+		}
+	}()
+	for _, name := range slices.Compact(slices.Clone(names)) {
+		d, err := fetch(ctx, name)
+		if err != nil {
+			return nil, &UpdateError{Name: name, Err: err}
+		}
+		downloads = append(downloads, d)
+	}
+
+	restore, err := swap(downloads)
+	if err != nil {
+		return nil, err
+	}
+	result, reloadErr := Reload()
+	if result != v2ray.ReloadApplied && result != v2ray.ReloadNotRunning {
+		restore()
+		// A rejected reload left V2Ray running on the previous files. Otherwise
+		// it was restarted with the new files, or could not be started at all.
+		if result != v2ray.ReloadRejected {
+			restart()
+		}
+		return nil, &UpdateError{Name: downloads[0].name, Err: fmt.Errorf("V2Ray rejected the new data files: %w", reloadErr)}
+	}
+
+	for _, d := range downloads {
+		f := db.GeoDataFile{Name: d.name, Version: d.version, SHA256: d.sum, SourceURL: sourceURL(d.name)}
+		if err := db.SaveGeoDataFile(ctx, f); err != nil {
+			log.Error().Err(err).Str("file", d.name).Msg("Could not record data file version")
+		}
+		security.LogEvent(nil, security.GeoDataUpdated, 0, fmt.Sprintf("Data file %s updated to version %s (sha256 %s)", d.name, d.version, d.sum))
+	}
+	return List()
+}
+
+// restart brings V2Ray back up with the restored data files: reloaded if it
+// is running, started if the failed reload left it stopped. Failures are logged.
+func restart() {
+	if running, _ := v2ray.Status(); running {
+		if _, err := Reload(); err != nil {
+			log.Error().Err(err).Msg("Could not reload V2Ray with the previous data files")
+		}
+		return
+	}
+	if err := Start(); err != nil {
+		log.Error().Err(err).Msg("Could not start V2Ray with the previous data files")
+	}
+}
+
+// fetch downloads a data file into a temporary file next to its destination
+// and checks it against its checksum file.
+func fetch(ctx context.Context, name string) (download, error) {
+	url := sourceURL(name)
+	want, err := fetchChecksum(ctx, url+".sha256sum")
+	if err != nil {
+		return download{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return download{}, fmt.Errorf("invalid download URL: %w", err)
+	}
+	req.Header.Set("User-Agent", "k2ray")
+	resp, err := Client.Do(req)
+	if err != nil {
+		return download{}, fmt.Errorf("could not download: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return download{}, fmt.Errorf("download returned HTTP %d", resp.StatusCode)
+	}
+
+	tmp, err := os.CreateTemp(config.AppConfig.GeoDataDir, "."+name+".*.tmp")
+	if err != nil {
+		return download{}, err
+	}
+	d := download{name: name, tmp: tmp.Name()}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(resp.Body, MaxFileSize+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > MaxFileSize {
+		err = fmt.Errorf("file is larger than %d bytes", MaxFileSize)
+	}
+	if err == nil {
+		d.sum = hex.EncodeToString(hash.Sum(nil))
+		if d.sum != want {
+			err = fmt.Errorf("checksum mismatch: expected %s, got %s", want, d.sum)
+		}
+	}
+	if err == nil {
+		err = checkFormat(d.tmp)
+	}
+	if err != nil {
+		os.Remove(d.tmp)
+		return download{}, err
+	}
+
+	d.version = version(resp, d.sum)
+	return d, nil
+}
+
+// fetchChecksum downloads a checksum file in sha256sum format.
+func fetchChecksum(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid checksum URL: %w", err)
+	}
+	req.Header.Set("User-Agent", "k2ray")
+	resp, err := Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not download checksum: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("checksum download returned HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", fmt.Errorf("could not read checksum: %w", err)
+	}
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		return "", errors.New("checksum file is empty")
+	}
+	sum := strings.ToLower(fields[0])
+	if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != sha256.Size {
+		return "", errors.New("checksum file does not start with a SHA-256 digest")
+	}
+	return sum, nil
+}
+
+// checkFormat rejects files that are not GeoIP or GeoSite lists, so that a
+// wrong URL with a matching checksum is not installed.
+func checkFormat(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = Categories(data)
+	return err
+}
+
+// version names a downloaded file after its GitHub release tag, else its
+// Last-Modified date, else the start of its checksum.
+func version(resp *http.Response, sum string) string {
+	if m := releaseTag.FindStringSubmatch(resp.Request.URL.Path); m != nil {
+		return m[1]
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		return modified.UTC().Format("20060102150405")
+	}
+	return sum[:12]
+}
+
+// swap renames the downloaded files into place, keeping the previous ones as
+// backups until the returned restore function or a later swap. The previous
+// file is linked to its backup before the new one is renamed over it, so V2Ray
+// never finds a data file missing. If a rename fails, the files swapped so far
+// are restored before returning.
+func swap(downloads []download) (restore func(), err error) {
+	type backup struct{ dst, bak string }
+	var backups []backup
+	restore = func() {
+		for _, b := range slices.Backward(backups) {
+			if b.bak == "" {
+				os.Remove(b.dst)
+			} else if err := os.Rename(b.bak, b.dst); err != nil {
+				log.Error().Err(err).Str("file", b.dst).Msg("Could not restore previous data file")
+			}
+		}
+	}
+
+	for _, d := range downloads {
+		b := backup{dst: path(d.name)}
+		if _, err := os.Stat(b.dst); err == nil {
+			b.bak = b.dst + ".bak"
+			if err := backUp(b.dst, b.bak); err != nil {
+				restore()
+				return nil, &UpdateError{Name: d.name, Err: fmt.Errorf("could not back up previous file: %w", err)}
+			}
+		}
+		if err := os.Rename(d.tmp, b.dst); err != nil {
+			restore()
+			return nil, &UpdateError{Name: d.name, Err: fmt.Errorf("could not install file: %w", err)}
+		}
+		backups = append(backups, b)
+	}
+	return restore, nil
+}
+
+// backUp hard-links dst to bak, replacing any earlier backup, and falls back
+// to copying where links are not supported. dst itself is left in place.
+func backUp(dst, bak string) error {
+	if err := os.Remove(bak); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Link(dst, bak); err == nil {
+		return nil
+	}
+
+	src, err := os.Open(dst)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	out, err := os.CreateTemp(filepath.Dir(bak), "."+filepath.Base(bak)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, src)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), bak)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+	}
+	return err
+}
+
+// hashFile returns the hex SHA-256 digest of a file.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,242 @@
+package geodata_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"k2ray/internal/config"
+	"k2ray/internal/db"
+	"k2ray/internal/geodata"
+	"k2ray/internal/v2ray"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	tmpfile, err := os.CreateTemp("", "test_geodata_*.db")
+	if err != nil {
+		log.Fatalf("Failed to create temp db file: %v", err)
+	}
+	dbPath := tmpfile.Name()
+	tmpfile.Close()
+
+	config.LoadConfig("")
+	config.AppConfig.DatabaseURL = dbPath
+	db.InitDB()
+
+	code := m.Run()
+
+	db.DB.Close()
+	os.Remove(dbPath)
+	os.Exit(code)
+}
+
+// field encodes a length-delimited protobuf field.
+func field(num byte, value []byte) []byte {
+	return append([]byte{num<<3 | 2, byte(len(value))}, value...)
+}
+
+// datFile builds a GeoSite-style list with one entry per code, each holding a
+// domain so that the entries carry more than their code.
+func datFile(codes ...string) []byte {
+	var data []byte
+	for _, code := range codes {
+		entry := field(1, []byte(code))
+		entry = append(entry, field(2, append([]byte{0x08, 0x02}, field(2, []byte(code+".example.com"))...))...)
+		data = append(data, field(1, entry)...)
+	}
+	return data
+}
+
+func sum(data []byte) string {
+	s := sha256.Sum256(data)
+	return hex.EncodeToString(s[:])
+}
+
+// fixtureServer serves data files and their checksum files.
+type fixtureServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func newFixtureServer(t *testing.T) *fixtureServer {
+	f := &fixtureServer{files: map[string][]byte{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// GitHub serves the latest release by redirecting to its tag.
+		if r.URL.Path == "/latest/geosite.dat" || r.URL.Path == "/latest/geosite.dat.sha256sum" {
+			http.Redirect(w, r, "/releases/download/202405010000/"+filepath.Base(r.URL.Path), http.StatusFound)
+			return
+		}
+		f.mu.Lock()
+		data, ok := f.files[filepath.Base(r.URL.Path)]
+		f.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Last-Modified", "Wed, 01 May 2024 10:00:00 GMT")
+		w.Write(data)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// serve publishes data under name with a matching checksum file.
+func (f *fixtureServer) serve(name string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[name] = data
+	f.files[name+".sha256sum"] = []byte(sum(data) + "  " + name + "\n")
+}
+
+// replace changes the published data without updating its checksum.
+func (f *fixtureServer) replace(name string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[name] = data
+}
+
+func TestCategories(t *testing.T) {
+	categories, err := geodata.Categories(datFile("GOOGLE", "cn", "CN", "category-ads-all"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"category-ads-all", "cn", "google"}, categories)
+
+	_, err = geodata.Categories(nil)
+	assert.Error(t, err)
+	_, err = geodata.Categories([]byte("<html>Not Found</html>"))
+	assert.Error(t, err)
+	_, err = geodata.Categories(field(1, field(1, []byte("cn")))[:3])
+	assert.Error(t, err, "truncated data is rejected")
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	fixtures := newFixtureServer(t)
+	original := *config.AppConfig
+	t.Cleanup(func() { *config.AppConfig = original })
+	config.AppConfig.GeoDataDir = filepath.Join(t.TempDir(), "assets")
+	config.AppConfig.GeoIPURL = fixtures.URL + "/geoip.dat"
+	config.AppConfig.GeoSiteURL = fixtures.URL + "/latest/geosite.dat"
+
+	var reloads []v2ray.ReloadResult
+	originalReload := geodata.Reload
+	t.Cleanup(func() { geodata.Reload = originalReload })
+	reloadResult := v2ray.ReloadNotRunning
+	geodata.Reload = func() (v2ray.ReloadResult, error) {
+		reloads = append(reloads, reloadResult)
+		if reloadResult != v2ray.ReloadNotRunning && reloadResult != v2ray.ReloadApplied {
+			return reloadResult, assert.AnError
+		}
+		return reloadResult, nil
+	}
+	starts := 0
+	originalStart := geodata.Start
+	t.Cleanup(func() { geodata.Start = originalStart })
+	geodata.Start = func() error {
+		starts++
+		return nil
+	}
+
+	files, err := geodata.List()
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.False(t, files[0].Installed)
+	_, err = geodata.FileCategories(geodata.GeoSite)
+	assert.ErrorIs(t, err, geodata.ErrNotInstalled)
+
+	geoip, geosite := datFile("cn", "private"), datFile("google", "cn")
+	fixtures.serve("geoip.dat", geoip)
+	fixtures.serve("geosite.dat", geosite)
+	files, err = geodata.Update(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, geodata.GeoIP, files[0].Name)
+	assert.True(t, files[0].Installed)
+	assert.Equal(t, sum(geoip), files[0].SHA256)
+	assert.Equal(t, int64(len(geoip)), files[0].Size)
+	assert.NotNil(t, files[0].ModTime)
+	assert.Equal(t, "20240501100000", files[0].Version, "named after Last-Modified")
+	assert.Equal(t, "202405010000", files[1].Version, "named after the release tag")
+	assert.NotNil(t, files[1].UpdatedAt)
+	assert.Len(t, reloads, 1)
+
+	categories, err := geodata.FileCategories(geodata.GeoSite)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cn", "google"}, categories)
+	_, err = geodata.FileCategories("other.dat")
+	assert.ErrorIs(t, err, geodata.ErrUnknownFile)
+
+	installed := func(name string) []byte {
+		data, err := os.ReadFile(filepath.Join(config.AppConfig.GeoDataDir, name))
+		require.NoError(t, err)
+		return data
+	}
+
+	// A download that does not match its checksum is not installed.
+	fixtures.replace("geoip.dat", datFile("cn", "private", "us"))
+	_, err = geodata.Update(ctx, []string{geodata.GeoIP})
+	var updateErr *geodata.UpdateError
+	require.ErrorAs(t, err, &updateErr)
+	assert.Equal(t, geodata.GeoIP, updateErr.Name)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+	assert.Equal(t, geoip, installed("geoip.dat"))
+
+	// Neither is a file that is not a data file at all.
+	fixtures.serve("geoip.dat", []byte("<html>maintenance</html>"))
+	_, err = geodata.Update(ctx, []string{geodata.GeoIP})
+	assert.Error(t, err)
+	assert.Equal(t, geoip, installed("geoip.dat"))
+
+	// If V2Ray rejects the new files, the previous ones are put back.
+	fixtures.serve("geoip.dat", datFile("cn", "private", "us"))
+	fixtures.serve("geosite.dat", datFile("google", "cn", "netflix"))
+	reloadResult, reloads = v2ray.ReloadRejected, nil
+	_, err = geodata.Update(ctx, nil)
+	require.ErrorAs(t, err, &updateErr)
+	assert.Contains(t, err.Error(), "rejected")
+	assert.Equal(t, geoip, installed("geoip.dat"))
+	assert.Equal(t, geosite, installed("geosite.dat"))
+	assert.Len(t, reloads, 1, "V2Ray still runs on the previous files")
+	assert.Zero(t, starts)
+
+	// If V2Ray could not be restarted with them either, it is started again
+	// once the previous ones are back.
+	reloadResult, reloads = v2ray.ReloadFailed, nil
+	_, err = geodata.Update(ctx, nil)
+	require.ErrorAs(t, err, &updateErr)
+	assert.Equal(t, geoip, installed("geoip.dat"))
+	assert.Equal(t, geosite, installed("geosite.dat"))
+	assert.Len(t, reloads, 1)
+	assert.Equal(t, 1, starts, "the stopped process is started rather than reloaded")
+
+	reloadResult = v2ray.ReloadApplied
+	files, err = geodata.Update(ctx, []string{geodata.GeoSite})
+	require.NoError(t, err)
+	assert.Equal(t, sum(datFile("google", "cn", "netflix")), files[1].SHA256)
+	assert.Equal(t, sum(geoip), files[0].SHA256, "files that were not requested are left alone")
+
+	// Files changed outside k2ray have no known version.
+	require.NoError(t, os.WriteFile(filepath.Join(config.AppConfig.GeoDataDir, "geoip.dat"), datFile("de"), 0o644))
+	files, err = geodata.List()
+	require.NoError(t, err)
+	assert.Empty(t, files[0].Version)
+	assert.Nil(t, files[0].UpdatedAt)
+
+	entries, err := os.ReadDir(config.AppConfig.GeoDataDir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.NotContains(t, entry.Name(), ".tmp", "temporary files are cleaned up")
+	}
+
+	_, err = geodata.Update(ctx, []string{"other.dat"})
+	assert.ErrorIs(t, err, geodata.ErrUnknownFile)
+}
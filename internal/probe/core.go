@@ -0,0 +1,144 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"k2ray/internal/config"
+	"k2ray/internal/generator"
+	"k2ray/internal/v2ray"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+var (
+	// HTTPURL is requested through the test core by probes that include an
+	// HTTP round trip. Any response status counts as success.
+	HTTPURL = generator.DefaultProbeURL
+
+	// StartCore runs document in a short-lived core that accepts HTTP proxy
+	// requests on 127.0.0.1:port, and returns a function that stops it.
+	StartCore = startCore
+)
+
+// measureHTTP times a GET of HTTPURL through a test core running only the
+// configuration's outbound.
+func measureHTTP(ctx context.Context, protocolName string, configData []byte) (time.Duration, error) {
+	proxy, err := generator.NewOutbound(generator.TagProxy, protocolName, configData)
+	if err != nil {
+		return 0, err
+	}
+	port, err := freePort()
+	if err != nil {
+		return 0, fmt.Errorf("could not pick a port for the test core: %w", err)
+	}
+	document, err := (&generator.Config{
+		Log:       generator.LogConfig{LogLevel: "none"},
+		Inbounds:  []generator.Inbound{{Tag: generator.TagHTTPIn, Protocol: "http", Listen: "127.0.0.1", Port: port}},
+		Outbounds: []generator.Outbound{proxy},
+		Routing:   generator.Routing{DomainStrategy: "AsIs", Rules: []generator.RoutingRule{}},
+	}).Marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	stop, err := StartCore(ctx, document, port)
+	if err != nil {
+		return 0, fmt.Errorf("could not start the test core: %w", err)
+	}
+	defer stop()
+
+	proxyURL := &url.URL{Scheme: "http", Host: net.JoinHostPort("127.0.0.1", strconv.Itoa(port))}
+	client := &http.Client{
+		Timeout:   Timeout,
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+	}
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, HTTPURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return time.Since(start), nil
+}
+
+// startCore runs the V2Ray executable against a temporary copy of document
+// and waits until its inbound accepts connections.
+func startCore(ctx context.Context, document []byte, port int) (func(), error) {
+	tmp, err := os.CreateTemp("", "k2ray_probe_*.json")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Write(document); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	cmd := exec.Command(config.AppConfig.V2RayExecutable, "run", "-c", tmp.Name())
+	cmd.Env = v2ray.Environ()
+	if err := cmd.Start(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+	stop := func() {
+		_ = cmd.Process.Kill()
+		<-exited
+		os.Remove(tmp.Name())
+	}
+
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	deadline := time.Now().Add(Timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", address, 100*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			return stop, nil
+		}
+		select {
+		case <-exited:
+			os.Remove(tmp.Name())
+			return nil, errors.New("the core exited before accepting connections")
+		case <-ctx.Done():
+			stop()
+			return nil, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return nil, errors.New("timed out waiting for the core to accept connections")
+		}
+	}
+}
+
+// freePort returns a local TCP port that was free a moment ago.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
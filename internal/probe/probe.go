@@ -0,0 +1,187 @@
+// Package probe measures how quickly the servers of stored configurations can
+// be reached and records the results in the probe_results table.
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"k2ray/internal/db"
+	"k2ray/internal/protocol"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Probe statuses, as stored in the probe_results.status column.
+const (
+	StatusOK          = "ok"
+	StatusUnreachable = "unreachable" // The TCP connection could not be opened
+	StatusTLSFailed   = "tls_failed"
+	StatusHTTPFailed  = "http_failed"
+	StatusUnsupported = "unsupported" // The protocol does not run over TCP
+	StatusInvalid     = "invalid"     // The stored config_data could not be decoded
+)
+
+var (
+	// Timeout bounds each step of a probe.
+	Timeout = 5 * time.Second
+
+	// Concurrency is the number of configurations Configs probes at once.
+	Concurrency = 8
+
+	// ErrUnsupported is returned by TargetOf for protocols that run over UDP.
+	ErrUnsupported = errors.New("probing is only supported for protocols that run over TCP")
+)
+
+// Target is the server endpoint a probe connects to.
+type Target struct {
+	Host       string
+	Port       int
+	TLS        bool   // Whether to measure a TLS handshake after connecting
+	ServerName string // SNI for the handshake
+}
+
+// TargetOf extracts the server endpoint of a stored configuration. QUIC-based
+// protocols and WireGuard run over UDP and return ErrUnsupported.
+func TargetOf(protocolName string, configData []byte) (Target, error) {
+	decoded, err := protocol.Decode(protocolName, configData)
+	if err != nil {
+		return Target{}, err
+	}
+
+	switch c := decoded.(type) {
+	case *protocol.VmessConfigData:
+		port, err := protocol.ParsePort(c.Port)
+		if err != nil {
+			return Target{}, err
+		}
+		t := Target{Host: c.Add, Port: port, TLS: c.Security == protocol.SecurityTLS, ServerName: c.Host}
+		if t.ServerName == "" {
+			t.ServerName = c.Add
+		}
+		return t, nil
+	case *protocol.VlessConfigData:
+		port, err := protocol.ParsePort(c.Port)
+		if err != nil {
+			return Target{}, err
+		}
+		t := Target{Host: c.Address, Port: port, ServerName: c.SNI}
+		switch c.Security {
+		case protocol.SecurityTLS:
+			t.TLS = true
+		case protocol.SecurityReality:
+			// REALITY servers complete the handshake of the site they imitate.
+			t.TLS, t.ServerName = true, c.RealitySettings.ServerName
+		}
+		if t.ServerName == "" {
+			t.ServerName = c.Address
+		}
+		return t, nil
+	case *protocol.TrojanConfigData:
+		// Trojan is carried over TLS unless explicitly disabled.
+		t := Target{Host: c.Server, Port: c.ServerPort, TLS: c.Security != protocol.SecurityNone, ServerName: c.SNI}
+		if t.ServerName == "" {
+			t.ServerName = c.Server
+		}
+		return t, nil
+	case *protocol.ShadowsocksConfigData:
+		return Target{Host: c.Server, Port: c.ServerPort}, nil
+	case *protocol.SocksConfigData:
+		return Target{Host: c.Server, Port: c.ServerPort}, nil
+	case *protocol.HTTPConfigData:
+		return Target{Host: c.Server, Port: c.ServerPort}, nil
+	}
+	return Target{}, ErrUnsupported
+}
+
+// Probe measures a single configuration: the time to open a TCP connection to
+// its server, then the TLS handshake if the server uses TLS and, if withHTTP
+// is set, an HTTP round trip through a test core. The result is not recorded.
+func Probe(ctx context.Context, protocolName string, configData []byte, withHTTP bool) db.ProbeResult {
+	var result db.ProbeResult
+	target, err := TargetOf(protocolName, configData)
+	if err != nil {
+		result.Status, result.Error = StatusInvalid, err.Error()
+		if errors.Is(err, ErrUnsupported) {
+			result.Status = StatusUnsupported
+		}
+		return result
+	}
+
+	address := net.JoinHostPort(target.Host, strconv.Itoa(target.Port))
+	dialer := net.Dialer{Timeout: Timeout}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		result.Status, result.Error = StatusUnreachable, err.Error()
+		return result
+	}
+	result.TCPConnectMs = millis(time.Since(start))
+
+	if target.TLS {
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName: target.ServerName,
+			// Only the handshake time is measured; proxies commonly use
+			// certificates that would not verify from here.
+			InsecureSkipVerify: true,
+		})
+		handshakeCtx, cancel := context.WithTimeout(ctx, Timeout)
+		start = time.Now()
+		err = tlsConn.HandshakeContext(handshakeCtx)
+		cancel()
+		if err != nil {
+			conn.Close()
+			result.Status, result.Error = StatusTLSFailed, err.Error()
+			return result
+		}
+		result.TLSHandshakeMs = millis(time.Since(start))
+	}
+	conn.Close()
+
+	if withHTTP {
+		elapsed, err := measureHTTP(ctx, protocolName, configData)
+		if err != nil {
+			result.Status, result.Error = StatusHTTPFailed, err.Error()
+			return result
+		}
+		result.HTTPMs = millis(elapsed)
+	}
+
+	result.Status = StatusOK
+	return result
+}
+
+// Configs probes the given configurations, at most Concurrency at a time, and
+// records each result. Results are returned in the order of configs.
+func Configs(ctx context.Context, configs []db.Configuration, withHTTP bool) []db.ProbeResult {
+	results := make([]db.ProbeResult, len(configs))
+	sem := make(chan struct{}, max(Concurrency, 1))
+	var wg sync.WaitGroup
+	for i, config := range configs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result := Probe(ctx, config.Protocol, []byte(config.ConfigData), withHTTP)
+			result.ConfigurationID = config.ID
+			if err := db.AddProbeResult(ctx, &result); err != nil {
+				log.Error().Err(err).Int64("config_id", config.ID).Msg("Error recording probe result")
+			}
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// millis converts d to whole milliseconds for storage.
+func millis(d time.Duration) *int64 {
+	ms := d.Milliseconds()
+	return &ms
+}
@@ -0,0 +1,220 @@
+package probe_test
+
+import (
+	"context"
+	"fmt"
+	"k2ray/internal/config"
+	"k2ray/internal/db"
+	"k2ray/internal/probe"
+	"k2ray/internal/utils"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	tmpfile, err := os.CreateTemp("", "test_probe_*.db")
+	if err != nil {
+		log.Fatalf("Failed to create temp db file: %v", err)
+	}
+	dbPath := tmpfile.Name()
+	tmpfile.Close()
+
+	config.LoadConfig("")
+	config.AppConfig.DatabaseURL = dbPath
+	db.InitDB()
+	probe.Timeout = 2 * time.Second
+
+	code := m.Run()
+
+	db.DB.Close()
+	os.Remove(dbPath)
+	os.Exit(code)
+}
+
+// hostPort splits the address of a local listener.
+func hostPort(t *testing.T, addr net.Addr) (string, int) {
+	host, port, err := net.SplitHostPort(addr.String())
+	require.NoError(t, err)
+	n, _ := strconv.Atoi(port)
+	return host, n
+}
+
+// tcpListener accepts connections and closes them straight away.
+func tcpListener(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return l
+}
+
+func socksConfig(host string, port int) []byte {
+	return []byte(fmt.Sprintf(`{"server":%q,"server_port":%d}`, host, port))
+}
+
+func trojanConfig(host string, port int) []byte {
+	return []byte(fmt.Sprintf(`{"server":%q,"server_port":%d,"password":"secret","sni":"example.com"}`, host, port))
+}
+
+func TestTargetOf(t *testing.T) {
+	target, err := probe.TargetOf("vmess", []byte(`{"add":"vm.example.com","port":"443","id":"b831381d-6324-4d53-ad4f-8cda48b30811","aid":0,"tls":"tls","host":"cdn.example.com"}`))
+	require.NoError(t, err)
+	assert.Equal(t, probe.Target{Host: "vm.example.com", Port: 443, TLS: true, ServerName: "cdn.example.com"}, target)
+
+	target, err = probe.TargetOf("vless", []byte(`{"id":"b831381d-6324-4d53-ad4f-8cda48b30811","add":"vl.example.com","port":8443,"tls":"reality",
+		"realitySettings":{"serverName":"www.microsoft.com","fingerprint":"chrome","publicKey":"jNXHt1yRo0vDuchQlIP6Z0ZvjT3KtzVI-T4E7RoLJS0"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, probe.Target{Host: "vl.example.com", Port: 8443, TLS: true, ServerName: "www.microsoft.com"}, target)
+
+	target, err = probe.TargetOf("trojan", []byte(`{"server":"tj.example.com","server_port":443,"password":"secret"}`))
+	require.NoError(t, err)
+	assert.Equal(t, probe.Target{Host: "tj.example.com", Port: 443, TLS: true, ServerName: "tj.example.com"}, target)
+
+	target, err = probe.TargetOf("shadowsocks", []byte(`{"server":"ss.example.com","server_port":8388,"password":"secret","method":"aes-256-gcm"}`))
+	require.NoError(t, err)
+	assert.Equal(t, probe.Target{Host: "ss.example.com", Port: 8388}, target)
+
+	_, err = probe.TargetOf("hysteria2", []byte(`{"server":"hy.example.com","server_port":443,"password":"secret"}`))
+	assert.ErrorIs(t, err, probe.ErrUnsupported)
+
+	_, err = probe.TargetOf("socks", []byte(`{"server_port":1080}`))
+	assert.Error(t, err)
+}
+
+func TestProbe(t *testing.T) {
+	ctx := context.Background()
+
+	host, port := hostPort(t, tcpListener(t).Addr())
+	result := probe.Probe(ctx, "socks", socksConfig(host, port), false)
+	assert.Equal(t, probe.StatusOK, result.Status, result.Error)
+	assert.NotNil(t, result.TCPConnectMs)
+	assert.Nil(t, result.TLSHandshakeMs, "plain TCP protocols skip the handshake")
+	assert.Nil(t, result.HTTPMs)
+
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+	host, port = hostPort(t, tlsServer.Listener.Addr())
+	result = probe.Probe(ctx, "trojan", trojanConfig(host, port), false)
+	assert.Equal(t, probe.StatusOK, result.Status, result.Error)
+	assert.NotNil(t, result.TCPConnectMs)
+	assert.NotNil(t, result.TLSHandshakeMs, "self-signed certificates are accepted")
+
+	host, port = hostPort(t, tcpListener(t).Addr())
+	result = probe.Probe(ctx, "trojan", trojanConfig(host, port), false)
+	assert.Equal(t, probe.StatusTLSFailed, result.Status)
+	assert.NotNil(t, result.TCPConnectMs)
+	assert.NotEmpty(t, result.Error)
+
+	closed := tcpListener(t)
+	host, port = hostPort(t, closed.Addr())
+	closed.Close()
+	result = probe.Probe(ctx, "socks", socksConfig(host, port), false)
+	assert.Equal(t, probe.StatusUnreachable, result.Status)
+	assert.Nil(t, result.TCPConnectMs)
+
+	result = probe.Probe(ctx, "hysteria2", []byte(`{"server":"127.0.0.1","server_port":443,"password":"secret"}`), false)
+	assert.Equal(t, probe.StatusUnsupported, result.Status)
+
+	result = probe.Probe(ctx, "socks", []byte(`{"server_port":"x"}`), false)
+	assert.Equal(t, probe.StatusInvalid, result.Status)
+}
+
+func TestProbeHTTP(t *testing.T) {
+	// The fake core is a plain HTTP server answering every proxied request.
+	var documents [][]byte
+	originalStartCore, originalURL := probe.StartCore, probe.HTTPURL
+	t.Cleanup(func() { probe.StartCore, probe.HTTPURL = originalStartCore, originalURL })
+	probe.HTTPURL = "http://probe.test/generate_204"
+	probe.StartCore = func(ctx context.Context, document []byte, port int) (func(), error) {
+		documents = append(documents, document)
+		l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			return nil, err
+		}
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})}
+		go server.Serve(l)
+		return func() { server.Close() }, nil
+	}
+
+	host, port := hostPort(t, tcpListener(t).Addr())
+	result := probe.Probe(context.Background(), "socks", socksConfig(host, port), true)
+	assert.Equal(t, probe.StatusOK, result.Status, result.Error)
+	assert.NotNil(t, result.HTTPMs)
+	require.Len(t, documents, 1)
+	assert.Contains(t, string(documents[0]), `"tag": "proxy"`)
+	assert.Contains(t, string(documents[0]), strconv.Itoa(port), "the core connects to the probed server")
+
+	probe.StartCore = func(ctx context.Context, document []byte, port int) (func(), error) {
+		return func() {}, nil // Nothing listens on the port
+	}
+	result = probe.Probe(context.Background(), "socks", socksConfig(host, port), true)
+	assert.Equal(t, probe.StatusHTTPFailed, result.Status)
+	assert.NotNil(t, result.TCPConnectMs, "earlier measurements are kept")
+	assert.Nil(t, result.HTTPMs)
+}
+
+func TestConfigs(t *testing.T) {
+	ctx := context.Background()
+	hashedPassword, _ := utils.HashPassword("password")
+	res, err := db.DB.Exec(`INSERT INTO users (username, password_hash) VALUES (?, ?)`, t.Name(), hashedPassword)
+	require.NoError(t, err)
+	userID, _ := res.LastInsertId()
+
+	host, port := hostPort(t, tcpListener(t).Addr())
+	closed := tcpListener(t)
+	_, closedPort := hostPort(t, closed.Addr())
+	closed.Close()
+
+	var configs []db.Configuration
+	for i, p := range []int{port, closedPort, port, port, port} {
+		data := string(socksConfig(host, p))
+		res, err := db.DB.Exec(`INSERT INTO configurations (user_id, name, protocol, config_data) VALUES (?, ?, 'socks', ?)`, userID, fmt.Sprintf("c%d", i), data)
+		require.NoError(t, err)
+		id, _ := res.LastInsertId()
+		configs = append(configs, db.Configuration{ID: id, Protocol: "socks", ConfigData: data})
+	}
+
+	originalConcurrency := probe.Concurrency
+	t.Cleanup(func() { probe.Concurrency = originalConcurrency })
+	probe.Concurrency = 2
+
+	results := probe.Configs(ctx, configs, false)
+	require.Len(t, results, len(configs))
+	for i, result := range results {
+		assert.Equal(t, configs[i].ID, result.ConfigurationID)
+		assert.NotZero(t, result.ID, "results are recorded")
+	}
+	assert.Equal(t, probe.StatusUnreachable, results[1].Status)
+	assert.Equal(t, probe.StatusOK, results[4].Status)
+
+	probe.Configs(ctx, configs[:1], false)
+	history, err := db.ListProbeResults(configs[0].ID, 10)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Greater(t, history[0].ID, history[1].ID, "newest first")
+
+	latest, err := db.LatestProbeResults([]int64{configs[0].ID, configs[1].ID, 999999})
+	require.NoError(t, err)
+	assert.Len(t, latest, 2)
+	assert.Equal(t, history[0].ID, latest[configs[0].ID].ID)
+	assert.Equal(t, probe.StatusUnreachable, latest[configs[1].ID].Status)
+}
@@ -0,0 +1,267 @@
+// Package protocol defines the stored config_data models for each supported
+// proxy protocol and the validation applied to them.
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Supported protocol names, as stored in the configurations.protocol column.
+const (
+	Vmess       = "vmess"
+	Vless       = "vless"
+	Shadowsocks = "shadowsocks"
+	Trojan      = "trojan"
+	Hysteria2   = "hysteria2"
+	TUIC        = "tuic"
+	Socks       = "socks"
+	HTTP        = "http"
+	WireGuard   = "wireguard"
+)
+
+// Transport security values for TransportSettings.Security.
+const (
+	SecurityNone    = "none"
+	SecurityTLS     = "tls"
+	SecurityReality = "reality" // VLESS only
+)
+
+// XTLS flow control values accepted in VlessConfigData.Flow.
+const (
+	FlowVision       = "xtls-rprx-vision"
+	FlowVisionUDP443 = "xtls-rprx-vision-udp443"
+)
+
+// Fingerprints lists the uTLS client fingerprints REALITY can imitate.
+var Fingerprints = []string{"chrome", "firefox", "safari", "ios", "android", "edge", "360", "qq", "random", "randomized"}
+
+// TransportSettings defines common transport settings for V2Ray protocols.
+type TransportSettings struct {
+	Network      string       `json:"net,omitempty"` // "tcp", "kcp", "ws", "h2", "quic", "grpc"
+	Security     string       `json:"tls,omitempty"` // "none", "tls", "reality"
+	WsSettings   WsSettings   `json:"wsSettings,omitzero"`
+	GrpcSettings GrpcSettings `json:"grpcSettings,omitzero"`
+}
+
+// WsSettings defines WebSocket-specific transport settings.
+type WsSettings struct {
+	Path    string            `json:"path,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// GrpcSettings defines gRPC-specific transport settings.
+type GrpcSettings struct {
+	ServiceName string `json:"serviceName,omitempty"`
+}
+
+// RealitySettings defines the client side of a VLESS REALITY connection.
+type RealitySettings struct {
+	ServerName  string `json:"serverName,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	PublicKey   string `json:"publicKey,omitempty"` // x25519, base64url without padding
+	ShortID     string `json:"shortId,omitempty"`   // up to 16 hex digits
+	SpiderX     string `json:"spiderX,omitempty"`
+}
+
+// VmessConfigData defines the structure for a VMess config.
+type VmessConfigData struct {
+	V                 string `json:"v,omitempty"`
+	Add               string `json:"add"`
+	Port              any    `json:"port"`
+	ID                string `json:"id"`
+	Aid               int    `json:"aid"`
+	Type              string `json:"type,omitempty"` // Header type
+	Host              string `json:"host,omitempty"`
+	Path              string `json:"path,omitempty"`
+	TransportSettings `json:","`
+}
+
+// VlessConfigData defines the structure for a VLESS config.
+type VlessConfigData struct {
+	ID                string          `json:"id"`
+	Address           string          `json:"add"`
+	Port              any             `json:"port"`
+	Encryption        string          `json:"encryption,omitempty"`
+	Flow              string          `json:"flow,omitempty"`
+	SNI               string          `json:"sni,omitempty"`
+	RealitySettings   RealitySettings `json:"realitySettings,omitzero"`
+	TransportSettings `json:","`
+}
+
+// ShadowsocksConfigData defines the structure for a Shadowsocks config.
+type ShadowsocksConfigData struct {
+	Server     string `json:"server"`
+	ServerPort int    `json:"server_port"`
+	Password   string `json:"password"`
+	Method     string `json:"method"`
+	Plugin     string `json:"plugin,omitempty"`
+}
+
+// TrojanConfigData defines the structure for a Trojan config.
+type TrojanConfigData struct {
+	Server            string `json:"server"`
+	ServerPort        int    `json:"server_port"`
+	Password          string `json:"password"`
+	SNI               string `json:"sni,omitempty"`
+	TransportSettings `json:","`
+}
+
+// QUICTLSSettings holds the TLS options shared by the QUIC-based protocols,
+// which always run over TLS.
+type QUICTLSSettings struct {
+	SNI      string   `json:"sni,omitempty"`
+	Insecure bool     `json:"insecure,omitempty"`
+	ALPN     []string `json:"alpn,omitempty"`
+	// PinSHA256 pins the server certificate by the SHA-256 hash of its DER
+	// encoding, written as hex with optional colons.
+	PinSHA256 string `json:"pin_sha256,omitempty"`
+}
+
+// Hysteria2ConfigData defines the structure for a Hysteria2 config.
+type Hysteria2ConfigData struct {
+	Server       string `json:"server"`
+	ServerPort   int    `json:"server_port"`
+	Password     string `json:"password"`
+	Obfs         string `json:"obfs,omitempty"` // "salamander"
+	ObfsPassword string `json:"obfs_password,omitempty"`
+	// UpMbps and DownMbps are bandwidth hints for Brutal congestion control;
+	// zero leaves the choice to the client (BBR).
+	UpMbps          int `json:"up_mbps,omitempty"`
+	DownMbps        int `json:"down_mbps,omitempty"`
+	QUICTLSSettings `json:","`
+}
+
+// TUICConfigData defines the structure for a TUIC v5 config.
+type TUICConfigData struct {
+	Server            string `json:"server"`
+	ServerPort        int    `json:"server_port"`
+	UUID              string `json:"uuid"`
+	Password          string `json:"password"`
+	CongestionControl string `json:"congestion_control,omitempty"` // "cubic", "new_reno", "bbr"
+	UDPRelayMode      string `json:"udp_relay_mode,omitempty"`     // "native", "quic"
+	QUICTLSSettings   `json:","`
+}
+
+// SocksConfigData defines the structure for an upstream SOCKS5 proxy.
+type SocksConfigData struct {
+	Server     string `json:"server"`
+	ServerPort int    `json:"server_port"`
+	Username   string `json:"username,omitempty"`
+	Password   string `json:"password,omitempty"`
+}
+
+// HTTPConfigData defines the structure for an upstream HTTP CONNECT proxy.
+type HTTPConfigData struct {
+	Server     string `json:"server"`
+	ServerPort int    `json:"server_port"`
+	Username   string `json:"username,omitempty"`
+	Password   string `json:"password,omitempty"`
+}
+
+// WireGuardConfigData defines the structure for a WireGuard peer.
+type WireGuardConfigData struct {
+	Server       string   `json:"server"`
+	ServerPort   int      `json:"server_port"`
+	SecretKey    string   `json:"secret_key"` // local private key
+	PublicKey    string   `json:"public_key"` // peer public key
+	PreSharedKey string   `json:"pre_shared_key,omitempty"`
+	Address      []string `json:"address"`            // local interface addresses, CIDR or bare IP
+	Reserved     []int    `json:"reserved,omitempty"` // 3 bytes, e.g. for Cloudflare WARP
+	MTU          int      `json:"mtu,omitempty"`
+}
+
+// ConfigData is implemented by every protocol's config_data model.
+type ConfigData interface {
+	validate() error
+}
+
+// ValidationError is a custom error type for validation failures.
+type ValidationError struct {
+	Msg string
+	// Fields maps the JSON path of each invalid field (e.g. "wsSettings.path")
+	// to what is wrong with it. It is nil for errors not tied to a field.
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Msg
+}
+
+// Decode performs JSON unmarshaling and validation for a given protocol.
+// The returned value is a pointer to the protocol's *ConfigData struct.
+func Decode(protocol string, data json.RawMessage) (ConfigData, error) {
+	var v ConfigData
+	switch protocol {
+	case Vmess:
+		v = &VmessConfigData{}
+	case Vless:
+		v = &VlessConfigData{}
+	case Shadowsocks:
+		v = &ShadowsocksConfigData{}
+	case Trojan:
+		v = &TrojanConfigData{}
+	case Hysteria2:
+		v = &Hysteria2ConfigData{}
+	case TUIC:
+		v = &TUICConfigData{}
+	case Socks:
+		v = &SocksConfigData{}
+	case HTTP:
+		v = &HTTPConfigData{}
+	case WireGuard:
+		v = &WireGuardConfigData{}
+	default:
+		return nil, &ValidationError{Msg: "Protocol not supported"}
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		verr := &ValidationError{Msg: "Invalid config_data format: " + err.Error()}
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			verr.Fields = map[string]string{typeErr.Field: "must be a " + typeErr.Type.String()}
+		}
+		return nil, verr
+	}
+
+	if err := v.validate(); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// ParsePort converts a port stored as either a JSON number or a string into an int.
+func ParsePort(v any) (int, error) {
+	var port int
+	switch p := v.(type) {
+	case float64:
+		if p != float64(int(p)) {
+			return 0, fmt.Errorf("port %v is not an integer", p)
+		}
+		port = int(p)
+	case int:
+		port = p
+	case json.Number:
+		n, err := strconv.Atoi(p.String())
+		if err != nil {
+			return 0, fmt.Errorf("port %q is not an integer", p)
+		}
+		port = n
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return 0, fmt.Errorf("port %q is not an integer", p)
+		}
+		port = n
+	default:
+		return 0, fmt.Errorf("port has unsupported type %T", v)
+	}
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %d is out of range", port)
+	}
+	return port, nil
+}
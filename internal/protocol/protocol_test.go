@@ -0,0 +1,210 @@
+package protocol_test
+
+import (
+	"k2ray/internal/protocol"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testUUID      = "b831381d-6324-4d53-ad4f-8cda48b30811"
+	testPublicKey = "uWhDlWI62pIgIWKYqjhL55xvC6_2CtcG8yDprrqtxDk"
+)
+
+func TestDecodeVless(t *testing.T) {
+	base := `"id":"` + testUUID + `","add":"vless.example.com","port":443`
+	reality := `"serverName":"www.microsoft.com","publicKey":"` + testPublicKey + `"`
+
+	tests := []struct {
+		name      string
+		data      string
+		wantField string
+	}{
+		{"plain", `{` + base + `}`, ""},
+		{"tls vision", `{` + base + `,"flow":"xtls-rprx-vision","tls":"tls"}`, ""},
+		{"reality vision", `{` + base + `,"flow":"xtls-rprx-vision","tls":"reality","realitySettings":{` + reality + `,"fingerprint":"chrome","shortId":"6ba85179e30d4fc2","spiderX":"/"}}`, ""},
+		{"reality empty short id", `{` + base + `,"tls":"reality","realitySettings":{` + reality + `}}`, ""},
+		{"reality over grpc", `{` + base + `,"net":"grpc","tls":"reality","realitySettings":{` + reality + `}}`, ""},
+
+		{"unknown security", `{` + base + `,"tls":"xtls"}`, "tls"},
+		{"unknown flow", `{` + base + `,"flow":"xtls-rprx-direct","tls":"tls"}`, "flow"},
+		{"flow without tls", `{` + base + `,"flow":"xtls-rprx-vision"}`, "flow"},
+		{"flow over ws", `{` + base + `,"flow":"xtls-rprx-vision","net":"ws","tls":"tls"}`, "flow"},
+		{"reality settings without reality", `{` + base + `,"tls":"tls","realitySettings":{` + reality + `}}`, "realitySettings"},
+		{"reality missing settings", `{` + base + `,"tls":"reality"}`, "realitySettings.publicKey"},
+		{"reality short public key", `{` + base + `,"tls":"reality","realitySettings":{"serverName":"www.microsoft.com","publicKey":"c2hvcnQ"}}`, "realitySettings.publicKey"},
+		{"reality padded public key", `{` + base + `,"tls":"reality","realitySettings":{"serverName":"www.microsoft.com","publicKey":"` + testPublicKey + `="}}`, "realitySettings.publicKey"},
+		{"reality odd short id", `{` + base + `,"tls":"reality","realitySettings":{` + reality + `,"shortId":"abc"}}`, "realitySettings.shortId"},
+		{"reality long short id", `{` + base + `,"tls":"reality","realitySettings":{` + reality + `,"shortId":"0123456789abcdef01"}}`, "realitySettings.shortId"},
+		{"reality non-hex short id", `{` + base + `,"tls":"reality","realitySettings":{` + reality + `,"shortId":"zz"}}`, "realitySettings.shortId"},
+		{"reality unknown fingerprint", `{` + base + `,"tls":"reality","realitySettings":{` + reality + `,"fingerprint":"netscape"}}`, "realitySettings.fingerprint"},
+		{"reality relative spiderX", `{` + base + `,"tls":"reality","realitySettings":{` + reality + `,"spiderX":"path"}}`, "realitySettings.spiderX"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := protocol.Decode(protocol.Vless, []byte(tt.data))
+			if tt.wantField == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var verr *protocol.ValidationError
+			if assert.ErrorAs(t, err, &verr) {
+				assert.Contains(t, verr.Fields, tt.wantField, verr.Msg)
+			}
+		})
+	}
+}
+
+func TestDecodeRealityOnlyForVless(t *testing.T) {
+	_, err := protocol.Decode(protocol.Trojan, []byte(`{"server":"trojan.example.com","server_port":443,"password":"secret","tls":"reality"}`))
+	assert.Error(t, err)
+
+	_, err = protocol.Decode(protocol.Vmess, []byte(`{"add":"vmess.example.com","port":443,"id":"`+testUUID+`","tls":"reality"}`))
+	assert.Error(t, err)
+}
+
+func TestDecodeQUICProtocols(t *testing.T) {
+	const pin = "2e2f5bf05b4f4fbc8a2f3b0fea89b5f5b1ac6d54ce53e42a8ba4f9d6a8f4a3c9"
+	hy2 := `"server":"hy2.example.com","server_port":443,"password":"secret"`
+	tuic := `"server":"tuic.example.com","server_port":443,"uuid":"` + testUUID + `","password":"secret"`
+
+	tests := []struct {
+		name      string
+		protocol  string
+		data      string
+		wantField string
+	}{
+		{"hysteria2 minimal", protocol.Hysteria2, `{` + hy2 + `}`, ""},
+		{"hysteria2 full", protocol.Hysteria2, `{` + hy2 + `,"obfs":"salamander","obfs_password":"x","up_mbps":50,"down_mbps":200,"sni":"real.example.com","alpn":["h3"],"pin_sha256":"` + pin + `"}`, ""},
+		{"hysteria2 colon pin", protocol.Hysteria2, `{` + hy2 + `,"pin_sha256":"2E:2F:5B:F0:5B:4F:4F:BC:8A:2F:3B:0F:EA:89:B5:F5:B1:AC:6D:54:CE:53:E4:2A:8B:A4:F9:D6:A8:F4:A3:C9"}`, ""},
+		{"hysteria2 missing password", protocol.Hysteria2, `{"server":"hy2.example.com","server_port":443}`, "password"},
+		{"hysteria2 unknown obfs", protocol.Hysteria2, `{` + hy2 + `,"obfs":"xor","obfs_password":"x"}`, "obfs"},
+		{"hysteria2 salamander without password", protocol.Hysteria2, `{` + hy2 + `,"obfs":"salamander"}`, "obfs_password"},
+		{"hysteria2 obfs password without obfs", protocol.Hysteria2, `{` + hy2 + `,"obfs_password":"x"}`, "obfs_password"},
+		{"hysteria2 negative bandwidth", protocol.Hysteria2, `{` + hy2 + `,"up_mbps":-1}`, "up_mbps"},
+		{"hysteria2 short pin", protocol.Hysteria2, `{` + hy2 + `,"pin_sha256":"2e2f"}`, "pin_sha256"},
+		{"hysteria2 empty alpn", protocol.Hysteria2, `{` + hy2 + `,"alpn":[""]}`, "alpn"},
+
+		{"tuic minimal", protocol.TUIC, `{` + tuic + `}`, ""},
+		{"tuic full", protocol.TUIC, `{` + tuic + `,"congestion_control":"bbr","udp_relay_mode":"quic","alpn":["h3"],"insecure":true}`, ""},
+		{"tuic missing password", protocol.TUIC, `{"server":"tuic.example.com","server_port":443,"uuid":"` + testUUID + `"}`, "password"},
+		{"tuic unknown congestion control", protocol.TUIC, `{` + tuic + `,"congestion_control":"brutal"}`, "congestion_control"},
+		{"tuic unknown relay mode", protocol.TUIC, `{` + tuic + `,"udp_relay_mode":"tcp"}`, "udp_relay_mode"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := protocol.Decode(tt.protocol, []byte(tt.data))
+			if tt.wantField == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var verr *protocol.ValidationError
+			if assert.ErrorAs(t, err, &verr) {
+				assert.Contains(t, verr.Fields, tt.wantField, verr.Msg)
+			}
+		})
+	}
+}
+
+func TestDecodeUpstreamProtocols(t *testing.T) {
+	const (
+		secretKey = "K7gNU3sdo+OL0wNhqoVWhr3g6s1xYv72ol/pe/Unols="
+		publicKey = "L/wdBjh++Lt6NDErbGw/aRVQaEUIw85+44iTdaGNb6A="
+	)
+	proxy := `"server":"proxy.example.com","server_port":1080`
+	wg := `"server":"wg.example.com","server_port":51820,"secret_key":"` + secretKey + `","public_key":"` + publicKey + `"`
+
+	tests := []struct {
+		name      string
+		protocol  string
+		data      string
+		wantField string
+	}{
+		{"socks no auth", protocol.Socks, `{` + proxy + `}`, ""},
+		{"socks auth", protocol.Socks, `{` + proxy + `,"username":"alice","password":"secret"}`, ""},
+		{"socks missing port", protocol.Socks, `{"server":"proxy.example.com"}`, "server_port"},
+		{"socks username only", protocol.Socks, `{` + proxy + `,"username":"alice"}`, "password"},
+		{"socks long username", protocol.Socks, `{` + proxy + `,"username":"` + strings.Repeat("a", 256) + `","password":"secret"}`, "username"},
+
+		{"http no auth", protocol.HTTP, `{` + proxy + `}`, ""},
+		{"http auth", protocol.HTTP, `{` + proxy + `,"username":"alice","password":"p:ss"}`, ""},
+		{"http password only", protocol.HTTP, `{` + proxy + `,"password":"secret"}`, "username"},
+		{"http colon in username", protocol.HTTP, `{` + proxy + `,"username":"a:b","password":"secret"}`, "username"},
+
+		{"wireguard minimal", protocol.WireGuard, `{` + wg + `,"address":["10.0.0.2"]}`, ""},
+		{"wireguard full", protocol.WireGuard, `{` + wg + `,"pre_shared_key":"` + secretKey + `","address":["10.0.0.2/32","fd00::2/128"],"reserved":[0,255,7],"mtu":1420}`, ""},
+		{"wireguard missing address", protocol.WireGuard, `{` + wg + `}`, "address"},
+		{"wireguard bad secret key", protocol.WireGuard, `{"server":"wg.example.com","server_port":51820,"secret_key":"c2hvcnQ=","public_key":"` + publicKey + `","address":["10.0.0.2/32"]}`, "secret_key"},
+		{"wireguard url-safe public key", protocol.WireGuard, `{"server":"wg.example.com","server_port":51820,"secret_key":"` + secretKey + `","public_key":"L_wdBjh--Lt6NDErbGw_aRVQaEUIw85-44iTdaGNb6A=","address":["10.0.0.2/32"]}`, "public_key"},
+		{"wireguard bad pre-shared key", protocol.WireGuard, `{` + wg + `,"pre_shared_key":"nope","address":["10.0.0.2/32"]}`, "pre_shared_key"},
+		{"wireguard bad address", protocol.WireGuard, `{` + wg + `,"address":["10.0.0.300/32"]}`, "address"},
+		{"wireguard short reserved", protocol.WireGuard, `{` + wg + `,"address":["10.0.0.2/32"],"reserved":[1,2]}`, "reserved"},
+		{"wireguard reserved out of range", protocol.WireGuard, `{` + wg + `,"address":["10.0.0.2/32"],"reserved":[1,2,256]}`, "reserved"},
+		{"wireguard small mtu", protocol.WireGuard, `{` + wg + `,"address":["10.0.0.2/32"],"mtu":576}`, "mtu"},
+		{"wireguard large mtu", protocol.WireGuard, `{` + wg + `,"address":["10.0.0.2/32"],"mtu":65535}`, "mtu"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := protocol.Decode(tt.protocol, []byte(tt.data))
+			if tt.wantField == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var verr *protocol.ValidationError
+			if assert.ErrorAs(t, err, &verr) {
+				assert.Contains(t, verr.Fields, tt.wantField, verr.Msg)
+			}
+		})
+	}
+}
+
+func TestDecodeFieldErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		protocol   string
+		data       string
+		wantFields []string
+	}{
+		{"vmess numeric port", protocol.Vmess, `{"add":"vmess.example.com","port":443,"id":"` + testUUID + `"}`, nil},
+		{"vmess string port", protocol.Vmess, `{"add":"vmess.example.com","port":"443","id":"` + testUUID + `"}`, nil},
+		{"vmess ipv6 address", protocol.Vmess, `{"add":"2001:db8::1","port":443,"id":"` + testUUID + `"}`, nil},
+		{"vmess legacy ws path", protocol.Vmess, `{"add":"vmess.example.com","port":443,"id":"` + testUUID + `","net":"ws","path":"/ray"}`, nil},
+		{"vmess everything missing", protocol.Vmess, `{}`, []string{"add", "port", "id"}},
+		{"vmess bad uuid", protocol.Vmess, `{"add":"vmess.example.com","port":443,"id":"not-a-uuid"}`, []string{"id"}},
+		{"vmess port out of range", protocol.Vmess, `{"add":"vmess.example.com","port":"70000","id":"` + testUUID + `"}`, []string{"port"}},
+		{"vmess port not a number", protocol.Vmess, `{"add":"vmess.example.com","port":"https","id":"` + testUUID + `"}`, []string{"port"}},
+		{"vmess bad host", protocol.Vmess, `{"add":"bad host!","port":443,"id":"` + testUUID + `","host":"-cdn.example.com"}`, []string{"add", "host"}},
+		{"vmess malformed ipv4", protocol.Vmess, `{"add":"10.0.0.300","port":443,"id":"` + testUUID + `"}`, []string{"add"}},
+		{"vmess unknown net", protocol.Vmess, `{"add":"vmess.example.com","port":443,"id":"` + testUUID + `","net":"carrier-pigeon"}`, []string{"net"}},
+		{"vmess ws without path", protocol.Vmess, `{"add":"vmess.example.com","port":443,"id":"` + testUUID + `","net":"ws"}`, []string{"wsSettings.path"}},
+		{"vless relative ws path", protocol.Vless, `{"add":"vless.example.com","port":443,"id":"` + testUUID + `","net":"ws","wsSettings":{"path":"ray"}}`, []string{"wsSettings.path"}},
+		{"vless bad encryption", protocol.Vless, `{"add":"vless.example.com","port":443,"id":"` + testUUID + `","encryption":"auto"}`, []string{"encryption"}},
+		{"trojan bad sni and port", protocol.Trojan, `{"server":"trojan.example.com","server_port":70000,"password":"secret","sni":"under_score.example.com"}`, []string{"server_port", "sni"}},
+		{"ss stream cipher", protocol.Shadowsocks, `{"server":"ss.example.com","server_port":8388,"password":"secret","method":"aes-256-cfb"}`, []string{"method"}},
+		{"ss 2022 bad key length", protocol.Shadowsocks, `{"server":"ss.example.com","server_port":8388,"password":"c2hvcnQ=","method":"2022-blake3-aes-256-gcm"}`, []string{"password"}},
+		{"ss 2022 multi-user keys", protocol.Shadowsocks, `{"server":"ss.example.com","server_port":8388,"password":"AAAAAAAAAAAAAAAAAAAAAA==:AQEBAQEBAQEBAQEBAQEBAQ==","method":"2022-blake3-aes-128-gcm"}`, nil},
+		{"wrong json type", protocol.Trojan, `{"server":"trojan.example.com","server_port":"443","password":"secret"}`, []string{"server_port"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := protocol.Decode(tt.protocol, []byte(tt.data))
+			if tt.wantFields == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var verr *protocol.ValidationError
+			if assert.ErrorAs(t, err, &verr) {
+				for _, field := range tt.wantFields {
+					assert.Contains(t, verr.Fields, field, verr.Msg)
+				}
+				assert.Len(t, verr.Fields, len(tt.wantFields), "unexpected fields: %v", verr.Fields)
+			}
+		})
+	}
+}
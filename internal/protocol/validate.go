@@ -0,0 +1,416 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Networks lists the allowed values of TransportSettings.Network.
+var Networks = []string{"tcp", "kcp", "ws", "h2", "quic", "grpc"}
+
+// ShadowsocksMethods lists the accepted Shadowsocks ciphers. Only AEAD ciphers
+// are allowed; the stream ciphers are insecure and removed from current cores.
+var ShadowsocksMethods = []string{
+	"aes-128-gcm",
+	"aes-192-gcm",
+	"aes-256-gcm",
+	"chacha20-ietf-poly1305",
+	"chacha20-poly1305", // Xray's name for chacha20-ietf-poly1305
+	"xchacha20-ietf-poly1305",
+	"xchacha20-poly1305",
+	"2022-blake3-aes-128-gcm",
+	"2022-blake3-aes-256-gcm",
+	"2022-blake3-chacha20-poly1305",
+}
+
+// WireGuard MTU bounds: the IPv6 minimum link MTU up to jumbo frames.
+const (
+	minWireGuardMTU = 1280
+	maxWireGuardMTU = 9000
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// FieldErrors collects validation failures keyed by the JSON path of the
+// offending field, so that every problem is reported at once.
+type FieldErrors map[string]string
+
+// Add records msg for field unless an earlier check already failed it.
+func (f FieldErrors) Add(field, msg string) {
+	if _, ok := f[field]; !ok {
+		f[field] = msg
+	}
+}
+
+// required records a failure for an empty field and reports whether it was set.
+func (f FieldErrors) required(field, value string) bool {
+	if value == "" {
+		f.Add(field, "is required")
+		return false
+	}
+	return true
+}
+
+func (f FieldErrors) host(field, value string) {
+	if f.required(field, value) && !validHost(value) {
+		f.Add(field, "must be a hostname or IP address")
+	}
+}
+
+// optionalHost checks hostnames such as SNI that may be omitted.
+func (f FieldErrors) optionalHost(field, value string) {
+	if value != "" && !validHost(value) {
+		f.Add(field, "must be a hostname or IP address")
+	}
+}
+
+// port checks ports stored as a JSON number or string.
+func (f FieldErrors) port(field string, value any) {
+	if value == nil {
+		f.Add(field, "is required")
+		return
+	}
+	if _, err := ParsePort(value); err != nil {
+		f.Add(field, "must be a port between 1 and 65535")
+	}
+}
+
+func (f FieldErrors) portNumber(field string, value int) {
+	if value == 0 {
+		f.Add(field, "is required")
+	} else if value < 1 || value > 65535 {
+		f.Add(field, "must be a port between 1 and 65535")
+	}
+}
+
+func (f FieldErrors) uuid(field, value string) {
+	if f.required(field, value) && !uuidPattern.MatchString(value) {
+		f.Add(field, "must be a UUID")
+	}
+}
+
+// Err turns the collected failures into a *ValidationError whose message
+// starts with subject, such as "Invalid VMess config", or nil if there were none.
+func (f FieldErrors) Err(subject string) error {
+	switch len(f) {
+	case 0:
+		return nil
+	case 1:
+		for field, msg := range f {
+			return &ValidationError{Msg: fmt.Sprintf("%s: '%s' %s", subject, field, msg), Fields: f}
+		}
+	}
+	return &ValidationError{Msg: fmt.Sprintf("%s: %d fields failed validation", subject, len(f)), Fields: f}
+}
+
+// validHost reports whether s is an IP address or a syntactically valid DNS name.
+func validHost(s string) bool {
+	if _, err := netip.ParseAddr(s); err == nil {
+		return true
+	}
+	s = strings.TrimSuffix(s, ".")
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	labels := strings.Split(s, ".")
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	// An all-numeric top-level label means a malformed IPv4 address.
+	return strings.Trim(labels[len(labels)-1], "0123456789") != ""
+}
+
+// validate checks the transport fields. allowReality is only set for VLESS;
+// legacyPath is the v2rayN top-level path that VMess may use instead of
+// wsSettings.path.
+func (t TransportSettings) validate(f FieldErrors, allowReality bool, legacyPath string) {
+	if t.Network != "" && !slices.Contains(Networks, t.Network) {
+		f.Add("net", "must be one of "+strings.Join(Networks, ", "))
+	}
+
+	switch t.Security {
+	case "", SecurityNone, SecurityTLS:
+	case SecurityReality:
+		if !allowReality {
+			f.Add("tls", "reality is only supported by VLESS")
+		}
+	default:
+		if allowReality {
+			f.Add("tls", "must be none, tls or reality")
+		} else {
+			f.Add("tls", "must be none or tls")
+		}
+	}
+
+	if t.Network == "ws" {
+		path := t.WsSettings.Path
+		if path == "" {
+			path = legacyPath
+		}
+		if path == "" {
+			f.Add("wsSettings.path", "is required for the ws transport")
+		} else if !strings.HasPrefix(path, "/") {
+			f.Add("wsSettings.path", "must start with '/'")
+		}
+		f.optionalHost("wsSettings.headers.Host", t.WsSettings.Headers["Host"])
+	}
+}
+
+func (c VmessConfigData) validate() error {
+	f := FieldErrors{}
+	f.host("add", c.Add)
+	f.port("port", c.Port)
+	f.uuid("id", c.ID)
+	if c.Aid < 0 || c.Aid > 65535 {
+		f.Add("aid", "must be between 0 and 65535")
+	}
+	f.optionalHost("host", c.Host)
+	c.TransportSettings.validate(f, false, c.Path)
+	return f.Err("Invalid VMess config")
+}
+
+func (c VlessConfigData) validate() error {
+	f := FieldErrors{}
+	f.uuid("id", c.ID)
+	f.host("add", c.Address)
+	f.port("port", c.Port)
+	if c.Encryption != "" && c.Encryption != "none" {
+		f.Add("encryption", "must be none")
+	}
+	f.optionalHost("sni", c.SNI)
+	c.TransportSettings.validate(f, true, "")
+
+	if c.Security == SecurityReality {
+		c.RealitySettings.validate(f)
+	} else if c.RealitySettings != (RealitySettings{}) {
+		f.Add("realitySettings", "requires 'tls' to be 'reality'")
+	}
+
+	switch c.Flow {
+	case "":
+	case FlowVision, FlowVisionUDP443:
+		if c.Network != "" && c.Network != "tcp" {
+			f.Add("flow", "requires the tcp transport")
+		} else if c.Security != SecurityTLS && c.Security != SecurityReality {
+			f.Add("flow", "requires tls or reality security")
+		}
+	default:
+		f.Add("flow", fmt.Sprintf("must be %s or %s", FlowVision, FlowVisionUDP443))
+	}
+	return f.Err("Invalid VLESS config")
+}
+
+func (r RealitySettings) validate(f FieldErrors) {
+	if f.required("realitySettings.serverName", r.ServerName) && !validHost(r.ServerName) {
+		f.Add("realitySettings.serverName", "must be a hostname")
+	}
+	if f.required("realitySettings.publicKey", r.PublicKey) {
+		if key, err := base64.RawURLEncoding.DecodeString(r.PublicKey); err != nil || len(key) != 32 {
+			f.Add("realitySettings.publicKey", "must be a 32-byte x25519 key in unpadded base64url")
+		}
+	}
+	if _, err := hex.DecodeString(r.ShortID); err != nil || len(r.ShortID) > 16 {
+		f.Add("realitySettings.shortId", "must be an even number of hex digits, at most 16")
+	}
+	if r.Fingerprint != "" && !slices.Contains(Fingerprints, r.Fingerprint) {
+		f.Add("realitySettings.fingerprint", "must be one of "+strings.Join(Fingerprints, ", "))
+	}
+	if r.SpiderX != "" && !strings.HasPrefix(r.SpiderX, "/") {
+		f.Add("realitySettings.spiderX", "must be a path starting with '/'")
+	}
+}
+
+func (c ShadowsocksConfigData) validate() error {
+	f := FieldErrors{}
+	f.host("server", c.Server)
+	f.portNumber("server_port", c.ServerPort)
+	f.required("password", c.Password)
+	if f.required("method", c.Method) && !slices.Contains(ShadowsocksMethods, c.Method) {
+		f.Add("method", "must be an AEAD cipher: "+strings.Join(ShadowsocksMethods, ", "))
+	}
+
+	// Shadowsocks 2022 passwords are base64 keys of the cipher's key size,
+	// joined with ':' for multi-user servers.
+	if strings.HasPrefix(c.Method, "2022-") && c.Password != "" {
+		size := 32
+		if c.Method == "2022-blake3-aes-128-gcm" {
+			size = 16
+		}
+		for _, part := range strings.Split(c.Password, ":") {
+			if key, err := base64.StdEncoding.DecodeString(part); err != nil || len(key) != size {
+				f.Add("password", fmt.Sprintf("must be a base64 %d-byte key for %s", size, c.Method))
+			}
+		}
+	}
+	return f.Err("Invalid Shadowsocks config")
+}
+
+func (c TrojanConfigData) validate() error {
+	f := FieldErrors{}
+	f.host("server", c.Server)
+	f.portNumber("server_port", c.ServerPort)
+	f.required("password", c.Password)
+	f.optionalHost("sni", c.SNI)
+	c.TransportSettings.validate(f, false, "")
+	return f.Err("Invalid Trojan config")
+}
+
+func (c Hysteria2ConfigData) validate() error {
+	f := FieldErrors{}
+	f.host("server", c.Server)
+	f.portNumber("server_port", c.ServerPort)
+	f.required("password", c.Password)
+	switch c.Obfs {
+	case "":
+		if c.ObfsPassword != "" {
+			f.Add("obfs_password", "requires 'obfs'")
+		}
+	case "salamander":
+		f.required("obfs_password", c.ObfsPassword)
+	default:
+		f.Add("obfs", "must be salamander")
+	}
+	if c.UpMbps < 0 {
+		f.Add("up_mbps", "must not be negative")
+	}
+	if c.DownMbps < 0 {
+		f.Add("down_mbps", "must not be negative")
+	}
+	c.QUICTLSSettings.validate(f)
+	return f.Err("Invalid Hysteria2 config")
+}
+
+func (c TUICConfigData) validate() error {
+	f := FieldErrors{}
+	f.host("server", c.Server)
+	f.portNumber("server_port", c.ServerPort)
+	f.uuid("uuid", c.UUID)
+	f.required("password", c.Password)
+	switch c.CongestionControl {
+	case "", "cubic", "new_reno", "bbr":
+	default:
+		f.Add("congestion_control", "must be cubic, new_reno or bbr")
+	}
+	switch c.UDPRelayMode {
+	case "", "native", "quic":
+	default:
+		f.Add("udp_relay_mode", "must be native or quic")
+	}
+	c.QUICTLSSettings.validate(f)
+	return f.Err("Invalid TUIC config")
+}
+
+func (t QUICTLSSettings) validate(f FieldErrors) {
+	f.optionalHost("sni", t.SNI)
+	for _, proto := range t.ALPN {
+		if proto == "" || len(proto) > 255 {
+			f.Add("alpn", "entries must be between 1 and 255 bytes")
+		}
+	}
+	if t.PinSHA256 != "" {
+		if pin, err := hex.DecodeString(strings.ReplaceAll(t.PinSHA256, ":", "")); err != nil || len(pin) != 32 {
+			f.Add("pin_sha256", "must be a SHA-256 hash in hex")
+		}
+	}
+}
+
+func (c SocksConfigData) validate() error {
+	f := FieldErrors{}
+	f.host("server", c.Server)
+	f.portNumber("server_port", c.ServerPort)
+	// RFC 1929 username/password authentication.
+	validateProxyAuth(f, c.Username, c.Password)
+	if len(c.Username) > 255 {
+		f.Add("username", "must be at most 255 bytes")
+	}
+	if len(c.Password) > 255 {
+		f.Add("password", "must be at most 255 bytes")
+	}
+	return f.Err("Invalid SOCKS config")
+}
+
+func (c HTTPConfigData) validate() error {
+	f := FieldErrors{}
+	f.host("server", c.Server)
+	f.portNumber("server_port", c.ServerPort)
+	validateProxyAuth(f, c.Username, c.Password)
+	// Basic authentication joins the two with a colon.
+	if strings.Contains(c.Username, ":") {
+		f.Add("username", "must not contain ':'")
+	}
+	return f.Err("Invalid HTTP proxy config")
+}
+
+func validateProxyAuth(f FieldErrors, username, password string) {
+	if username == "" && password != "" {
+		f.Add("username", "is required when 'password' is set")
+	}
+	if password == "" && username != "" {
+		f.Add("password", "is required when 'username' is set")
+	}
+}
+
+func (c WireGuardConfigData) validate() error {
+	f := FieldErrors{}
+	f.host("server", c.Server)
+	f.portNumber("server_port", c.ServerPort)
+
+	keys := []struct {
+		field, value string
+		required     bool
+	}{
+		{"secret_key", c.SecretKey, true},
+		{"public_key", c.PublicKey, true},
+		{"pre_shared_key", c.PreSharedKey, false},
+	}
+	for _, key := range keys {
+		if key.value == "" {
+			if key.required {
+				f.Add(key.field, "is required")
+			}
+			continue
+		}
+		if k, err := base64.StdEncoding.DecodeString(key.value); err != nil || len(k) != 32 {
+			f.Add(key.field, "must be a 32-byte key in base64")
+		}
+	}
+
+	if len(c.Address) == 0 {
+		f.Add("address", "is required")
+	}
+	for _, addr := range c.Address {
+		if _, err := netip.ParsePrefix(addr); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(addr); err != nil {
+			f.Add("address", fmt.Sprintf("%q is not an IP address or CIDR prefix", addr))
+		}
+	}
+
+	if len(c.Reserved) != 0 {
+		if len(c.Reserved) != 3 {
+			f.Add("reserved", "must have exactly 3 bytes")
+		}
+		for _, b := range c.Reserved {
+			if b < 0 || b > 255 {
+				f.Add("reserved", "values must be between 0 and 255")
+			}
+		}
+	}
+	if c.MTU != 0 && (c.MTU < minWireGuardMTU || c.MTU > maxWireGuardMTU) {
+		f.Add("mtu", fmt.Sprintf("must be between %d and %d", minWireGuardMTU, maxWireGuardMTU))
+	}
+	return f.Err("Invalid WireGuard config")
+}
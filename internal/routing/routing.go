@@ -0,0 +1,178 @@
+// Package routing validates the user-defined routing rules that are merged into
+// the generated V2Ray document.
+package routing
+
+import (
+	"fmt"
+	"k2ray/internal/generator"
+	"k2ray/internal/protocol"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Domain matcher prefixes. A value without a prefix is a keyword match.
+const (
+	MatchDomain  = "domain"
+	MatchFull    = "full"
+	MatchKeyword = "keyword"
+	MatchRegexp  = "regexp"
+	MatchGeosite = "geosite"
+)
+
+// Matchers lists the accepted domain matcher prefixes.
+var Matchers = []string{MatchDomain, MatchFull, MatchKeyword, MatchRegexp, MatchGeosite}
+
+// Networks lists the accepted values of a rule's network list.
+var Networks = []string{"tcp", "udp"}
+
+// Protocols lists the sniffed protocols a rule can match on.
+var Protocols = []string{"http", "tls", "quic", "bittorrent"}
+
+// InboundTags lists the inbounds of the generated document.
+var InboundTags = []string{generator.TagSocksIn, generator.TagHTTPIn, generator.TagDokodemoIn}
+
+// OutboundTags lists the outbounds a rule can send traffic to.
+var OutboundTags = []string{generator.TagProxy, generator.TagDirect, generator.TagBlock}
+
+var (
+	domainPattern = regexp.MustCompile(`^(?i)[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?(\.[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?)*\.?$`)
+	// Site lists are named like "category-ads-all" or "geolocation-!cn" and
+	// may select an attribute with "@", as in "google@cn".
+	geositePattern = regexp.MustCompile(`^(?i)[a-z0-9!._-]+(@[a-z0-9!._-]+)?$`)
+	geoipPattern   = regexp.MustCompile(`^(?i)!?[a-z0-9_-]+$`)
+)
+
+// Validate checks the syntax of every condition of rule and that its tags name
+// parts of the generated document. Failures are returned as
+// *protocol.ValidationError with one entry per invalid field.
+func Validate(rule generator.RoutingRule) error {
+	f := protocol.FieldErrors{}
+
+	if len(rule.Domain) == 0 && len(rule.IP) == 0 && rule.Port == "" && rule.Network == "" &&
+		len(rule.Protocol) == 0 && len(rule.InboundTag) == 0 {
+		return &protocol.ValidationError{Msg: "Invalid routing rule: it must match on at least one of domain, ip, port, network, protocol or inbound_tag"}
+	}
+
+	for i, domain := range rule.Domain {
+		if msg := checkDomain(domain); msg != "" {
+			f.Add(fmt.Sprintf("domain[%d]", i), msg)
+		}
+	}
+	for i, ip := range rule.IP {
+		if msg := checkIP(ip); msg != "" {
+			f.Add(fmt.Sprintf("ip[%d]", i), msg)
+		}
+	}
+	if rule.Port != "" && !validPortList(rule.Port) {
+		f.Add("port", `must be a comma-separated list of ports and ranges, e.g. "53,443,1000-2000"`)
+	}
+	if rule.Network != "" && !validList(strings.Split(rule.Network, ","), Networks) {
+		f.Add("network", "must be tcp, udp or tcp,udp")
+	}
+	if !validList(rule.Protocol, Protocols) {
+		f.Add("protocol", "must only contain "+strings.Join(Protocols, ", "))
+	}
+	if !validList(rule.InboundTag, InboundTags) {
+		f.Add("inbound_tag", "must only contain "+strings.Join(InboundTags, ", "))
+	}
+	if rule.OutboundTag == "" {
+		f.Add("outbound_tag", "is required")
+	} else if !slices.Contains(OutboundTags, rule.OutboundTag) {
+		f.Add("outbound_tag", "must be one of "+strings.Join(OutboundTags, ", "))
+	}
+
+	return f.Err("Invalid routing rule")
+}
+
+// checkDomain returns what is wrong with a domain condition, or "" if it is valid.
+func checkDomain(value string) string {
+	matcher, pattern, found := strings.Cut(value, ":")
+	if !found {
+		matcher, pattern = MatchKeyword, value
+	}
+	if pattern == "" {
+		return "must not be empty"
+	}
+	switch matcher {
+	case MatchDomain, MatchFull:
+		if !domainPattern.MatchString(pattern) {
+			return fmt.Sprintf("'%s:' must be followed by a domain name", matcher)
+		}
+	case MatchKeyword:
+		if strings.ContainsFunc(pattern, isSpace) {
+			return "must not contain whitespace"
+		}
+	case MatchRegexp:
+		if _, err := regexp.Compile(pattern); err != nil {
+			return "is not a valid regular expression: " + err.Error()
+		}
+	case MatchGeosite:
+		if !geositePattern.MatchString(pattern) {
+			return "'geosite:' must be followed by a site list name, e.g. geosite:cn"
+		}
+	default:
+		return fmt.Sprintf("has unknown matcher '%s:'; use one of %s", matcher, strings.Join(Matchers, ", "))
+	}
+	return ""
+}
+
+// checkIP returns what is wrong with an ip condition, or "" if it is valid.
+func checkIP(value string) string {
+	if code, ok := strings.CutPrefix(value, "geoip:"); ok {
+		if !geoipPattern.MatchString(code) {
+			return "'geoip:' must be followed by a country code or list name, e.g. geoip:cn"
+		}
+		return ""
+	}
+	if _, err := netip.ParsePrefix(value); err == nil {
+		return ""
+	}
+	if _, err := netip.ParseAddr(value); err == nil {
+		return ""
+	}
+	return "must be an IP address, a CIDR or geoip:<code>"
+}
+
+// validPortList reports whether value is a comma-separated list of ports and
+// port ranges such as "53,443,1000-2000".
+func validPortList(value string) bool {
+	for _, part := range strings.Split(value, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		first, err := parsePort(from)
+		if err != nil {
+			return false
+		}
+		if isRange {
+			last, err := parsePort(to)
+			if err != nil || last < first {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// validList reports whether values holds distinct members of allowed.
+func validList(values, allowed []string) bool {
+	for i, v := range values {
+		if !slices.Contains(allowed, v) || slices.Contains(values[:i], v) {
+			return false
+		}
+	}
+	return true
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
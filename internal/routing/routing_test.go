@@ -0,0 +1,60 @@
+package routing_test
+
+import (
+	"k2ray/internal/generator"
+	"k2ray/internal/protocol"
+	"k2ray/internal/routing"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      generator.RoutingRule
+		wantField string
+	}{
+		{"domain matchers", generator.RoutingRule{Domain: []string{"google", "domain:example.com", "full:www.example.com", "keyword:ads", `regexp:\.cn$`, "geosite:category-ads-all", "geosite:geolocation-!cn", "geosite:google@cn"}, OutboundTag: "direct"}, ""},
+		{"ips", generator.RoutingRule{IP: []string{"geoip:cn", "geoip:!cn", "geoip:private", "10.0.0.0/8", "1.1.1.1", "2001:db8::/32"}, OutboundTag: "block"}, ""},
+		{"ports and networks", generator.RoutingRule{Port: "53,443, 1000-2000", Network: "tcp,udp", OutboundTag: "proxy"}, ""},
+		{"protocols and inbounds", generator.RoutingRule{Protocol: []string{"bittorrent"}, InboundTag: []string{"socks-in", "http-in"}, OutboundTag: "direct"}, ""},
+
+		{"unknown matcher", generator.RoutingRule{Domain: []string{"ext:site.dat:cn"}, OutboundTag: "direct"}, "domain[0]"},
+		{"bad domain", generator.RoutingRule{Domain: []string{"domain:exa mple.com"}, OutboundTag: "direct"}, "domain[0]"},
+		{"empty full", generator.RoutingRule{Domain: []string{"example.com", "full:"}, OutboundTag: "direct"}, "domain[1]"},
+		{"bad regexp", generator.RoutingRule{Domain: []string{"regexp:(unclosed"}, OutboundTag: "direct"}, "domain[0]"},
+		{"bad geosite", generator.RoutingRule{Domain: []string{"geosite:"}, OutboundTag: "direct"}, "domain[0]"},
+		{"keyword with spaces", generator.RoutingRule{Domain: []string{"two words"}, OutboundTag: "direct"}, "domain[0]"},
+		{"bad ip", generator.RoutingRule{IP: []string{"10.0.0.0/33"}, OutboundTag: "direct"}, "ip[0]"},
+		{"bad geoip", generator.RoutingRule{IP: []string{"geoip:"}, OutboundTag: "direct"}, "ip[0]"},
+		{"bad port", generator.RoutingRule{Port: "0", OutboundTag: "direct"}, "port"},
+		{"reversed range", generator.RoutingRule{Port: "2000-1000", OutboundTag: "direct"}, "port"},
+		{"bad network", generator.RoutingRule{Network: "tcp,tcp", OutboundTag: "direct"}, "network"},
+		{"bad protocol", generator.RoutingRule{Protocol: []string{"ftp"}, OutboundTag: "direct"}, "protocol"},
+		{"bad inbound", generator.RoutingRule{InboundTag: []string{"api"}, OutboundTag: "direct"}, "inbound_tag"},
+		{"missing outbound", generator.RoutingRule{Port: "53"}, "outbound_tag"},
+		{"unknown outbound", generator.RoutingRule{Port: "53", OutboundTag: "tor"}, "outbound_tag"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := routing.Validate(tt.rule)
+			if tt.wantField == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var verr *protocol.ValidationError
+			if assert.ErrorAs(t, err, &verr) {
+				assert.Contains(t, verr.Fields, tt.wantField, verr.Msg)
+			}
+		})
+	}
+
+	t.Run("no conditions", func(t *testing.T) {
+		var verr *protocol.ValidationError
+		if assert.ErrorAs(t, routing.Validate(generator.RoutingRule{OutboundTag: "direct"}), &verr) {
+			assert.Empty(t, verr.Fields)
+		}
+	})
+}
@@ -27,9 +27,60 @@ const (
 	UserDeleted AuditEventType = "USER_DELETED"
 
 	// Config Management Events
-	ConfigCreated AuditEventType = "CONFIG_CREATED"
-	ConfigUpdated AuditEventType = "CONFIG_UPDATED"
-	ConfigDeleted AuditEventType = "CONFIG_DELETED"
+	ConfigCreated  AuditEventType = "CONFIG_CREATED"
+	ConfigUpdated  AuditEventType = "CONFIG_UPDATED"
+	ConfigDeleted  AuditEventType = "CONFIG_DELETED"
+	ConfigShared   AuditEventType = "CONFIG_SHARED"
+	ConfigUnshared AuditEventType = "CONFIG_UNSHARED"
+
+	// Config Organization Events
+	GroupCreated AuditEventType = "GROUP_CREATED"
+	GroupUpdated AuditEventType = "GROUP_UPDATED"
+	GroupDeleted AuditEventType = "GROUP_DELETED"
+	TagDeleted   AuditEventType = "TAG_DELETED"
+
+	// User Group Events
+	UserGroupCreated AuditEventType = "USER_GROUP_CREATED"
+	UserGroupUpdated AuditEventType = "USER_GROUP_UPDATED"
+	UserGroupDeleted AuditEventType = "USER_GROUP_DELETED"
+
+	// Config Template Events
+	TemplateCreated AuditEventType = "TEMPLATE_CREATED"
+	TemplateUpdated AuditEventType = "TEMPLATE_UPDATED"
+	TemplateDeleted AuditEventType = "TEMPLATE_DELETED"
+
+	// Balancer Events
+	BalancerCreated AuditEventType = "BALANCER_CREATED"
+	BalancerUpdated AuditEventType = "BALANCER_UPDATED"
+	BalancerDeleted AuditEventType = "BALANCER_DELETED"
+
+	// Routing Rule Events
+	RoutingRuleCreated    AuditEventType = "ROUTING_RULE_CREATED"
+	RoutingRuleUpdated    AuditEventType = "ROUTING_RULE_UPDATED"
+	RoutingRuleDeleted    AuditEventType = "ROUTING_RULE_DELETED"
+	RoutingRulesReordered AuditEventType = "ROUTING_RULES_REORDERED"
+
+	// Failover Events
+	FailoverPolicyUpdated AuditEventType = "FAILOVER_POLICY_UPDATED"
+	FailoverSwitched      AuditEventType = "FAILOVER_SWITCHED"
+
+	// GeoIP/GeoSite Data Events
+	GeoDataUpdated AuditEventType = "GEODATA_UPDATED"
+
+	// Subscription Events
+	SubscriptionCreated      AuditEventType = "SUBSCRIPTION_CREATED"
+	SubscriptionUpdated      AuditEventType = "SUBSCRIPTION_UPDATED"
+	SubscriptionDeleted      AuditEventType = "SUBSCRIPTION_DELETED"
+	SubscriptionRefreshed    AuditEventType = "SUBSCRIPTION_REFRESHED"
+	SubscriptionTokenRotated AuditEventType = "SUBSCRIPTION_TOKEN_ROTATED"
+	SubscriptionTokenRevoked AuditEventType = "SUBSCRIPTION_TOKEN_REVOKED"
+
+	// V2Ray Process Events
+	V2RayStarted      AuditEventType = "V2RAY_STARTED"
+	V2RayStopped      AuditEventType = "V2RAY_STOPPED"
+	V2RayCrashed      AuditEventType = "V2RAY_CRASHED"
+	V2RayRestarted    AuditEventType = "V2RAY_RESTARTED"
+	V2RayCrashLooping AuditEventType = "V2RAY_CRASH_LOOPING"
 )
 
 // AuditEvent represents a security-sensitive event that should be logged.
@@ -44,13 +95,18 @@ type AuditEvent struct {
 
 // LogEvent creates and logs a new audit event.
 // It's designed to be called from within a Gin context to automatically capture IP and UserID.
+// Background jobs that act on behalf of the system may pass a nil context.
 func LogEvent(c *gin.Context, eventType AuditEventType, targetID int64, details string) {
 	var userID int64
-	// Try to get UserID from the context (it will be present for authenticated routes).
-	if id, exists := c.Get("user_id"); exists {
-		if u, ok := id.(int64); ok {
-			userID = u
+	clientIP := "system"
+	if c != nil {
+		// Try to get UserID from the context (it will be present for authenticated routes).
+		if id, exists := c.Get("user_id"); exists {
+			if u, ok := id.(int64); ok {
+				userID = u
+			}
 		}
+		clientIP = c.ClientIP()
 	}
 
 	event := AuditEvent{
@@ -58,7 +114,7 @@ func LogEvent(c *gin.Context, eventType AuditEventType, targetID int64, details
 		Type:      eventType,
 		UserID:    userID,
 		TargetID:  targetID,
-		ClientIP:  c.ClientIP(),
+		ClientIP:  clientIP,
 		Details:   details,
 	}
 
@@ -83,4 +139,4 @@ func (e AuditEvent) MarshalZerologObject(ze *zerolog.Event) {
 	if e.Details != "" {
 		ze.Str("details", e.Details)
 	}
-}
\ No newline at end of file
+}
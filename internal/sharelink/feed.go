@@ -0,0 +1,36 @@
+package sharelink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"strings"
+)
+
+// DecodeFeed splits a subscription feed into its share links. Feeds are usually
+// the base64 encoding of one link per line, but some providers serve the plain
+// list, so both are accepted.
+func DecodeFeed(body []byte) []string {
+	content := bytes.TrimSpace(body)
+	if !bytes.Contains(content, []byte("://")) {
+		compact := strings.Join(strings.Fields(string(content)), "")
+		if decoded, err := decodeBase64(compact); err == nil {
+			content = decoded
+		}
+	}
+
+	var links []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			links = append(links, line)
+		}
+	}
+	return links
+}
+
+// EncodeFeed renders links as a standard base64 subscription feed.
+func EncodeFeed(links []string) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n")))
+}
@@ -0,0 +1,171 @@
+package sharelink
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"k2ray/internal/protocol"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Format renders a stored configuration as its canonical share link. The output
+// is stable for a given configuration, and Parse(Format(...)) yields the same
+// config_data for configurations that were themselves imported from a link.
+func Format(name, protocolName string, configData []byte) (string, error) {
+	data, err := protocol.Decode(protocolName, configData)
+	if err != nil {
+		return "", err
+	}
+
+	switch c := data.(type) {
+	case *protocol.VmessConfigData:
+		return formatVmess(name, c)
+	case *protocol.VlessConfigData:
+		port, err := protocol.ParsePort(c.Port)
+		if err != nil {
+			return "", err
+		}
+		q := transportQuery(c.TransportSettings)
+		setIfNotEmpty(q, "encryption", c.Encryption)
+		setIfNotEmpty(q, "flow", c.Flow)
+		setIfNotEmpty(q, "sni", c.SNI)
+		if c.Security == protocol.SecurityReality {
+			r := c.RealitySettings
+			setIfNotEmpty(q, "sni", r.ServerName)
+			setIfNotEmpty(q, "fp", r.Fingerprint)
+			setIfNotEmpty(q, "pbk", r.PublicKey)
+			setIfNotEmpty(q, "sid", r.ShortID)
+			setIfNotEmpty(q, "spx", r.SpiderX)
+		}
+		return formatURL("vless", url.User(c.ID), c.Address, port, q, name), nil
+	case *protocol.TrojanConfigData:
+		q := transportQuery(c.TransportSettings)
+		setIfNotEmpty(q, "sni", c.SNI)
+		return formatURL("trojan", url.User(c.Password), c.Server, c.ServerPort, q, name), nil
+	case *protocol.ShadowsocksConfigData:
+		return formatShadowsocks(name, c), nil
+	case *protocol.Hysteria2ConfigData:
+		q := quicTLSQuery(c.QUICTLSSettings, "insecure")
+		setIfNotEmpty(q, "obfs", c.Obfs)
+		setIfNotEmpty(q, "obfs-password", c.ObfsPassword)
+		return formatURL("hysteria2", url.User(c.Password), c.Server, c.ServerPort, q, name), nil
+	case *protocol.TUICConfigData:
+		q := quicTLSQuery(c.QUICTLSSettings, "allow_insecure")
+		setIfNotEmpty(q, "congestion_control", c.CongestionControl)
+		setIfNotEmpty(q, "udp_relay_mode", c.UDPRelayMode)
+		return formatURL("tuic", url.UserPassword(c.UUID, c.Password), c.Server, c.ServerPort, q, name), nil
+	default:
+		return "", fmt.Errorf("share links are not supported for protocol %q", protocolName)
+	}
+}
+
+func formatVmess(name string, c *protocol.VmessConfigData) (string, error) {
+	port, err := protocol.ParsePort(c.Port)
+	if err != nil {
+		return "", err
+	}
+
+	v := c.V
+	if v == "" {
+		v = "2"
+	}
+	host, path := c.Host, c.Path
+	if host == "" {
+		host = c.WsSettings.Headers["Host"]
+	}
+	if path == "" {
+		path = c.WsSettings.Path
+	}
+	if c.Network == "grpc" {
+		path = c.GrpcSettings.ServiceName
+	}
+
+	// The field order matches the v2rayN exporter; every value is a string.
+	link := struct {
+		V    string `json:"v"`
+		Ps   string `json:"ps"`
+		Add  string `json:"add"`
+		Port string `json:"port"`
+		ID   string `json:"id"`
+		Aid  string `json:"aid"`
+		Net  string `json:"net"`
+		Type string `json:"type"`
+		Host string `json:"host"`
+		Path string `json:"path"`
+		TLS  string `json:"tls"`
+	}{v, name, c.Add, strconv.Itoa(port), c.ID, strconv.Itoa(c.Aid), c.Network, c.Type, host, path, c.Security}
+
+	raw, err := json.Marshal(link)
+	if err != nil {
+		return "", err
+	}
+	return "vmess://" + base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// formatShadowsocks renders a SIP002 link. SIP002 requires percent-encoded
+// userinfo for the 2022 ciphers and allows base64url for everything else.
+func formatShadowsocks(name string, c *protocol.ShadowsocksConfigData) string {
+	u := url.URL{
+		Scheme:   "ss",
+		Host:     net.JoinHostPort(c.Server, strconv.Itoa(c.ServerPort)),
+		Fragment: name,
+	}
+	if strings.HasPrefix(c.Method, "2022-") {
+		u.User = url.UserPassword(c.Method, c.Password)
+	} else {
+		u.User = url.User(base64.RawURLEncoding.EncodeToString([]byte(c.Method + ":" + c.Password)))
+	}
+	if c.Plugin != "" {
+		u.Path = "/"
+		u.RawQuery = url.Values{"plugin": {c.Plugin}}.Encode()
+	}
+	return u.String()
+}
+
+func formatURL(scheme string, user *url.Userinfo, host string, port int, q url.Values, name string) string {
+	u := url.URL{
+		Scheme:   scheme,
+		User:     user,
+		Host:     net.JoinHostPort(host, strconv.Itoa(port)),
+		RawQuery: q.Encode(),
+		Fragment: name,
+	}
+	return u.String()
+}
+
+// transportQuery is the inverse of transportFromQuery.
+func transportQuery(t protocol.TransportSettings) url.Values {
+	q := url.Values{}
+	setIfNotEmpty(q, "type", t.Network)
+	setIfNotEmpty(q, "security", t.Security)
+	switch t.Network {
+	case "ws":
+		setIfNotEmpty(q, "path", t.WsSettings.Path)
+		setIfNotEmpty(q, "host", t.WsSettings.Headers["Host"])
+	case "grpc":
+		setIfNotEmpty(q, "serviceName", t.GrpcSettings.ServiceName)
+	}
+	return q
+}
+
+// quicTLSQuery renders the TLS options of hysteria2:// and tuic:// links, which
+// disagree on the name of the insecure flag.
+func quicTLSQuery(t protocol.QUICTLSSettings, insecureKey string) url.Values {
+	q := url.Values{}
+	setIfNotEmpty(q, "sni", t.SNI)
+	if t.Insecure {
+		q.Set(insecureKey, "1")
+	}
+	setIfNotEmpty(q, "alpn", strings.Join(t.ALPN, ","))
+	setIfNotEmpty(q, "pinSHA256", t.PinSHA256)
+	return q
+}
+
+func setIfNotEmpty(q url.Values, key, value string) {
+	if value != "" {
+		q.Set(key, value)
+	}
+}
@@ -0,0 +1,77 @@
+package sharelink_test
+
+import (
+	"encoding/json"
+	"k2ray/internal/protocol"
+	"k2ray/internal/sharelink"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		protocol   string
+		configData string
+		wantPrefix string
+	}{
+		{"vmess ws", protocol.Vmess, `{"v":"2","add":"vmess.example.com","port":443,"id":"` + testUUID + `","aid":0,"type":"none","host":"cdn.example.com","path":"/ws","net":"ws","tls":"tls"}`, "vmess://"},
+		{"vmess grpc", protocol.Vmess, `{"v":"2","add":"vmess.example.com","port":443,"id":"` + testUUID + `","aid":0,"net":"grpc","tls":"tls","grpcSettings":{"serviceName":"svc"}}`, "vmess://"},
+		{"vless ws", protocol.Vless, `{"id":"` + testUUID + `","add":"vless.example.com","port":443,"encryption":"none","sni":"sni.example.com","net":"ws","tls":"tls","wsSettings":{"path":"/ws?ed=2048","headers":{"Host":"cdn.example.com"}}}`, "vless://"},
+		{"vless reality vision", protocol.Vless, `{"id":"` + testUUID + `","add":"vless.example.com","port":443,"encryption":"none","flow":"xtls-rprx-vision","realitySettings":{"serverName":"www.microsoft.com","fingerprint":"chrome","publicKey":"uWhDlWI62pIgIWKYqjhL55xvC6_2CtcG8yDprrqtxDk","shortId":"6ba85179e30d4fc2","spiderX":"/"},"net":"tcp","tls":"reality"}`, "vless://"},
+		{"vless ipv6 grpc", protocol.Vless, `{"id":"` + testUUID + `","add":"2001:db8::1","port":443,"net":"grpc","tls":"tls","grpcSettings":{"serviceName":"tunnel"}}`, "vless://"},
+		{"trojan", protocol.Trojan, `{"server":"trojan.example.com","server_port":443,"password":"p@ss/w:rd","sni":"trojan.example.com","net":"tcp","tls":"tls"}`, "trojan://"},
+		{"shadowsocks plugin", protocol.Shadowsocks, `{"server":"ss.example.com","server_port":8388,"password":"secret","method":"chacha20-ietf-poly1305","plugin":"obfs-local;obfs=http"}`, "ss://"},
+		{"hysteria2", protocol.Hysteria2, `{"server":"hy2.example.com","server_port":443,"password":"user:pass","obfs":"salamander","obfs_password":"cry_me_a_r1ver","sni":"real.example.com","insecure":true,"alpn":["h3"],"pin_sha256":"06:29:84:32:e8:06:6b:29:e2:22:3b:cc:23:aa:95:04:b5:6a:e5:08:fa:bf:34:35:50:88:69:b9:c3:19:0e:22"}`, "hysteria2://"},
+		{"tuic", protocol.TUIC, `{"server":"tuic.example.com","server_port":443,"uuid":"` + testUUID + `","password":"secret","congestion_control":"bbr","udp_relay_mode":"native","sni":"tuic.example.com","alpn":["h3","spdy/3.1"]}`, "tuic://"},
+		{"shadowsocks 2022", protocol.Shadowsocks, `{"server":"ss.example.com","server_port":8388,"password":"YctPZ6U7xPPcU+gp3u+0tx/tRizJN9K8y+uKlW2qjlI=","method":"2022-blake3-aes-256-gcm"}`, "ss://"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := "Round Trip #" + tt.name
+			link, err := sharelink.Format(name, tt.protocol, []byte(tt.configData))
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(link, tt.wantPrefix), link)
+
+			parsed, err := sharelink.Parse(link)
+			require.NoError(t, err)
+			assert.Equal(t, name, parsed.Name)
+			assert.Equal(t, tt.protocol, parsed.Protocol)
+
+			data, err := json.Marshal(parsed.ConfigData)
+			require.NoError(t, err)
+			assert.Equal(t, tt.configData, string(data))
+
+			again, err := sharelink.Format(name, parsed.Protocol, data)
+			require.NoError(t, err)
+			assert.Equal(t, link, again)
+		})
+	}
+}
+
+func TestFormatVmessLegacyFields(t *testing.T) {
+	// Stored configs that carry the ws options in wsSettings and a string port
+	// still export a standard v2rayN link.
+	link, err := sharelink.Format("Legacy", protocol.Vmess, []byte(`{"add":"vmess.example.com","port":"443","id":"`+testUUID+`","net":"ws","wsSettings":{"path":"/ws","headers":{"Host":"cdn.example.com"}}}`))
+	require.NoError(t, err)
+
+	parsed, err := sharelink.Parse(link)
+	require.NoError(t, err)
+	data := parsed.ConfigData.(*protocol.VmessConfigData)
+	assert.Equal(t, "2", data.V)
+	assert.Equal(t, 443, data.Port)
+	assert.Equal(t, "/ws", data.Path)
+	assert.Equal(t, "cdn.example.com", data.Host)
+}
+
+func TestFormatErrors(t *testing.T) {
+	_, err := sharelink.Format("Bad", protocol.Vmess, []byte(`{"add":"vmess.example.com"}`))
+	assert.Error(t, err)
+
+	_, err = sharelink.Format("Bad", "wireguard", []byte(`{}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,391 @@
+// Package sharelink converts between stored configurations and the share links
+// (vmess://, vless://, ss://, trojan://, hysteria2://, tuic://) used by most
+// proxy clients.
+package sharelink
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"k2ray/internal/protocol"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Link is a share link decoded into a configuration.
+type Link struct {
+	Name       string
+	Protocol   string
+	ConfigData protocol.ConfigData
+}
+
+// Parse decodes a single share link. The returned ConfigData has not been
+// validated; callers are expected to run it through protocol.Decode.
+func Parse(link string) (*Link, error) {
+	link = strings.TrimSpace(link)
+	scheme, rest, ok := strings.Cut(link, "://")
+	if !ok {
+		return nil, fmt.Errorf("not a share link")
+	}
+
+	switch strings.ToLower(scheme) {
+	case "vmess":
+		return parseVmess(rest)
+	case "vless":
+		return parseVless(link)
+	case "trojan":
+		return parseTrojan(link)
+	case "ss":
+		return parseShadowsocks(rest)
+	case "hysteria2", "hy2":
+		return parseHysteria2(link)
+	case "tuic":
+		return parseTUIC(link)
+	default:
+		return nil, fmt.Errorf("unsupported share link scheme %q", scheme)
+	}
+}
+
+// vmessLink is the JSON document carried by vmess:// links (the v2rayN format).
+// Port and aid are strings in most exporters but numbers in some.
+type vmessLink struct {
+	V    any    `json:"v"`
+	Ps   string `json:"ps"`
+	Add  string `json:"add"`
+	Port any    `json:"port"`
+	ID   string `json:"id"`
+	Aid  any    `json:"aid"`
+	Net  string `json:"net"`
+	Type string `json:"type"`
+	Host string `json:"host"`
+	Path string `json:"path"`
+	TLS  string `json:"tls"`
+}
+
+func parseVmess(payload string) (*Link, error) {
+	raw, err := decodeBase64(payload)
+	if err != nil {
+		return nil, fmt.Errorf("vmess link is not valid base64: %w", err)
+	}
+	var v vmessLink
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("vmess link does not contain valid JSON: %w", err)
+	}
+
+	port, err := protocol.ParsePort(v.Port)
+	if err != nil {
+		return nil, fmt.Errorf("vmess link has an invalid port: %w", err)
+	}
+	aid := 0
+	if v.Aid != nil && v.Aid != "" {
+		if aid, err = toInt(v.Aid); err != nil {
+			return nil, fmt.Errorf("vmess link has an invalid aid: %w", err)
+		}
+	}
+
+	data := &protocol.VmessConfigData{
+		V:    toString(v.V),
+		Add:  v.Add,
+		Port: port,
+		ID:   v.ID,
+		Aid:  aid,
+		Type: v.Type,
+		Host: v.Host,
+		Path: v.Path,
+		TransportSettings: protocol.TransportSettings{
+			Network:  v.Net,
+			Security: v.TLS,
+		},
+	}
+	if v.Net == "grpc" {
+		data.Path = ""
+		data.GrpcSettings.ServiceName = v.Path
+	}
+
+	return &Link{
+		Name:       linkName(v.Ps, protocol.Vmess, v.Add, port),
+		Protocol:   protocol.Vmess,
+		ConfigData: data,
+	}, nil
+}
+
+func parseVless(link string) (*Link, error) {
+	u, host, port, err := parseURL(link)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+
+	data := &protocol.VlessConfigData{
+		ID:                u.User.Username(),
+		Address:           host,
+		Port:              port,
+		Encryption:        q.Get("encryption"),
+		Flow:              q.Get("flow"),
+		SNI:               q.Get("sni"),
+		TransportSettings: transportFromQuery(q),
+	}
+	if data.Security == protocol.SecurityReality {
+		// REALITY links reuse sni for the camouflage server name.
+		data.RealitySettings = protocol.RealitySettings{
+			ServerName:  data.SNI,
+			Fingerprint: q.Get("fp"),
+			PublicKey:   q.Get("pbk"),
+			ShortID:     q.Get("sid"),
+			SpiderX:     q.Get("spx"),
+		}
+		data.SNI = ""
+	}
+
+	return &Link{
+		Name:       linkName(u.Fragment, protocol.Vless, host, port),
+		Protocol:   protocol.Vless,
+		ConfigData: data,
+	}, nil
+}
+
+func parseTrojan(link string) (*Link, error) {
+	u, host, port, err := parseURL(link)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+
+	return &Link{
+		Name:     linkName(u.Fragment, protocol.Trojan, host, port),
+		Protocol: protocol.Trojan,
+		ConfigData: &protocol.TrojanConfigData{
+			Server:            host,
+			ServerPort:        port,
+			Password:          u.User.Username(),
+			SNI:               q.Get("sni"),
+			TransportSettings: transportFromQuery(q),
+		},
+	}, nil
+}
+
+// parseShadowsocks handles both SIP002 links (ss://userinfo@host:port/?plugin=...#name,
+// with userinfo either base64 or percent-encoded) and the legacy form where
+// everything before the fragment is base64("method:password@host:port").
+func parseShadowsocks(rest string) (*Link, error) {
+	rest, fragment, _ := strings.Cut(rest, "#")
+	name, err := url.PathUnescape(fragment)
+	if err != nil {
+		return nil, fmt.Errorf("ss link has an invalid name: %w", err)
+	}
+
+	if !strings.Contains(rest, "@") {
+		decoded, err := decodeBase64(rest)
+		if err != nil {
+			return nil, fmt.Errorf("ss link is not valid base64: %w", err)
+		}
+		rest = string(decoded)
+	}
+
+	at := strings.LastIndex(rest, "@")
+	if at < 0 {
+		return nil, fmt.Errorf("ss link is missing the server address")
+	}
+	userinfo, hostpart := rest[:at], rest[at+1:]
+
+	hostpart, query, _ := strings.Cut(hostpart, "?")
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("ss link has an invalid query: %w", err)
+	}
+	host, port, err := splitHostPort(strings.TrimSuffix(hostpart, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	userinfo = unescapeUserinfo(userinfo)
+	method, password, ok := strings.Cut(userinfo, ":")
+	if !ok {
+		decoded, err := decodeBase64(userinfo)
+		if err != nil {
+			return nil, fmt.Errorf("ss link has invalid credentials: %w", err)
+		}
+		if method, password, ok = strings.Cut(string(decoded), ":"); !ok {
+			return nil, fmt.Errorf("ss link credentials must be method:password")
+		}
+	}
+
+	return &Link{
+		Name:     linkName(name, protocol.Shadowsocks, host, port),
+		Protocol: protocol.Shadowsocks,
+		ConfigData: &protocol.ShadowsocksConfigData{
+			Server:     host,
+			ServerPort: port,
+			Password:   password,
+			Method:     method,
+			Plugin:     q.Get("plugin"),
+		},
+	}, nil
+}
+
+// parseHysteria2 handles hysteria2:// (and the hy2:// shorthand) links. The whole
+// userinfo is the authentication string, even when it contains a colon.
+func parseHysteria2(link string) (*Link, error) {
+	u, host, port, err := parseURL(link)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+
+	password := u.User.Username()
+	if p, ok := u.User.Password(); ok {
+		password += ":" + p
+	}
+	return &Link{
+		Name:     linkName(u.Fragment, protocol.Hysteria2, host, port),
+		Protocol: protocol.Hysteria2,
+		ConfigData: &protocol.Hysteria2ConfigData{
+			Server:          host,
+			ServerPort:      port,
+			Password:        password,
+			Obfs:            q.Get("obfs"),
+			ObfsPassword:    q.Get("obfs-password"),
+			QUICTLSSettings: quicTLSFromQuery(q, "insecure"),
+		},
+	}, nil
+}
+
+func parseTUIC(link string) (*Link, error) {
+	u, host, port, err := parseURL(link)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+
+	password, _ := u.User.Password()
+	return &Link{
+		Name:     linkName(u.Fragment, protocol.TUIC, host, port),
+		Protocol: protocol.TUIC,
+		ConfigData: &protocol.TUICConfigData{
+			Server:            host,
+			ServerPort:        port,
+			UUID:              u.User.Username(),
+			Password:          password,
+			CongestionControl: q.Get("congestion_control"),
+			UDPRelayMode:      q.Get("udp_relay_mode"),
+			QUICTLSSettings:   quicTLSFromQuery(q, "allow_insecure"),
+		},
+	}, nil
+}
+
+// quicTLSFromQuery maps the TLS query parameters of hysteria2:// and tuic://
+// links onto QUICTLSSettings.
+func quicTLSFromQuery(q url.Values, insecureKey string) protocol.QUICTLSSettings {
+	t := protocol.QUICTLSSettings{
+		SNI:       q.Get("sni"),
+		PinSHA256: q.Get("pinSHA256"),
+	}
+	t.Insecure, _ = strconv.ParseBool(q.Get(insecureKey))
+	if alpn := q.Get("alpn"); alpn != "" {
+		t.ALPN = strings.Split(alpn, ",")
+	}
+	return t
+}
+
+// transportFromQuery maps the transport query parameters shared by vless:// and
+// trojan:// links onto TransportSettings.
+func transportFromQuery(q url.Values) protocol.TransportSettings {
+	t := protocol.TransportSettings{
+		Network:  q.Get("type"),
+		Security: q.Get("security"),
+	}
+	switch t.Network {
+	case "ws":
+		t.WsSettings.Path = q.Get("path")
+		if host := q.Get("host"); host != "" {
+			t.WsSettings.Headers = map[string]string{"Host": host}
+		}
+	case "grpc":
+		t.GrpcSettings.ServiceName = q.Get("serviceName")
+	}
+	return t
+}
+
+func parseURL(link string) (*url.URL, string, int, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("invalid share link: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, "", 0, fmt.Errorf("%s link is missing the user credentials", u.Scheme)
+	}
+	host, port, err := splitHostPort(u.Host)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return u, host, port, nil
+}
+
+func splitHostPort(hostport string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid server address %q: %w", hostport, err)
+	}
+	port, err := protocol.ParsePort(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid server port: %w", err)
+	}
+	return host, port, nil
+}
+
+// unescapeUserinfo percent-decodes SIP002 userinfo, returning the input
+// unchanged when it is not valid percent-encoding.
+func unescapeUserinfo(s string) string {
+	if u, err := url.PathUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
+
+// decodeBase64 accepts standard and URL-safe alphabets, with or without padding.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+func linkName(name, protocolName, host string, port int) string {
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) < 3 {
+		name = fmt.Sprintf("%s %s:%d", protocolName, host, port)
+	}
+	if r := []rune(name); len(r) > 50 {
+		name = string(r[:50])
+	}
+	return name
+}
+
+func toInt(v any) (int, error) {
+	switch n := v.(type) {
+	case float64:
+		return int(n), nil
+	case string:
+		return strconv.Atoi(n)
+	default:
+		return 0, fmt.Errorf("unexpected type %T", v)
+	}
+}
+
+func toString(v any) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64)
+	default:
+		return fmt.Sprint(s)
+	}
+}
@@ -0,0 +1,145 @@
+package sharelink_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"k2ray/internal/protocol"
+	"k2ray/internal/sharelink"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name         string
+		link         string
+		wantName     string
+		wantProtocol string
+		wantData     string
+	}{
+		{
+			name:         "vmess ws tls",
+			link:         "vmess://" + base64.StdEncoding.EncodeToString([]byte(`{"v":"2","ps":"My VMess","add":"vmess.example.com","port":"443","id":"`+testUUID+`","aid":"0","net":"ws","type":"none","host":"cdn.example.com","path":"/ws","tls":"tls"}`)),
+			wantName:     "My VMess",
+			wantProtocol: protocol.Vmess,
+			wantData:     `{"v":"2","add":"vmess.example.com","port":443,"id":"` + testUUID + `","aid":0,"type":"none","host":"cdn.example.com","path":"/ws","net":"ws","tls":"tls"}`,
+		},
+		{
+			name:         "vmess numeric fields, grpc, unpadded url-safe base64",
+			link:         "vmess://" + base64.RawURLEncoding.EncodeToString([]byte(`{"v":2,"ps":"gRPC","add":"vmess.example.com","port":8443,"id":"`+testUUID+`","aid":4,"net":"grpc","path":"svc","tls":"tls"}`)),
+			wantName:     "gRPC",
+			wantProtocol: protocol.Vmess,
+			wantData:     `{"v":"2","add":"vmess.example.com","port":8443,"id":"` + testUUID + `","aid":4,"net":"grpc","tls":"tls","grpcSettings":{"serviceName":"svc"}}`,
+		},
+		{
+			name:         "vless ws",
+			link:         "vless://" + testUUID + "@vless.example.com:443?encryption=none&security=tls&sni=sni.example.com&type=ws&path=%2Fws%3Fed%3D2048&host=cdn.example.com#VLESS%20WS",
+			wantName:     "VLESS WS",
+			wantProtocol: protocol.Vless,
+			wantData:     `{"id":"` + testUUID + `","add":"vless.example.com","port":443,"encryption":"none","sni":"sni.example.com","net":"ws","tls":"tls","wsSettings":{"path":"/ws?ed=2048","headers":{"Host":"cdn.example.com"}}}`,
+		},
+		{
+			name:         "vless reality vision",
+			link:         "vless://" + testUUID + "@vless.example.com:443?encryption=none&flow=xtls-rprx-vision&security=reality&sni=www.microsoft.com&fp=chrome&pbk=uWhDlWI62pIgIWKYqjhL55xvC6_2CtcG8yDprrqtxDk&sid=6ba85179e30d4fc2&spx=%2F&type=tcp#REALITY",
+			wantName:     "REALITY",
+			wantProtocol: protocol.Vless,
+			wantData:     `{"id":"` + testUUID + `","add":"vless.example.com","port":443,"encryption":"none","flow":"xtls-rprx-vision","realitySettings":{"serverName":"www.microsoft.com","fingerprint":"chrome","publicKey":"uWhDlWI62pIgIWKYqjhL55xvC6_2CtcG8yDprrqtxDk","shortId":"6ba85179e30d4fc2","spiderX":"/"},"net":"tcp","tls":"reality"}`,
+		},
+		{
+			name:         "vless grpc ipv6",
+			link:         "vless://" + testUUID + "@[2001:db8::1]:443?type=grpc&serviceName=tunnel&security=tls",
+			wantName:     "vless 2001:db8::1:443",
+			wantProtocol: protocol.Vless,
+			wantData:     `{"id":"` + testUUID + `","add":"2001:db8::1","port":443,"net":"grpc","tls":"tls","grpcSettings":{"serviceName":"tunnel"}}`,
+		},
+		{
+			name:         "trojan",
+			link:         "trojan://p%40ss@trojan.example.com:443?security=tls&sni=trojan.example.com&type=tcp#Trojan",
+			wantName:     "Trojan",
+			wantProtocol: protocol.Trojan,
+			wantData:     `{"server":"trojan.example.com","server_port":443,"password":"p@ss","sni":"trojan.example.com","net":"tcp","tls":"tls"}`,
+		},
+		{
+			name:         "hysteria2 shorthand scheme",
+			link:         "hy2://letmein@hy2.example.com:8443/?insecure=1&obfs=salamander&obfs-password=gawrgura&sni=real.example.com&alpn=h3#Hysteria2",
+			wantName:     "Hysteria2",
+			wantProtocol: protocol.Hysteria2,
+			wantData:     `{"server":"hy2.example.com","server_port":8443,"password":"letmein","obfs":"salamander","obfs_password":"gawrgura","sni":"real.example.com","insecure":true,"alpn":["h3"]}`,
+		},
+		{
+			name:         "tuic v5",
+			link:         "tuic://" + testUUID + ":p%40ss@tuic.example.com:443?congestion_control=bbr&alpn=h3,spdy/3.1&sni=tuic.example.com&udp_relay_mode=quic&allow_insecure=1#TUIC",
+			wantName:     "TUIC",
+			wantProtocol: protocol.TUIC,
+			wantData:     `{"server":"tuic.example.com","server_port":443,"uuid":"` + testUUID + `","password":"p@ss","congestion_control":"bbr","udp_relay_mode":"quic","sni":"tuic.example.com","insecure":true,"alpn":["h3","spdy/3.1"]}`,
+		},
+		{
+			name:         "shadowsocks SIP002 base64 userinfo with plugin",
+			link:         "ss://" + base64.RawURLEncoding.EncodeToString([]byte("chacha20-ietf-poly1305:secret")) + "@ss.example.com:8388/?plugin=obfs-local%3Bobfs%3Dhttp#SS%20Plugin",
+			wantName:     "SS Plugin",
+			wantProtocol: protocol.Shadowsocks,
+			wantData:     `{"server":"ss.example.com","server_port":8388,"password":"secret","method":"chacha20-ietf-poly1305","plugin":"obfs-local;obfs=http"}`,
+		},
+		{
+			name:         "shadowsocks SIP002 percent-encoded userinfo",
+			link:         "ss://2022-blake3-aes-256-gcm:YctPZ6U7xPPcU%2Bgp3u%2B0tx%2FtRizJN9K8y%2BuKlW2qjlI%3D@ss.example.com:8388#SS2022",
+			wantName:     "SS2022",
+			wantProtocol: protocol.Shadowsocks,
+			wantData:     `{"server":"ss.example.com","server_port":8388,"password":"YctPZ6U7xPPcU+gp3u+0tx/tRizJN9K8y+uKlW2qjlI=","method":"2022-blake3-aes-256-gcm"}`,
+		},
+		{
+			name:         "shadowsocks legacy",
+			link:         "ss://" + base64.StdEncoding.EncodeToString([]byte("aes-256-gcm:pa:ss@ss.example.com:8388")) + "#Legacy",
+			wantName:     "Legacy",
+			wantProtocol: protocol.Shadowsocks,
+			wantData:     `{"server":"ss.example.com","server_port":8388,"password":"pa:ss","method":"aes-256-gcm"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link, err := sharelink.Parse(tt.link)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantName, link.Name)
+			assert.Equal(t, tt.wantProtocol, link.Protocol)
+
+			data, err := json.Marshal(link.ConfigData)
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.wantData, string(data))
+
+			_, err = protocol.Decode(link.Protocol, data)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+	}{
+		{"no scheme", "example.com:443"},
+		{"unsupported scheme", "http://example.com"},
+		{"vmess bad base64", "vmess://not*base64"},
+		{"vmess bad json", "vmess://" + base64.StdEncoding.EncodeToString([]byte("{"))},
+		{"vmess bad port", "vmess://" + base64.StdEncoding.EncodeToString([]byte(`{"add":"a.com","port":"99999","id":"x"}`))},
+		{"vless missing id", "vless://vless.example.com:443"},
+		{"vless missing port", "vless://" + testUUID + "@vless.example.com"},
+		{"hysteria2 missing password", "hysteria2://hy2.example.com:443"},
+		{"tuic missing port", "tuic://" + testUUID + ":secret@tuic.example.com"},
+		{"trojan bad port", "trojan://secret@trojan.example.com:0"},
+		{"ss missing server", "ss://" + base64.StdEncoding.EncodeToString([]byte("aes-256-gcm:secret"))},
+		{"ss bad credentials", "ss://" + base64.RawURLEncoding.EncodeToString([]byte("no-colon")) + "@ss.example.com:8388"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sharelink.Parse(tt.link)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package subscription
+
+import (
+	"context"
+	"k2ray/internal/db"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CheckInterval is how often the scheduler looks for subscriptions that are due.
+var CheckInterval = time.Minute
+
+// StartScheduler refreshes due subscriptions in the background until ctx is cancelled.
+func StartScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(CheckInterval)
+		defer ticker.Stop()
+
+		for {
+			RefreshDue(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RefreshDue refreshes every subscription that has never been refreshed or
+// whose refresh interval has elapsed at now. Failures are logged and recorded
+// on the subscription; they do not stop the remaining refreshes.
+func RefreshDue(ctx context.Context, now time.Time) {
+	rows, err := db.DB.QueryContext(ctx, `SELECT id, refresh_interval, last_refreshed_at FROM subscriptions`)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list subscriptions for refresh")
+		return
+	}
+
+	var due []int64
+	for rows.Next() {
+		var id int64
+		var interval int
+		var last *time.Time
+		if err := rows.Scan(&id, &interval, &last); err != nil {
+			log.Error().Err(err).Msg("Error scanning subscription row")
+			continue
+		}
+		if last == nil || !now.Before(last.Add(time.Duration(interval)*time.Minute)) {
+			due = append(due, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		// Refresh logs and records its own failures.
+		Refresh(ctx, id)
+	}
+}
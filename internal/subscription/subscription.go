@@ -0,0 +1,327 @@
+// Package subscription keeps configurations in sync with the share link feeds
+// that users register as subscriptions.
+package subscription
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"k2ray/internal/db"
+	"k2ray/internal/protocol"
+	"k2ray/internal/security"
+	"k2ray/internal/sharelink"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxFeedSize caps how much of a feed response is read.
+	maxFeedSize = 5 << 20
+
+	// revisionComment is recorded on the configuration revisions a refresh
+	// writes. They have no author.
+	revisionComment = "Subscription refresh"
+)
+
+var (
+	// Client is the HTTP client used to download feeds. It refuses to connect
+	// to addresses that are not public, unless the feed belongs to an admin.
+	Client = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{DialContext: dialFeed, TLSHandshakeTimeout: 10 * time.Second, ForceAttemptHTTP2: true},
+	}
+
+	// ErrNotFound is returned when the subscription does not exist.
+	ErrNotFound = errors.New("subscription not found")
+
+	// ErrPrivateAddress is returned when a feed URL points at a loopback,
+	// private or link-local address that only admins may subscribe to.
+	ErrPrivateAddress = errors.New("subscription URL must not point to a private, loopback or link-local address")
+
+	// refreshMu serializes refreshes so a manual refresh cannot race the scheduler.
+	refreshMu sync.Mutex
+)
+
+// Result summarizes what a refresh changed.
+type Result struct {
+	Added     int      `json:"added"`
+	Updated   int      `json:"updated"`
+	Unchanged int      `json:"unchanged"`
+	Removed   int      `json:"removed"`
+	Invalid   int      `json:"invalid"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// entry is a validated feed link ready to be stored.
+type entry struct {
+	key        string
+	name       string
+	protocol   string
+	configData string
+}
+
+// Refresh downloads the subscription's feed and upserts its links as
+// configurations tagged with the subscription ID. Configurations whose links
+// disappeared from the feed are removed. If the feed cannot be fetched or has
+// no valid links, the existing configurations are left untouched.
+func Refresh(ctx context.Context, subscriptionID int64) (*Result, error) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	var userID int64
+	var feedURL string
+	var role db.UserRole
+	err := db.DB.QueryRowContext(ctx, `SELECT s.user_id, s.url, u.role FROM subscriptions s JOIN users u ON u.id = s.user_id WHERE s.id = ?`,
+		subscriptionID).Scan(&userID, &feedURL, &role)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if role == db.AdminRole {
+		ctx = context.WithValue(ctx, privateAllowedKey{}, true)
+	}
+
+	result := &Result{}
+	entries, err := fetchEntries(ctx, feedURL, result)
+	if err == nil {
+		err = apply(ctx, subscriptionID, userID, entries, result)
+	}
+	recordRefresh(subscriptionID, err)
+	if err != nil {
+		log.Warn().Err(err).Int64("subscription_id", subscriptionID).Msg("Subscription refresh failed")
+		return result, err
+	}
+
+	details := fmt.Sprintf("Subscription refreshed: %d added, %d updated, %d removed, %d invalid",
+		result.Added, result.Updated, result.Removed, result.Invalid)
+	security.LogEvent(nil, security.SubscriptionRefreshed, subscriptionID, details)
+	return result, nil
+}
+
+// fetchEntries downloads and decodes the feed, counting invalid links in result.
+func fetchEntries(ctx context.Context, feedURL string, result *Result) ([]entry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid feed URL: %w", err)
+	}
+	req.Header.Set("User-Agent", "k2ray")
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch feed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return nil, fmt.Errorf("could not read feed: %w", err)
+	}
+
+	var entries []entry
+	seen := make(map[string]int)
+	for i, raw := range sharelink.DecodeFeed(body) {
+		e, err := decodeEntry(raw)
+		if err != nil {
+			result.Invalid++
+			result.Errors = append(result.Errors, fmt.Sprintf("link %d: %v", i+1, err))
+			continue
+		}
+		// Names identify entries across refreshes; disambiguate duplicates by position.
+		seen[e.name]++
+		e.key = e.name
+		if n := seen[e.name]; n > 1 {
+			e.key = fmt.Sprintf("%s #%d", e.name, n)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("feed contains no valid share links")
+	}
+	return entries, nil
+}
+
+func decodeEntry(raw string) (entry, error) {
+	link, err := sharelink.Parse(raw)
+	if err != nil {
+		return entry{}, err
+	}
+	data, err := json.Marshal(link.ConfigData)
+	if err != nil {
+		return entry{}, err
+	}
+	if _, err := protocol.Decode(link.Protocol, data); err != nil {
+		return entry{}, err
+	}
+	return entry{name: link.Name, protocol: link.Protocol, configData: string(data)}, nil
+}
+
+// apply upserts entries and removes the subscription's configurations that are
+// no longer in the feed, all in one transaction.
+func apply(ctx context.Context, subscriptionID, userID int64, entries []entry, result *Result) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	type stored struct {
+		id                         int64
+		name, protocol, configData string
+	}
+	existing := make(map[string]stored)
+	rows, err := tx.QueryContext(ctx, `SELECT id, subscription_key, name, protocol, config_data FROM configurations WHERE subscription_id = ?`, subscriptionID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var key string
+		var s stored
+		if err := rows.Scan(&s.id, &key, &s.name, &s.protocol, &s.configData); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[key] = s
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		s, ok := existing[e.key]
+		switch {
+		case !ok:
+			var res sql.Result
+			res, err = tx.ExecContext(ctx, `INSERT INTO configurations (user_id, name, protocol, config_data, subscription_id, subscription_key) VALUES (?, ?, ?, ?, ?, ?)`,
+				userID, e.name, e.protocol, e.configData, subscriptionID, e.key)
+			if err == nil {
+				s.id, _ = res.LastInsertId()
+				_, err = db.AddConfigRevision(ctx, tx, s.id, e.name, e.configData, nil, revisionComment)
+			}
+			result.Added++
+		case s.name != e.name || s.protocol != e.protocol || s.configData != e.configData:
+			_, err = tx.ExecContext(ctx, `UPDATE configurations SET name = ?, protocol = ?, config_data = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+				e.name, e.protocol, e.configData, s.id)
+			if err == nil {
+				_, err = db.AddConfigRevision(ctx, tx, s.id, e.name, e.configData, nil, revisionComment)
+			}
+			result.Updated++
+		default:
+			result.Unchanged++
+		}
+		if err != nil {
+			return err
+		}
+		delete(existing, e.key)
+	}
+
+	for _, s := range existing {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM configurations WHERE id = ?`, s.id); err != nil {
+			return err
+		}
+		result.Removed++
+	}
+
+	return tx.Commit()
+}
+
+// recordRefresh stores the time and outcome of a refresh attempt. Failed
+// attempts also count, so a broken feed is retried on the normal schedule.
+func recordRefresh(subscriptionID int64, refreshErr error) {
+	var lastError *string
+	if refreshErr != nil {
+		msg := refreshErr.Error()
+		lastError = &msg
+	}
+	_, err := db.DB.Exec(`UPDATE subscriptions SET last_refreshed_at = ?, last_error = ? WHERE id = ?`,
+		time.Now().UTC(), lastError, subscriptionID)
+	if err != nil {
+		log.Error().Err(err).Int64("subscription_id", subscriptionID).Msg("Failed to record subscription refresh")
+	}
+}
+
+// Delete removes a subscription. The trg_subscriptions_delete trigger removes
+// the configurations it manages along with it.
+func Delete(ctx context.Context, subscriptionID int64) error {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	res, err := db.DB.ExecContext(ctx, `DELETE FROM subscriptions WHERE id = ?`, subscriptionID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ValidateURL checks that a feed URL is an absolute http(s) URL. Unless
+// allowPrivate is set, its host must not resolve to an address that is not
+// public. Hosts that cannot be resolved yet are accepted, as every connection
+// to a feed is checked again.
+func ValidateURL(ctx context.Context, feedURL string, allowPrivate bool) error {
+	u, err := url.Parse(feedURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("subscription URL must use http or https")
+	}
+	if allowPrivate {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if !isPublic(addr) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+// privateAllowedKey marks the request contexts of feeds owned by admins,
+// which may be served from private addresses.
+type privateAllowedKey struct{}
+
+// dialFeed connects to a feed server. Unless the request context allows
+// private addresses, the resolved address is checked right before connecting,
+// so host names that resolve to a private address and redirects are caught.
+func dialFeed(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	if allowed, _ := ctx.Value(privateAllowedKey{}).(bool); !allowed {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublic(addrPort.Addr()) {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// isPublic reports whether addr is neither unspecified, loopback, private,
+// link-local nor multicast.
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return !addr.IsUnspecified() && !addr.IsLoopback() && !addr.IsPrivate() &&
+		!addr.IsLinkLocalUnicast() && !addr.IsLinkLocalMulticast() && !addr.IsMulticast()
+}
@@ -0,0 +1,281 @@
+package subscription_test
+
+import (
+	"context"
+	"encoding/base64"
+	"k2ray/internal/config"
+	"k2ray/internal/db"
+	"k2ray/internal/sharelink"
+	"k2ray/internal/subscription"
+	"k2ray/internal/utils"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	tmpfile, err := os.CreateTemp("", "test_subscription_*.db")
+	if err != nil {
+		log.Fatalf("Failed to create temp db file: %v", err)
+	}
+	dbPath := tmpfile.Name()
+	tmpfile.Close()
+
+	config.LoadConfig("")
+	config.AppConfig.DatabaseURL = dbPath
+	db.InitDB()
+
+	code := m.Run()
+
+	db.DB.Close()
+	os.Remove(dbPath)
+	os.Exit(code)
+}
+
+// feedServer serves whatever feed body is currently set.
+type feedServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	body   string
+	status int
+}
+
+func newFeedServer(t *testing.T) *feedServer {
+	f := &feedServer{status: http.StatusOK}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		w.WriteHeader(f.status)
+		w.Write([]byte(f.body))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *feedServer) serve(status int, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status, f.body = status, body
+}
+
+func createSubscription(t *testing.T, feedURL string, interval int) (userID, subscriptionID int64) {
+	hashedPassword, _ := utils.HashPassword("password")
+	// The feed servers listen on 127.0.0.1, which only admins may subscribe to.
+	res, err := db.DB.Exec(`INSERT INTO users (username, password_hash, role) VALUES (?, ?, 'admin')`, t.Name(), hashedPassword)
+	require.NoError(t, err)
+	userID, _ = res.LastInsertId()
+
+	res, err = db.DB.Exec(`INSERT INTO subscriptions (user_id, name, url, refresh_interval) VALUES (?, ?, ?, ?)`, userID, "Provider", feedURL, interval)
+	require.NoError(t, err)
+	subscriptionID, _ = res.LastInsertId()
+	return
+}
+
+type storedConfig struct {
+	id         int64
+	userID     int64
+	configData string
+}
+
+func subscriptionConfigs(t *testing.T, subscriptionID int64) map[string]storedConfig {
+	rows, err := db.DB.Query(`SELECT id, user_id, name, config_data FROM configurations WHERE subscription_id = ?`, subscriptionID)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	configs := make(map[string]storedConfig)
+	for rows.Next() {
+		var name string
+		var c storedConfig
+		require.NoError(t, rows.Scan(&c.id, &c.userID, &name, &c.configData))
+		configs[name] = c
+	}
+	return configs
+}
+
+func trojanLink(name, server string) string {
+	return "trojan://secret@" + server + ":443?security=tls#" + name
+}
+
+func TestRefresh(t *testing.T) {
+	feed := newFeedServer(t)
+	userID, subID := createSubscription(t, feed.URL, 60)
+
+	feed.serve(http.StatusOK, sharelink.EncodeFeed([]string{
+		trojanLink("Node-A", "a.example.com"),
+		trojanLink("Node-B", "b.example.com"),
+		"ss://broken",
+	}))
+	result, err := subscription.Refresh(context.Background(), subID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Added)
+	assert.Equal(t, 1, result.Invalid)
+	assert.Len(t, result.Errors, 1)
+
+	configs := subscriptionConfigs(t, subID)
+	require.Len(t, configs, 2)
+	assert.Equal(t, userID, configs["Node-A"].userID)
+	assert.Contains(t, configs["Node-B"].configData, "b.example.com")
+	nodeA := configs["Node-A"].id
+
+	// Node-A moves, Node-B disappears and Node-C is new. The feed is plain text this time.
+	feed.serve(http.StatusOK, trojanLink("Node-A", "a2.example.com")+"\n"+trojanLink("Node-C", "c.example.com")+"\n")
+	result, err = subscription.Refresh(context.Background(), subID)
+	require.NoError(t, err)
+	assert.Equal(t, subscription.Result{Added: 1, Updated: 1, Removed: 1}, *result)
+
+	configs = subscriptionConfigs(t, subID)
+	require.Len(t, configs, 2)
+	assert.Equal(t, nodeA, configs["Node-A"].id, "updated entries keep their ID")
+	assert.Contains(t, configs["Node-A"].configData, "a2.example.com")
+	assert.Contains(t, configs, "Node-C")
+
+	// An unchanged feed changes nothing.
+	result, err = subscription.Refresh(context.Background(), subID)
+	require.NoError(t, err)
+	assert.Equal(t, subscription.Result{Unchanged: 2}, *result)
+
+	// Node-A was added and updated by the system, and unchanged refreshes add no revisions.
+	revisions, err := db.ListConfigRevisions(nodeA)
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.Nil(t, revisions[0].AuthorID)
+	assert.Equal(t, "Subscription refresh", revisions[0].Comment)
+	assert.Contains(t, revisions[0].ConfigData, "a2.example.com")
+	assert.Contains(t, revisions[1].ConfigData, "a.example.com")
+
+	var lastError *string
+	var lastRefreshed *time.Time
+	require.NoError(t, db.DB.QueryRow(`SELECT last_error, last_refreshed_at FROM subscriptions WHERE id = ?`, subID).Scan(&lastError, &lastRefreshed))
+	assert.Nil(t, lastError)
+	assert.NotNil(t, lastRefreshed)
+}
+
+func TestRefreshFailureKeepsConfigs(t *testing.T) {
+	feed := newFeedServer(t)
+	_, subID := createSubscription(t, feed.URL, 60)
+
+	feed.serve(http.StatusOK, sharelink.EncodeFeed([]string{trojanLink("Node-A", "a.example.com")}))
+	_, err := subscription.Refresh(context.Background(), subID)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		status int
+		body   string
+	}{
+		{http.StatusInternalServerError, "oops"},
+		{http.StatusOK, base64.StdEncoding.EncodeToString([]byte("nothing useful here"))},
+		{http.StatusOK, ""},
+	} {
+		feed.serve(tc.status, tc.body)
+		_, err = subscription.Refresh(context.Background(), subID)
+		assert.Error(t, err)
+		assert.Len(t, subscriptionConfigs(t, subID), 1)
+
+		var lastError *string
+		require.NoError(t, db.DB.QueryRow(`SELECT last_error FROM subscriptions WHERE id = ?`, subID).Scan(&lastError))
+		if assert.NotNil(t, lastError) {
+			assert.Equal(t, err.Error(), *lastError)
+		}
+	}
+
+	_, err = subscription.Refresh(context.Background(), 999999)
+	assert.ErrorIs(t, err, subscription.ErrNotFound)
+}
+
+func TestRefreshRejectsPrivateAddresses(t *testing.T) {
+	feed := newFeedServer(t)
+	feed.serve(http.StatusOK, sharelink.EncodeFeed([]string{trojanLink("Node-A", "a.example.com")}))
+	userID, subID := createSubscription(t, feed.URL, 60)
+	_, err := db.DB.Exec(`UPDATE users SET role = 'user' WHERE id = ?`, userID)
+	require.NoError(t, err)
+
+	_, err = subscription.Refresh(context.Background(), subID)
+	assert.ErrorIs(t, err, subscription.ErrPrivateAddress)
+	assert.Empty(t, subscriptionConfigs(t, subID))
+
+	ctx := context.Background()
+	assert.ErrorIs(t, subscription.ValidateURL(ctx, feed.URL, false), subscription.ErrPrivateAddress)
+	assert.ErrorIs(t, subscription.ValidateURL(ctx, "http://[::ffff:169.254.169.254]/latest", false), subscription.ErrPrivateAddress)
+	assert.ErrorIs(t, subscription.ValidateURL(ctx, "http://0.0.0.0:8080/feed", false), subscription.ErrPrivateAddress)
+	assert.NoError(t, subscription.ValidateURL(ctx, feed.URL, true), "admins may subscribe to private feeds")
+	assert.NoError(t, subscription.ValidateURL(ctx, "https://203.0.113.7/feed", false))
+	assert.Error(t, subscription.ValidateURL(ctx, "ftp://example.com/feed", false))
+}
+
+func TestRefreshDuplicateNames(t *testing.T) {
+	feed := newFeedServer(t)
+	_, subID := createSubscription(t, feed.URL, 60)
+
+	feed.serve(http.StatusOK, sharelink.EncodeFeed([]string{
+		trojanLink("Same", "a.example.com"),
+		trojanLink("Same", "b.example.com"),
+	}))
+	result, err := subscription.Refresh(context.Background(), subID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Added)
+
+	var count int
+	require.NoError(t, db.DB.QueryRow(`SELECT COUNT(*) FROM configurations WHERE subscription_id = ?`, subID).Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestRefreshDue(t *testing.T) {
+	feed := newFeedServer(t)
+	feed.serve(http.StatusOK, sharelink.EncodeFeed([]string{trojanLink("Node-A", "a.example.com")}))
+	_, subID := createSubscription(t, feed.URL, 30)
+
+	lastRefreshed := func() *time.Time {
+		var last *time.Time
+		require.NoError(t, db.DB.QueryRow(`SELECT last_refreshed_at FROM subscriptions WHERE id = ?`, subID).Scan(&last))
+		return last
+	}
+
+	// Never refreshed: due immediately.
+	subscription.RefreshDue(context.Background(), time.Now())
+	first := lastRefreshed()
+	require.NotNil(t, first)
+	assert.Len(t, subscriptionConfigs(t, subID), 1)
+
+	// Not due until the interval has elapsed.
+	subscription.RefreshDue(context.Background(), first.Add(29*time.Minute))
+	assert.True(t, first.Equal(*lastRefreshed()))
+
+	subscription.RefreshDue(context.Background(), first.Add(31*time.Minute))
+	assert.False(t, first.Equal(*lastRefreshed()))
+}
+
+func TestDelete(t *testing.T) {
+	feed := newFeedServer(t)
+	feed.serve(http.StatusOK, sharelink.EncodeFeed([]string{trojanLink("Node-A", "a.example.com")}))
+	_, subID := createSubscription(t, feed.URL, 60)
+
+	_, err := subscription.Refresh(context.Background(), subID)
+	require.NoError(t, err)
+	require.Len(t, subscriptionConfigs(t, subID), 1)
+
+	require.NoError(t, subscription.Delete(context.Background(), subID))
+	assert.Empty(t, subscriptionConfigs(t, subID))
+	assert.ErrorIs(t, subscription.Delete(context.Background(), subID), subscription.ErrNotFound)
+
+	t.Run("Deleting the owner", func(t *testing.T) {
+		// Removes their subscriptions and, with them, the configurations
+		// they manage.
+		userID, subID := createSubscription(t, feed.URL, 60)
+		_, err := subscription.Refresh(context.Background(), subID)
+		require.NoError(t, err)
+		require.Len(t, subscriptionConfigs(t, subID), 1)
+		_, err = db.DB.Exec(`DELETE FROM users WHERE id = ?`, userID)
+		require.NoError(t, err)
+		assert.Empty(t, subscriptionConfigs(t, subID))
+		var n int
+		require.NoError(t, db.DB.QueryRow(`SELECT COUNT(*) FROM subscriptions WHERE id = ?`, subID).Scan(&n))
+		assert.Zero(t, n)
+	})
+}
@@ -1,11 +1,10 @@
 package twofactor
 
 import (
-	"bytes"
 	"crypto/rand"
 	"encoding/base64"
-	"image/png"
 	"k2ray/internal/config"
+	"k2ray/internal/utils"
 	"strings"
 
 	"github.com/pquerna/otp"
@@ -22,17 +21,7 @@ func GenerateSecret() (*otp.Key, error) {
 
 // GenerateQRCode generates a PNG image of the QR code for the given OTP key.
 func GenerateQRCode(key *otp.Key) ([]byte, error) {
-	img, err := key.Image(256, 256)
-	if err != nil {
-		return nil, err
-	}
-
-	var buf bytes.Buffer
-	if err := png.Encode(&buf, img); err != nil {
-		return nil, err
-	}
-
-	return buf.Bytes(), nil
+	return utils.QRCodePNG(key.String(), 256)
 }
 
 // ValidateCode checks if the provided passcode is valid for the given secret.
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONChange is a single difference between two JSON documents. Path is a
+// JSON Pointer (RFC 6901) and Op is "add", "remove" or "replace", as in JSON
+// Patch. Old is null for additions and New is null for removals; both are
+// always present, so that a change to or from null keeps its value.
+type JSONChange struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	Old  any    `json:"old"`
+	New  any    `json:"new"`
+}
+
+// DiffJSON compares two JSON documents and returns their differences, ordered
+// by path. Objects are compared key by key and arrays element by element;
+// everything else is replaced as a whole.
+func DiffJSON(a, b []byte) ([]JSONChange, error) {
+	var va, vb any
+	if err := json.Unmarshal(a, &va); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		return nil, err
+	}
+	changes := []JSONChange{}
+	diffValue("", va, vb, &changes)
+	return changes, nil
+}
+
+func diffValue(path string, a, b any, changes *[]JSONChange) {
+	switch ta := a.(type) {
+	case map[string]any:
+		if tb, ok := b.(map[string]any); ok {
+			diffObject(path, ta, tb, changes)
+			return
+		}
+	case []any:
+		if tb, ok := b.([]any); ok {
+			diffArray(path, ta, tb, changes)
+			return
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, JSONChange{Op: "replace", Path: path, Old: a, New: b})
+	}
+}
+
+func diffObject(path string, a, b map[string]any, changes *[]JSONChange) {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := path + "/" + escapePointer(k)
+		va, inA := a[k]
+		vb, inB := b[k]
+		switch {
+		case !inB:
+			*changes = append(*changes, JSONChange{Op: "remove", Path: p, Old: va})
+		case !inA:
+			*changes = append(*changes, JSONChange{Op: "add", Path: p, New: vb})
+		default:
+			diffValue(p, va, vb, changes)
+		}
+	}
+}
+
+func diffArray(path string, a, b []any, changes *[]JSONChange) {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		p := path + "/" + strconv.Itoa(i)
+		switch {
+		case i >= len(b):
+			*changes = append(*changes, JSONChange{Op: "remove", Path: p, Old: a[i]})
+		case i >= len(a):
+			*changes = append(*changes, JSONChange{Op: "add", Path: p, New: b[i]})
+		default:
+			diffValue(p, a[i], b[i], changes)
+		}
+	}
+}
+
+// escapePointer escapes a key for use as a JSON Pointer reference token.
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffJSON(t *testing.T) {
+	a := `{"add":"a.com","port":443,"ws":{"path":"/a","headers":{"Host":"a.com"}},"alpn":["h2","http/1.1"],"a/b":1,"gone":true}`
+	b := `{"add":"b.com","port":443,"ws":{"path":"/a","headers":{}},"alpn":["h2"],"a/b":2,"new":null}`
+
+	changes, err := DiffJSON([]byte(a), []byte(b))
+	require.NoError(t, err)
+	assert.Equal(t, []JSONChange{
+		{Op: "replace", Path: "/a~1b", Old: float64(1), New: float64(2)},
+		{Op: "replace", Path: "/add", Old: "a.com", New: "b.com"},
+		{Op: "remove", Path: "/alpn/1", Old: "http/1.1"},
+		{Op: "remove", Path: "/gone", Old: true},
+		{Op: "add", Path: "/new"},
+		{Op: "remove", Path: "/ws/headers/Host", Old: "a.com"},
+	}, changes)
+
+	changes, err = DiffJSON([]byte(a), []byte(a))
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	// A type change replaces the value as a whole.
+	changes, err = DiffJSON([]byte(`{"x":{"y":1}}`), []byte(`{"x":[1]}`))
+	require.NoError(t, err)
+	assert.Equal(t, []JSONChange{{Op: "replace", Path: "/x", Old: map[string]any{"y": float64(1)}, New: []any{float64(1)}}}, changes)
+
+	// Changes to and from null keep both values when encoded.
+	changes, err = DiffJSON([]byte(`{"x":null}`), []byte(`{"x":1}`))
+	require.NoError(t, err)
+	encoded, err := json.Marshal(changes)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"op":"replace","path":"/x","old":null,"new":1}]`, string(encoded))
+
+	_, err = DiffJSON([]byte(`{`), []byte(`{}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,155 @@
+package v2ray
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"k2ray/internal/db"
+	"strconv"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Selection is what V2Ray runs: the configuration or balancer whose ID is
+// stored under Key, which is ActiveConfigKey or ActiveBalancerKey.
+type Selection struct {
+	Key string
+	ID  int64
+}
+
+// ErrSelectionChanged is returned by Switch when the active selection is no
+// longer the one the switch was meant to replace.
+var ErrSelectionChanged = errors.New("the active selection has changed")
+
+// activateMu serializes changes of the active selection, so that concurrent
+// activations cannot restore each other's stale selections.
+var activateMu sync.Mutex
+
+// activeKeys are the settings that make up the active selection.
+var activeKeys = []string{ActiveConfigKey, ActiveBalancerKey}
+
+// ActiveSelection returns the active selection. ok is false if nothing is active.
+func ActiveSelection() (sel Selection, ok bool, err error) {
+	activateMu.Lock()
+	defer activateMu.Unlock()
+	return activeSelection()
+}
+
+// Activate validates sel and makes it the active selection. If V2Ray is
+// running it is reloaded, and should the reload fail the previous selection is
+// restored and the reload result returned with the error. A selection that
+// fails validation is not stored and is reported as *InvalidConfigError.
+func Activate(sel Selection) (ReloadResult, error) {
+	activateMu.Lock()
+	defer activateMu.Unlock()
+	return activate(sel)
+}
+
+// Switch is like Activate, but only replaces from. If another selection was
+// activated in the meantime, nothing changes and ErrSelectionChanged is returned.
+func Switch(from, to Selection) (ReloadResult, error) {
+	activateMu.Lock()
+	defer activateMu.Unlock()
+
+	current, ok, err := activeSelection()
+	if err != nil {
+		return "", err
+	}
+	if !ok || current != from {
+		return "", ErrSelectionChanged
+	}
+	return activate(to)
+}
+
+// activate implements Activate. The caller must hold activateMu.
+func activate(sel Selection) (ReloadResult, error) {
+	var validateErr error
+	switch sel.Key {
+	case ActiveConfigKey:
+		var protocol, configData string
+		err := db.DB.QueryRow("SELECT protocol, config_data FROM configurations WHERE id = ?", sel.ID).Scan(&protocol, &configData)
+		if err != nil {
+			return "", fmt.Errorf("could not retrieve config data for ID %d: %w", sel.ID, err)
+		}
+		validateErr = ValidateStored(protocol, []byte(configData))
+	case ActiveBalancerKey:
+		validateErr = ValidateBalancer(sel.ID)
+	default:
+		return "", fmt.Errorf("unknown selection key %q", sel.Key)
+	}
+	if validateErr != nil {
+		var invalid *InvalidConfigError
+		if errors.As(validateErr, &invalid) {
+			return "", validateErr
+		}
+		log.Warn().Err(validateErr).Str("key", sel.Key).Int64("id", sel.ID).Msg("Could not run pre-flight validation for active config")
+	}
+
+	previous := make(map[string]sql.NullString, len(activeKeys))
+	for _, key := range activeKeys {
+		var value sql.NullString
+		err := db.DB.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+		if err != nil && err != sql.ErrNoRows {
+			return "", fmt.Errorf("could not read active selection: %w", err)
+		}
+		previous[key] = value
+	}
+
+	selection := make(map[string]sql.NullString, len(activeKeys))
+	for _, key := range activeKeys {
+		selection[key] = sql.NullString{}
+	}
+	selection[sel.Key] = sql.NullString{String: strconv.FormatInt(sel.ID, 10), Valid: true}
+	if err := writeSettings(selection); err != nil {
+		return "", fmt.Errorf("could not store active selection: %w", err)
+	}
+
+	result, reloadErr := Reload()
+	if result != ReloadApplied && result != ReloadNotRunning {
+		if err := writeSettings(previous); err != nil {
+			log.Error().Err(err).Msg("Error restoring previous active config")
+		}
+		return result, reloadErr
+	}
+	return result, nil
+}
+
+// activeSelection implements ActiveSelection. The caller must hold activateMu.
+func activeSelection() (Selection, bool, error) {
+	// A balancer takes precedence, as it does for the document V2Ray runs.
+	for _, key := range []string{ActiveBalancerKey, ActiveConfigKey} {
+		var id int64
+		err := db.DB.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&id)
+		if err == nil {
+			return Selection{Key: key, ID: id}, true, nil
+		}
+		if err != sql.ErrNoRows {
+			return Selection{}, false, fmt.Errorf("could not read active selection: %w", err)
+		}
+	}
+	return Selection{}, false, nil
+}
+
+// writeSettings stores the given settings in one transaction, deleting the
+// ones whose value is not valid.
+func writeSettings(values map[string]sql.NullString) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsertSQL := `INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value;`
+	for key, value := range values {
+		if value.Valid {
+			_, err = tx.Exec(upsertSQL, key, value.String)
+		} else {
+			_, err = tx.Exec("DELETE FROM settings WHERE key = ?", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
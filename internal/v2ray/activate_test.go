@@ -0,0 +1,52 @@
+package v2ray_test
+
+import (
+	"k2ray/internal/db"
+	"k2ray/internal/v2ray"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActivate(t *testing.T) {
+	userID, good := createTestUserAndConfig(t)
+	t.Cleanup(func() { db.DB.Exec(`DELETE FROM settings WHERE key = ?`, v2ray.ActiveConfigKey) })
+	createConfig := func(configData string) int64 {
+		res, err := db.DB.Exec(`INSERT INTO configurations (user_id, name, protocol, config_data) VALUES (?, ?, ?, ?)`, userID, "test-config", "vmess", configData)
+		require.NoError(t, err)
+		id, _ := res.LastInsertId()
+		return id
+	}
+	other := createConfig(`{"add": "other.test", "port": 443, "id": "6a88a032-a0e0-5b7d-8447-6d552954c423"}`)
+	invalid := createConfig(`{"add": "fake-invalid.test", "port": 443, "id": "519e621c-c9a8-5f30-a488-1e1ec833bcfd"}`)
+	active := func() v2ray.Selection {
+		sel, ok, err := v2ray.ActiveSelection()
+		require.NoError(t, err)
+		require.True(t, ok)
+		return sel
+	}
+	goodSel := v2ray.Selection{Key: v2ray.ActiveConfigKey, ID: good}
+	otherSel := v2ray.Selection{Key: v2ray.ActiveConfigKey, ID: other}
+
+	result, err := v2ray.Activate(goodSel)
+	require.NoError(t, err)
+	assert.Equal(t, v2ray.ReloadNotRunning, result)
+	assert.Equal(t, goodSel, active())
+
+	// Validation applies even while V2Ray is stopped.
+	_, err = v2ray.Activate(v2ray.Selection{Key: v2ray.ActiveConfigKey, ID: invalid})
+	var invalidErr *v2ray.InvalidConfigError
+	assert.ErrorAs(t, err, &invalidErr)
+	assert.Equal(t, goodSel, active(), "a rejected selection is not stored")
+
+	// A switch only replaces the selection it was meant for.
+	_, err = v2ray.Switch(otherSel, goodSel)
+	assert.ErrorIs(t, err, v2ray.ErrSelectionChanged)
+	assert.Equal(t, goodSel, active())
+
+	result, err = v2ray.Switch(goodSel, otherSel)
+	require.NoError(t, err)
+	assert.Equal(t, v2ray.ReloadNotRunning, result)
+	assert.Equal(t, otherSel, active())
+}
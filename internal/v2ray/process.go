@@ -4,97 +4,548 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"k2ray/internal/config"
 	"k2ray/internal/db"
+	"k2ray/internal/generator"
+	"k2ray/internal/security"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
+// Settings selecting what V2Ray runs. At most one of them is set.
 const (
 	ActiveConfigKey   = "active_config_id"
-	V2RayConfigPath   = "/tmp/k2ray_config.json"
-	V2RayExecutable   = "/usr/bin/v2ray" // Assumed path
+	ActiveBalancerKey = "active_balancer_id"
 )
 
+// Process states reported by Info.
+const (
+	StateStopped      = "stopped"
+	StateRunning      = "running"
+	StateRestarting   = "restarting"
+	StateCrashLooping = "crash-looping"
+)
+
+var (
+	// startupGrace is how long Start waits to make sure the process does not exit right away.
+	startupGrace = 500 * time.Millisecond
+	// stopTimeout is how long Stop waits after SIGTERM before killing the process.
+	stopTimeout = 5 * time.Second
+)
+
+// RestartPolicy controls how the supervisor reacts to unexpected exits.
+type RestartPolicy struct {
+	// InitialBackoff is the delay before the first restart; it doubles with every crash in the window.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between restarts.
+	MaxBackoff time.Duration
+	// MaxCrashes is the number of crashes within CrashWindow that marks the process as crash-looping.
+	MaxCrashes int
+	// CrashWindow is the sliding window used to count crashes.
+	CrashWindow time.Duration
+}
+
+// DefaultRestartPolicy is the policy used unless SetRestartPolicy is called.
+var DefaultRestartPolicy = RestartPolicy{
+	InitialBackoff: 1 * time.Second,
+	MaxBackoff:     30 * time.Second,
+	MaxCrashes:     5,
+	CrashWindow:    2 * time.Minute,
+}
+
+// backoff returns the restart delay after the given number of recent crashes.
+func (p RestartPolicy) backoff(crashes int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < crashes && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// ProcessStatus is a snapshot of the supervised V2Ray process.
+type ProcessStatus struct {
+	State          string
+	Running        bool
+	PID            int
+	StartedAt      time.Time
+	Uptime         time.Duration
+	ExitCode       int
+	LastExitReason string
+	Crashed        bool
+	RestartCount   int
+}
+
 // ManagerState holds the current state of the V2Ray process manager.
-// NOTE: This is a mocked implementation for development purposes, as the v2ray-core
-// executable is not available in the sandboxed environment. The logic here simulates
-// the behavior of a real process manager.
 type ManagerState struct {
-	mu        sync.RWMutex
-	isRunning bool
-	pid       int // In a real implementation, this would store the process ID.
+	ops            sync.Mutex // Serializes Start, Stop and Reload.
+	mu             sync.RWMutex
+	policy         RestartPolicy
+	cmd            *exec.Cmd
+	done           chan struct{} // Closed when the current process has exited.
+	executable     string
+	configPath     string
+	state          string
+	pid            int
+	startedAt      time.Time
+	stopping       bool // Set by Stop so that the exit is not reported as a crash.
+	supervised     bool // Set once the process is past startup and should be restarted on crashes.
+	exitCode       int
+	lastExitReason string
+	crashed        bool
+	restartCount   int
+	crashTimes     []time.Time
+	restartTimer   *time.Timer
+	generation     int // Bumped whenever a pending restart must be discarded.
 }
 
 // manager is a singleton instance of the ManagerState.
-var manager = &ManagerState{}
+var manager = &ManagerState{policy: DefaultRestartPolicy, state: StateStopped}
 
-// Start fetches the active config, writes it to a file, and mocks starting the V2Ray process.
-func Start() error {
+// SetRestartPolicy replaces the supervisor's restart policy.
+func SetRestartPolicy(policy RestartPolicy) {
 	manager.mu.Lock()
 	defer manager.mu.Unlock()
+	manager.policy = policy
+}
 
-	if manager.isRunning {
+// Start fetches the active config, writes it to a file, and launches the V2Ray process.
+// It returns an error if the process exits during the startup grace period.
+// Once started, the process is supervised and restarted if it exits unexpectedly.
+func Start() error {
+	manager.ops.Lock()
+	defer manager.ops.Unlock()
+
+	manager.mu.RLock()
+	state := manager.state
+	manager.mu.RUnlock()
+	if state == StateRunning || state == StateRestarting {
 		return errors.New("V2Ray process is already running")
 	}
 
-	// 1. Get active config ID from DB
+	configData, err := activeConfigData()
+	if err != nil {
+		return err
+	}
+	if err := Validate(configData); err != nil {
+		return err
+	}
+
+	configPath := config.AppConfig.V2RayConfigPath
+	if err := writeConfigFile(configPath, configData); err != nil {
+		return fmt.Errorf("could not write V2Ray config file: %w", err)
+	}
+
+	if err := manager.startAndConfirm(config.AppConfig.V2RayExecutable, configPath); err != nil {
+		return err
+	}
+
+	_, pid := Status()
+	log.Info().Int("pid", pid).Msg("V2Ray process started successfully.")
+	return nil
+}
+
+// activeConfigData renders the balancer referenced by the active_balancer_id setting or,
+// if none is set, the configuration referenced by active_config_id into a complete V2Ray
+// document, merging in the enabled routing rules.
+func activeConfigData() ([]byte, error) {
+	rules, err := db.ListRoutingRules(true)
+	if err != nil {
+		return nil, fmt.Errorf("could not get routing rules: %w", err)
+	}
+	opts := generator.DefaultOptions
+	for _, rule := range rules {
+		opts.Rules = append(opts.Rules, RoutingRule(rule))
+	}
+
+	var balancerID int64
+	err = db.DB.QueryRow("SELECT value FROM settings WHERE key = ?", ActiveBalancerKey).Scan(&balancerID)
+	if err == nil {
+		return renderBalancer(balancerID, opts)
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("could not get active balancer: %w", err)
+	}
+
 	var configID int64
-	err := db.DB.QueryRow("SELECT value FROM settings WHERE key = ?", ActiveConfigKey).Scan(&configID)
+	err = db.DB.QueryRow("SELECT value FROM settings WHERE key = ?", ActiveConfigKey).Scan(&configID)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return errors.New("no active V2Ray configuration is set")
+			return nil, errors.New("no active V2Ray configuration is set")
 		}
-		return fmt.Errorf("could not get active config: %w", err)
+		return nil, fmt.Errorf("could not get active config: %w", err)
 	}
 
-	// 2. Get config data from DB
-	var configData string
-	err = db.DB.QueryRow("SELECT config_data FROM configurations WHERE id = ?", configID).Scan(&configData)
+	var protocol, configData string
+	err = db.DB.QueryRow("SELECT protocol, config_data FROM configurations WHERE id = ?", configID).Scan(&protocol, &configData)
 	if err != nil {
-		return fmt.Errorf("could not retrieve config data for ID %d: %w", configID, err)
+		return nil, fmt.Errorf("could not retrieve config data for ID %d: %w", configID, err)
 	}
+	return render(protocol, []byte(configData), opts)
+}
 
-	// 3. Write config to file
-	err = os.WriteFile(V2RayConfigPath, []byte(configData), 0600)
+// renderBalancer loads a balancer and its members and renders them into a balanced document.
+// A balancer that cannot be rendered, for example because it has no members left, is
+// reported as *InvalidConfigError.
+func renderBalancer(balancerID int64, opts generator.Options) ([]byte, error) {
+	var balancer generator.BalancerOptions
+	err := db.DB.QueryRow("SELECT strategy, probe_url, probe_interval FROM balancers WHERE id = ?", balancerID).
+		Scan(&balancer.Strategy, &balancer.ProbeURL, &balancer.ProbeInterval)
 	if err != nil {
-		return fmt.Errorf("could not write V2Ray config file: %w", err)
+		return nil, fmt.Errorf("could not retrieve balancer %d: %w", balancerID, err)
 	}
 
-	// 4. Mock starting the process
-	log.Info().
-		Str("executable", V2RayExecutable).
-		Str("config_path", V2RayConfigPath).
-		Msg("MOCK: Would run V2Ray start command")
-	manager.isRunning = true
-	manager.pid = 12345 // Mock PID
+	rows, err := db.DB.Query(`SELECT c.name, c.protocol, c.config_data FROM balancer_members m
+		JOIN configurations c ON c.id = m.configuration_id WHERE m.balancer_id = ? ORDER BY m.position`, balancerID)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve members of balancer %d: %w", balancerID, err)
+	}
+	defer rows.Close()
+	var members []generator.Member
+	for rows.Next() {
+		var member generator.Member
+		if err := rows.Scan(&member.Name, &member.Protocol, &member.ConfigData); err != nil {
+			return nil, fmt.Errorf("could not retrieve members of balancer %d: %w", balancerID, err)
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not retrieve members of balancer %d: %w", balancerID, err)
+	}
+
+	cfg, err := generator.BuildBalanced(members, balancer, opts)
+	if err != nil {
+		return nil, &InvalidConfigError{Issues: []ConfigIssue{{Message: err.Error()}}}
+	}
+	return cfg.Marshal()
+}
+
+// RoutingRule converts a stored routing rule into its entry in the generated document.
+func RoutingRule(rule db.RoutingRule) generator.RoutingRule {
+	return generator.RoutingRule{
+		Domain:      rule.Domain,
+		IP:          rule.IP,
+		Port:        rule.Port,
+		Network:     rule.Network,
+		Protocol:    rule.Protocol,
+		InboundTag:  rule.InboundTag,
+		OutboundTag: rule.OutboundTag,
+	}
+}
+
+// RenderConfig turns a stored configuration into the document written for V2Ray.
+// Stored data that cannot be rendered is reported as *InvalidConfigError.
+func RenderConfig(protocol string, configData []byte) ([]byte, error) {
+	return render(protocol, configData, generator.DefaultOptions)
+}
+
+func render(protocol string, configData []byte, opts generator.Options) ([]byte, error) {
+	cfg, err := generator.Build(protocol, configData, opts)
+	if err != nil {
+		return nil, &InvalidConfigError{Issues: []ConfigIssue{{Message: err.Error()}}}
+	}
+	return cfg.Marshal()
+}
+
+// writeConfigFile atomically replaces the file at path by writing to a temporary
+// file in the same directory and renaming it into place.
+func writeConfigFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // No-op once the rename has succeeded.
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
 
-	log.Info().Msg("Mock V2Ray process started successfully.")
+// startAndConfirm launches a fresh process and waits out the startup grace period.
+// The process is only supervised if it is still running afterwards.
+func (m *ManagerState) startAndConfirm(executable, configPath string) error {
+	m.mu.Lock()
+	m.cancelRestartLocked()
+	m.crashTimes = nil
+	m.restartCount = 0
+	m.exitCode = 0
+	m.lastExitReason = ""
+	done, err := m.launch(executable, configPath)
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	cmd := m.cmd
+	m.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(startupGrace):
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cmd != cmd || m.state != StateRunning {
+		return fmt.Errorf("V2Ray process exited during startup with code %d: %s", m.exitCode, m.lastExitReason)
+	}
+	m.supervised = true
 	return nil
 }
 
-// Stop mocks stopping the V2Ray process.
+// Environ returns the environment V2Ray processes are started with: k2ray's
+// own, with V2RAY_LOCATION_ASSET pointing V2Ray at the managed data files.
+func Environ() []string {
+	return append(os.Environ(), "V2RAY_LOCATION_ASSET="+config.AppConfig.GeoDataDir)
+}
+
+// launch starts the executable and the goroutine that reaps it. The caller must hold manager.mu.
+func (m *ManagerState) launch(executable, configPath string) (chan struct{}, error) {
+	processLog := log.With().Str("component", "v2ray").Logger()
+	cmd := exec.Command(executable, "run", "-c", configPath)
+	cmd.Env = Environ()
+	cmd.Stdout = processLog
+	cmd.Stderr = processLog
+	// Do not let leftover children holding the output pipes block Wait forever.
+	cmd.WaitDelay = time.Second
+
+	log.Info().
+		Str("executable", executable).
+		Str("config_path", configPath).
+		Msg("Starting V2Ray process")
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start V2Ray process: %w", err)
+	}
+
+	done := make(chan struct{})
+	m.cmd = cmd
+	m.done = done
+	m.executable = executable
+	m.configPath = configPath
+	m.state = StateRunning
+	m.pid = cmd.Process.Pid
+	m.startedAt = time.Now()
+	m.stopping = false
+	m.supervised = false
+	m.crashed = false
+
+	go m.wait(cmd, done)
+	return done, nil
+}
+
+// wait blocks until the process exits, records how it ended and, for a
+// supervised process, schedules a restart or declares a crash loop.
+func (m *ManagerState) wait(cmd *exec.Cmd, done chan struct{}) {
+	err := cmd.Wait()
+
+	m.mu.Lock()
+	m.state = StateStopped
+	m.pid = 0
+	if cmd.ProcessState != nil {
+		m.exitCode = cmd.ProcessState.ExitCode()
+		m.lastExitReason = cmd.ProcessState.String()
+	} else {
+		m.exitCode = -1
+		m.lastExitReason = err.Error()
+	}
+	m.crashed = !m.stopping
+	crashed, supervised := m.crashed, m.supervised
+	exitCode, reason := m.exitCode, m.lastExitReason
+	var delay time.Duration
+	if crashed && supervised {
+		delay = m.recordCrashLocked()
+	}
+	state, policy := m.state, m.policy
+	m.mu.Unlock()
+	close(done)
+
+	if !crashed {
+		log.Info().Int("exit_code", exitCode).Msg("V2Ray process exited")
+		return
+	}
+	log.Error().Int("exit_code", exitCode).Str("reason", reason).Msg("V2Ray process exited unexpectedly")
+	if !supervised {
+		return
+	}
+	security.LogEvent(nil, security.V2RayCrashed, 0, fmt.Sprintf("V2Ray process exited unexpectedly with code %d: %s", exitCode, reason))
+	reportRestartDecision(state, delay, policy)
+}
+
+// recordCrashLocked adds a crash to the sliding window and either schedules a restart,
+// returning its delay, or moves the manager into the crash-looping state.
+// The caller must hold m.mu.
+func (m *ManagerState) recordCrashLocked() time.Duration {
+	now := time.Now()
+	recent := m.crashTimes[:0]
+	for _, t := range m.crashTimes {
+		if now.Sub(t) < m.policy.CrashWindow {
+			recent = append(recent, t)
+		}
+	}
+	m.crashTimes = append(recent, now)
+
+	if len(m.crashTimes) >= m.policy.MaxCrashes {
+		m.state = StateCrashLooping
+		return 0
+	}
+
+	delay := m.policy.backoff(len(m.crashTimes))
+	m.state = StateRestarting
+	m.generation++
+	generation := m.generation
+	m.restartTimer = time.AfterFunc(delay, func() { m.restart(generation) })
+	return delay
+}
+
+// reportRestartDecision logs and audits the outcome of recordCrashLocked.
+func reportRestartDecision(state string, delay time.Duration, policy RestartPolicy) {
+	if state == StateCrashLooping {
+		log.Error().Int("max_crashes", policy.MaxCrashes).Dur("window", policy.CrashWindow).Msg("V2Ray process is crash-looping, giving up on restarts")
+		details := fmt.Sprintf("V2Ray crashed %d times within %s, automatic restarts disabled", policy.MaxCrashes, policy.CrashWindow)
+		security.LogEvent(nil, security.V2RayCrashLooping, 0, details)
+		return
+	}
+	log.Warn().Dur("backoff", delay).Msg("Scheduling V2Ray restart")
+}
+
+// restart relaunches the process after a crash, unless the pending restart was cancelled.
+func (m *ManagerState) restart(generation int) {
+	m.mu.Lock()
+	if m.state != StateRestarting || m.generation != generation {
+		m.mu.Unlock()
+		return
+	}
+	m.restartTimer = nil
+	m.restartCount++
+	restartCount := m.restartCount
+
+	_, err := m.launch(m.executable, m.configPath)
+	if err != nil {
+		m.state = StateStopped
+		m.lastExitReason = err.Error()
+		delay := m.recordCrashLocked()
+		state, policy := m.state, m.policy
+		m.mu.Unlock()
+		log.Error().Err(err).Msg("Failed to restart V2Ray process")
+		security.LogEvent(nil, security.V2RayCrashed, 0, "V2Ray process could not be restarted: "+err.Error())
+		reportRestartDecision(state, delay, policy)
+		return
+	}
+	m.supervised = true
+	pid := m.pid
+	m.mu.Unlock()
+
+	log.Info().Int("pid", pid).Int("restart_count", restartCount).Msg("V2Ray process restarted")
+	security.LogEvent(nil, security.V2RayRestarted, 0, fmt.Sprintf("V2Ray process restarted (attempt %d, pid %d)", restartCount, pid))
+}
+
+// cancelRestartLocked discards any pending restart. The caller must hold m.mu.
+func (m *ManagerState) cancelRestartLocked() {
+	m.generation++
+	if m.restartTimer != nil {
+		m.restartTimer.Stop()
+		m.restartTimer = nil
+	}
+}
+
+// Stop sends SIGTERM to the V2Ray process and waits for it to exit,
+// killing it if it does not shut down within stopTimeout.
+// A pending restart or a crash-looping state is cleared as well.
 func Stop() error {
-	manager.mu.Lock()
-	defer manager.mu.Unlock()
+	manager.ops.Lock()
+	defer manager.ops.Unlock()
 
-	if !manager.isRunning {
+	manager.mu.Lock()
+	switch manager.state {
+	case StateStopped:
+		manager.mu.Unlock()
 		return errors.New("V2Ray process is not running")
+	case StateRestarting, StateCrashLooping:
+		manager.cancelRestartLocked()
+		manager.state = StateStopped
+		manager.mu.Unlock()
+		log.Info().Msg("V2Ray supervisor stopped, pending restarts cancelled.")
+		return nil
 	}
+	manager.mu.Unlock()
 
-	// Mock killing the process
-	log.Info().Int("pid", manager.pid).Msg("MOCK: Would kill V2Ray process")
-	manager.isRunning = false
-	manager.pid = 0
+	if err := manager.terminate(); err != nil {
+		return err
+	}
+	log.Info().Msg("V2Ray process stopped successfully.")
+	return nil
+}
+
+// terminate stops the running process without it being reported as a crash.
+func (m *ManagerState) terminate() error {
+	m.mu.Lock()
+	if m.state != StateRunning {
+		m.mu.Unlock()
+		return nil
+	}
+	m.stopping = true
+	m.cancelRestartLocked()
+	process := m.cmd.Process
+	done := m.done
+	m.mu.Unlock()
+
+	log.Info().Int("pid", process.Pid).Msg("Stopping V2Ray process")
+	if err := process.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("could not signal V2Ray process: %w", err)
+	}
 
-	log.Info().Msg("Mock V2Ray process stopped successfully.")
+	select {
+	case <-done:
+	case <-time.After(stopTimeout):
+		log.Warn().Int("pid", process.Pid).Msg("V2Ray process did not exit in time, killing it")
+		if err := process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			return fmt.Errorf("could not kill V2Ray process: %w", err)
+		}
+		<-done
+	}
 	return nil
 }
 
-// Status returns the current (mocked) status of the V2Ray process.
+// Status returns whether the V2Ray process is running and its PID.
 func Status() (isRunning bool, pid int) {
 	manager.mu.RLock()
 	defer manager.mu.RUnlock()
-	return manager.isRunning, manager.pid
-}
\ No newline at end of file
+	return manager.state == StateRunning, manager.pid
+}
+
+// Info returns a detailed snapshot of the V2Ray process state.
+func Info() ProcessStatus {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	status := ProcessStatus{
+		State:          manager.state,
+		Running:        manager.state == StateRunning,
+		PID:            manager.pid,
+		StartedAt:      manager.startedAt,
+		ExitCode:       manager.exitCode,
+		LastExitReason: manager.lastExitReason,
+		Crashed:        manager.crashed,
+		RestartCount:   manager.restartCount,
+	}
+	if status.Running {
+		status.Uptime = time.Since(manager.startedAt)
+	}
+	return status
+}
@@ -1,14 +1,20 @@
 package v2ray_test
 
 import (
+	"context"
+	"encoding/json"
 	"k2ray/internal/api"
 	"k2ray/internal/config"
 	"k2ray/internal/db"
 	"k2ray/internal/utils"
 	"k2ray/internal/v2ray"
+	"k2ray/internal/v2ray/v2raytest"
 	"log"
 	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -24,8 +30,19 @@ func TestMain(m *testing.M) {
 	dbPath := tmpfile.Name()
 	tmpfile.Close()
 
+	binDir, err := os.MkdirTemp("", "test_v2ray_bin_*")
+	if err != nil {
+		log.Fatalf("Failed to create temp bin dir: %v", err)
+	}
+	fakeBinary, err := v2raytest.WriteFakeBinary(binDir)
+	if err != nil {
+		log.Fatalf("Failed to write fake v2ray binary: %v", err)
+	}
+
 	config.LoadConfig("")
 	config.AppConfig.DatabaseURL = dbPath
+	config.AppConfig.V2RayExecutable = fakeBinary
+	config.AppConfig.V2RayConfigPath = filepath.Join(binDir, "config.json")
 
 	db.InitDB()
 
@@ -37,27 +54,38 @@ func TestMain(m *testing.M) {
 
 	db.DB.Close()
 	os.Remove(dbPath)
+	os.RemoveAll(binDir)
 	os.Exit(code)
 }
 
 func createTestUserAndConfig(t *testing.T) (userID, configID int64) {
+	return createTestUserAndConfigWithData(t, `{"v": "2", "add": "test.com", "port": 443, "id": "b831381d-6324-4d53-ad4f-8cda48b30811"}`)
+}
+
+func createTestUserAndConfigWithData(t *testing.T, configData string) (userID, configID int64) {
 	// Create User
 	hashedPassword, _ := utils.HashPassword("password")
-	res, err := db.DB.Exec(`INSERT INTO users (username, password_hash) VALUES (?, ?)`, "testuser", hashedPassword)
+	res, err := db.DB.Exec(`INSERT INTO users (username, password_hash) VALUES (?, ?)`, t.Name(), hashedPassword)
 	assert.NoError(t, err)
 	userID, _ = res.LastInsertId()
 
 	// Create Config
-	configData := `{"v": "2", "add": "test.com", "port": 443}`
 	res, err = db.DB.Exec(`INSERT INTO configurations (user_id, name, protocol, config_data) VALUES (?, ?, ?, ?)`, userID, "test-config", "vmess", configData)
 	assert.NoError(t, err)
 	configID, _ = res.LastInsertId()
 	return
 }
 
+func setActiveConfig(t *testing.T, configID int64) {
+	upsertSQL := `INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`
+	_, err := db.DB.Exec(upsertSQL, v2ray.ActiveConfigKey, configID)
+	assert.NoError(t, err)
+}
+
 func TestV2RayProcessManager(t *testing.T) {
+	configPath := config.AppConfig.V2RayConfigPath
 	// Cleanup any previous test artifacts
-	os.Remove(v2ray.V2RayConfigPath)
+	os.Remove(configPath)
 
 	// 1. Initial status should be stopped
 	isRunning, _ := v2ray.Status()
@@ -69,8 +97,7 @@ func TestV2RayProcessManager(t *testing.T) {
 
 	// 3. Set an active config
 	_, configID := createTestUserAndConfig(t)
-	_, err = db.DB.Exec(`INSERT INTO settings (key, value) VALUES (?, ?)`, v2ray.ActiveConfigKey, configID)
-	assert.NoError(t, err)
+	setActiveConfig(t, configID)
 
 	// 4. Start the service
 	err = v2ray.Start()
@@ -80,8 +107,15 @@ func TestV2RayProcessManager(t *testing.T) {
 	isRunning, pid := v2ray.Status()
 	assert.True(t, isRunning, "Status should be running after start")
 	assert.NotZero(t, pid, "PID should be non-zero after start")
-	_, err = os.Stat(v2ray.V2RayConfigPath)
+	_, err = os.Stat(configPath)
 	assert.NoError(t, err, "Config file should be created")
+	content, _ := os.ReadFile(configPath)
+	assert.Contains(t, string(content), `"inbounds"`, "Config file should hold the generated core config")
+	assert.Contains(t, string(content), `"address": "test.com"`)
+	assert.NoError(t, syscall.Kill(pid, 0), "PID should belong to a live process")
+
+	// Starting again while running should fail
+	assert.Error(t, v2ray.Start(), "Start should fail while already running")
 
 	// 6. Stop the service
 	err = v2ray.Stop()
@@ -90,7 +124,216 @@ func TestV2RayProcessManager(t *testing.T) {
 	// 7. Verify final status
 	isRunning, _ = v2ray.Status()
 	assert.False(t, isRunning, "Status should be stopped after stop")
+	info := v2ray.Info()
+	assert.False(t, info.Crashed, "A requested stop should not be reported as a crash")
+	assert.Error(t, syscall.Kill(pid, 0), "Process should be gone after stop")
+
+	// Stopping again should fail
+	assert.Error(t, v2ray.Stop(), "Stop should fail when not running")
 
 	// Cleanup
-	os.Remove(v2ray.V2RayConfigPath)
+	os.Remove(configPath)
+}
+
+func TestV2RayProcessCrashDetection(t *testing.T) {
+	_, configID := createTestUserAndConfigWithData(t, `{"add": "fake-crash.test", "port": 443, "id": "a00b49a2-f0da-5008-9067-c94a706689d2"}`)
+	setActiveConfig(t, configID)
+
+	err := v2ray.Start()
+	assert.Error(t, err, "Start should report a process that exits during startup")
+
+	assert.Eventually(t, func() bool {
+		return !v2ray.Info().Running
+	}, 2*time.Second, 20*time.Millisecond)
+
+	info := v2ray.Info()
+	assert.True(t, info.Crashed, "Unexpected exit should be reported as a crash")
+	assert.Equal(t, 1, info.ExitCode)
+	assert.Zero(t, info.PID)
+}
+
+func TestV2RayProcessAutoRestart(t *testing.T) {
+	v2ray.SetRestartPolicy(v2ray.RestartPolicy{
+		InitialBackoff: 50 * time.Millisecond,
+		MaxBackoff:     200 * time.Millisecond,
+		MaxCrashes:     5,
+		CrashWindow:    time.Minute,
+	})
+	defer v2ray.SetRestartPolicy(v2ray.DefaultRestartPolicy)
+
+	_, configID := createTestUserAndConfig(t)
+	setActiveConfig(t, configID)
+
+	err := v2ray.Start()
+	assert.NoError(t, err)
+	_, pid := v2ray.Status()
+
+	// Kill the process behind the manager's back to simulate a crash.
+	assert.NoError(t, syscall.Kill(pid, syscall.SIGKILL))
+
+	assert.Eventually(t, func() bool {
+		info := v2ray.Info()
+		return info.Running && info.PID != pid
+	}, 2*time.Second, 20*time.Millisecond, "Process should be restarted after a crash")
+
+	info := v2ray.Info()
+	assert.Equal(t, v2ray.StateRunning, info.State)
+	assert.Equal(t, 1, info.RestartCount)
+	assert.Equal(t, "signal: killed", info.LastExitReason)
+
+	assert.NoError(t, v2ray.Stop())
+	assert.Equal(t, v2ray.StateStopped, v2ray.Info().State)
+}
+
+func TestV2RayProcessCrashLoop(t *testing.T) {
+	v2ray.SetRestartPolicy(v2ray.RestartPolicy{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     40 * time.Millisecond,
+		MaxCrashes:     3,
+		CrashWindow:    time.Minute,
+	})
+	defer v2ray.SetRestartPolicy(v2ray.DefaultRestartPolicy)
+
+	_, configID := createTestUserAndConfig(t)
+	setActiveConfig(t, configID)
+
+	err := v2ray.Start()
+	assert.NoError(t, err)
+	_, pid := v2ray.Status()
+
+	// Break the config on disk so that every restart crashes, then crash the process.
+	err = os.WriteFile(config.AppConfig.V2RayConfigPath, []byte(`{"add": "fake-crash.test"}`), 0600)
+	assert.NoError(t, err)
+	assert.NoError(t, syscall.Kill(pid, syscall.SIGKILL))
+
+	assert.Eventually(t, func() bool {
+		return v2ray.Info().State == v2ray.StateCrashLooping
+	}, 3*time.Second, 20*time.Millisecond, "Manager should give up after repeated crashes")
+
+	info := v2ray.Info()
+	assert.False(t, info.Running)
+	assert.Equal(t, 2, info.RestartCount)
+	assert.Equal(t, 1, info.ExitCode)
+
+	// Stop clears the crash-looping state, and a manual start is allowed again.
+	assert.NoError(t, v2ray.Stop())
+	assert.Equal(t, v2ray.StateStopped, v2ray.Info().State)
+	assert.Error(t, v2ray.Stop())
+}
+
+func TestV2RayReload(t *testing.T) {
+	configPath := config.AppConfig.V2RayConfigPath
+
+	// Reloading while stopped is a no-op.
+	result, err := v2ray.Reload()
+	assert.NoError(t, err)
+	assert.Equal(t, v2ray.ReloadNotRunning, result)
+
+	userID, firstID := createTestUserAndConfigWithData(t, `{"add": "first.test", "port": 443, "id": "12547715-91cb-5ef0-bda3-dea04bd6883e"}`)
+	setActiveConfig(t, firstID)
+	assert.NoError(t, v2ray.Start())
+	_, firstPID := v2ray.Status()
+
+	// A working config is applied with a fresh process.
+	res, err := db.DB.Exec(`INSERT INTO configurations (user_id, name, protocol, config_data) VALUES (?, ?, ?, ?)`, userID, "second", "vmess", `{"add": "second.test", "port": 443, "id": "0aacf175-796b-52be-b4f5-bf741b77d7cd"}`)
+	assert.NoError(t, err)
+	secondID, _ := res.LastInsertId()
+	setActiveConfig(t, secondID)
+
+	result, err = v2ray.Reload()
+	assert.NoError(t, err)
+	assert.Equal(t, v2ray.ReloadApplied, result)
+	running, secondPID := v2ray.Status()
+	assert.True(t, running)
+	assert.NotEqual(t, firstPID, secondPID)
+	content, _ := os.ReadFile(configPath)
+	assert.Contains(t, string(content), "second.test")
+
+	// A config that crashes on startup is rolled back to the previous file.
+	res, err = db.DB.Exec(`INSERT INTO configurations (user_id, name, protocol, config_data) VALUES (?, ?, ?, ?)`, userID, "broken", "vmess", `{"add": "fake-crash.test", "port": 443, "id": "53236f58-992e-5f46-a148-7e17f281bc1f"}`)
+	assert.NoError(t, err)
+	brokenID, _ := res.LastInsertId()
+	setActiveConfig(t, brokenID)
+
+	result, err = v2ray.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, v2ray.ReloadRolledBack, result)
+	assert.True(t, v2ray.Info().Running, "Previous config should be running again after a rollback")
+	content, _ = os.ReadFile(configPath)
+	assert.Contains(t, string(content), "second.test")
+
+	assert.NoError(t, v2ray.Stop())
+}
+
+func TestV2RayRoutingRules(t *testing.T) {
+	ctx := context.Background()
+	t.Cleanup(func() { db.DB.Exec(`DELETE FROM routing_rules`) })
+	_, err := db.CreateRoutingRule(ctx, db.RoutingRule{Name: "ads", Enabled: true, Domain: []string{"geosite:category-ads-all"}, OutboundTag: "block"})
+	assert.NoError(t, err)
+	_, err = db.CreateRoutingRule(ctx, db.RoutingRule{Name: "off", Enabled: false, Port: "25", OutboundTag: "block"})
+	assert.NoError(t, err)
+	_, err = db.CreateRoutingRule(ctx, db.RoutingRule{Name: "cn", Position: 1, Enabled: true, IP: []string{"geoip:cn"}, OutboundTag: "direct"})
+	assert.NoError(t, err)
+
+	_, configID := createTestUserAndConfig(t)
+	setActiveConfig(t, configID)
+	assert.NoError(t, v2ray.Start())
+	defer v2ray.Stop()
+
+	content, err := os.ReadFile(config.AppConfig.V2RayConfigPath)
+	assert.NoError(t, err)
+	var doc struct {
+		Routing struct {
+			Rules []map[string]any `json:"rules"`
+		} `json:"routing"`
+	}
+	assert.NoError(t, json.Unmarshal(content, &doc))
+	rules := doc.Routing.Rules
+	if assert.Len(t, rules, 3, "disabled rules are left out") {
+		assert.Equal(t, []any{"geoip:cn"}, rules[0]["ip"])
+		assert.Equal(t, []any{"geosite:category-ads-all"}, rules[1]["domain"])
+		assert.Equal(t, []any{"geoip:private"}, rules[2]["ip"])
+		assert.Equal(t, "field", rules[0]["type"])
+	}
+}
+
+func TestV2RayBalancer(t *testing.T) {
+	userID, firstID := createTestUserAndConfig(t)
+	res, err := db.DB.Exec(`INSERT INTO configurations (user_id, name, protocol, config_data) VALUES (?, ?, ?, ?)`, userID, "second", "vmess", `{"add": "second.test", "port": 443, "id": "0aacf175-796b-52be-b4f5-bf741b77d7cd"}`)
+	assert.NoError(t, err)
+	secondID, _ := res.LastInsertId()
+	res, err = db.DB.Exec(`INSERT INTO balancers (user_id, name, strategy) VALUES (?, 'pool', 'leastPing')`, userID)
+	assert.NoError(t, err)
+	balancerID, _ := res.LastInsertId()
+	_, err = db.DB.Exec(`INSERT INTO balancer_members (balancer_id, configuration_id, position) VALUES (?, ?, 1), (?, ?, 2)`, balancerID, secondID, balancerID, firstID)
+	assert.NoError(t, err)
+
+	// The active balancer takes precedence over the active configuration.
+	setActiveConfig(t, firstID)
+	_, err = db.DB.Exec(`INSERT INTO settings (key, value) VALUES (?, ?)`, v2ray.ActiveBalancerKey, balancerID)
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.DB.Exec(`DELETE FROM settings WHERE key = ?`, v2ray.ActiveBalancerKey) })
+	assert.NoError(t, v2ray.ValidateBalancer(balancerID))
+
+	assert.NoError(t, v2ray.Start())
+	defer v2ray.Stop()
+	content, err := os.ReadFile(config.AppConfig.V2RayConfigPath)
+	assert.NoError(t, err)
+	var doc struct {
+		Outbounds []struct {
+			Tag string `json:"tag"`
+		} `json:"outbounds"`
+		Routing struct {
+			Balancers []map[string]any `json:"balancers"`
+		} `json:"routing"`
+		Observatory map[string]any `json:"observatory"`
+	}
+	assert.NoError(t, json.Unmarshal(content, &doc))
+	if assert.Len(t, doc.Outbounds, 4) {
+		assert.Equal(t, "proxy-1", doc.Outbounds[0].Tag)
+		assert.Equal(t, "proxy-2", doc.Outbounds[1].Tag)
+	}
+	assert.Contains(t, string(content), "second.test")
+	assert.Len(t, doc.Routing.Balancers, 1)
+	assert.NotEmpty(t, doc.Observatory)
 }
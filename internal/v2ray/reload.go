@@ -0,0 +1,76 @@
+package v2ray
+
+import (
+	"errors"
+	"fmt"
+	"k2ray/internal/config"
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ReloadResult describes the outcome of a Reload call.
+type ReloadResult string
+
+const (
+	// ReloadNotRunning means V2Ray was not running, so there was nothing to reload.
+	ReloadNotRunning ReloadResult = "not_running"
+	// ReloadApplied means the new configuration is live.
+	ReloadApplied ReloadResult = "applied"
+	// ReloadRejected means the new configuration failed validation and nothing was changed.
+	ReloadRejected ReloadResult = "rejected"
+	// ReloadRolledBack means the new configuration failed and the previous one was restored.
+	ReloadRolledBack ReloadResult = "rolled_back"
+	// ReloadFailed means neither the new nor the previous configuration could be started.
+	ReloadFailed ReloadResult = "failed"
+)
+
+// Reload applies the current active configuration to a running V2Ray process.
+// V2Ray has no in-place reload, so once the new configuration has passed Validate
+// the process is gracefully restarted with the new file. If the new process does
+// not survive startup, the previous file is restored and the previous process restarted.
+func Reload() (ReloadResult, error) {
+	manager.ops.Lock()
+	defer manager.ops.Unlock()
+
+	if running, _ := Status(); !running {
+		return ReloadNotRunning, nil
+	}
+
+	configData, err := activeConfigData()
+	if err != nil {
+		return ReloadFailed, err
+	}
+	if err := Validate(configData); err != nil {
+		return ReloadRejected, err
+	}
+
+	configPath := config.AppConfig.V2RayConfigPath
+	executable := config.AppConfig.V2RayExecutable
+	previous, err := os.ReadFile(configPath)
+	if err != nil {
+		return ReloadFailed, fmt.Errorf("could not back up current V2Ray config file: %w", err)
+	}
+
+	if err := writeConfigFile(configPath, configData); err != nil {
+		return ReloadFailed, fmt.Errorf("could not write V2Ray config file: %w", err)
+	}
+	if err := manager.terminate(); err != nil {
+		return ReloadFailed, err
+	}
+
+	startErr := manager.startAndConfirm(executable, configPath)
+	if startErr == nil {
+		log.Info().Msg("V2Ray configuration reloaded successfully.")
+		return ReloadApplied, nil
+	}
+
+	log.Error().Err(startErr).Msg("New V2Ray configuration failed to start, rolling back")
+	if err := writeConfigFile(configPath, previous); err != nil {
+		return ReloadFailed, errors.Join(startErr, fmt.Errorf("could not restore previous V2Ray config file: %w", err))
+	}
+	if err := manager.startAndConfirm(executable, configPath); err != nil {
+		return ReloadFailed, errors.Join(startErr, fmt.Errorf("previous V2Ray configuration failed to start: %w", err))
+	}
+	return ReloadRolledBack, startErr
+}
@@ -0,0 +1,68 @@
+// Package v2raytest provides a fake v2ray executable for tests that need to
+// exercise the process manager without a real v2ray-core binary.
+package v2raytest
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// fakeScript mimics the parts of the v2ray CLI that K2Ray uses.
+// Its behaviour is driven by marker strings inside the config file:
+//   - "fake-crash" makes "run" exit with code 1 shortly after starting.
+//   - "fake-crash-once" does the same, but only the first time for a given config path.
+//   - "fake-invalid" makes "test" reject the config with a v2ray-style error on line 3.
+//   - "fake-geoip" makes "test" fail unless geoip.dat is in $V2RAY_LOCATION_ASSET.
+const fakeScript = `#!/bin/sh
+cmd="$1"
+config="$3"
+case "$cmd" in
+version)
+	echo "V2Ray 5.0.0 (fake)"
+	exit 0
+	;;
+test)
+	if grep -q "fake-invalid" "$config" 2>/dev/null; then
+		echo "V2Ray 5.0.0 (fake)"
+		echo "Failed to start: main: failed to load config files: [$config] > infra/conf: line 3 char 12: unknown field fake-invalid" >&2
+		exit 23
+	fi
+	if grep -q "fake-geoip" "$config" 2>/dev/null && [ ! -f "$V2RAY_LOCATION_ASSET/geoip.dat" ]; then
+		echo "Failed to start: main: failed to load config files: [$config] > infra/conf: failed to load geoip.dat: file not found" >&2
+		exit 23
+	fi
+	echo "Configuration OK."
+	exit 0
+	;;
+run)
+	if grep -q "fake-crash-once" "$config" 2>/dev/null; then
+		if [ ! -f "$config.crashed" ]; then
+			touch "$config.crashed"
+			echo "fake v2ray: crashing once" >&2
+			exit 1
+		fi
+	elif grep -q "fake-crash" "$config" 2>/dev/null; then
+		sleep 0.1
+		echo "fake v2ray: crashing" >&2
+		exit 1
+	fi
+	trap 'kill $child 2>/dev/null; exit 0' TERM INT
+	sleep 3600 &
+	child=$!
+	wait $child
+	;;
+*)
+	echo "fake v2ray: unknown command $cmd" >&2
+	exit 2
+	;;
+esac
+`
+
+// WriteFakeBinary writes the fake v2ray executable into dir and returns its path.
+func WriteFakeBinary(dir string) (string, error) {
+	path := filepath.Join(dir, "v2ray")
+	if err := os.WriteFile(path, []byte(fakeScript), 0755); err != nil {
+		return "", err
+	}
+	return path, nil
+}
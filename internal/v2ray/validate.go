@@ -0,0 +1,139 @@
+package v2ray
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"k2ray/internal/config"
+	"k2ray/internal/generator"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validateTimeout bounds how long the config test may run.
+var validateTimeout = 10 * time.Second
+
+// lineRef matches the "line N" references v2ray puts in its config errors.
+var lineRef = regexp.MustCompile(`line (\d+)`)
+
+// ConfigIssue is a single problem reported for a configuration file.
+type ConfigIssue struct {
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// InvalidConfigError is returned when a configuration fails validation.
+type InvalidConfigError struct {
+	Issues []ConfigIssue
+}
+
+func (e *InvalidConfigError) Error() string {
+	messages := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		if issue.Line > 0 {
+			messages[i] = fmt.Sprintf("line %d: %s", issue.Line, issue.Message)
+		} else {
+			messages[i] = issue.Message
+		}
+	}
+	return "invalid V2Ray configuration: " + strings.Join(messages, "; ")
+}
+
+// Validate checks a configuration document before it is applied. The JSON syntax
+// is checked first, then the V2Ray executable is run in config-test mode against
+// a temporary copy of the document. Rejections are returned as *InvalidConfigError;
+// any other error means the test itself could not be run.
+func Validate(configData []byte) error {
+	var doc any
+	if err := json.Unmarshal(configData, &doc); err != nil {
+		issue := ConfigIssue{Message: err.Error()}
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			issue.Line = lineAt(configData, syntaxErr.Offset)
+		}
+		return &InvalidConfigError{Issues: []ConfigIssue{issue}}
+	}
+
+	tmp, err := os.CreateTemp("", "k2ray_config_test_*.json")
+	if err != nil {
+		return fmt.Errorf("could not create temporary config file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(configData); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write temporary config file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write temporary config file: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, config.AppConfig.V2RayExecutable, "test", "-c", tmp.Name())
+	cmd.Env = Environ()
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err = cmd.Run()
+	if err == nil {
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || ctx.Err() != nil {
+		return fmt.Errorf("could not run V2Ray config test: %w", err)
+	}
+	return &InvalidConfigError{Issues: parseTestOutput(output.String(), exitErr.ExitCode())}
+}
+
+// ValidateStored renders a stored configuration and validates the resulting document.
+func ValidateStored(protocol string, configData []byte) error {
+	document, err := RenderConfig(protocol, configData)
+	if err != nil {
+		return err
+	}
+	return Validate(document)
+}
+
+// ValidateBalancer renders a stored balancer and validates the resulting document.
+func ValidateBalancer(balancerID int64) error {
+	document, err := renderBalancer(balancerID, generator.DefaultOptions)
+	if err != nil {
+		return err
+	}
+	return Validate(document)
+}
+
+// parseTestOutput extracts the error lines from the output of a failed config test.
+func parseTestOutput(output string, exitCode int) []ConfigIssue {
+	var issues []ConfigIssue
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || !strings.Contains(strings.ToLower(line), "fail") && !strings.Contains(strings.ToLower(line), "error") {
+			continue
+		}
+		issue := ConfigIssue{Message: strings.TrimPrefix(line, "Failed to start: ")}
+		if m := lineRef.FindStringSubmatch(line); m != nil {
+			issue.Line, _ = strconv.Atoi(m[1])
+		}
+		issues = append(issues, issue)
+	}
+	if len(issues) == 0 {
+		issues = append(issues, ConfigIssue{Message: fmt.Sprintf("config test failed with exit code %d", exitCode)})
+	}
+	return issues
+}
+
+// lineAt returns the 1-based line number of the given byte offset.
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
@@ -0,0 +1,63 @@
+package v2ray_test
+
+import (
+	"errors"
+	"k2ray/internal/config"
+	"k2ray/internal/v2ray"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	t.Run("Valid config", func(t *testing.T) {
+		err := v2ray.Validate([]byte(`{"outbounds": [{"protocol": "freedom"}]}`))
+		assert.NoError(t, err)
+	})
+
+	t.Run("JSON syntax error", func(t *testing.T) {
+		err := v2ray.Validate([]byte("{\n  \"log\": {},\n  \"inbounds\": [,]\n}"))
+		var invalid *v2ray.InvalidConfigError
+		if assert.True(t, errors.As(err, &invalid)) {
+			assert.Len(t, invalid.Issues, 1)
+			assert.Equal(t, 3, invalid.Issues[0].Line)
+		}
+	})
+
+	t.Run("Rejected by config test", func(t *testing.T) {
+		err := v2ray.Validate([]byte(`{"outbounds": [{"protocol": "fake-invalid"}]}`))
+		var invalid *v2ray.InvalidConfigError
+		if assert.True(t, errors.As(err, &invalid)) {
+			assert.Len(t, invalid.Issues, 1)
+			assert.Equal(t, 3, invalid.Issues[0].Line)
+			assert.Contains(t, invalid.Issues[0].Message, "unknown field fake-invalid")
+			assert.NotContains(t, invalid.Issues[0].Message, "Failed to start")
+		}
+	})
+
+	t.Run("Data files are loaded from the data directory", func(t *testing.T) {
+		original := config.AppConfig.GeoDataDir
+		t.Cleanup(func() { config.AppConfig.GeoDataDir = original })
+		config.AppConfig.GeoDataDir = t.TempDir()
+		doc := []byte(`{"routing": {"rules": [{"ip": ["geoip:fake-geoip"], "outboundTag": "direct"}]}}`)
+
+		var invalid *v2ray.InvalidConfigError
+		assert.ErrorAs(t, v2ray.Validate(doc), &invalid, "geoip.dat is missing")
+		require.NoError(t, os.WriteFile(filepath.Join(config.AppConfig.GeoDataDir, "geoip.dat"), nil, 0o644))
+		assert.NoError(t, v2ray.Validate(doc), "V2RAY_LOCATION_ASSET points at the data directory")
+	})
+}
+
+func TestStartRejectsInvalidConfig(t *testing.T) {
+	_, configID := createTestUserAndConfigWithData(t, `{"add": "fake-invalid.test", "port": 443, "id": "ab31f8cd-8efa-56c0-8304-400b4a61d12b"}`)
+	setActiveConfig(t, configID)
+
+	err := v2ray.Start()
+	var invalid *v2ray.InvalidConfigError
+	assert.True(t, errors.As(err, &invalid), "Start should refuse a config that fails validation")
+	running, _ := v2ray.Status()
+	assert.False(t, running)
+}
@@ -16,7 +16,7 @@ mkdir -p "$DIST_DIR"
 
 # Build Go backend
 echo "🛠️ Building Go backend..."
-go build -v -o "$DIST_DIR/k2ray" "$ROOT_DIR/cmd/k2ray"
+go build -v -tags sqlite_fts5 -o "$DIST_DIR/k2ray" "$ROOT_DIR/cmd/k2ray"
 
 # Build frontend
 echo "🎨 Building frontend..."
@@ -35,7 +35,7 @@ for PLATFORM in $PLATFORMS; do
     echo "🛠️ Building for $GOOS/$GOARCH..."
 
     # Set environment variables and build
-    env GOOS=$GOOS GOARCH=$GOARCH go build -v -o "$RELEASE_DIR/$OUTPUT_NAME" -ldflags="-s -w -X main.Version=$VERSION" "$ROOT_DIR/cmd/k2ray"
+    env GOOS=$GOOS GOARCH=$GOARCH go build -v -tags sqlite_fts5 -o "$RELEASE_DIR/$OUTPUT_NAME" -ldflags="-s -w -X main.Version=$VERSION" "$ROOT_DIR/cmd/k2ray"
 
     # Create an archive for the binary
     echo "🗜️ Compressing $OUTPUT_NAME..."